@@ -9,12 +9,14 @@ import (
     "flag"
     "fmt"
     "github.com/korchasa/speelka-agent-go/internal/utils/log_formatter"
+    "io"
     "os"
     "os/signal"
     "runtime/debug"
     "syscall"
 
     "github.com/korchasa/speelka-agent-go/internal/application"
+    "github.com/korchasa/speelka-agent-go/internal/eval"
 
     "github.com/korchasa/speelka-agent-go/internal/configuration"
     "github.com/sirupsen/logrus"
@@ -26,6 +28,9 @@ import (
 var (
     configFile = flag.String("config", "", "Path to configuration file (YAML or JSON format)")
     callInput  = flag.String("call", "", "Run in direct call mode with the given user query (bypasses MCP server)")
+    batchFile  = flag.String("batch", "", "Run the queries of a JSONL file (- for stdin) in direct call mode, writing one JSON result per line")
+    batchConcurrency = flag.Int("concurrency", 1, "Number of batch queries run at once")
+    replMode   = flag.Bool("repl", false, "Chat with the agent in the terminal (bypasses MCP server)")
 )
 
 // main - application entry point
@@ -33,6 +38,20 @@ var (
 // Features: Sets up signal handling for graceful shutdown
 func main() {
     flag.Parse()
+    var evalCmd *evalCommand
+    if flag.Arg(0) == "eval" {
+        evalCmd = parseEvalCommand(flag.Args()[1:])
+    }
+    modes := 0
+    for _, set := range []bool{*callInput != "", *batchFile != "", *replMode, evalCmd != nil} {
+        if set {
+            modes++
+        }
+    }
+    if modes > 1 {
+        fmt.Fprintln(os.Stderr, "--call, --batch, --repl and eval cannot be used together")
+        os.Exit(1)
+    }
 
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
@@ -77,6 +96,11 @@ func main() {
         }
     }()
 
+    if *replMode && !conf.GetSessionStoreConfig().Enabled() {
+        // The REPL keeps the chat in a session
+        conf.Agent.Sessions.Store = configuration.SessionStoreMemory
+    }
+
     app, err := application.NewMCPApp(log, conf)
     if err != nil {
         log.Fatalf("Failed to create application: %v", err)
@@ -92,6 +116,46 @@ func main() {
         _ = json.NewEncoder(os.Stdout).Encode(result)
         os.Exit(code)
         return
+    } else if *batchFile != "" {
+        // Batch mode: many direct calls with one set of MCP connections
+        input := os.Stdin
+        if *batchFile != "-" {
+            input, err = os.Open(*batchFile)
+            if err != nil {
+                log.Fatalf("Failed to open batch input: %v", err)
+            }
+        }
+        log.Infof("Running in batch mode with input: %s", *batchFile)
+        summary, code, err := app.ExecuteBatch(ctx, input, os.Stdout, *batchConcurrency)
+        if err != nil {
+            log.Errorf("Batch failed: %v", err)
+        }
+        fmt.Fprintf(os.Stderr, "Batch finished: %d items, %d succeeded, %d failed, %d tokens, $%.4f, %.1fs\n",
+            summary.Items, summary.Succeeded, summary.Failed, summary.Tokens, summary.Cost, float64(summary.DurationMs)/1000)
+        os.Exit(code)
+    } else if evalCmd != nil {
+        // Eval mode: run the suite and write the reports
+        report, err := app.ExecuteEval(ctx, evalCmd.suite)
+        if err != nil {
+            log.Fatalf("Eval failed: %v", err)
+        }
+        if err := evalCmd.writeReports(report); err != nil {
+            log.Fatalf("Failed to write eval report: %v", err)
+        }
+        status := "passed"
+        if !report.Passed {
+            status = "failed"
+        }
+        fmt.Fprintf(os.Stderr, "Eval %s: %d of %d runs passed, $%.4f, %.1fs\n",
+            status, report.Runs-report.FailedRuns, report.Runs, report.Cost, float64(report.DurationMs)/1000)
+        if !report.Passed {
+            os.Exit(1)
+        }
+    } else if *replMode {
+        // REPL mode: chat with the agent in the terminal
+        if err := app.ExecuteREPL(ctx, os.Stdin, os.Stdout); err != nil {
+            log.Fatalf("REPL failed: %v", err)
+        }
     } else {
         log.Infof("Running in MCP server mode")
         err = app.Start(ctx)
@@ -101,6 +165,69 @@ func main() {
     }
 }
 
+// evalCommand holds the arguments of the eval command
+type evalCommand struct {
+    suite      eval.Suite
+    reportFile string
+    junitFile  string
+}
+
+// parseEvalCommand parses `eval [flags] suite.yaml` and loads the suite, exiting on errors
+func parseEvalCommand(args []string) *evalCommand {
+    flags := flag.NewFlagSet("eval", flag.ExitOnError)
+    repetitions := flags.Int("repetitions", 0, "Run each case this many times, overriding the suite")
+    reportFile := flags.String("report", "", "Write the JSON report to this file instead of stdout")
+    junitFile := flags.String("junit", "", "Also write the report as JUnit XML to this file")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: speelka-agent --config agent.yaml eval [flags] suite.yaml")
+        flags.PrintDefaults()
+    }
+    _ = flags.Parse(args)
+    if flags.NArg() != 1 {
+        flags.Usage()
+        os.Exit(1)
+    }
+    suite, err := eval.LoadSuite(flags.Arg(0))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    if *repetitions > 0 {
+        suite.Repetitions = *repetitions
+        for i := range suite.Cases {
+            suite.Cases[i].Repetitions = 0
+        }
+    }
+    return &evalCommand{suite: suite, reportFile: *reportFile, junitFile: *junitFile}
+}
+
+// writeReports writes the JSON report to the report file or stdout, and the JUnit report if asked for
+func (c *evalCommand) writeReports(report eval.Report) error {
+    if c.reportFile == "" {
+        if err := report.WriteJSON(os.Stdout); err != nil {
+            return err
+        }
+    } else if err := writeFile(c.reportFile, report.WriteJSON); err != nil {
+        return err
+    }
+    if c.junitFile != "" {
+        return writeFile(c.junitFile, report.WriteJUnit)
+    }
+    return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    if err := write(f); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
 func loadConfiguration(ctx context.Context) (*configuration.Configuration, error) {
     configManager := configuration.NewConfigurationManager()
     err := configManager.LoadConfiguration(ctx, *configFile)
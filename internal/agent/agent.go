@@ -2,12 +2,17 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
 	types2 "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/metrics"
+	"github.com/korchasa/speelka-agent-go/internal/session_store"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
 	"github.com/korchasa/speelka-agent-go/internal/utils/dump"
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/tmc/langchaingo/llms"
@@ -25,6 +30,12 @@ type Agent struct {
 	toolConnector toolConnectorSpec
 	log           *logrus.Logger
 	chat          *chat.Chat // Injected chat instance
+	toolExecutor  *toolExecutor
+	sessions      sessionStoreSpec
+	finishTool    mcp.Tool
+	metrics       metricsSpec     // optional
+	tracer        *tracing.Tracer // nil disables tracing
+	locks         sessionLocksSpec
 }
 
 var finishTool = mcp.NewTool(
@@ -41,6 +52,8 @@ var finishTool = mcp.NewTool(
 type calculatorSpec interface {
 	// CalculateLLMResponse returns the number of tokens, USD cost, and approximation flag for the given model and LLM response.
 	CalculateLLMResponse(modelName string, resp types2.LLMResponse) (tokens int, cost float64, isApprox bool, err error)
+	// CalculateCost returns the USD cost of the given token usage. It fails for models missing from the catalog.
+	CalculateCost(modelName string, inputTokens, outputTokens int) (float64, error)
 }
 
 // ToolConnectorSpec represents the interface for the tool connector component.
@@ -59,11 +72,48 @@ type toolConnectorSpec interface {
 	// ExecuteTool executes a tool on the appropriate tool server.
 	// It returns the result of the tool execution and an error if the execution fails.
 	ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error)
+	// GetToolServerID returns the ID of the tool server that exposes the tool.
+	// It returns false if no connected server exposes the tool.
+	GetToolServerID(toolName string) (string, bool)
 	// Close closes all connections to tool servers.
 	// It returns an error if any connection fails to close.
 	Close() error
 }
 
+// serverHealthSpec is implemented by tool connectors that supervise the health of their servers.
+type serverHealthSpec interface {
+	// IsServerHealthy reports whether the tool server is usable.
+	IsServerHealthy(serverID string) bool
+}
+
+// sessionStoreSpec represents the storage for multi-turn sessions.
+// Responsibility: Defining the contract for saving and restoring conversations
+// Features: Implementations handle expiry; an expired session is reported as missing
+type sessionStoreSpec interface {
+	// Load returns the saved session. The second value is false if it does not exist or has expired.
+	Load(id string) (types.SessionState, bool, error)
+	// Save stores the session.
+	Save(state types.SessionState) error
+	// Delete removes the session.
+	Delete(id string) error
+}
+
+// sessionLocksSpec marks the sessions that a call is using.
+// Responsibility: Keeping one conversation from being used by two calls at once
+// Features: Agents of several tools share one instance, so the lock covers all of them
+type sessionLocksSpec interface {
+	// Acquire marks the session as in use. It returns an error if another call is using it.
+	Acquire(id string) error
+	// Release marks the session as free again.
+	Release(id string)
+}
+
+// metricsSpec records the metrics of sessions.
+type metricsSpec interface {
+	// SessionFinished records a finished session of the tool with its outcome and number of LLM iterations.
+	SessionFinished(tool, outcome string, iterations int)
+}
+
 // llmServiceSpec represents the interface for the LLM service.
 // Responsibility: Defining the contract for the LLM service
 // Features: Defines methods for sending requests to the LLM
@@ -80,6 +130,7 @@ func NewAgent(
 	toolConnector toolConnectorSpec,
 	log *logrus.Logger,
 	chat *chat.Chat,
+	sessions sessionStoreSpec,
 ) *Agent {
 	return &Agent{
 		config:        config,
@@ -87,16 +138,60 @@ func NewAgent(
 		toolConnector: toolConnector,
 		log:           log,
 		chat:          chat,
+		toolExecutor:  newToolExecutor(toolConnector, config.ToolCalls, config.Approval, log),
+		sessions:      sessions,
+		finishTool:    buildFinishTool(config.Tool.OutputSchema),
+		locks:         session_store.NewLocks(),
 	}
 }
 
+// SetSessionLocks makes the agent share the session locks with the agents of the other tools.
+func (a *Agent) SetSessionLocks(l sessionLocksSpec) {
+	a.locks = l
+}
+
+// SetToolCallSlots makes the agent share the tool call limits with the agents of the other tools.
+func (a *Agent) SetToolCallSlots(s *ToolCallSlots) {
+	a.toolExecutor.slots = s
+}
+
+// SetMetrics makes the agent record the metrics of its sessions.
+func (a *Agent) SetMetrics(m metricsSpec) {
+	a.metrics = m
+}
+
+// SetTracer makes the agent trace its sessions and their iterations.
+func (a *Agent) SetTracer(t *tracing.Tracer) {
+	a.tracer = t
+}
+
 // GetAllTools returns all available tools (internal and from MCPs)
 func (a *Agent) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
 	mcpTools, err := a.toolConnector.GetAllTools(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tools from MCP connector: %w", err)
 	}
-	mcpTools = append(mcpTools, finishTool)
+	if len(a.config.AllowedTools) > 0 {
+		allowed := mcpTools[:0:0]
+		for _, tool := range mcpTools {
+			if a.config.IsToolAllowed(tool.Name) {
+				allowed = append(allowed, tool)
+			}
+		}
+		mcpTools = allowed
+	}
+	if health, ok := a.toolConnector.(serverHealthSpec); ok {
+		available := mcpTools[:0:0]
+		for _, tool := range mcpTools {
+			if serverID, found := a.toolConnector.GetToolServerID(tool.Name); found && !health.IsServerHealthy(serverID) {
+				a.log.Warnf("Tool `%s` hidden: server `%s` is unhealthy", tool.Name, serverID)
+				continue
+			}
+			available = append(available, tool)
+		}
+		mcpTools = available
+	}
+	mcpTools = append(mcpTools, a.finishTool)
 	var toolNames []string
 	for _, t := range mcpTools {
 		toolNames = append(toolNames, t.Name)
@@ -112,65 +207,322 @@ func (a *Agent) isFinishCommand(call types.CallToolRequest) bool {
 
 // RunSession manages the main loop of interaction with LLM and tools, returning the final answer and meta information.
 // CallDirect now simply calls RunSession and returns the result.
-func (a *Agent) RunSession(ctx context.Context, input string) (string, types.MetaInfo, error) {
+func (a *Agent) RunSession(ctx context.Context, input string, opts types.SessionOptions) (answer string, meta types.MetaInfo, err error) {
 	start := time.Now()
+	iteration := 0
+	outcome := ""
+	ctx, span := a.tracer.Start(ctx, "agent.session", tracing.KindInternal)
+	span.SetAttribute("agent.tool", a.config.Tool.Name)
+	var iterationSpan *tracing.Span
+	defer func() {
+		outcome = sessionOutcome(outcome, err)
+		iterationSpan.End()
+		span.SetAttribute("session.outcome", outcome)
+		span.SetAttribute("session.iterations", iteration)
+		span.SetAttribute("session.cost", meta.Cost)
+		if meta.SessionID != "" {
+			span.SetAttribute("session.id", meta.SessionID)
+		}
+		span.RecordError(err)
+		span.End()
+		if a.metrics != nil {
+			a.metrics.SessionFinished(a.config.Tool.Name, outcome, iteration)
+		}
+	}()
 	tools, err := a.GetAllTools(ctx)
 	if err != nil {
 		return "", types.MetaInfo{}, err
 	}
-	session, err := a.beginSession(input, tools)
+	session, sessionID, err := a.openSession(input, tools, opts)
 	if err != nil {
 		return "", types.MetaInfo{}, err
 	}
-	iteration := 0
-	var meta types.MetaInfo
+	if sessionID != "" {
+		defer a.releaseSession(sessionID)
+	}
+	session.LowerRequestBudget(opts.RequestBudget)
 	for iteration < a.config.MaxLLMIterations {
+		if ctx.Err() != nil {
+			return "", buildMeta(session, start, sessionID), a.cancelledError(ctx, iteration)
+		}
 		iteration++
-		resp, err := a.llmService.SendRequest(ctx, session.GetLLMMessages(), tools)
+		iterationSpan.End()
+		var iterationCtx context.Context
+		iterationCtx, iterationSpan = a.tracer.Start(ctx, "agent.iteration", tracing.KindInternal)
+		iterationSpan.SetAttribute("agent.iteration", iteration)
+		if err := session.Compact(iterationCtx); err != nil {
+			a.log.Warnf("Sending the request anyway: %v", err)
+		}
+		if estimate, exceeds := session.WouldExceedRequestBudget(); exceeds {
+			outcome = metrics.SessionBudgetExceeded
+			return "", buildMeta(session, start, sessionID), fmt.Errorf("request budget would be exceeded: call cost %.4f + next request ~%.4f > budget %.4f", session.CallCost(), estimate, session.RequestBudget())
+		}
+		a.reportProgress(opts, session, iteration, types.ProgressEvent{Kind: types.ProgressEventIteration})
+		resp, err := a.llmService.SendRequest(iterationCtx, session.GetLLMMessages(), tools)
 		if err != nil {
-			return "", types.MetaInfo{}, err
+			if ctx.Err() != nil || error_handling.IsCancelled(err) {
+				return "", buildMeta(session, start, sessionID), a.cancelledError(ctx, iteration)
+			}
+			// The earlier iterations were paid for, so the call still reports their cost
+			return "", buildMeta(session, start, sessionID), err
 		}
 		session.AddAssistantMessage(resp)
 		if session.ExceededRequestBudget() {
-			info := session.GetInfo()
-			return "", types.MetaInfo{
-				Tokens:     info.TotalTokens,
-				Cost:       info.TotalCost,
-				DurationMs: time.Since(start).Milliseconds(),
-			}, fmt.Errorf("exceeded request budget: total cost %.4f > budget %.4f", info.TotalCost, info.RequestBudget)
+			outcome = metrics.SessionBudgetExceeded
+			return "", buildMeta(session, start, sessionID), fmt.Errorf("exceeded request budget: call cost %.4f > budget %.4f", session.CallCost(), session.RequestBudget())
 		}
 		if len(resp.Calls) == 0 {
-			return "", types.MetaInfo{}, fmt.Errorf("LLM returned no tool calls")
+			return "", buildMeta(session, start, sessionID), fmt.Errorf("LLM returned no tool calls")
 		}
+		var rejected []types.CallToolRequest
 		for _, call := range resp.Calls {
 			if a.isFinishCommand(call) {
-				var finalMessage string
-				if args, ok := call.Params.Arguments.(map[string]interface{}); ok {
-					finalMessage, _ = args["text"].(string)
+				finalMessage, structured, err := a.parseFinalAnswer(call)
+				if err != nil {
+					// Let the LLM fix the answer in the next iteration
+					a.log.Warnf("Rejected the final answer: %v", err)
+					session.AddToolCall(call)
+					session.AddToolResult(call, mcp.NewToolResultError(fmt.Sprintf("Error: %v. Call `%s` again with a corrected answer.", err, call.ToolName())))
+					rejected = append(rejected, call)
+					continue
 				}
-				info := session.GetInfo()
-				meta = types.MetaInfo{
-					Tokens:           info.TotalTokens,
-					Cost:             info.TotalCost,
-					DurationMs:       time.Since(start).Milliseconds(),
-					PromptTokens:     resp.Metadata.Tokens.PromptTokens,
-					CompletionTokens: resp.Metadata.Tokens.CompletionTokens,
-					ReasoningTokens:  resp.Metadata.Tokens.ReasoningTokens,
+				if sessionID != "" {
+					// Keep the answer in the history so follow-up questions can refer to it
+					session.AddToolCall(call)
+					session.AddToolResult(call, mcp.NewToolResultText(finalMessage))
+					a.saveSession(sessionID, opts.Client, session)
 				}
+				meta := buildMeta(session, start, sessionID)
+				meta.PromptTokens = resp.Metadata.Tokens.PromptTokens
+				meta.CompletionTokens = resp.Metadata.Tokens.CompletionTokens
+				meta.ReasoningTokens = resp.Metadata.Tokens.ReasoningTokens
+				meta.StructuredContent = structured
 				return finalMessage, meta, nil
 			}
 		}
-		a.handleLLMToolCallRequest(ctx, resp, session, iteration)
+		if len(rejected) > 0 {
+			resp.Calls = withoutCalls(resp.Calls, rejected)
+		}
+		a.handleLLMToolCallRequest(iterationCtx, resp, session, iteration, opts)
+	}
+	outcome = metrics.SessionMaxIterations
+	return "", buildMeta(session, start, sessionID), fmt.Errorf("exceeded maximum number of LLM iterations (%d)", a.config.MaxLLMIterations)
+}
+
+// sessionOutcome classifies a finished session for the metrics, unless the outcome is already known.
+func sessionOutcome(outcome string, err error) string {
+	switch {
+	case outcome != "":
+		return outcome
+	case err == nil:
+		return metrics.SessionSuccess
+	case error_handling.IsCancelled(err):
+		return metrics.SessionCancelled
 	}
+	return metrics.SessionError
+}
+
+// withoutCalls returns calls except the excluded ones.
+func withoutCalls(calls, excluded []types.CallToolRequest) []types.CallToolRequest {
+	var kept []types.CallToolRequest
+	for _, call := range calls {
+		skip := false
+		for _, e := range excluded {
+			if call.ID == e.ID {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, call)
+		}
+	}
+	return kept
+}
+
+// cancelledError logs the cancellation and returns the error reported to the caller.
+// The conversation of a multi-turn session is left as it was saved after the previous call.
+func (a *Agent) cancelledError(ctx context.Context, iteration int) error {
+	a.log.Warnf("Session cancelled by the caller at iteration %d", iteration)
+	cause := ctx.Err()
+	if cause == nil {
+		cause = context.Canceled
+	}
+	return error_handling.WrapError(cause, "session cancelled", error_handling.ErrorCategoryCancelled)
+}
+
+// buildMeta returns the meta information for the current state of the session.
+func buildMeta(session *chat.Chat, start time.Time, sessionID string) types.MetaInfo {
 	info := session.GetInfo()
-	return "", types.MetaInfo{
+	return types.MetaInfo{
 		Tokens:     info.TotalTokens,
 		Cost:       info.TotalCost,
+		CallCost:   session.CallCost(),
 		DurationMs: time.Since(start).Milliseconds(),
-	}, fmt.Errorf("exceeded maximum number of LLM iterations (%d)", a.config.MaxLLMIterations)
+		SessionID:  sessionID,
+	}
+}
+
+// openSession returns the chat for this call and the session ID it belongs to.
+// Without a session store, or for a stateless call, it always begins a new chat and returns an empty ID.
+// With a store it restores the conversation for the given ID, or begins a new one under a fresh ID.
+func (a *Agent) openSession(input string, tools []mcp.Tool, opts types.SessionOptions) (*chat.Chat, string, error) {
+	sessionID := opts.SessionID
+	if a.sessions == nil {
+		if sessionID != "" {
+			return nil, "", fmt.Errorf("sessions are not enabled")
+		}
+		session, err := a.beginSession(input, tools, opts.Arguments)
+		return session, "", err
+	}
+	if sessionID == "" && opts.Stateless {
+		session, err := a.beginSession(input, tools, opts.Arguments)
+		return session, "", err
+	}
+	if sessionID == "" {
+		id, err := session_store.NewSessionID()
+		if err != nil {
+			return nil, "", err
+		}
+		if err := a.acquireSession(id); err != nil {
+			return nil, "", err
+		}
+		session, err := a.beginSession(input, tools, opts.Arguments)
+		if err != nil {
+			a.releaseSession(id)
+			return nil, "", err
+		}
+		return session, id, nil
+	}
+	if err := a.acquireSession(sessionID); err != nil {
+		return nil, "", err
+	}
+	state, ok, err := a.sessions.Load(sessionID)
+	if err != nil {
+		a.releaseSession(sessionID)
+		return nil, "", fmt.Errorf("failed to load session: %w", err)
+	}
+	if !ok {
+		a.releaseSession(sessionID)
+		return nil, "", fmt.Errorf("session `%s` not found or expired", sessionID)
+	}
+	if err := a.checkOwner(state, opts.Client); err != nil {
+		a.releaseSession(sessionID)
+		return nil, "", err
+	}
+	session := a.newChat()
+	session.Restore(state.Messages, state.Info)
+	session.AddUserMessage(input)
+	a.log.Infof("Continuing session `%s` with %d messages", sessionID, len(state.Messages))
+	return session, sessionID, nil
+}
+
+// saveSession stores the conversation of the client so that it can be continued by a later call.
+func (a *Agent) saveSession(sessionID string, client string, session *chat.Chat) {
+	messages, info := session.State()
+	err := a.sessions.Save(types.SessionState{
+		ID:       sessionID,
+		Tool:     a.config.Tool.Name,
+		Client:   client,
+		Messages: messages,
+		Info:     info,
+	})
+	if err != nil {
+		a.log.Errorf("failed to save session `%s`: %v", sessionID, err)
+	}
+}
+
+// acquireSession marks the session as in use, so that one conversation is never run by two calls at once.
+func (a *Agent) acquireSession(sessionID string) error {
+	return a.locks.Acquire(sessionID)
+}
+
+func (a *Agent) releaseSession(sessionID string) {
+	a.locks.Release(sessionID)
+}
+
+// checkOwner returns an error if the session was started with another tool or by another client.
+func (a *Agent) checkOwner(state types.SessionState, client string) error {
+	if state.Tool != "" && state.Tool != a.config.Tool.Name {
+		return fmt.Errorf("session `%s` belongs to tool `%s`", state.ID, state.Tool)
+	}
+	if state.Client != client {
+		// The other client is not named, so that clients cannot learn about each other
+		return fmt.Errorf("session `%s` belongs to another client", state.ID)
+	}
+	return nil
+}
+
+// EndSession deletes a saved conversation of the tool of the agent, if the client started it.
+func (a *Agent) EndSession(sessionID string, client string) error {
+	if a.sessions == nil {
+		return fmt.Errorf("sessions are not enabled")
+	}
+	if err := a.acquireSession(sessionID); err != nil {
+		return err
+	}
+	defer a.releaseSession(sessionID)
+	state, ok, err := a.sessions.Load(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	if ok {
+		if err := a.checkOwner(state, client); err != nil {
+			return err
+		}
+	}
+	if err := a.sessions.Delete(sessionID); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	a.log.Infof("Session `%s` ended", sessionID)
+	return nil
+}
+
+// LoadSession returns the saved conversation. The second value is false if it does not exist or has expired.
+func (a *Agent) LoadSession(sessionID string) (types.SessionState, bool, error) {
+	if a.sessions == nil {
+		return types.SessionState{}, false, fmt.Errorf("sessions are not enabled")
+	}
+	return a.sessions.Load(sessionID)
+}
+
+// SystemPrompt returns the system prompt of the saved session or, if sessionID is empty,
+// the one a new session would begin with, rendered with an empty input.
+func (a *Agent) SystemPrompt(ctx context.Context, sessionID string) (string, error) {
+	var messages []llms.MessageContent
+	if sessionID != "" {
+		state, ok, err := a.LoadSession(sessionID)
+		if err != nil {
+			return "", fmt.Errorf("failed to load session: %w", err)
+		}
+		if !ok {
+			return "", fmt.Errorf("session `%s` not found or expired", sessionID)
+		}
+		messages = state.Messages
+	} else {
+		tools, err := a.GetAllTools(ctx)
+		if err != nil {
+			return "", err
+		}
+		session, err := a.beginSession("", tools, nil)
+		if err != nil {
+			return "", err
+		}
+		messages, _ = session.State()
+	}
+	if len(messages) == 0 {
+		return "", fmt.Errorf("the session has no system prompt")
+	}
+	var texts []string
+	for _, part := range messages[0].Parts {
+		if text, ok := part.(llms.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
 }
 
-func (a *Agent) beginSession(userRequest string, tools []mcp.Tool) (*chat.Chat, error) {
+func (a *Agent) newChat() *chat.Chat {
 	// Create a new Chat instance for each session, passing request budget
 	var calculator calculatorSpec = nil
 	if svc, ok := a.llmService.(interface{ GetCalculator() calculatorSpec }); ok {
@@ -183,8 +535,15 @@ func (a *Agent) beginSession(userRequest string, tools []mcp.Tool) (*chat.Chat,
 		a.log,
 		calculator,
 		a.config.MaxTokens,
-		0.0, // No request budget in AgentConfig, use 0.0 (unlimited)
+		a.config.RequestBudget,
 	)
+	session.SetCompaction(a.config.Compaction, a.llmService)
+	return session
+}
+
+func (a *Agent) beginSession(userRequest string, tools []mcp.Tool, arguments map[string]any) (*chat.Chat, error) {
+	session := a.newChat()
+	session.SetArguments(a.promptArguments(arguments))
 	info := session.GetInfo()
 	a.log.Infof("Chat configured with max tokens: %d, request budget: %.4f", info.MaxTokens, info.RequestBudget)
 
@@ -195,7 +554,42 @@ func (a *Agent) beginSession(userRequest string, tools []mcp.Tool) (*chat.Chat,
 	return session, nil
 }
 
-func (a *Agent) handleLLMToolCallRequest(ctx context.Context, resp types2.LLMResponse, session *chat.Chat, _ int) {
+// promptArguments returns the prompt template values of the arguments of the input schema. Arguments left
+// out are empty, and values other than strings are rendered as JSON.
+func (a *Agent) promptArguments(arguments map[string]any) map[string]any {
+	properties := a.config.Tool.InputProperties()
+	if len(properties) == 0 {
+		return nil
+	}
+	values := make(map[string]any, len(properties))
+	for name := range properties {
+		switch value := arguments[name].(type) {
+		case nil:
+			values[name] = ""
+		case string:
+			values[name] = value
+		default:
+			// The value was decoded from JSON, so it always encodes
+			data, _ := json.Marshal(value)
+			values[name] = string(data)
+		}
+	}
+	return values
+}
+
+// reportProgress fills the event with the iteration and running totals and passes it to the session progress callback.
+func (a *Agent) reportProgress(opts types.SessionOptions, session *chat.Chat, iteration int, event types.ProgressEvent) {
+	if opts.Progress == nil {
+		return
+	}
+	info := session.GetInfo()
+	event.Iteration = iteration
+	event.Tokens = info.TotalTokens
+	event.Cost = info.TotalCost
+	opts.Progress(event)
+}
+
+func (a *Agent) handleLLMToolCallRequest(ctx context.Context, resp types2.LLMResponse, session *chat.Chat, iteration int, opts types.SessionOptions) {
 	var toolCalls []string
 	for _, call := range resp.Calls {
 		toolCalls = append(toolCalls, call.String())
@@ -204,16 +598,37 @@ func (a *Agent) handleLLMToolCallRequest(ctx context.Context, resp types2.LLMRes
 		"request_cost":     resp.Metadata.Cost,
 		"request_duration": resp.Metadata.DurationMs,
 	}).Infof("<< LLM asked to call tools:\n%s", strings.Join(toolCalls, "\n"))
+	// Calls run concurrently, but results are added to the chat in the order the LLM requested them
+	var report types.ProgressFunc
+	if opts.Progress != nil {
+		report = func(event types.ProgressEvent) {
+			a.reportProgress(opts, session, iteration, event)
+		}
+	}
+	var allowed []types.CallToolRequest
 	for _, call := range resp.Calls {
-		session.AddToolCall(call)
-		result, err := a.toolConnector.ExecuteTool(ctx, call)
-		if err != nil {
-			a.log.Errorf("failed to execute tool %s: %v", call.ToolName(), err)
-			errorResult := mcp.NewToolResultError(fmt.Sprintf("Error: %v", err))
-			session.AddToolResult(call, errorResult)
+		if a.config.IsToolAllowed(call.ToolName()) {
+			allowed = append(allowed, call)
+		}
+	}
+	outcomes := a.toolExecutor.ExecuteAll(ctx, allowed, report, opts.Approve)
+	for _, call := range resp.Calls {
+		if !a.config.IsToolAllowed(call.ToolName()) {
+			a.log.Warnf("LLM asked to call tool `%s`, which is not allowed for `%s`", call.ToolName(), a.config.Tool.Name)
+			session.AddToolCall(call)
+			session.AddToolResult(call, mcp.NewToolResultError(fmt.Sprintf("Error: tool `%s` is not available", call.ToolName())))
+			continue
+		}
+		outcome := outcomes[0]
+		outcomes = outcomes[1:]
+		session.AddToolCall(outcome.call)
+		if outcome.err != nil {
+			a.log.Errorf("failed to execute tool %s: %v", outcome.call.ToolName(), outcome.err)
+			errorResult := mcp.NewToolResultError(fmt.Sprintf("Error: %v", outcome.err))
+			session.AddToolResult(outcome.call, errorResult)
 			continue
 		}
-		session.AddToolResult(call, result)
+		session.AddToolResult(outcome.call, outcome.result)
 	}
 
 	a.log.Infof("Iteration complete: %s", dump.SDump(session.GetInfo()))
@@ -4,17 +4,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
 	types2 "github.com/korchasa/speelka-agent-go/internal/llm/types"
 
 	"github.com/korchasa/speelka-agent-go/internal/chat"
+	"github.com/korchasa/speelka-agent-go/internal/session_store"
 	"github.com/korchasa/speelka-agent-go/internal/types"
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
 )
 
@@ -29,7 +36,7 @@ func newTestLogger() *logrus.Logger {
 }
 
 func TestHandleLLMAnswerToolRequest(t *testing.T) {
-	a := NewAgent(configuration.AgentConfig{}, nil, nil, newTestLogger(), nil)
+	a := NewAgent(configuration.AgentConfig{}, nil, nil, newTestLogger(), nil, nil)
 	sess := &chat.Chat{} // Not used in this test
 	resp := types2.LLMResponse{}
 
@@ -96,6 +103,7 @@ type mockToolConnector struct {
 	getAllToolsErr error
 	tools          []mcp.Tool
 	executeToolFn  func(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error)
+	serverIDs      map[string]string
 }
 
 func (m *mockToolConnector) InitAndConnectToMCPs(ctx context.Context) error { return nil }
@@ -111,15 +119,25 @@ func (m *mockToolConnector) ExecuteTool(ctx context.Context, call types.CallTool
 	}
 	return mcp.NewToolResultText("ok"), nil
 }
+func (m *mockToolConnector) GetToolServerID(toolName string) (string, bool) {
+	if m.serverIDs != nil {
+		id, ok := m.serverIDs[toolName]
+		return id, ok
+	}
+	return "", false
+}
 func (m *mockToolConnector) Close() error { return nil }
 
 type mockLLMService struct {
 	responses []types2.LLMResponse
 	err       error
+	errAfter  error // Returned once the responses are used up
 	callIdx   int
+	requests  [][]llms.MessageContent
 }
 
 func (m *mockLLMService) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (types2.LLMResponse, error) {
+	m.requests = append(m.requests, messages)
 	if m.err != nil {
 		return types2.LLMResponse{}, m.err
 	}
@@ -128,7 +146,7 @@ func (m *mockLLMService) SendRequest(ctx context.Context, messages []llms.Messag
 		m.callIdx++
 		return resp, nil
 	}
-	return types2.LLMResponse{}, nil
+	return types2.LLMResponse{}, m.errAfter
 }
 
 func TestAgent_RunSession(t *testing.T) {
@@ -140,8 +158,9 @@ func TestAgent_RunSession(t *testing.T) {
 			&mockToolConnector{getAllToolsErr: fmt.Errorf("fail")},
 			newTestLogger(),
 			chatInstance,
+			nil,
 		)
-		_, _, err := agent.RunSession(context.Background(), "input")
+		_, _, err := agent.RunSession(context.Background(), "input", types.SessionOptions{})
 		if err == nil || err.Error() != "fail" && !strings.Contains(err.Error(), "fail") {
 			t.Errorf("expected error from GetAllTools, got %v", err)
 		}
@@ -154,12 +173,47 @@ func TestAgent_RunSession(t *testing.T) {
 			&mockToolConnector{tools: []mcp.Tool{finishTool}},
 			newTestLogger(),
 			chatInstance,
+			nil,
 		)
-		_, _, err := agent.RunSession(context.Background(), "input")
+		_, _, err := agent.RunSession(context.Background(), "input", types.SessionOptions{})
 		if err == nil || !strings.Contains(err.Error(), "llm fail") {
 			t.Errorf("expected error from LLMService, got %v", err)
 		}
 	})
+	t.Run("failures report the cost of the paid iterations", func(t *testing.T) {
+		toolTurn := newFinishResponse(t, "call-1", "")
+		toolTurn.Calls[0].Params.Name = "some_tool"
+		for name, tc := range map[string]struct {
+			responses []types2.LLMResponse
+			errAfter  error
+			error     string
+		}{
+			"LLM error":     {[]types2.LLMResponse{toolTurn}, fmt.Errorf("llm fail"), "llm fail"},
+			"no tool calls": {[]types2.LLMResponse{toolTurn, {Text: "no calls", Metadata: types2.LLMResponseMetadata{Tokens: types2.LLMResponseTokensMetadata{TotalTokens: 5}, Cost: 0.02}}}, nil, "LLM returned no tool calls"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				agent := NewAgent(
+					configuration.AgentConfig{MaxLLMIterations: 3},
+					&mockLLMService{responses: tc.responses, errAfter: tc.errAfter},
+					&mockToolConnector{tools: []mcp.Tool{finishTool}},
+					newTestLogger(),
+					nil,
+					nil,
+				)
+				_, meta, err := agent.RunSession(context.Background(), "input", types.SessionOptions{})
+				if err == nil || !strings.Contains(err.Error(), tc.error) {
+					t.Fatalf("expected %q, got %v", tc.error, err)
+				}
+				var want float64
+				for _, resp := range tc.responses {
+					want += resp.Metadata.Cost
+				}
+				if math.Abs(meta.CallCost-want) > 1e-9 || meta.Cost != meta.CallCost || meta.Tokens == 0 {
+					t.Errorf("expected the cost %.4f of the paid responses, got %+v", want, meta)
+				}
+			})
+		}
+	})
 	t.Run("exceed max iterations", func(t *testing.T) {
 		chatInstance := chat.NewChat("model", "prompt", "arg", newTestLogger(), nil, 10, 0.0)
 		llmCall := llms.ToolCall{
@@ -180,8 +234,9 @@ func TestAgent_RunSession(t *testing.T) {
 			&mockToolConnector{tools: []mcp.Tool{finishTool}},
 			newTestLogger(),
 			chatInstance,
+			nil,
 		)
-		_, _, err = agent.RunSession(context.Background(), "input")
+		_, _, err = agent.RunSession(context.Background(), "input", types.SessionOptions{})
 		if err == nil || !strings.Contains(err.Error(), "exceeded maximum number of LLM iterations") {
 			t.Errorf("expected max iterations error, got %v", err)
 		}
@@ -214,8 +269,9 @@ func TestAgent_RunSession(t *testing.T) {
 			&mockToolConnector{tools: []mcp.Tool{finishTool}},
 			newTestLogger(),
 			chatInstance,
+			nil,
 		)
-		msg, meta, err := agent.RunSession(context.Background(), "input")
+		msg, meta, err := agent.RunSession(context.Background(), "input", types.SessionOptions{})
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -229,3 +285,604 @@ func TestAgent_RunSession(t *testing.T) {
 }
 
 // --- END: Unit tests for CallDirect and RunSession ---
+
+func TestAgent_RunSession_Progress(t *testing.T) {
+	toolCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-1",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: "lookup", Arguments: `{}`},
+	})
+	if err != nil {
+		t.Fatalf("failed to create CallToolRequest: %v", err)
+	}
+	finishCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-2",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: finishTool.Name, Arguments: `{"text": "done"}`},
+	})
+	if err != nil {
+		t.Fatalf("failed to create CallToolRequest: %v", err)
+	}
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 3},
+		&mockLLMService{responses: []types2.LLMResponse{
+			{Calls: []types.CallToolRequest{toolCall}},
+			{Calls: []types.CallToolRequest{finishCall}},
+		}},
+		&mockToolConnector{tools: []mcp.Tool{finishTool}},
+		newTestLogger(),
+		nil,
+		nil,
+	)
+	var mu sync.Mutex
+	var events []types.ProgressEvent
+	opts := types.SessionOptions{Progress: func(event types.ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}}
+	answer, _, err := agent.RunSession(context.Background(), "input", opts)
+	if err != nil || answer != "done" {
+		t.Fatalf("unexpected result: %q, %v", answer, err)
+	}
+	expected := []types.ProgressEvent{
+		{Kind: types.ProgressEventIteration, Iteration: 1},
+		{Kind: types.ProgressEventToolCall, Iteration: 1, ToolName: "lookup"},
+		{Kind: types.ProgressEventToolResult, Iteration: 1, ToolName: "lookup"},
+		{Kind: types.ProgressEventIteration, Iteration: 2},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.Kind != e.Kind || got.Iteration != e.Iteration || got.ToolName != e.ToolName {
+			t.Errorf("event %d: expected %+v, got %+v", i, e, got)
+		}
+	}
+	if args, ok := events[1].Arguments.(map[string]any); !ok || len(args) != 0 {
+		t.Errorf("expected the arguments of the call, got %#v", events[1].Arguments)
+	}
+	if events[2].Result != "ok" {
+		t.Errorf("expected the text of the result, got %q", events[2].Result)
+	}
+}
+
+func newFinishResponse(t *testing.T, id, text string) types2.LLMResponse {
+	call, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           id,
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: finishTool.Name, Arguments: fmt.Sprintf(`{"text": %q}`, text)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create CallToolRequest: %v", err)
+	}
+	return types2.LLMResponse{
+		Calls:    []types.CallToolRequest{call},
+		Metadata: types2.LLMResponseMetadata{Tokens: types2.LLMResponseTokensMetadata{TotalTokens: 10}, Cost: 0.01},
+	}
+}
+
+func TestAgent_RunSession_Sessions(t *testing.T) {
+	llm := &mockLLMService{responses: []types2.LLMResponse{
+		newFinishResponse(t, "call-1", "Paris"),
+		newFinishResponse(t, "call-2", "About 2 million"),
+	}}
+	store := session_store.NewMemoryStore(time.Hour)
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 2, SystemPromptTemplate: "{{input}}", Tool: configuration.MCPServerToolConfig{ArgumentName: "input"}},
+		llm,
+		&mockToolConnector{},
+		newTestLogger(),
+		nil,
+		store,
+	)
+
+	answer, meta, err := agent.RunSession(context.Background(), "Capital of France?", types.SessionOptions{})
+	if err != nil || answer != "Paris" {
+		t.Fatalf("unexpected first result: %q, %v", answer, err)
+	}
+	if meta.SessionID == "" {
+		t.Fatalf("expected session id in meta")
+	}
+
+	answer, meta2, err := agent.RunSession(context.Background(), "Its population?", types.SessionOptions{SessionID: meta.SessionID})
+	if err != nil || answer != "About 2 million" {
+		t.Fatalf("unexpected second result: %q, %v", answer, err)
+	}
+	if meta2.SessionID != meta.SessionID {
+		t.Errorf("expected the same session id, got %q", meta2.SessionID)
+	}
+	if meta2.Tokens <= meta.Tokens || meta2.Cost <= meta.Cost {
+		t.Errorf("expected counters to accumulate across turns: %+v then %+v", meta, meta2)
+	}
+	second := llm.requests[1]
+	if len(second) != 5 {
+		t.Fatalf("expected system, assistant, finish call, finish result and follow-up messages, got %d", len(second))
+	}
+	if resp, ok := second[3].Parts[0].(llms.ToolCallResponse); !ok || !strings.Contains(resp.Content, "Paris") {
+		t.Errorf("expected previous answer in history, got %+v", second[3])
+	}
+	if second[4].Role != llms.ChatMessageTypeHuman {
+		t.Errorf("expected follow-up user message, got %s", second[4].Role)
+	}
+
+	if err := agent.EndSession(meta.SessionID, ""); err != nil {
+		t.Fatalf("unexpected error ending session: %v", err)
+	}
+	if _, _, err := agent.RunSession(context.Background(), "again", types.SessionOptions{SessionID: meta.SessionID}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error for ended session, got %v", err)
+	}
+}
+
+// countingStore counts the saved sessions.
+type countingStore struct {
+	*session_store.MemoryStore
+	saves int
+}
+
+func (s *countingStore) Save(state types.SessionState) error {
+	s.saves++
+	return s.MemoryStore.Save(state)
+}
+
+func TestAgent_RunSession_Stateless(t *testing.T) {
+	llm := &mockLLMService{responses: []types2.LLMResponse{newFinishResponse(t, "call-1", "Paris")}}
+	store := &countingStore{MemoryStore: session_store.NewMemoryStore(time.Hour)}
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 2, SystemPromptTemplate: "{{input}}", Tool: configuration.MCPServerToolConfig{ArgumentName: "input"}},
+		llm,
+		&mockToolConnector{},
+		newTestLogger(),
+		nil,
+		store,
+	)
+
+	answer, meta, err := agent.RunSession(context.Background(), "Capital of France?", types.SessionOptions{Stateless: true})
+	if err != nil || answer != "Paris" {
+		t.Fatalf("unexpected result: %q, %v", answer, err)
+	}
+	if meta.SessionID != "" || store.saves != 0 {
+		t.Errorf("expected no saved session, got id %q and %d saves", meta.SessionID, store.saves)
+	}
+}
+
+func TestAgent_RunSession_SessionClient(t *testing.T) {
+	store := session_store.NewMemoryStore(time.Hour)
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 2, SystemPromptTemplate: "{{input}}", Tool: configuration.MCPServerToolConfig{Name: "answer", ArgumentName: "input"}},
+		&mockLLMService{responses: []types2.LLMResponse{
+			newFinishResponse(t, "call-1", "Paris"),
+			newFinishResponse(t, "call-2", "About 2 million"),
+		}},
+		&mockToolConnector{},
+		newTestLogger(),
+		nil,
+		store,
+	)
+
+	_, meta, err := agent.RunSession(context.Background(), "Capital of France?", types.SessionOptions{Client: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state, _, _ := store.Load(meta.SessionID); state.Client != "alice" {
+		t.Errorf("expected the client to be saved, got %q", state.Client)
+	}
+	for _, client := range []string{"bob", ""} {
+		if _, _, err := agent.RunSession(context.Background(), "Continue", types.SessionOptions{SessionID: meta.SessionID, Client: client}); err == nil || !strings.Contains(err.Error(), "belongs to another client") {
+			t.Errorf("expected client %q not to continue the session, got %v", client, err)
+		}
+		if err := agent.EndSession(meta.SessionID, client); err == nil || !strings.Contains(err.Error(), "belongs to another client") {
+			t.Errorf("expected client %q not to end the session, got %v", client, err)
+		}
+	}
+	if _, _, err := agent.RunSession(context.Background(), "Its population?", types.SessionOptions{SessionID: meta.SessionID, Client: "alice"}); err != nil {
+		t.Errorf("expected the client to continue its session, got %v", err)
+	}
+	if err := agent.EndSession(meta.SessionID, "alice"); err != nil {
+		t.Errorf("expected the client to end its session, got %v", err)
+	}
+}
+
+func TestAgent_RunSession_SessionOwner(t *testing.T) {
+	store := session_store.NewMemoryStore(time.Hour)
+	locks := session_store.NewLocks()
+	newToolAgent := func(tool string, llm *mockLLMService) *Agent {
+		ag := NewAgent(
+			configuration.AgentConfig{MaxLLMIterations: 2, SystemPromptTemplate: "{{input}}", Tool: configuration.MCPServerToolConfig{Name: tool, ArgumentName: "input"}},
+			llm,
+			&mockToolConnector{},
+			newTestLogger(),
+			nil,
+			store,
+		)
+		ag.SetSessionLocks(locks)
+		return ag
+	}
+	answer := newToolAgent("answer", &mockLLMService{responses: []types2.LLMResponse{
+		newFinishResponse(t, "call-1", "Paris"),
+		newFinishResponse(t, "call-2", "About 2 million"),
+	}})
+	classify := newToolAgent("classify", &mockLLMService{})
+
+	_, meta, err := answer.RunSession(context.Background(), "Capital of France?", types.SessionOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state, _, _ := store.Load(meta.SessionID)
+	if state.Tool != "answer" {
+		t.Errorf("expected the owner to be saved, got %q", state.Tool)
+	}
+	if _, _, err := classify.RunSession(context.Background(), "Continue", types.SessionOptions{SessionID: meta.SessionID}); err == nil || !strings.Contains(err.Error(), "belongs to tool `answer`") {
+		t.Errorf("expected another tool not to continue the session, got %v", err)
+	}
+	if err := classify.EndSession(meta.SessionID, ""); err == nil || !strings.Contains(err.Error(), "belongs to tool `answer`") {
+		t.Errorf("expected another tool not to end the session, got %v", err)
+	}
+
+	// A session in use by one tool is busy for all of them
+	if err := locks.Acquire(meta.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := classify.EndSession(meta.SessionID, ""); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("expected a busy session, got %v", err)
+	}
+	locks.Release(meta.SessionID)
+
+	if _, _, err := answer.RunSession(context.Background(), "Its population?", types.SessionOptions{SessionID: meta.SessionID}); err != nil {
+		t.Errorf("expected the owner to continue the session, got %v", err)
+	}
+	if err := answer.EndSession(meta.SessionID, ""); err != nil {
+		t.Errorf("expected the owner to end the session, got %v", err)
+	}
+}
+
+func TestAgent_SystemPrompt(t *testing.T) {
+	store := session_store.NewMemoryStore(time.Hour)
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 1, SystemPromptTemplate: "Answer: {{input}}", Tool: configuration.MCPServerToolConfig{ArgumentName: "input"}},
+		&mockLLMService{responses: []types2.LLMResponse{newFinishResponse(t, "call-1", "Paris")}},
+		&mockToolConnector{},
+		newTestLogger(),
+		nil,
+		store,
+	)
+	prompt, err := agent.SystemPrompt(context.Background(), "")
+	if err != nil || prompt != "Answer: " {
+		t.Fatalf("unexpected prompt of a new session: %q, %v", prompt, err)
+	}
+
+	_, meta, err := agent.RunSession(context.Background(), "Capital of France?", types.SessionOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prompt, err = agent.SystemPrompt(context.Background(), meta.SessionID)
+	if err != nil || prompt != "Answer: Capital of France?" {
+		t.Errorf("unexpected prompt of the session: %q, %v", prompt, err)
+	}
+	state, ok, err := agent.LoadSession(meta.SessionID)
+	if err != nil || !ok || len(state.Messages) != 4 {
+		t.Errorf("expected the saved session with 4 messages, got %d, %v, %v", len(state.Messages), ok, err)
+	}
+	if _, err := agent.SystemPrompt(context.Background(), "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestAgent_RunSession_SessionsDisabled(t *testing.T) {
+	agent := NewAgent(configuration.AgentConfig{MaxLLMIterations: 1}, &mockLLMService{}, &mockToolConnector{}, newTestLogger(), nil, nil)
+	if _, _, err := agent.RunSession(context.Background(), "input", types.SessionOptions{SessionID: "abc"}); err == nil {
+		t.Errorf("expected error when sessions are disabled")
+	}
+	if err := agent.EndSession("abc", ""); err == nil {
+		t.Errorf("expected error when sessions are disabled")
+	}
+}
+
+func TestAgent_RunSession_Cancelled(t *testing.T) {
+	toolCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-1",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: "slow", Arguments: `{}`},
+	})
+	if err != nil {
+		t.Fatalf("failed to create CallToolRequest: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	llm := &mockLLMService{responses: []types2.LLMResponse{{
+		Calls:    []types.CallToolRequest{toolCall},
+		Metadata: types2.LLMResponseMetadata{Tokens: types2.LLMResponseTokensMetadata{TotalTokens: 10}, Cost: 0.01},
+	}}}
+	conn := &mockToolConnector{executeToolFn: func(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	agent := NewAgent(configuration.AgentConfig{MaxLLMIterations: 5}, llm, conn, newTestLogger(), nil, nil)
+
+	_, meta, err := agent.RunSession(ctx, "input", types.SessionOptions{})
+	if !error_handling.IsCancelled(err) {
+		t.Fatalf("expected cancelled error, got %v", err)
+	}
+	if len(llm.requests) != 1 {
+		t.Errorf("expected the loop to stop after the first iteration, got %d LLM requests", len(llm.requests))
+	}
+	if meta.Cost != 0.01 || meta.Tokens == 0 {
+		t.Errorf("expected partial meta of the first iteration, got %+v", meta)
+	}
+}
+
+func TestAgent_RunSession_RequestBudget(t *testing.T) {
+	someCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-1",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: "some_tool", Arguments: `{}`},
+	})
+	require.NoError(t, err)
+	expensive := types2.LLMResponse{Calls: []types.CallToolRequest{someCall}}
+	expensive.Metadata.Cost = 0.5
+	expensive.Metadata.Tokens.TotalTokens = 1000
+	expensive.Metadata.Tokens.CompletionTokens = 100
+
+	newAgent := func(budget float64, llm *mockLLMService) *Agent {
+		return NewAgent(
+			configuration.AgentConfig{Model: "gpt-4o", MaxLLMIterations: 5, RequestBudget: budget},
+			llm,
+			&mockToolConnector{tools: []mcp.Tool{finishTool}},
+			newTestLogger(),
+			nil,
+			nil,
+		)
+	}
+
+	t.Run("configured budget is enforced", func(t *testing.T) {
+		llm := &mockLLMService{responses: []types2.LLMResponse{expensive, expensive}}
+		_, meta, err := newAgent(0.4, llm).RunSession(context.Background(), "input", types.SessionOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeded request budget")
+		assert.Len(t, llm.requests, 1)
+		assert.InDelta(t, 0.5, meta.Cost, 1e-9)
+	})
+
+	t.Run("pre-flight check stops before the request", func(t *testing.T) {
+		llm := &mockLLMService{responses: []types2.LLMResponse{expensive, expensive}}
+		// The first request costs 0.5, the estimate of the second is tiny but still over the budget
+		_, _, err := newAgent(0.5, llm).RunSession(context.Background(), "input", types.SessionOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "request budget would be exceeded")
+		assert.Len(t, llm.requests, 1)
+	})
+
+	t.Run("per-call budget lowers but never raises", func(t *testing.T) {
+		llm := &mockLLMService{responses: []types2.LLMResponse{expensive, expensive}}
+		_, _, err := newAgent(0, llm).RunSession(context.Background(), "input", types.SessionOptions{RequestBudget: 0.4})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeded request budget")
+
+		llm = &mockLLMService{responses: []types2.LLMResponse{expensive, expensive}}
+		_, _, err = newAgent(0.4, llm).RunSession(context.Background(), "input", types.SessionOptions{RequestBudget: 10})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeded request budget")
+		assert.Len(t, llm.requests, 1)
+	})
+
+	t.Run("budgets apply to the call on a resumed session", func(t *testing.T) {
+		first := newFinishResponse(t, "call-1", "Paris")
+		first.Metadata.Cost = 0.5
+		second := newFinishResponse(t, "call-2", "About 2 million")
+		second.Metadata.Cost = 0.05
+		store := session_store.NewMemoryStore(time.Hour)
+		ag := NewAgent(
+			configuration.AgentConfig{MaxLLMIterations: 2, RequestBudget: 0.6, SystemPromptTemplate: "{{input}}", Tool: configuration.MCPServerToolConfig{ArgumentName: "input"}},
+			&mockLLMService{responses: []types2.LLMResponse{first, second}},
+			&mockToolConnector{},
+			newTestLogger(),
+			nil,
+			store,
+		)
+		_, meta, err := ag.RunSession(context.Background(), "Capital of France?", types.SessionOptions{RequestBudget: 0.55})
+		require.NoError(t, err)
+
+		_, meta, err = ag.RunSession(context.Background(), "Its population?", types.SessionOptions{SessionID: meta.SessionID, RequestBudget: 0.1})
+		require.NoError(t, err, "the cost of earlier calls does not count against the budget of this one")
+		assert.InDelta(t, 0.55, meta.Cost, 1e-9)
+		state, _, err := store.Load(meta.SessionID)
+		require.NoError(t, err)
+		assert.Equal(t, 0.6, state.Info.RequestBudget, "the budget of one call is not saved with the session")
+	})
+}
+
+func TestAgent_RunSession_OutputSchema(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"title":    map[string]any{"type": "string"},
+			"severity": map[string]any{"type": "string", "enum": []any{"low", "high"}},
+		},
+		"required": []any{"title", "severity"},
+	}
+	newFinish := func(id, args string) types2.LLMResponse {
+		call, err := types.NewCallToolRequest(llms.ToolCall{
+			ID:           id,
+			Type:         "function",
+			FunctionCall: &llms.FunctionCall{Name: finishTool.Name, Arguments: args},
+		})
+		require.NoError(t, err)
+		return types2.LLMResponse{Calls: []types.CallToolRequest{call}}
+	}
+	llm := &mockLLMService{responses: []types2.LLMResponse{
+		newFinish("call-1", `{"title": "Disk full", "severity": "medium"}`),
+		newFinish("call-2", `{"title": "Disk full", "severity": "high"}`),
+	}}
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 3, Tool: configuration.MCPServerToolConfig{OutputSchema: schema}},
+		llm,
+		&mockToolConnector{},
+		newTestLogger(),
+		nil,
+		nil,
+	)
+
+	tools, err := agent.GetAllTools(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tools, 1)
+	assert.Equal(t, []string{"title", "severity"}, tools[0].InputSchema.Required)
+	assert.Contains(t, tools[0].InputSchema.Properties, "severity")
+
+	answer, meta, err := agent.RunSession(context.Background(), "input", types.SessionOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"title": "Disk full", "severity": "high"}, meta.StructuredContent)
+	assert.JSONEq(t, `{"title": "Disk full", "severity": "high"}`, answer)
+
+	require.Len(t, llm.requests, 2)
+	retry := llm.requests[1]
+	feedback := retry[len(retry)-1].Parts[0].(llms.ToolCallResponse).Content
+	assert.Contains(t, feedback, "does not match the output schema")
+	assert.Contains(t, feedback, "$.severity: must be one of [low high]")
+}
+
+func TestAgent_AllowedTools(t *testing.T) {
+	readCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-1",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: "read_file", Arguments: `{}`},
+	})
+	require.NoError(t, err)
+	writeCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-2",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: "write_file", Arguments: `{}`},
+	})
+	require.NoError(t, err)
+
+	var executed []string
+	connector := &mockToolConnector{
+		tools: []mcp.Tool{mcp.NewTool("read_file"), mcp.NewTool("write_file")},
+		executeToolFn: func(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
+			executed = append(executed, call.ToolName())
+			return mcp.NewToolResultText("contents"), nil
+		},
+	}
+	llm := &mockLLMService{responses: []types2.LLMResponse{
+		{Calls: []types.CallToolRequest{writeCall, readCall}},
+		newFinishResponse(t, "call-3", "done"),
+	}}
+	agent := NewAgent(
+		configuration.AgentConfig{MaxLLMIterations: 3, AllowedTools: []string{"read_file"}},
+		llm,
+		connector,
+		newTestLogger(),
+		nil,
+		nil,
+	)
+
+	tools, err := agent.GetAllTools(context.Background())
+	require.NoError(t, err)
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	assert.Equal(t, []string{"read_file", finishTool.Name}, names)
+
+	_, _, err = agent.RunSession(context.Background(), "input", types.SessionOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read_file"}, executed)
+
+	// Results follow the order of the calls: the rejected write first, then the read
+	history := llm.requests[1]
+	rejected := history[len(history)-3].Parts[0].(llms.ToolCallResponse)
+	assert.Equal(t, "call-2", rejected.ToolCallID)
+	assert.Contains(t, rejected.Content, "tool `write_file` is not available")
+	read := history[len(history)-1].Parts[0].(llms.ToolCallResponse)
+	assert.Equal(t, "call-1", read.ToolCallID)
+}
+
+type healthAwareConnector struct {
+	mockToolConnector
+	unhealthy map[string]bool
+}
+
+func (h *healthAwareConnector) IsServerHealthy(serverID string) bool { return !h.unhealthy[serverID] }
+
+func TestAgent_GetAllTools_HidesUnhealthyServers(t *testing.T) {
+	connector := &healthAwareConnector{
+		mockToolConnector: mockToolConnector{
+			tools:     []mcp.Tool{mcp.NewTool("read_file"), mcp.NewTool("search")},
+			serverIDs: map[string]string{"read_file": "fs", "search": "web"},
+		},
+		unhealthy: map[string]bool{"web": true},
+	}
+	agent := NewAgent(configuration.AgentConfig{}, &mockLLMService{}, connector, newTestLogger(), nil, nil)
+
+	tools, err := agent.GetAllTools(context.Background())
+	require.NoError(t, err)
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	assert.Equal(t, []string{"read_file", finishTool.Name}, names)
+}
+
+func TestAgent_promptArguments(t *testing.T) {
+	cfg := configuration.AgentConfig{}
+	cfg.Tool.InputSchema = map[string]any{"type": "object", "properties": map[string]any{
+		"team":   map[string]any{"type": "string"},
+		"labels": map[string]any{"type": "array"},
+		"urgent": map[string]any{"type": "boolean"},
+	}}
+	agent := NewAgent(cfg, &mockLLMService{}, &mockToolConnector{}, newTestLogger(), nil, nil)
+
+	values := agent.promptArguments(map[string]any{"team": "billing", "labels": []any{"a", "b"}})
+	assert.Equal(t, map[string]any{"team": "billing", "labels": `["a","b"]`, "urgent": ""}, values)
+	assert.Nil(t, NewAgent(configuration.AgentConfig{}, nil, nil, newTestLogger(), nil, nil).promptArguments(nil))
+}
+
+// recordingMetrics records the sessions reported by the agent.
+type recordingMetrics struct {
+	sessions []string
+}
+
+func (r *recordingMetrics) SessionFinished(tool, outcome string, iterations int) {
+	r.sessions = append(r.sessions, fmt.Sprintf("%s:%s:%d", tool, outcome, iterations))
+}
+
+func TestAgent_RunSession_Metrics(t *testing.T) {
+	someCall, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "call-1",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: "some_tool", Arguments: `{}`},
+	})
+	require.NoError(t, err)
+	toolTurn := types2.LLMResponse{Calls: []types.CallToolRequest{someCall}}
+	expensive := toolTurn
+	expensive.Metadata.Cost = 1
+	expensive.Metadata.Tokens.TotalTokens = 1000
+	expensive.Metadata.Tokens.CompletionTokens = 100
+
+	cases := []struct {
+		name      string
+		responses []types2.LLMResponse
+		llmErr    error
+		budget    float64
+		expected  string
+	}{
+		{"success", []types2.LLMResponse{toolTurn, newFinishResponse(t, "call-2", "done")}, nil, 0, "process:success:2"},
+		{"max iterations", []types2.LLMResponse{toolTurn, toolTurn, toolTurn}, nil, 0, "process:max_iterations:3"},
+		{"budget", []types2.LLMResponse{expensive}, nil, 0.5, "process:budget_exceeded:1"},
+		{"error", nil, fmt.Errorf("boom"), 0, "process:error:1"},
+		{"cancelled", nil, context.Canceled, 0, "process:cancelled:1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := configuration.AgentConfig{Model: "gpt-4o", MaxLLMIterations: 3, RequestBudget: tc.budget}
+			cfg.Tool.Name = "process"
+			llm := &mockLLMService{responses: tc.responses, err: tc.llmErr}
+			agent := NewAgent(cfg, llm, &mockToolConnector{tools: []mcp.Tool{finishTool}}, newTestLogger(), nil, nil)
+			m := &recordingMetrics{}
+			agent.SetMetrics(m)
+
+			_, _, _ = agent.RunSession(context.Background(), "input", types.SessionOptions{})
+			assert.Equal(t, []string{tc.expected}, m.sessions)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/korchasa/speelka-agent-go/internal/utils/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// buildFinishTool returns the finish tool for the configured output schema.
+// Without a schema it is the free-text finishTool; with one, the arguments of the call are the answer itself.
+// Keywords of the schema root other than properties and required are only enforced by parseFinalAnswer.
+func buildFinishTool(outputSchema map[string]any) mcp.Tool {
+	if outputSchema == nil {
+		return finishTool
+	}
+	tool := mcp.NewTool(
+		finishTool.Name,
+		mcp.WithDescription("Use this tool to answer the user and finish the session. The arguments are the final answer and must match the input schema."),
+	)
+	if props, ok := outputSchema["properties"].(map[string]any); ok {
+		tool.InputSchema.Properties = props
+	}
+	if required, ok := outputSchema["required"].([]any); ok {
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				tool.InputSchema.Required = append(tool.InputSchema.Required, s)
+			}
+		}
+	}
+	return tool
+}
+
+// parseFinalAnswer extracts the final answer from a call to the finish tool.
+// With an output schema the arguments are validated and returned as the structured answer, together with
+// their JSON rendering as the text answer. An error describes what the LLM has to fix.
+func (a *Agent) parseFinalAnswer(call types.CallToolRequest) (string, map[string]any, error) {
+	args, _ := call.Params.Arguments.(map[string]interface{})
+	schema := a.config.Tool.OutputSchema
+	if schema == nil {
+		text, _ := args["text"].(string)
+		return text, nil, nil
+	}
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	if violations := jsonschema.Validate(schema, args); len(violations) > 0 {
+		return "", nil, fmt.Errorf("the answer does not match the output schema:\n- %s", strings.Join(violations, "\n- "))
+	}
+	text, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render the answer: %w", err)
+	}
+	return string(text), args, nil
+}
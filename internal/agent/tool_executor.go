@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+)
+
+// toolCallOutcome holds the result of a single tool call executed by toolExecutor.
+type toolCallOutcome struct {
+	call   types.CallToolRequest
+	result *mcp.CallToolResult
+	err    error
+}
+
+// ToolCallSlots limits the concurrent tool calls of all the agents that share it.
+// Responsibility: Holding the global and per-server tool call limits of the process
+// Features: Servers marked as sequential get a single slot, so they are never called concurrently,
+// whichever tools of the process the calls come from
+type ToolCallSlots struct {
+	global         chan struct{}
+	perServerLimit int
+	sequential     map[string]bool
+	servers        map[string]chan struct{}
+	mu             sync.Mutex
+}
+
+// NewToolCallSlots creates the slots for the given limits.
+func NewToolCallSlots(cfg configuration.ToolCallsConfig) *ToolCallSlots {
+	maxParallel := cfg.MaxParallel
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	perServer := cfg.MaxParallelPerServer
+	if perServer < 1 || perServer > maxParallel {
+		perServer = maxParallel
+	}
+	sequential := make(map[string]bool, len(cfg.SequentialServers))
+	for _, id := range cfg.SequentialServers {
+		sequential[id] = true
+	}
+	return &ToolCallSlots{
+		global:         make(chan struct{}, maxParallel),
+		perServerLimit: perServer,
+		sequential:     sequential,
+		servers:        make(map[string]chan struct{}),
+	}
+}
+
+// server returns the semaphore limiting concurrent calls to the server, creating it on first use.
+func (s *ToolCallSlots) server(serverID string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sem, ok := s.servers[serverID]
+	if !ok {
+		limit := s.perServerLimit
+		if s.sequential[serverID] {
+			limit = 1
+		}
+		sem = make(chan struct{}, limit)
+		s.servers[serverID] = sem
+	}
+	return sem
+}
+
+// toolExecutor runs the tool calls requested in one LLM turn with bounded concurrency.
+// Responsibility: Executing independent tool calls in parallel
+// Features: Global and per-server limits of ToolCallSlots, shared by all sessions of the agent and, in server mode,
+// by the agents of all tools; calls are checked against the approval policy first
+// and the calls of one turn ask for approval one at a time
+type toolExecutor struct {
+	connector toolConnectorSpec
+	slots     *ToolCallSlots
+	approval  configuration.ApprovalConfig
+	log       *logrus.Logger
+}
+
+// newToolExecutor creates a toolExecutor with its own slots for the given limits and approval policies.
+func newToolExecutor(connector toolConnectorSpec, cfg configuration.ToolCallsConfig, approval configuration.ApprovalConfig, log *logrus.Logger) *toolExecutor {
+	return &toolExecutor{
+		connector: connector,
+		slots:     NewToolCallSlots(cfg),
+		approval:  approval,
+		log:       log,
+	}
+}
+
+// ExecuteAll executes the calls and returns their outcomes in the order of the calls.
+// If report is not nil, it is called when each call starts and finishes.
+// approve decides on calls that require approval; if it is nil, such calls are rejected. The calls ask
+// approve one at a time; calls of other sessions, which pass their own approve, are not held up.
+func (e *toolExecutor) ExecuteAll(ctx context.Context, calls []types.CallToolRequest, report types.ProgressFunc, approve types.ApprovalFunc) []toolCallOutcome {
+	outcomes := make([]toolCallOutcome, len(calls))
+	// A session runs its turns one after another, so this is one approval question at a time per session
+	approvals := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		outcomes[i].call = call
+		wg.Add(1)
+		go func(i int, call types.CallToolRequest) {
+			defer wg.Done()
+			outcomes[i].result, outcomes[i].err = e.execute(ctx, call, report, approve, approvals)
+		}(i, call)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// execute runs one call once it is approved and both the global and the server slots are acquired.
+// approvals is held while asking approve.
+func (e *toolExecutor) execute(ctx context.Context, call types.CallToolRequest, report types.ProgressFunc, approve types.ApprovalFunc, approvals chan struct{}) (*mcp.CallToolResult, error) {
+	serverID, _ := e.connector.GetToolServerID(call.ToolName())
+	// Approval comes first, so a call waiting for a human does not hold slots of other calls
+	if rejected := e.checkApproval(ctx, serverID, call, report, approve, approvals); rejected != nil {
+		return rejected, nil
+	}
+	serverSem := e.slots.server(serverID)
+	if err := acquire(ctx, serverSem); err != nil {
+		return nil, fmt.Errorf("waiting for server `%s` slot: %w", serverID, err)
+	}
+	defer release(serverSem)
+	if err := acquire(ctx, e.slots.global); err != nil {
+		return nil, fmt.Errorf("waiting for tool call slot: %w", err)
+	}
+	defer release(e.slots.global)
+
+	e.log.Debugf("Executing tool `%s` on server `%s`", call.ToolName(), serverID)
+	if report != nil {
+		report(types.ProgressEvent{Kind: types.ProgressEventToolCall, ToolName: call.ToolName(), Arguments: call.Params.Arguments})
+	}
+	result, err := e.connector.ExecuteTool(ctx, call)
+	if report != nil {
+		report(types.ProgressEvent{
+			Kind:     types.ProgressEventToolResult,
+			ToolName: call.ToolName(),
+			IsError:  err != nil || (result != nil && result.IsError),
+			Result:   resultText(result, err),
+		})
+	}
+	return result, err
+}
+
+// resultText returns the text content of a tool result, or the error of a failed call.
+func resultText(result *mcp.CallToolResult, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if result == nil {
+		return ""
+	}
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := mcp.AsTextContent(content); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// checkApproval applies the approval policy of the tool. It returns the result to put in the chat
+// instead of calling the tool, or nil if the call may run.
+func (e *toolExecutor) checkApproval(ctx context.Context, serverID string, call types.CallToolRequest, report types.ProgressFunc, approve types.ApprovalFunc, approvals chan struct{}) *mcp.CallToolResult {
+	switch e.approval.Policy(serverID, call.ToolName()) {
+	case configuration.ApprovalDeny:
+		e.log.Warnf("Call of tool `%s` denied by the approval policy", call.ToolName())
+		return mcp.NewToolResultError(fmt.Sprintf("Calling `%s` is not allowed by the approval policy. The call was not made.", call.ToolName()))
+	case configuration.ApprovalRequire:
+	default:
+		return nil
+	}
+	if approve == nil {
+		e.log.Warnf("Call of tool `%s` requires approval, but there is no one to ask", call.ToolName())
+		return mcp.NewToolResultError(fmt.Sprintf("Calling `%s` requires approval, but no approval channel is available. The call was not made.", call.ToolName()))
+	}
+	if report != nil {
+		report(types.ProgressEvent{Kind: types.ProgressEventApproval, ToolName: call.ToolName()})
+	}
+	if err := acquire(ctx, approvals); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Approval of `%s` was interrupted: %v. The call was not made.", call.ToolName(), err))
+	}
+	decision, err := approve(ctx, types.ApprovalRequest{
+		ServerID:  serverID,
+		ToolName:  call.ToolName(),
+		Arguments: call.Params.Arguments,
+	})
+	release(approvals)
+	if err != nil {
+		e.log.Errorf("Failed to get approval for tool `%s`: %v", call.ToolName(), err)
+		return mcp.NewToolResultError(fmt.Sprintf("Approval of `%s` failed: %v. The call was not made.", call.ToolName(), err))
+	}
+	if !decision.Approved {
+		e.log.Infof("Call of tool `%s` rejected: %s", call.ToolName(), decision.Reason)
+		text := fmt.Sprintf("The user rejected the call of `%s`.", call.ToolName())
+		if decision.Reason != "" {
+			text += " Reason: " + decision.Reason
+		}
+		return mcp.NewToolResultError(text)
+	}
+	e.log.Infof("Call of tool `%s` approved", call.ToolName())
+	return nil
+}
+
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func release(sem chan struct{}) {
+	<-sem
+}
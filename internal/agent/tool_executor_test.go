@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// concurrencyTracker records the maximum number of simultaneous calls overall and per server.
+type concurrencyTracker struct {
+	mu           sync.Mutex
+	current      int
+	max          int
+	serverCur    map[string]int
+	serverMax    map[string]int
+	toolToServer map[string]string
+}
+
+func newConcurrencyTracker(toolToServer map[string]string) *concurrencyTracker {
+	return &concurrencyTracker{
+		serverCur:    map[string]int{},
+		serverMax:    map[string]int{},
+		toolToServer: toolToServer,
+	}
+}
+
+func (c *concurrencyTracker) execute(_ context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv := c.toolToServer[call.ToolName()]
+	c.mu.Lock()
+	c.current++
+	c.serverCur[srv]++
+	if c.current > c.max {
+		c.max = c.current
+	}
+	if c.serverCur[srv] > c.serverMax[srv] {
+		c.serverMax[srv] = c.serverCur[srv]
+	}
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	c.current--
+	c.serverCur[srv]--
+	c.mu.Unlock()
+	if call.ToolName() == "broken" {
+		return nil, fmt.Errorf("tool failed")
+	}
+	return mcp.NewToolResultText("result of " + call.ID), nil
+}
+
+func newTestCall(t *testing.T, id, tool string) types.CallToolRequest {
+	call, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           id,
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: tool, Arguments: `{}`},
+	})
+	require.NoError(t, err)
+	return call
+}
+
+func TestToolExecutor_ExecuteAll(t *testing.T) {
+	servers := map[string]string{"a1": "a", "a2": "a", "a3": "a", "b1": "b", "b2": "b", "broken": "b"}
+
+	t.Run("keeps original order", func(t *testing.T) {
+		tracker := newConcurrencyTracker(servers)
+		conn := &mockToolConnector{executeToolFn: tracker.execute, serverIDs: servers}
+		exec := newToolExecutor(conn, configuration.ToolCallsConfig{MaxParallel: 4, MaxParallelPerServer: 2}, configuration.ApprovalConfig{}, newTestLogger())
+		calls := []types.CallToolRequest{
+			newTestCall(t, "1", "a1"),
+			newTestCall(t, "2", "broken"),
+			newTestCall(t, "3", "b1"),
+			newTestCall(t, "4", "a2"),
+		}
+		outcomes := exec.ExecuteAll(context.Background(), calls, nil, nil)
+		require.Len(t, outcomes, 4)
+		for i, o := range outcomes {
+			assert.Equal(t, calls[i].ID, o.call.ID)
+		}
+		assert.Error(t, outcomes[1].err)
+		assert.Equal(t, "result of 3", outcomes[2].result.Content[0].(mcp.TextContent).Text)
+	})
+
+	t.Run("respects global and per-server limits", func(t *testing.T) {
+		tracker := newConcurrencyTracker(servers)
+		conn := &mockToolConnector{executeToolFn: tracker.execute, serverIDs: servers}
+		exec := newToolExecutor(conn, configuration.ToolCallsConfig{MaxParallel: 3, MaxParallelPerServer: 2}, configuration.ApprovalConfig{}, newTestLogger())
+		var calls []types.CallToolRequest
+		for i, tool := range []string{"a1", "a2", "a3", "b1", "b2", "a1", "b1"} {
+			calls = append(calls, newTestCall(t, fmt.Sprint(i), tool))
+		}
+		exec.ExecuteAll(context.Background(), calls, nil, nil)
+		assert.LessOrEqual(t, tracker.max, 3)
+		assert.Greater(t, tracker.max, 1)
+		assert.LessOrEqual(t, tracker.serverMax["a"], 2)
+		assert.LessOrEqual(t, tracker.serverMax["b"], 2)
+	})
+
+	t.Run("sequential server", func(t *testing.T) {
+		tracker := newConcurrencyTracker(servers)
+		conn := &mockToolConnector{executeToolFn: tracker.execute, serverIDs: servers}
+		exec := newToolExecutor(conn, configuration.ToolCallsConfig{
+			MaxParallel:          4,
+			MaxParallelPerServer: 4,
+			SequentialServers:    []string{"a"},
+		}, configuration.ApprovalConfig{}, newTestLogger())
+		calls := []types.CallToolRequest{
+			newTestCall(t, "1", "a1"),
+			newTestCall(t, "2", "a2"),
+			newTestCall(t, "3", "a3"),
+		}
+		exec.ExecuteAll(context.Background(), calls, nil, nil)
+		assert.Equal(t, 1, tracker.serverMax["a"])
+	})
+
+	t.Run("shared slots hold across executors", func(t *testing.T) {
+		tracker := newConcurrencyTracker(servers)
+		conn := &mockToolConnector{executeToolFn: tracker.execute, serverIDs: servers}
+		cfg := configuration.ToolCallsConfig{MaxParallel: 4, MaxParallelPerServer: 4, SequentialServers: []string{"a"}}
+		slots := NewToolCallSlots(cfg)
+		first := newToolExecutor(conn, cfg, configuration.ApprovalConfig{}, newTestLogger())
+		second := newToolExecutor(conn, cfg, configuration.ApprovalConfig{}, newTestLogger())
+		first.slots, second.slots = slots, slots
+		var wg sync.WaitGroup
+		for _, exec := range []*toolExecutor{first, second} {
+			wg.Add(1)
+			go func(exec *toolExecutor) {
+				defer wg.Done()
+				exec.ExecuteAll(context.Background(), []types.CallToolRequest{newTestCall(t, "1", "a1"), newTestCall(t, "2", "a2")}, nil, nil)
+			}(exec)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, tracker.serverMax["a"], "the agents of several tools never call a sequential server concurrently")
+	})
+
+	t.Run("zero config runs sequentially", func(t *testing.T) {
+		tracker := newConcurrencyTracker(servers)
+		conn := &mockToolConnector{executeToolFn: tracker.execute, serverIDs: servers}
+		exec := newToolExecutor(conn, configuration.ToolCallsConfig{}, configuration.ApprovalConfig{}, newTestLogger())
+		calls := []types.CallToolRequest{
+			newTestCall(t, "1", "a1"),
+			newTestCall(t, "2", "b1"),
+		}
+		exec.ExecuteAll(context.Background(), calls, nil, nil)
+		assert.Equal(t, 1, tracker.max)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		conn := &mockToolConnector{serverIDs: servers}
+		exec := newToolExecutor(conn, configuration.ToolCallsConfig{MaxParallel: 1}, configuration.ApprovalConfig{}, newTestLogger())
+		exec.slots.global <- struct{}{} // occupy the only slot
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		outcomes := exec.ExecuteAll(ctx, []types.CallToolRequest{newTestCall(t, "1", "a1")}, nil, nil)
+		assert.ErrorIs(t, outcomes[0].err, context.Canceled)
+	})
+}
+
+func TestToolExecutor_Approval(t *testing.T) {
+	servers := map[string]string{"read": "fs", "write": "fs", "delete": "fs"}
+	approval := configuration.ApprovalConfig{Servers: map[string]map[string]string{
+		"fs": {"write": configuration.ApprovalRequire, "delete": configuration.ApprovalDeny},
+	}}
+	resultText := func(o toolCallOutcome) string {
+		require.NotNil(t, o.result)
+		return o.result.Content[0].(mcp.TextContent).Text
+	}
+	newExecutor := func() (*toolExecutor, *concurrencyTracker) {
+		tracker := newConcurrencyTracker(servers)
+		conn := &mockToolConnector{executeToolFn: tracker.execute, serverIDs: servers}
+		return newToolExecutor(conn, configuration.ToolCallsConfig{MaxParallel: 4}, approval, newTestLogger()), tracker
+	}
+
+	t.Run("auto and deny", func(t *testing.T) {
+		exec, _ := newExecutor()
+		outcomes := exec.ExecuteAll(context.Background(), []types.CallToolRequest{
+			newTestCall(t, "1", "read"),
+			newTestCall(t, "2", "delete"),
+		}, nil, nil)
+		assert.Equal(t, "result of 1", resultText(outcomes[0]))
+		assert.True(t, outcomes[1].result.IsError)
+		assert.Contains(t, resultText(outcomes[1]), "not allowed by the approval policy")
+	})
+
+	t.Run("no approval channel", func(t *testing.T) {
+		exec, _ := newExecutor()
+		outcomes := exec.ExecuteAll(context.Background(), []types.CallToolRequest{newTestCall(t, "1", "write")}, nil, nil)
+		assert.True(t, outcomes[0].result.IsError)
+		assert.Contains(t, resultText(outcomes[0]), "no approval channel")
+	})
+
+	t.Run("approved", func(t *testing.T) {
+		exec, _ := newExecutor()
+		var asked []types.ApprovalRequest
+		var events []types.ProgressEventKind
+		var mu sync.Mutex
+		approve := func(_ context.Context, req types.ApprovalRequest) (types.ApprovalDecision, error) {
+			asked = append(asked, req)
+			return types.ApprovalDecision{Approved: true}, nil
+		}
+		report := func(e types.ProgressEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e.Kind)
+		}
+		outcomes := exec.ExecuteAll(context.Background(), []types.CallToolRequest{newTestCall(t, "1", "write")}, report, approve)
+		assert.Equal(t, "result of 1", resultText(outcomes[0]))
+		require.Len(t, asked, 1)
+		assert.Equal(t, "fs", asked[0].ServerID)
+		assert.Equal(t, "write", asked[0].ToolName)
+		assert.Equal(t, []types.ProgressEventKind{types.ProgressEventApproval, types.ProgressEventToolCall, types.ProgressEventToolResult}, events)
+	})
+
+	t.Run("rejected with reason", func(t *testing.T) {
+		exec, tracker := newExecutor()
+		approve := func(context.Context, types.ApprovalRequest) (types.ApprovalDecision, error) {
+			return types.ApprovalDecision{Approved: false, Reason: "use the staging directory"}, nil
+		}
+		outcomes := exec.ExecuteAll(context.Background(), []types.CallToolRequest{newTestCall(t, "1", "write")}, nil, approve)
+		assert.True(t, outcomes[0].result.IsError)
+		assert.Contains(t, resultText(outcomes[0]), "rejected")
+		assert.Contains(t, resultText(outcomes[0]), "use the staging directory")
+		assert.Equal(t, 0, tracker.max, "a rejected call must not reach the server")
+	})
+
+	t.Run("one question at a time per session", func(t *testing.T) {
+		exec, _ := newExecutor()
+		var mu sync.Mutex
+		asking, maxAsking := 0, 0
+		// Both sessions must be asking at once before the first answer comes
+		bothAsked := make(chan struct{})
+		var once sync.Once
+		newApprove := func() types.ApprovalFunc {
+			return func(ctx context.Context, _ types.ApprovalRequest) (types.ApprovalDecision, error) {
+				mu.Lock()
+				asking++
+				maxAsking = max(maxAsking, asking)
+				if asking == 2 {
+					once.Do(func() { close(bothAsked) })
+				}
+				mu.Unlock()
+				select {
+				case <-bothAsked:
+				case <-time.After(time.Second):
+				}
+				mu.Lock()
+				asking--
+				mu.Unlock()
+				return types.ApprovalDecision{Approved: true}, nil
+			}
+		}
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				exec.ExecuteAll(context.Background(), []types.CallToolRequest{
+					newTestCall(t, "1", "write"),
+					newTestCall(t, "2", "write"),
+				}, nil, newApprove())
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 2, maxAsking, "one question per session, not one per agent")
+	})
+
+	t.Run("approval error", func(t *testing.T) {
+		exec, _ := newExecutor()
+		approve := func(context.Context, types.ApprovalRequest) (types.ApprovalDecision, error) {
+			return types.ApprovalDecision{}, fmt.Errorf("hook crashed")
+		}
+		outcomes := exec.ExecuteAll(context.Background(), []types.CallToolRequest{newTestCall(t, "1", "write")}, nil, approve)
+		assert.NoError(t, outcomes[0].err)
+		assert.Contains(t, resultText(outcomes[0]), "hook crashed")
+	})
+}
@@ -4,26 +4,58 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/agent"
+	"github.com/korchasa/speelka-agent-go/internal/approval"
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/cassette"
 	"github.com/korchasa/speelka-agent-go/internal/chat"
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
 	"github.com/korchasa/speelka-agent-go/internal/llm"
 	"github.com/korchasa/speelka-agent-go/internal/llm/cost"
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
 	"github.com/korchasa/speelka-agent-go/internal/mcp_connector"
 	"github.com/korchasa/speelka-agent-go/internal/mcp_server"
+	"github.com/korchasa/speelka-agent-go/internal/metrics"
+	"github.com/korchasa/speelka-agent-go/internal/quota"
+	"github.com/korchasa/speelka-agent-go/internal/session_store"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
 	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/korchasa/speelka-agent-go/internal/utils/jsonschema"
+	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/sirupsen/logrus"
+	"github.com/tmc/langchaingo/llms"
 )
 
+// errorTypeCancelled is the error type reported when the caller cancels the session.
+const errorTypeCancelled = "cancelled"
+
+// errorTypeLimitExceeded is the error type reported when a quota or rate limit rejects a call.
+const errorTypeLimitExceeded = "limit_exceeded"
+
+// tracerShutdownTimeout bounds the export of the remaining spans on exit.
+const tracerShutdownTimeout = 5 * time.Second
+
 // MCPApp is responsible for instantiating and managing the Agent and its dependencies
 // (for server/daemon mode)
 type MCPApp struct {
-	cfg       *configuration.Configuration
-	agent     agentSpec
-	mcpServer *mcp_server.MCPServer
-	logger    *logrus.Logger
+	cfg          *configuration.Configuration
+	agent        agentSpec                                          // Agent of the first tool, used by direct calls
+	agents       map[string]agentSpec                               // Agents by the name of the tool they serve
+	sessions     sessionStoreSpec                                   // Store shared by the agents, nil if sessions are disabled
+	newAgent     func(configuration.AgentConfig) (agentSpec, error) // Builds more agents on the same backends, for the REPL
+	llmService   func(model string) (llmServiceSpec, error)         // LLM service of the model, shared with the agents
+	mcpServer    *mcp_server.MCPServer
+	approvalHook *approval.CommandHook
+	limiter      *quota.Limiter   // Limits of the MCP calls, nil if none are configured
+	metrics      *metrics.Metrics // Metrics of the agent, nil if the endpoint is disabled
+	tracer       *tracing.Tracer  // Exports spans, nil if tracing is disabled
+	logger       *logrus.Logger
 }
 
 // NewMCPApp creates a new instance of MCPApp with the given logger and configuration
@@ -36,26 +68,82 @@ func NewMCPApp(logger *logrus.Logger, cfg *configuration.Configuration) (*MCPApp
 }
 
 type agentSpec interface {
-	RunSession(ctx context.Context, input string) (string, types.MetaInfo, error)
+	RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error)
+	EndSession(sessionID string, client string) error
+}
+
+// llmServiceSpec represents the LLM service passed to the agent.
+type llmServiceSpec interface {
+	SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error)
+}
+
+// toolConnectorSpec represents the MCP connections passed to the agent.
+type toolConnectorSpec interface {
+	InitAndConnectToMCPs(ctx context.Context) error
+	ConnectServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error)
+	GetAllTools(ctx context.Context) ([]mcp.Tool, error)
+	ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error)
+	GetToolServerID(toolName string) (string, bool)
+	Close() error
+}
+
+// sessionStoreSpec represents the storage for multi-turn sessions passed to the agent.
+type sessionStoreSpec interface {
+	Load(id string) (types.SessionState, bool, error)
+	Save(state types.SessionState) error
+	Delete(id string) error
 }
 
 // Initialize creates and initializes all components needed by the Agent
 func (a *MCPApp) Initialize(ctx context.Context) error {
-	ag, err := buildAgent(ctx, a.cfg, a.logger)
+	if a.cfg.GetMCPServerConfig().Metrics.Enabled {
+		a.metrics = metrics.New()
+	}
+	if tracingCfg := a.cfg.GetTracingConfig(); tracingCfg.Enabled() {
+		tracer, err := tracing.NewTracer(tracingCfg, a.logger)
+		if err != nil {
+			return fmt.Errorf("failed to create tracer: %w", err)
+		}
+		a.tracer = tracer
+		a.logger.Infof("Exporting traces of service `%s` with the `%s` exporter", tracingCfg.ServiceName, tracingCfg.Exporter)
+	}
+	agents, builder, err := buildAgents(ctx, a.cfg, a.metrics, a.tracer, a.logger)
 	if err != nil {
 		return fmt.Errorf("failed to initialize agent and server: %w", err)
 	}
-	a.agent = ag
+	a.agents = agents
+	a.sessions = builder.sessions
+	a.newAgent = builder.newAgent
+	a.llmService = builder.llmService
+	a.agent = agents[a.cfg.GetAgentConfigs()[0].Tool.Name]
+	if hookCfg := a.cfg.GetApprovalHookConfig(); hookCfg.Enabled() {
+		a.approvalHook = approval.NewCommandHook(hookCfg, a.logger)
+		a.logger.Infof("Approval hook `%s` configured", hookCfg.Command)
+	}
 	return nil
 }
 
-// Start starts the Agent in daemon or stdio mode
+// Start serves the Agent over the enabled transports until ctx is done
 func (a *MCPApp) Start(ctx context.Context) (err error) {
+	defer a.shutdownTracer()
 	a.mcpServer, err = mcp_server.NewMCPServer(a.cfg.GetMCPServerConfig(), a.logger)
 	if err != nil {
 		return fmt.Errorf("failed to create MCP server: %w", err)
 	}
 	a.logger.Info("MCPServer instance created (server mode)")
+	if a.metrics != nil {
+		a.mcpServer.SetMetricsHandler(a.metrics.Handler())
+	}
+	if serverCfg := a.cfg.GetMCPServerConfig(); serverCfg.APIsEnabled() {
+		a.mcpServer.SetAPIHandler(a.apiHandler(serverCfg))
+	}
+	if limitsCfg := a.cfg.GetLimitsConfig(); limitsCfg.Enabled() {
+		if a.limiter, err = quota.NewLimiter(limitsCfg, a.logger); err != nil {
+			return fmt.Errorf("failed to create limiter: %w", err)
+		}
+		a.logger.Infof("Limits configured: %d concurrent sessions, %g requests per minute and $%.2f a day per client",
+			limitsCfg.MaxConcurrentSessions, limitsCfg.RequestsPerMinute, limitsCfg.DailySpend)
+	}
 	if err = a.mcpServer.Serve(ctx, a.dispatchMCPCall); err != nil {
 		return fmt.Errorf("failed to serve mcp server: %w", err)
 	}
@@ -76,41 +164,76 @@ func (a *MCPApp) ExecuteDirectCall(ctx context.Context, input string) (types.Dir
 	if a.agent == nil {
 		return a.outputErrorAndExit("config", fmt.Errorf("agent not initialized"))
 	}
-	result := a.handleDirectCall(ctx, input)
+	result := a.handleDirectCall(ctx, a.agent, input, types.SessionOptions{})
+	a.shutdownTracer()
 	if result.Success {
 		return result, 0, nil
 	}
-	switch result.Error.Type {
-	case "user", "config":
-		return result, 1, errors.New(result.Error.Message)
+	return result, exitCode(result), errors.New(result.Error.Message)
+}
+
+// exitCode returns the exit code of a direct call: 0 on success, 1 for user and configuration errors, 2 otherwise.
+func exitCode(result types.DirectCallResult) int {
+	switch {
+	case result.Success:
+		return 0
+	case result.Error.Type == "user" || result.Error.Type == "config":
+		return 1
 	default:
-		return result, 2, errors.New(result.Error.Message)
+		return 2
+	}
+}
+
+// shutdownTracer exports the remaining spans.
+func (a *MCPApp) shutdownTracer() {
+	ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+	defer cancel()
+	if err := a.tracer.Shutdown(ctx); err != nil {
+		a.logger.Warnf("Failed to export the remaining spans: %v", err)
 	}
 }
 
-// handleDirectCall executes the direct call on the initialized agent.
-func (a *MCPApp) handleDirectCall(ctx context.Context, input string) types.DirectCallResult {
-	answer, meta, err := a.agent.RunSession(ctx, input)
+// handleDirectCall runs a session of the agent and renders its result as JSON output.
+// It serves the direct call mode and the REST API. The meta is kept on errors, so that a cancelled session
+// reports what it used up.
+func (a *MCPApp) handleDirectCall(ctx context.Context, ag agentSpec, input string, opts types.SessionOptions) types.DirectCallResult {
+	opts.Approve = a.approver(ctx)
+	answer, meta, err := ag.RunSession(ctx, input, opts)
 	res := types.DirectCallResult{
 		Success: err == nil,
 		Result:  map[string]any{"answer": answer},
 		Meta:    meta,
 		Error:   types.DirectCallError{},
 	}
+	if meta.StructuredContent != nil {
+		res.Result["structured_content"] = meta.StructuredContent
+	}
 	if err != nil {
 		res.Success = false
 		res.Result = map[string]any{"answer": ""}
-		res.Error = types.DirectCallError{Type: "internal", Message: err.Error()}
+		res.Error = types.DirectCallError{Type: errorType(err), Message: err.Error()}
 	}
 	return res
 }
 
 func (a *MCPApp) dispatchMCPCall(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	toolName := req.Params.Name
-	argName := a.cfg.GetAgentConfig().Tool.ArgumentName
-	if err := validateToolName(toolName, a.cfg); err != nil {
+	if toolName == mcp_server.EndSessionToolName {
+		return a.dispatchEndSession(ctx, req), nil
+	}
+	ag, agentConfig, err := a.agentForTool(toolName)
+	if err != nil {
 		return mcp.NewToolResultError(err.Error()), nil
 	}
+	if req.Params.Meta != nil {
+		// Join the trace of the caller, e.g. an agent that calls this one
+		ctx = tracing.Extract(ctx, req.Params.Meta.AdditionalFields)
+	}
+	client := auth.ClientFromContext(ctx)
+	if client != "" {
+		a.logger.Infof("Tool `%s` called by client `%s`", toolName, client)
+	}
+	argName := agentConfig.Tool.ArgumentName
 	args, ok := req.Params.Arguments.(map[string]interface{})
 	if !ok {
 		return mcp.NewToolResultError("arguments is not a map"), nil
@@ -119,11 +242,118 @@ func (a *MCPApp) dispatchMCPCall(ctx context.Context, req mcp.CallToolRequest) (
 	if err != nil {
 		return mcp.NewToolResultError(err.Error()), nil
 	}
-	answer, _, err := a.agent.RunSession(ctx, userInput)
+	sessionID, err := extractSessionID(req)
 	if err != nil {
 		return mcp.NewToolResultError(err.Error()), nil
 	}
-	return mcp.NewToolResultText(answer), nil
+	budget, err := extractRequestBudget(args)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	opts, err := a.sessionOptions(ctx, agentConfig.Tool, args, sessionID, budget)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if a.mcpServer != nil {
+		opts.Progress = newMCPProgressReporter(ctx, req, a.mcpServer, a.logger)
+	}
+	done, err := a.admit(ctx, client, agentConfig.RequestBudget, &opts)
+	if err != nil {
+		a.logger.Warnf("Tool `%s` call rejected: %v", toolName, err)
+		return limitResult(err), nil
+	}
+	var meta types.MetaInfo
+	// The ledger gets the cost of this call; meta.Cost of a continued session includes the earlier ones
+	defer func() { done(meta.CallCost) }()
+	answer, meta, err := ag.RunSession(ctx, userInput, opts)
+	if err != nil {
+		result := mcp.NewToolResultError(err.Error())
+		if error_handling.IsCancelled(err) {
+			// The client is no longer waiting for the answer, but may still log what the call has used up
+			result.Meta = map[string]any{"errorType": errorTypeCancelled, "meta": meta}
+		}
+		return result, nil
+	}
+	result := mcp.NewToolResultText(answer)
+	if meta.StructuredContent != nil {
+		result.Meta = map[string]any{mcp_server.StructuredContentMetaKey: meta.StructuredContent}
+	}
+	if meta.SessionID != "" {
+		if result.Meta == nil {
+			result.Meta = map[string]any{}
+		}
+		result.Meta["sessionId"] = meta.SessionID
+		result.Content = append(result.Content, mcp.NewTextContent(
+			fmt.Sprintf("Session ID: %s (pass it as `%s` to continue the conversation)", meta.SessionID, mcp_server.SessionIDArgumentName),
+		))
+	}
+	return result, nil
+}
+
+// admit checks the limits of the client before a session and lowers its request budget to what the client
+// has left for today. configured is the request budget of the agent, which the per-call budget of opts can only lower.
+// The returned function releases the call with the cost of the session.
+func (a *MCPApp) admit(ctx context.Context, client string, configured float64, opts *types.SessionOptions) (func(cost float64), error) {
+	if a.limiter == nil {
+		return func(float64) {}, nil
+	}
+	budget := configured
+	if opts.RequestBudget > 0 && (budget <= 0 || opts.RequestBudget < budget) {
+		budget = opts.RequestBudget
+	}
+	ticket, err := a.limiter.Acquire(ctx, client, budget)
+	if err != nil {
+		return nil, err
+	}
+	// A session may not cost more than the client has left for today, less what its running calls may still spend
+	if ticket.Remaining > 0 {
+		opts.RequestBudget = ticket.Remaining
+	}
+	return ticket.Done, nil
+}
+
+// sessionOwner returns the agent of the tool that started the session, or the agent of the first tool
+// if the session is unknown or has no recorded owner. The agent checks the owner again before ending it.
+func (a *MCPApp) sessionOwner(sessionID string) agentSpec {
+	if a.sessions == nil {
+		return a.agent
+	}
+	state, ok, err := a.sessions.Load(sessionID)
+	if err != nil || !ok || state.Tool == "" {
+		return a.agent
+	}
+	if ag, ok := a.agents[state.Tool]; ok {
+		return ag
+	}
+	return a.agent
+}
+
+// limitResult reports a call that was not admitted, so that clients can tell it from a failed session and retry later.
+func limitResult(err error) *mcp.CallToolResult {
+	result := mcp.NewToolResultError(err.Error())
+	var limitErr *quota.LimitError
+	if errors.As(err, &limitErr) {
+		result.Meta = map[string]any{"errorType": errorTypeLimitExceeded, "limit": limitErr.Limit}
+		if limitErr.RetryAfter > 0 {
+			result.Meta["retryAfterSeconds"] = math.Ceil(limitErr.RetryAfter.Seconds())
+		}
+	}
+	return result
+}
+
+// dispatchEndSession handles a call to the end session tool.
+func (a *MCPApp) dispatchEndSession(ctx context.Context, req mcp.CallToolRequest) *mcp.CallToolResult {
+	sessionID, err := extractSessionID(req)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error())
+	}
+	if sessionID == "" {
+		return mcp.NewToolResultError(fmt.Sprintf("missing argument: %s", mcp_server.SessionIDArgumentName))
+	}
+	if err := a.sessionOwner(sessionID).EndSession(sessionID, auth.ClientFromContext(ctx)); err != nil {
+		return mcp.NewToolResultError(err.Error())
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("Session %s ended", sessionID))
 }
 
 // outputErrorAndExit prepares a JSON error result and code, does not exit.
@@ -134,19 +364,28 @@ func (a *MCPApp) outputErrorAndExit(errType string, err error) (types.DirectCall
 		Meta:    types.MetaInfo{},
 		Error:   types.DirectCallError{Type: errType, Message: err.Error()},
 	}
-	code := 2
-	if errType == "user" || errType == "config" {
-		code = 1
+	return result, exitCode(result), err
+}
+
+// errorType returns the DirectCallError type for an error returned by the agent.
+func errorType(err error) string {
+	if error_handling.IsCancelled(err) {
+		return errorTypeCancelled
 	}
-	return result, code, err
+	return "internal"
 }
 
-func validateToolName(toolName string, cfg *configuration.Configuration) error {
-	expected := cfg.GetAgentConfig().Tool.Name
-	if toolName != expected {
-		return fmt.Errorf("invalid tool name: %s", toolName)
+// agentForTool returns the agent serving the tool and its configuration.
+func (a *MCPApp) agentForTool(toolName string) (agentSpec, configuration.AgentConfig, error) {
+	for _, agentConfig := range a.cfg.GetAgentConfigs() {
+		if agentConfig.Tool.Name != toolName {
+			continue
+		}
+		if ag, ok := a.agents[toolName]; ok {
+			return ag, agentConfig, nil
+		}
 	}
-	return nil
+	return nil, configuration.AgentConfig{}, fmt.Errorf("invalid tool name: %s", toolName)
 }
 
 func extractUserInput(arguments map[string]interface{}, argName string) (string, error) {
@@ -164,59 +403,240 @@ func extractUserInput(arguments map[string]interface{}, argName string) (string,
 	return userInput, nil
 }
 
-func buildDirectCallResult(answer string, meta types.MetaInfo, err error) types.DirectCallResult {
+// sessionOptions builds the options of a session of the tool for the client of ctx, with the arguments of its
+// input schema validated against the schema. The MCP tool, the REST API and batch mode all build them here.
+func (a *MCPApp) sessionOptions(ctx context.Context, tool configuration.MCPServerToolConfig, arguments map[string]any, sessionID string, budget float64) (types.SessionOptions, error) {
+	values, err := extractArguments(arguments, tool)
 	if err != nil {
-		return types.DirectCallResult{
-			Success: false,
-			Result:  map[string]any{"answer": ""},
-			Meta:    types.MetaInfo{},
-			Error:   types.DirectCallError{Type: "internal", Message: err.Error()},
+		return types.SessionOptions{}, err
+	}
+	return types.SessionOptions{
+		SessionID:     sessionID,
+		Client:        auth.ClientFromContext(ctx),
+		RequestBudget: budget,
+		Arguments:     values,
+		Approve:       a.approver(ctx),
+	}, nil
+}
+
+// extractArguments returns the arguments of the input schema of the tool, validated against the schema.
+func extractArguments(arguments map[string]interface{}, tool configuration.MCPServerToolConfig) (map[string]any, error) {
+	if tool.InputSchema == nil {
+		return nil, nil
+	}
+	values := make(map[string]any)
+	for name := range tool.InputProperties() {
+		if value, ok := arguments[name]; ok && value != nil {
+			values[name] = value
 		}
 	}
-	return types.DirectCallResult{
-		Success: true,
-		Result:  map[string]any{"answer": answer},
-		Meta:    meta,
-		Error:   types.DirectCallError{},
+	if violations := jsonschema.Validate(tool.InputSchema, values); len(violations) > 0 {
+		return nil, fmt.Errorf("invalid arguments: %s", strings.Join(violations, "; "))
 	}
+	return values, nil
 }
 
-// buildAgent creates an agent and MCPServer for server/daemon mode.
-func buildAgent(ctx context.Context, cfg *configuration.Configuration, log *logrus.Logger) (agentSpec, error) {
-	llmService, err := llm.NewLLMService(cfg.GetLLMConfig(), log)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create LLM service: %w", err)
+// extractSessionID returns the session ID from the tool arguments or, if absent there, from `_meta.sessionId`.
+func extractSessionID(req mcp.CallToolRequest) (string, error) {
+	if value, ok := req.GetArguments()[mcp_server.SessionIDArgumentName]; ok && value != nil {
+		sessionID, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("invalid %s argument type: expected string, got %T", mcp_server.SessionIDArgumentName, value)
+		}
+		return sessionID, nil
+	}
+	if req.Params.Meta != nil {
+		if sessionID, ok := req.Params.Meta.AdditionalFields["sessionId"].(string); ok {
+			return sessionID, nil
+		}
 	}
-	log.Info("LLM service instance created (server mode)")
+	return "", nil
+}
 
-	// MCP ToolConnector for server mode
-	toolConnector := mcp_connector.NewMCPConnector(cfg.GetMCPConnectorConfig(), log)
-	log.Info("ToolConnector instance created (server mode)")
+// extractRequestBudget returns the per-call budget from the tool arguments, or 0 if it is not given.
+func extractRequestBudget(arguments map[string]interface{}) (float64, error) {
+	value, ok := arguments[mcp_server.BudgetArgumentName]
+	if !ok || value == nil {
+		return 0, nil
+	}
+	budget, ok := value.(float64)
+	if !ok || budget <= 0 {
+		return 0, fmt.Errorf("invalid %s argument: expected a positive number, got %v", mcp_server.BudgetArgumentName, value)
+	}
+	return budget, nil
+}
+
+// agentBuilder builds agents that share the MCP connections, the tool call limits, the session store and locks
+// and one LLM service per model.
+// It is not safe for concurrent use.
+type agentBuilder struct {
+	llmConfig     configuration.LLMConfig
+	newLLMService func(configuration.LLMConfig) (llmServiceSpec, error)
+	llmServices   map[string]llmServiceSpec
+	toolConnector toolConnectorSpec
+	sessions      sessionStoreSpec
+	sessionLocks  *session_store.Locks
+	toolCallSlots *agent.ToolCallSlots
+	calculator    *cost.Calculator
+	metrics       *metrics.Metrics
+	tracer        *tracing.Tracer
+	log           *logrus.Logger
+}
+
+// buildAgents creates the agent of each exposed tool for server/daemon mode.
+// The agents share the MCP connections, the tool call limits, the session store and one LLM service per model.
+// If m is not nil, the agents, LLM services and MCP connections record their metrics to it; a nil tracer
+// disables tracing. The returned builder makes further agents and LLM services on the same backends.
+func buildAgents(ctx context.Context, cfg *configuration.Configuration, m *metrics.Metrics, tracer *tracing.Tracer, log *logrus.Logger) (map[string]agentSpec, *agentBuilder, error) {
+	toolConnector, newLLMService, err := buildBackends(cfg.GetCassetteConfig(), cfg.GetMCPConnectorConfig(), m, tracer, log)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// initialization of MCP connections and loading tools
 	if err := toolConnector.InitAndConnectToMCPs(ctx); err != nil {
-		return nil, fmt.Errorf("failed to initialize MCP connections: %w", err)
+		return nil, nil, fmt.Errorf("failed to initialize MCP connections: %w", err)
+	}
+
+	sessions, err := buildSessionStore(cfg.GetSessionStoreConfig())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create session store: %w", err)
+	}
+
+	builder := &agentBuilder{
+		llmConfig:     cfg.GetLLMConfig(),
+		newLLMService: newLLMService,
+		llmServices:   make(map[string]llmServiceSpec),
+		toolConnector: toolConnector,
+		sessions:      sessions,
+		sessionLocks:  session_store.NewLocks(),
+		toolCallSlots: agent.NewToolCallSlots(cfg.GetAgentConfig().ToolCalls),
+		calculator:    cost.NewCalculator(),
+		metrics:       m,
+		tracer:        tracer,
+		log:           log,
 	}
+	agents := make(map[string]agentSpec)
+	for _, agentConfig := range cfg.GetAgentConfigs() {
+		ag, err := builder.newAgent(agentConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		agents[agentConfig.Tool.Name] = ag
+		log.Infof("Agent instance created for tool `%s` (server mode)", agentConfig.Tool.Name)
+	}
+	return agents, builder, nil
+}
 
-	agentConfig := cfg.GetAgentConfig()
-	calculator := cost.NewCalculator()
+// llmService returns the LLM service of the model, creating it on first use.
+func (b *agentBuilder) llmService(model string) (llmServiceSpec, error) {
+	if svc, ok := b.llmServices[model]; ok {
+		return svc, nil
+	}
+	llmConfig := b.llmConfig
+	llmConfig.Model = model
+	svc, err := b.newLLMService(llmConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LLM service for model `%s`: %w", model, err)
+	}
+	b.llmServices[model] = svc
+	b.log.Infof("LLM service instance created for model `%s` (server mode)", model)
+	return svc, nil
+}
+
+// newAgent creates an agent with the given configuration.
+func (b *agentBuilder) newAgent(agentConfig configuration.AgentConfig) (agentSpec, error) {
+	llmService, err := b.llmService(agentConfig.Model)
+	if err != nil {
+		return nil, err
+	}
 	chatInstance := chat.NewChat(
 		agentConfig.Model,
 		agentConfig.SystemPromptTemplate,
 		agentConfig.Tool.ArgumentName,
-		log,
-		calculator,
+		b.log,
+		b.calculator,
 		agentConfig.MaxTokens,
-		0.0,
+		agentConfig.RequestBudget,
 	)
 	ag := agent.NewAgent(
 		agentConfig,
 		llmService,
-		toolConnector,
-		log,
+		b.toolConnector,
+		b.log,
 		chatInstance,
+		b.sessions,
 	)
-	log.Info("Agent instance created (server mode)")
-
+	// A session is locked across the tools, so that another tool cannot run it at the same time
+	ag.SetSessionLocks(b.sessionLocks)
+	// Sequential servers and the global limits hold across the tools, which call the same MCP servers
+	ag.SetToolCallSlots(b.toolCallSlots)
+	if b.metrics != nil {
+		ag.SetMetrics(b.metrics)
+	}
+	ag.SetTracer(b.tracer)
 	return ag, nil
 }
+
+// buildBackends creates the MCP connector and the LLM service constructor.
+// In cassette replay mode both are served from the cassette; in record mode both are recorded to it.
+// The LLM services and MCP connections record their metrics to m if it is not nil and are traced by tracer.
+func buildBackends(cassetteCfg configuration.CassetteConfig, connectorCfg configuration.MCPConnectorConfig, m *metrics.Metrics, tracer *tracing.Tracer, log *logrus.Logger) (toolConnectorSpec, func(configuration.LLMConfig) (llmServiceSpec, error), error) {
+	newLLMService := func(llmConfig configuration.LLMConfig) (llmServiceSpec, error) {
+		svc, err := llm.NewLLMService(llmConfig, log)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			svc.SetMetrics(m)
+		}
+		svc.SetTracer(tracer)
+		return svc, nil
+	}
+	newConnector := func() *mcp_connector.MCPConnector {
+		connector := mcp_connector.NewMCPConnector(connectorCfg, log)
+		if m != nil {
+			connector.SetMetrics(m)
+		}
+		connector.SetTracer(tracer)
+		return connector
+	}
+	switch {
+	case cassetteCfg.Replaying():
+		player, err := cassette.NewPlayer(cassetteCfg.Path, cassetteCfg.Strict, log)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load cassette: %w", err)
+		}
+		log.Infof("Replaying cassette `%s`", cassetteCfg.Path)
+		return player, func(configuration.LLMConfig) (llmServiceSpec, error) { return player, nil }, nil
+	case cassetteCfg.Recording():
+		recorder := cassette.NewRecorder(cassetteCfg.Path, log)
+		log.Infof("Recording cassette `%s`", cassetteCfg.Path)
+		toolConnector := recorder.Tools(newConnector())
+		return toolConnector, func(llmConfig configuration.LLMConfig) (llmServiceSpec, error) {
+			svc, err := newLLMService(llmConfig)
+			if err != nil {
+				return nil, err
+			}
+			return recorder.LLM(svc), nil
+		}, nil
+	default:
+		toolConnector := newConnector()
+		log.Info("ToolConnector instance created (server mode)")
+		return toolConnector, newLLMService, nil
+	}
+}
+
+// buildSessionStore creates the session store selected in the configuration, or nil if sessions are disabled.
+func buildSessionStore(cfg configuration.SessionStoreConfig) (sessionStoreSpec, error) {
+	switch cfg.Store {
+	case "":
+		return nil, nil
+	case configuration.SessionStoreMemory:
+		return session_store.NewMemoryStore(cfg.TTL), nil
+	case configuration.SessionStoreFile:
+		return session_store.NewFileStore(cfg.Dir, cfg.TTL)
+	default:
+		return nil, fmt.Errorf("unknown session store: %s", cfg.Store)
+	}
+}
@@ -6,10 +6,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/korchasa/speelka-agent-go/internal/auth"
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
+	"github.com/korchasa/speelka-agent-go/internal/mcp_server"
+	"github.com/korchasa/speelka-agent-go/internal/quota"
+	"github.com/korchasa/speelka-agent-go/internal/session_store"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
 
 	"github.com/korchasa/speelka-agent-go/internal/types"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -20,12 +31,28 @@ type mockAgent struct {
 	callResult string
 	callMeta   types.MetaInfo
 	callErr    error
+	callOpts   types.SessionOptions
+	callCtx    context.Context
+	callInput  string
+
+	endedSession string
+	endedBy      string
+	endErr       error
 }
 
-func (m *mockAgent) RunSession(ctx context.Context, input string) (string, types.MetaInfo, error) {
+func (m *mockAgent) RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+	m.callOpts = opts
+	m.callCtx = ctx
+	m.callInput = input
 	return m.callResult, m.callMeta, m.callErr
 }
 
+func (m *mockAgent) EndSession(sessionID string, client string) error {
+	m.endedSession = sessionID
+	m.endedBy = client
+	return m.endErr
+}
+
 // Implement types.AgentSpec for MCPApp tests
 func (m *mockAgent) RegisterTools() {}
 
@@ -51,8 +78,7 @@ func TestApp_SuccessfulCall(t *testing.T) {
 		callMeta:   types.MetaInfo{Tokens: 10, Cost: 0.1, DurationMs: 100},
 		callErr:    nil,
 	}
-	answer, meta, err := app.agent.RunSession(context.Background(), "test")
-	res := buildDirectCallResult(answer, meta, err)
+	res := app.handleDirectCall(context.Background(), app.agent, "test", types.SessionOptions{})
 	if !res.Success {
 		t.Errorf("expected success=true, got false")
 	}
@@ -74,8 +100,7 @@ func TestApp_ErrorCall(t *testing.T) {
 		callMeta:   types.MetaInfo{},
 		callErr:    errors.New("fail"),
 	}
-	answer, meta, err := app.agent.RunSession(context.Background(), "test")
-	res := buildDirectCallResult(answer, meta, err)
+	res := app.handleDirectCall(context.Background(), app.agent, "test", types.SessionOptions{})
 	if res.Success {
 		t.Errorf("expected success=false, got true")
 	}
@@ -94,8 +119,7 @@ func TestApp_JSONOutputAlwaysValid(t *testing.T) {
 		callMeta:   types.MetaInfo{Tokens: 1},
 		callErr:    nil,
 	}
-	answer, meta, err := app.agent.RunSession(context.Background(), "bar")
-	res := buildDirectCallResult(answer, meta, err)
+	res := app.handleDirectCall(context.Background(), app.agent, "bar", types.SessionOptions{})
 	b, err := json.Marshal(res)
 	if err != nil {
 		t.Fatalf("marshal failed: %v", err)
@@ -118,8 +142,7 @@ func TestApp_JSONOutputWithNewlines(t *testing.T) {
 		callMeta:   types.MetaInfo{Tokens: 3},
 		callErr:    nil,
 	}
-	answer, meta, err := app.agent.RunSession(context.Background(), "bar")
-	res := buildDirectCallResult(answer, meta, err)
+	res := app.handleDirectCall(context.Background(), app.agent, "bar", types.SessionOptions{})
 	b, err := json.Marshal(res)
 	if err != nil {
 		t.Fatalf("marshal failed: %v", err)
@@ -139,8 +162,8 @@ func TestApp_JSONOutputWithNewlines(t *testing.T) {
 
 func TestApp_DispatchMCPCall_Success(t *testing.T) {
 	a := &MCPApp{
-		agent: &mockAgent{callResult: "ok", callMeta: types.MetaInfo{}, callErr: nil},
-		cfg:   &configuration.Configuration{},
+		agents: map[string]agentSpec{"answer": &mockAgent{callResult: "ok", callMeta: types.MetaInfo{}, callErr: nil}},
+		cfg:    &configuration.Configuration{},
 	}
 	a.cfg.Agent.Tool.Name = "answer"
 	a.cfg.Agent.Tool.ArgumentName = "text"
@@ -162,7 +185,7 @@ func TestApp_DispatchMCPCall_Success(t *testing.T) {
 }
 
 func TestApp_DispatchMCPCall_InvalidTool(t *testing.T) {
-	a := &MCPApp{agent: &mockAgent{}, cfg: &configuration.Configuration{}}
+	a := &MCPApp{agents: map[string]agentSpec{"answer": &mockAgent{}}, cfg: &configuration.Configuration{}}
 	a.cfg.Agent.Tool.Name = "answer"
 	a.cfg.Agent.Tool.ArgumentName = "text"
 	req := mcp.CallToolRequest{Params: struct {
@@ -177,7 +200,7 @@ func TestApp_DispatchMCPCall_InvalidTool(t *testing.T) {
 }
 
 func TestApp_DispatchMCPCall_MissingArgument(t *testing.T) {
-	a := &MCPApp{agent: &mockAgent{}, cfg: &configuration.Configuration{}}
+	a := &MCPApp{agents: map[string]agentSpec{"answer": &mockAgent{}}, cfg: &configuration.Configuration{}}
 	a.cfg.Agent.Tool.Name = "answer"
 	a.cfg.Agent.Tool.ArgumentName = "text"
 	req := mcp.CallToolRequest{Params: struct {
@@ -192,7 +215,7 @@ func TestApp_DispatchMCPCall_MissingArgument(t *testing.T) {
 }
 
 func TestApp_DispatchMCPCall_EmptyInput(t *testing.T) {
-	a := &MCPApp{agent: &mockAgent{}, cfg: &configuration.Configuration{}}
+	a := &MCPApp{agents: map[string]agentSpec{"answer": &mockAgent{}}, cfg: &configuration.Configuration{}}
 	a.cfg.Agent.Tool.Name = "answer"
 	a.cfg.Agent.Tool.ArgumentName = "text"
 	req := mcp.CallToolRequest{Params: struct {
@@ -207,7 +230,7 @@ func TestApp_DispatchMCPCall_EmptyInput(t *testing.T) {
 }
 
 func TestApp_DispatchMCPCall_CoreError(t *testing.T) {
-	a := &MCPApp{agent: &mockAgent{callErr: errors.New("fail")}, cfg: &configuration.Configuration{}}
+	a := &MCPApp{agents: map[string]agentSpec{"answer": &mockAgent{callErr: errors.New("fail")}}, cfg: &configuration.Configuration{}}
 	a.cfg.Agent.Tool.Name = "answer"
 	a.cfg.Agent.Tool.ArgumentName = "text"
 	req := mcp.CallToolRequest{Params: struct {
@@ -221,22 +244,334 @@ func TestApp_DispatchMCPCall_CoreError(t *testing.T) {
 	}
 }
 
-func Test_validateToolName(t *testing.T) {
+func TestApp_DispatchMCPCall_Cancelled(t *testing.T) {
+	cancelErr := error_handling.WrapError(context.Canceled, "session cancelled", error_handling.ErrorCategoryCancelled)
+	a := &MCPApp{agents: map[string]agentSpec{"answer": &mockAgent{callErr: cancelErr, callMeta: types.MetaInfo{Tokens: 7}}}, cfg: &configuration.Configuration{}}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi"}
+	res, _ := a.dispatchMCPCall(context.Background(), req)
+	if !res.IsError {
+		t.Fatalf("expected error result")
+	}
+	if res.Meta["errorType"] != "cancelled" || res.Meta["meta"].(types.MetaInfo).Tokens != 7 {
+		t.Errorf("unexpected meta: %+v", res.Meta)
+	}
+}
+
+func TestApp_DispatchMCPCall_Session(t *testing.T) {
+	ag := &mockAgent{callResult: "ok", callMeta: types.MetaInfo{SessionID: "sess-1"}}
+	a := &MCPApp{agent: ag, agents: map[string]agentSpec{"answer": ag}, cfg: &configuration.Configuration{}, logger: newTestLogger()}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi", "session_id": "sess-1"}
+	res, err := a.dispatchMCPCall(auth.WithClient(context.Background(), "alice"), req)
+	if err != nil || res.IsError {
+		t.Fatalf("expected success, got %v, %v", err, res)
+	}
+	if ag.callOpts.SessionID != "sess-1" {
+		t.Errorf("expected session id to be passed to agent, got %q", ag.callOpts.SessionID)
+	}
+	if ag.callOpts.Client != "alice" {
+		t.Errorf("expected the client to be passed to agent, got %q", ag.callOpts.Client)
+	}
+	if res.Meta["sessionId"] != "sess-1" {
+		t.Errorf("expected session id in result meta, got %v", res.Meta)
+	}
+	if len(res.Content) != 2 {
+		t.Errorf("expected answer and session id contents, got %d", len(res.Content))
+	}
+
+	req.Params.Arguments = map[string]interface{}{"text": "hi"}
+	req.Params.Meta = &mcp.Meta{AdditionalFields: map[string]any{"sessionId": "sess-2"}}
+	_, _ = a.dispatchMCPCall(context.Background(), req)
+	if ag.callOpts.SessionID != "sess-2" {
+		t.Errorf("expected session id from _meta, got %q", ag.callOpts.SessionID)
+	}
+
+	req.Params.Arguments = map[string]interface{}{"text": "hi", "session_id": 5}
+	res, _ = a.dispatchMCPCall(context.Background(), req)
+	if !res.IsError {
+		t.Errorf("expected error for non-string session id")
+	}
+}
+
+func TestApp_DispatchMCPCall_TraceContext(t *testing.T) {
+	ag := &mockAgent{callResult: "ok"}
+	a := &MCPApp{agent: ag, agents: map[string]agentSpec{"answer": ag}, cfg: &configuration.Configuration{}}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi"}
+	req.Params.Meta = &mcp.Meta{AdditionalFields: map[string]any{
+		tracing.TraceParentKey: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}}
+	if _, err := a.dispatchMCPCall(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sc := tracing.SpanContextFromContext(ag.callCtx)
+	if !sc.IsValid() || tracing.FormatTraceParent(sc) != req.Params.Meta.AdditionalFields[tracing.TraceParentKey] {
+		t.Errorf("expected the session to join the trace of the caller, got %+v", sc)
+	}
+}
+
+func TestApp_DispatchMCPCall_Budget(t *testing.T) {
+	ag := &mockAgent{callResult: "ok"}
+	a := &MCPApp{agent: ag, agents: map[string]agentSpec{"answer": ag}, cfg: &configuration.Configuration{}}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi", "budget": 0.25}
+	res, err := a.dispatchMCPCall(context.Background(), req)
+	if err != nil || res.IsError {
+		t.Fatalf("expected success, got %v, %v", err, res)
+	}
+	if ag.callOpts.RequestBudget != 0.25 {
+		t.Errorf("expected budget to be passed to agent, got %v", ag.callOpts.RequestBudget)
+	}
+
+	for _, invalid := range []interface{}{-1.0, 0.0, "1"} {
+		req.Params.Arguments = map[string]interface{}{"text": "hi", "budget": invalid}
+		res, _ = a.dispatchMCPCall(context.Background(), req)
+		if !res.IsError {
+			t.Errorf("expected error for budget %v", invalid)
+		}
+	}
+}
+
+func TestApp_DispatchMCPCall_Limits(t *testing.T) {
+	// A continued session: only the cost of the call counts against the spend
+	ag := &mockAgent{callResult: "ok", callMeta: types.MetaInfo{Cost: 5, CallCost: 0.75}}
+	a := &MCPApp{agent: ag, agents: map[string]agentSpec{"answer": ag}, cfg: &configuration.Configuration{}, logger: newTestLogger()}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+	limiter, err := quota.NewLimiter(configuration.LimitsConfig{
+		ClientLimits: configuration.ClientLimits{DailySpend: 1},
+	}, a.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.limiter = limiter
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi"}
+	ctx := auth.WithClient(context.Background(), "alice")
+	res, _ := a.dispatchMCPCall(ctx, req)
+	if res.IsError {
+		t.Fatalf("expected success, got %v", res)
+	}
+	if ag.callOpts.RequestBudget != 1 {
+		t.Errorf("expected the remaining daily spend as budget, got %v", ag.callOpts.RequestBudget)
+	}
+
+	req.Params.Arguments = map[string]interface{}{"text": "hi", "budget": 0.5}
+	res, _ = a.dispatchMCPCall(ctx, req)
+	if res.IsError || ag.callOpts.RequestBudget != 0.25 {
+		t.Errorf("expected the lower of the requested budget and the remaining spend, got %v", ag.callOpts.RequestBudget)
+	}
+
+	res, _ = a.dispatchMCPCall(ctx, req)
+	if !res.IsError || res.Meta["errorType"] != errorTypeLimitExceeded || res.Meta["limit"] != quota.LimitSpend {
+		t.Errorf("expected a spend limit error, got %+v", res)
+	}
+	if text := res.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "daily spend cap of client `alice` reached") {
+		t.Errorf("unexpected error message: %s", text)
+	}
+
+	res, _ = a.dispatchMCPCall(auth.WithClient(context.Background(), "bob"), req)
+	if res.IsError {
+		t.Errorf("expected other clients to pass, got %v", res)
+	}
+}
+
+func TestApp_Admit_ReservesTheBudgetOfTheCall(t *testing.T) {
+	a := &MCPApp{cfg: &configuration.Configuration{}, logger: newTestLogger()}
+	limiter, err := quota.NewLimiter(configuration.LimitsConfig{
+		ClientLimits: configuration.ClientLimits{DailySpend: 1},
+	}, a.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.limiter = limiter
+	ctx := context.Background()
+
+	// Calls without a budget argument reserve the configured budget, so several of them run at the same time
+	var first, second types.SessionOptions
+	doneFirst, err := a.admit(ctx, "alice", 0.4, &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doneFirst(0)
+	doneSecond, err := a.admit(ctx, "alice", 0.4, &second)
+	if err != nil {
+		t.Fatalf("expected a concurrent call within the cap to pass, got %v", err)
+	}
+	defer doneSecond(0)
+	if first.RequestBudget != 0.4 || second.RequestBudget != 0.4 {
+		t.Errorf("expected the configured budget, got %v and %v", first.RequestBudget, second.RequestBudget)
+	}
+
+	// A per-call budget lowers the configured one, and what is left lowers both
+	third := types.SessionOptions{RequestBudget: 0.1}
+	doneThird, err := a.admit(ctx, "alice", 0.4, &third)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doneThird(0)
+	if third.RequestBudget != 0.1 {
+		t.Errorf("expected the per-call budget, got %v", third.RequestBudget)
+	}
+	var fourth types.SessionOptions
+	doneFourth, err := a.admit(ctx, "alice", 0.4, &fourth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doneFourth(0)
+	if math.Abs(fourth.RequestBudget-0.1) > 1e-9 {
+		t.Errorf("expected what is left of the cap, got %v", fourth.RequestBudget)
+	}
+}
+
+func TestApp_StructuredContent(t *testing.T) {
+	structured := map[string]any{"title": "Disk full"}
+	ag := &mockAgent{callResult: `{"title": "Disk full"}`, callMeta: types.MetaInfo{StructuredContent: structured, SessionID: "sess-1"}}
+	a := &MCPApp{agent: ag, agents: map[string]agentSpec{"answer": ag}, cfg: &configuration.Configuration{}}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi"}
+	res, err := a.dispatchMCPCall(context.Background(), req)
+	if err != nil || res.IsError {
+		t.Fatalf("expected success, got %v, %v", err, res)
+	}
+	if !reflect.DeepEqual(res.Meta[mcp_server.StructuredContentMetaKey], structured) {
+		t.Errorf("expected structured content in result meta, got %v", res.Meta)
+	}
+	if res.Meta["sessionId"] != "sess-1" {
+		t.Errorf("expected session id in result meta, got %v", res.Meta)
+	}
+	if tc, ok := res.Content[0].(mcp.TextContent); !ok || tc.Text != ag.callResult {
+		t.Errorf("expected the JSON rendering as text content, got %v", res.Content[0])
+	}
+
+	direct := a.handleDirectCall(context.Background(), ag, "hi", types.SessionOptions{})
+	if !reflect.DeepEqual(direct.Result["structured_content"], structured) {
+		t.Errorf("expected structured content in direct call result, got %v", direct.Result)
+	}
+}
+
+func TestApp_DispatchMCPCall_EndSession(t *testing.T) {
+	ag := &mockAgent{}
+	a := &MCPApp{agent: ag, cfg: &configuration.Configuration{}}
+	a.cfg.Agent.Tool.Name = "answer"
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "end_session"
+	req.Params.Arguments = map[string]interface{}{"session_id": "sess-1"}
+	res, _ := a.dispatchMCPCall(auth.WithClient(context.Background(), "alice"), req)
+	if res.IsError || ag.endedSession != "sess-1" || ag.endedBy != "alice" {
+		t.Errorf("expected session to be ended by the client, got %v, %q, %q", res, ag.endedSession, ag.endedBy)
+	}
+
+	req.Params.Arguments = map[string]interface{}{}
+	res, _ = a.dispatchMCPCall(context.Background(), req)
+	if !res.IsError {
+		t.Errorf("expected error for missing session id")
+	}
+
+	ag.endErr = errors.New("busy")
+	req.Params.Arguments = map[string]interface{}{"session_id": "sess-1"}
+	res, _ = a.dispatchMCPCall(context.Background(), req)
+	if !res.IsError {
+		t.Errorf("expected error from agent")
+	}
+}
+
+func TestApp_DispatchMCPCall_EndSessionOfOtherTool(t *testing.T) {
+	first, owner := &mockAgent{}, &mockAgent{}
+	store := session_store.NewMemoryStore(time.Hour)
+	if err := store.Save(types.SessionState{ID: "sess-1", Tool: "classify"}); err != nil {
+		t.Fatal(err)
+	}
+	a := &MCPApp{agent: first, agents: map[string]agentSpec{"answer": first, "classify": owner}, sessions: store, cfg: &configuration.Configuration{}}
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "end_session"
+	req.Params.Arguments = map[string]interface{}{"session_id": "sess-1"}
+	res, _ := a.dispatchMCPCall(context.Background(), req)
+	if res.IsError || owner.endedSession != "sess-1" || first.endedSession != "" {
+		t.Errorf("expected the agent of the owner to end the session, got %v", res)
+	}
+
+	req.Params.Arguments = map[string]interface{}{"session_id": "unknown"}
+	a.dispatchMCPCall(context.Background(), req)
+	if first.endedSession != "unknown" {
+		t.Errorf("expected the agent of the first tool to handle unknown sessions")
+	}
+}
+
+func Test_buildSessionStore(t *testing.T) {
+	store, err := buildSessionStore(configuration.SessionStoreConfig{})
+	if err != nil || store != nil {
+		t.Errorf("expected no store when disabled, got %v, %v", store, err)
+	}
+	store, err = buildSessionStore(configuration.SessionStoreConfig{Store: "memory"})
+	if err != nil || store == nil {
+		t.Errorf("expected memory store, got %v, %v", store, err)
+	}
+	store, err = buildSessionStore(configuration.SessionStoreConfig{Store: "file", Dir: t.TempDir()})
+	if err != nil || store == nil {
+		t.Errorf("expected file store, got %v, %v", store, err)
+	}
+	if _, err = buildSessionStore(configuration.SessionStoreConfig{Store: "redis"}); err == nil {
+		t.Errorf("expected error for unknown store")
+	}
+}
+
+func TestMCPApp_agentForTool(t *testing.T) {
+	summarize, classify := &mockAgent{callResult: "summary"}, &mockAgent{callResult: "label"}
 	cfg := &configuration.Configuration{}
-	cfg.Agent.Tool.Name = "answer"
 	cfg.Agent.Tool.ArgumentName = "text"
-	t.Run("valid name", func(t *testing.T) {
-		err := validateToolName("answer", cfg)
-		if err != nil {
-			t.Errorf("expected nil, got %v", err)
-		}
-	})
-	t.Run("invalid name", func(t *testing.T) {
-		err := validateToolName("wrong", cfg)
-		if err == nil || err.Error() != "invalid tool name: wrong" {
-			t.Errorf("expected error for invalid tool name, got %v", err)
-		}
-	})
+	cfg.Agent.Tools = []configuration.ToolDefinition{
+		{Name: "summarize", Description: "Summarize"},
+		{Name: "classify", Description: "Classify", ArgumentName: "input"},
+	}
+	a := &MCPApp{agents: map[string]agentSpec{"summarize": summarize, "classify": classify}, cfg: cfg}
+
+	ag, agentConfig, err := a.agentForTool("classify")
+	if err != nil || ag != classify || agentConfig.Tool.ArgumentName != "input" {
+		t.Errorf("expected the classify agent, got %v, %+v, %v", ag, agentConfig.Tool, err)
+	}
+	ag, agentConfig, err = a.agentForTool("summarize")
+	if err != nil || ag != summarize || agentConfig.Tool.ArgumentName != "text" {
+		t.Errorf("expected the summarize agent with the inherited argument, got %v, %+v, %v", ag, agentConfig.Tool, err)
+	}
+	if _, _, err := a.agentForTool("wrong"); err == nil || err.Error() != "invalid tool name: wrong" {
+		t.Errorf("expected error for invalid tool name, got %v", err)
+	}
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "classify"
+	req.Params.Arguments = map[string]interface{}{"input": "hello"}
+	res, err := a.dispatchMCPCall(context.Background(), req)
+	if err != nil || res.IsError {
+		t.Fatalf("expected success, got %v, %v", err, res)
+	}
+	if tc, ok := res.Content[0].(mcp.TextContent); !ok || tc.Text != "label" {
+		t.Errorf("expected the answer of the classify agent, got %v", res.Content)
+	}
 }
 
 func Test_extractUserInput(t *testing.T) {
@@ -273,21 +608,62 @@ func Test_extractUserInput(t *testing.T) {
 	})
 }
 
-func Test_buildDirectCallResult(t *testing.T) {
+func Test_extractArguments(t *testing.T) {
+	tool := configuration.MCPServerToolConfig{ArgumentName: "text", InputSchema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"team":  map[string]any{"type": "string"},
+			"limit": map[string]any{"type": "integer"},
+		},
+		"required": []any{"team"},
+	}}
+	t.Run("ok", func(t *testing.T) {
+		args, err := extractArguments(map[string]interface{}{"text": "hi", "team": "billing", "session_id": "s1"}, tool)
+		if err != nil || !reflect.DeepEqual(args, map[string]any{"team": "billing"}) {
+			t.Errorf("expected only the arguments of the schema, got %v, %v", args, err)
+		}
+	})
+	t.Run("violations", func(t *testing.T) {
+		_, err := extractArguments(map[string]interface{}{"text": "hi", "limit": 1.5}, tool)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid arguments:") || !strings.Contains(err.Error(), "team") || !strings.Contains(err.Error(), "$.limit") {
+			t.Errorf("expected the violations of team and limit, got %v", err)
+		}
+	})
+	t.Run("no schema", func(t *testing.T) {
+		args, err := extractArguments(map[string]interface{}{"text": "hi", "team": "billing"}, configuration.MCPServerToolConfig{ArgumentName: "text"})
+		if err != nil || args != nil {
+			t.Errorf("expected no arguments, got %v, %v", args, err)
+		}
+	})
+}
+
+func Test_handleDirectCall(t *testing.T) {
 	meta := types.MetaInfo{Tokens: 1}
 	t.Run("success", func(t *testing.T) {
-		res := buildDirectCallResult("ok", meta, nil)
+		app := &MCPApp{}
+		res := app.handleDirectCall(context.Background(), &mockAgent{callResult: "ok", callMeta: meta}, "hi", types.SessionOptions{})
 		if !res.Success || res.Result["answer"] != "ok" || res.Meta.Tokens != 1 || res.Error.Type != "" {
 			t.Errorf("unexpected result: %+v", res)
 		}
 	})
 	t.Run("error", func(t *testing.T) {
-		err := fmt.Errorf("fail")
-		res := buildDirectCallResult("", meta, err)
+		app := &MCPApp{}
+		res := app.handleDirectCall(context.Background(), &mockAgent{callMeta: meta, callErr: fmt.Errorf("fail")}, "hi", types.SessionOptions{})
 		if res.Success || res.Result["answer"] != "" || res.Error.Type != "internal" || res.Error.Message != "fail" {
 			t.Errorf("unexpected error result: %+v", res)
 		}
 	})
+	t.Run("cancelled", func(t *testing.T) {
+		app := &MCPApp{}
+		err := error_handling.WrapError(context.Canceled, "session cancelled", error_handling.ErrorCategoryCancelled)
+		res := app.handleDirectCall(context.Background(), &mockAgent{callMeta: meta, callErr: err}, "hi", types.SessionOptions{})
+		if res.Success || res.Error.Type != "cancelled" {
+			t.Errorf("unexpected cancelled result: %+v", res)
+		}
+		if res.Meta.Tokens != 1 {
+			t.Errorf("expected the partial meta of the cancelled session, got %+v", res.Meta)
+		}
+	})
 }
 
 func Test_MCPConnector_ToolsInitialization(t *testing.T) {
@@ -363,11 +739,93 @@ func TestApp_ExecuteDirectCall_InternalError(t *testing.T) {
 	}
 }
 
+func TestApp_ExecuteDirectCall_FakeProvider(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.yaml")
+	if err := os.WriteFile(script, []byte("turns:\n  - toolCalls:\n      - name: finish\n        arguments: {text: \"4\"}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "agent.yaml")
+	config := `
+agent:
+  llm:
+    provider: fake
+    model: gpt-4o-mini
+    script: ` + script + `
+    promptTemplate: "Answer: {{input}}. Tools: {{tools}}"
+`
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mgr := configuration.NewConfigurationManager()
+	if err := mgr.LoadConfiguration(context.Background(), configFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Validate(); err != nil {
+		t.Fatalf("a fake provider needs no API key: %v", err)
+	}
+	app, _ := NewMCPApp(newTestLogger(), mgr.GetConfiguration())
+	if err := app.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, code, err := app.ExecuteDirectCall(context.Background(), "What is 2+2?")
+	if !res.Success || code != 0 || err != nil || res.Result["answer"] != "4" {
+		t.Fatalf("expected the scripted answer, got: %+v, %d, %v", res, code, err)
+	}
+	if res.Meta.Tokens == 0 || res.Meta.CompletionTokens == 0 || res.Meta.Cost == 0 {
+		t.Errorf("expected synthetic usage and its cost, got: %+v", res.Meta)
+	}
+}
+
+func TestApp_DispatchMCPCall_FailedSessionIsCharged(t *testing.T) {
+	dir := t.TempDir()
+	// The first response is paid for; the second request matches no turn and fails the session
+	script := filepath.Join(dir, "script.yaml")
+	if err := os.WriteFile(script, []byte("turns:\n  - turn: 1\n    toolCalls:\n      - name: lookup\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &configuration.Configuration{}
+	cfg.Agent.Tool.Name = "answer"
+	cfg.Agent.Tool.ArgumentName = "text"
+	cfg.Agent.LLM.Provider = configuration.LLMProviderFake
+	cfg.Agent.LLM.Model = "gpt-4o-mini"
+	cfg.Agent.LLM.Script = script
+	cfg.Agent.LLM.PromptTemplate = "Answer: {{text}}. Tools: {{tools}}"
+	cfg.Agent.Chat.MaxLLMIterations = 5
+	app, _ := NewMCPApp(newTestLogger(), cfg)
+	if err := app.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := quota.NewLimiter(configuration.LimitsConfig{ClientLimits: configuration.ClientLimits{DailySpend: 1}}, app.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.limiter = limiter
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "answer"
+	req.Params.Arguments = map[string]interface{}{"text": "hi"}
+	ctx := auth.WithClient(context.Background(), "alice")
+	res, _ := app.dispatchMCPCall(ctx, req)
+	if !res.IsError || !strings.Contains(res.Content[0].(mcp.TextContent).Text, "no scripted turn matches request 2") {
+		t.Fatalf("expected the session to fail in its second request, got %+v", res)
+	}
+	ticket, err := limiter.Acquire(ctx, "alice", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ticket.Done(0)
+	if ticket.Remaining >= 1 {
+		t.Errorf("expected the cost of the first response to be recorded, %.6f of $1 is left", ticket.Remaining)
+	}
+}
+
 func TestApp_Start_InvalidConfig(t *testing.T) {
 	logger := newTestLogger()
 	cfg := &configuration.Configuration{}
-	cfg.Runtime.Transports.HTTP.Enabled = true
-	cfg.Runtime.Transports.Stdio.Enabled = true
+	cfg.Runtime.Transports.HTTP.Enabled = false
+	cfg.Runtime.Transports.Stdio.Enabled = false
 	app, err := NewMCPApp(logger, cfg)
 	if err != nil {
 		t.Fatalf("unexpected error from NewMCPApp: %v", err)
@@ -0,0 +1,75 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/korchasa/speelka-agent-go/internal/mcp_server"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// elicitorSpec asks the user of the MCP client that issued the current request for input.
+type elicitorSpec interface {
+	Elicit(ctx context.Context, message string, schema map[string]any) (mcp_server.ElicitationResult, error)
+}
+
+// approvalSchema is the form shown to the user when a tool call needs approval.
+var approvalSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"approve": map[string]any{
+			"type":        "boolean",
+			"title":       "Approve",
+			"description": "Allow the agent to make this call",
+		},
+		"reason": map[string]any{
+			"type":        "string",
+			"title":       "Reason",
+			"description": "Optional comment passed back to the agent",
+		},
+	},
+	"required": []string{"approve"},
+}
+
+// newElicitationApprover returns an approval callback that asks the user of the MCP client through elicitation.
+func newElicitationApprover(elicitor elicitorSpec) types.ApprovalFunc {
+	return func(ctx context.Context, req types.ApprovalRequest) (types.ApprovalDecision, error) {
+		result, err := elicitor.Elicit(ctx, approvalMessage(req), approvalSchema)
+		if err != nil {
+			return types.ApprovalDecision{}, err
+		}
+		reason, _ := result.Content["reason"].(string)
+		switch result.Action {
+		case mcp_server.ElicitationAccept:
+			approved, _ := result.Content["approve"].(bool)
+			return types.ApprovalDecision{Approved: approved, Reason: reason}, nil
+		case mcp_server.ElicitationDecline:
+			return types.ApprovalDecision{Approved: false, Reason: reason}, nil
+		default:
+			return types.ApprovalDecision{Approved: false, Reason: "the user dismissed the approval request"}, nil
+		}
+	}
+}
+
+// approvalMessage renders the question shown to the user.
+func approvalMessage(req types.ApprovalRequest) string {
+	args, err := json.Marshal(req.Arguments)
+	if err != nil {
+		args = []byte(fmt.Sprintf("%v", req.Arguments))
+	}
+	return fmt.Sprintf("The agent wants to call `%s` on server `%s` with arguments %s. Allow it?", req.ToolName, req.ServerID, args)
+}
+
+// approver returns the callback deciding on calls that require approval for the current request.
+// The local hook is used if configured; otherwise the MCP client is asked if it supports elicitation.
+// Without either such calls are rejected.
+func (a *MCPApp) approver(ctx context.Context) types.ApprovalFunc {
+	if a.approvalHook != nil {
+		return a.approvalHook.Approve
+	}
+	if a.mcpServer != nil && a.mcpServer.SupportsElicitation(ctx) {
+		return newElicitationApprover(a.mcpServer)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/approval"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/mcp_server"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockElicitor struct {
+	result  mcp_server.ElicitationResult
+	err     error
+	message string
+}
+
+func (m *mockElicitor) Elicit(ctx context.Context, message string, schema map[string]any) (mcp_server.ElicitationResult, error) {
+	m.message = message
+	return m.result, m.err
+}
+
+func TestNewElicitationApprover(t *testing.T) {
+	req := types.ApprovalRequest{ServerID: "fs", ToolName: "write_file", Arguments: map[string]any{"path": "a.txt"}}
+	tests := []struct {
+		name   string
+		result mcp_server.ElicitationResult
+		want   types.ApprovalDecision
+	}{
+		{"approved", mcp_server.ElicitationResult{Action: "accept", Content: map[string]any{"approve": true}}, types.ApprovalDecision{Approved: true}},
+		{"rejected in form", mcp_server.ElicitationResult{Action: "accept", Content: map[string]any{"approve": false, "reason": "wrong file"}}, types.ApprovalDecision{Reason: "wrong file"}},
+		{"declined", mcp_server.ElicitationResult{Action: "decline"}, types.ApprovalDecision{}},
+		{"cancelled", mcp_server.ElicitationResult{Action: "cancel"}, types.ApprovalDecision{Reason: "the user dismissed the approval request"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elicitor := &mockElicitor{result: tt.result}
+			decision, err := newElicitationApprover(elicitor)(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, decision)
+			assert.Contains(t, elicitor.message, "`write_file` on server `fs`")
+			assert.Contains(t, elicitor.message, `{"path":"a.txt"}`)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		elicitor := &mockElicitor{err: errors.New("client gone")}
+		_, err := newElicitationApprover(elicitor)(context.Background(), req)
+		assert.Error(t, err)
+	})
+}
+
+func TestMCPApp_approver(t *testing.T) {
+	app := &MCPApp{}
+	assert.Nil(t, app.approver(context.Background()), "without a hook or a client no one can approve")
+
+	app.approvalHook = approval.NewCommandHook(configuration.ApprovalHookConfig{Command: "true"}, newTestLogger())
+	approve := app.approver(context.Background())
+	require.NotNil(t, approve)
+	decision, err := approve(context.Background(), types.ApprovalRequest{ToolName: "x"})
+	require.NoError(t, err)
+	assert.True(t, decision.Approved)
+}
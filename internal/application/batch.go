@@ -0,0 +1,128 @@
+// Package application: batch mode running many direct calls with one set of connections
+package application
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// maxBatchLineSize bounds one line of the batch input.
+const maxBatchLineSize = 10 << 20
+
+// batchItem is one line of the batch input: the body of a REST call with an optional ID.
+type batchItem struct {
+	ID any `json:"id"`
+	runRequest
+}
+
+// ExecuteBatch runs the JSONL inputs of r on the initialized agents, up to concurrency at once, and writes
+// one BatchResult line per input to w, in the order of the input. It returns the summary of the batch and
+// the exit code: 0 if every input succeeded, otherwise the highest code of the failed ones.
+func (a *MCPApp) ExecuteBatch(ctx context.Context, r io.Reader, w io.Writer, concurrency int) (types.BatchSummary, int, error) {
+	defer a.shutdownTracer()
+	if a.agent == nil {
+		return types.BatchSummary{}, 1, fmt.Errorf("agent not initialized")
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	start := time.Now()
+	results := make(chan chan types.BatchResult, concurrency)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(results)
+		readErr <- a.startBatchItems(ctx, r, results, concurrency)
+	}()
+
+	var summary types.BatchSummary
+	code := 0
+	enc := json.NewEncoder(w)
+	var writeErr error
+	// Results are written in input order, each as soon as it and all before it have finished
+	for pending := range results {
+		result := <-pending
+		summary.Items++
+		summary.Tokens += result.Meta.Tokens
+		summary.Cost += result.Meta.Cost
+		if result.Success {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+			code = max(code, exitCode(result.DirectCallResult))
+		}
+		if writeErr == nil {
+			writeErr = enc.Encode(result)
+		}
+	}
+	summary.DurationMs = time.Since(start).Milliseconds()
+	if err := <-readErr; err != nil {
+		return summary, max(code, 1), fmt.Errorf("failed to read batch input: %w", err)
+	}
+	if writeErr != nil {
+		return summary, max(code, 1), fmt.Errorf("failed to write batch results: %w", writeErr)
+	}
+	return summary, code, nil
+}
+
+// startBatchItems starts a session for each line of r once one of concurrency slots is free and passes
+// the future of its result to results.
+func (a *MCPApp) startBatchItems(ctx context.Context, r io.Reader, results chan<- chan types.BatchResult, concurrency int) error {
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		item := batchItem{ID: line}
+		parseErr := json.Unmarshal(scanner.Bytes(), &item)
+		pending := make(chan types.BatchResult, 1)
+		results <- pending
+		if parseErr != nil {
+			result, _, _ := a.outputErrorAndExit("user", fmt.Errorf("invalid input on line %d: %w", line, parseErr))
+			pending <- types.BatchResult{ID: line, DirectCallResult: result}
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			pending <- types.BatchResult{ID: item.ID, DirectCallResult: a.runBatchItem(ctx, item.runRequest)}
+		}()
+	}
+	return scanner.Err()
+}
+
+// runBatchItem runs one input with the options of a REST call.
+func (a *MCPApp) runBatchItem(ctx context.Context, req runRequest) types.DirectCallResult {
+	if err := req.validate(); err != nil {
+		result, _, _ := a.outputErrorAndExit("user", err)
+		return result
+	}
+	toolName := req.Options.Tool
+	if toolName == "" {
+		toolName = a.cfg.GetAgentConfigs()[0].Tool.Name
+	}
+	ag, agentConfig, err := a.agentForTool(toolName)
+	if err != nil {
+		result, _, _ := a.outputErrorAndExit("user", err)
+		return result
+	}
+	opts, err := a.sessionOptions(ctx, agentConfig.Tool, req.Arguments, req.Options.SessionID, req.Options.Budget)
+	if err != nil {
+		result, _, _ := a.outputErrorAndExit("user", err)
+		return result
+	}
+	return a.handleDirectCall(ctx, ag, req.Input, opts)
+}
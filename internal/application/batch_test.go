@@ -0,0 +1,148 @@
+package application
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoAgent answers with its input after the delay named by the input, and fails on `fail`.
+type echoAgent struct {
+	mockAgent
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (m *echoAgent) RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+	m.mu.Lock()
+	m.running++
+	m.peak = max(m.peak, m.running)
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.running--
+		m.mu.Unlock()
+	}()
+	if delay, err := time.ParseDuration(input); err == nil {
+		time.Sleep(delay)
+	}
+	if input == "fail" {
+		return "", types.MetaInfo{Tokens: 1}, errors.New("failed")
+	}
+	return input, types.MetaInfo{Tokens: 10, Cost: 0.01}, nil
+}
+
+func runBatch(t *testing.T, a *MCPApp, input string, concurrency int) ([]types.BatchResult, types.BatchSummary, int, error) {
+	t.Helper()
+	var out bytes.Buffer
+	summary, code, err := a.ExecuteBatch(context.Background(), strings.NewReader(input), &out, concurrency)
+	var results []types.BatchResult
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var result types.BatchResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &result), scanner.Text())
+		results = append(results, result)
+	}
+	return results, summary, code, err
+}
+
+func TestExecuteBatch(t *testing.T) {
+	ag := &echoAgent{}
+	a := newRESTTestApp(ag)
+	input := `{"id": "slow", "input": "60ms"}
+{"input": "40ms"}
+
+{"id": 7, "input": "20ms"}
+`
+	results, summary, code, err := runBatch(t, a, input, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	require.Len(t, results, 3)
+	assert.Equal(t, "slow", results[0].ID, "results keep the order of the input")
+	assert.Equal(t, "60ms", results[0].Result["answer"])
+	assert.Equal(t, 2.0, results[1].ID, "items without an ID get their line number")
+	assert.Equal(t, 7.0, results[2].ID)
+	assert.Equal(t, 3, ag.peak)
+	assert.Equal(t, 3, summary.Items)
+	assert.Equal(t, 3, summary.Succeeded)
+	assert.Equal(t, 30, summary.Tokens)
+	assert.InDelta(t, 0.03, summary.Cost, 1e-9)
+	assert.GreaterOrEqual(t, summary.DurationMs, int64(60))
+
+	ag = &echoAgent{}
+	a = newRESTTestApp(ag)
+	_, _, _, err = runBatch(t, a, input, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ag.peak)
+}
+
+func TestExecuteBatch_Failures(t *testing.T) {
+	a := newRESTTestApp(&echoAgent{})
+	results, summary, code, err := runBatch(t, a, `{"input": "ok"}
+{"input":
+{"input": ""}
+{"input": "ok", "options": {"tool": "other"}}
+`, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, code, "user errors only")
+	require.Len(t, results, 4)
+	assert.True(t, results[0].Success)
+	for i, message := range map[int]string{1: "invalid input on line 2", 2: "empty input", 3: "invalid tool name: other"} {
+		assert.False(t, results[i].Success)
+		assert.Equal(t, "user", results[i].Error.Type)
+		assert.Contains(t, results[i].Error.Message, message)
+	}
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, 3, summary.Failed)
+
+	results, summary, code, err = runBatch(t, a, "{\"input\": \"fail\"}\n{\"input\": \"\"}\n", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, code, "the highest exit code wins")
+	assert.Equal(t, "internal", results[0].Error.Type)
+	assert.Equal(t, 1, summary.Tokens)
+
+	results, summary, code, err = runBatch(t, a, "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, results)
+	assert.Equal(t, 0, summary.Items)
+}
+
+func TestExecuteBatch_Arguments(t *testing.T) {
+	ag := &mockAgent{callResult: "ok"}
+	a := newRESTTestApp(ag)
+	a.cfg.Agent.Tools = []configuration.ToolDefinition{{Name: "answer", InputSchema: map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"team": map[string]any{"type": "string"}},
+		"required":   []any{"team"},
+	}}}
+
+	var out bytes.Buffer
+	ctx := auth.WithClient(context.Background(), "cron")
+	summary, code, err := a.ExecuteBatch(ctx, strings.NewReader(`{"input": "hi", "arguments": {"team": "billing"}}`+"\n"), &out, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code, out.String())
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, map[string]any{"team": "billing"}, ag.callOpts.Arguments)
+	assert.Equal(t, "cron", ag.callOpts.Client, "the session belongs to the client of the batch")
+
+	results, _, code, err := runBatch(t, a, `{"input": "hi"}`+"\n", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, code)
+	require.Len(t, results, 1)
+	assert.Equal(t, "user", results[0].Error.Type)
+	assert.Contains(t, results[0].Error.Message, "invalid arguments:")
+}
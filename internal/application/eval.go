@@ -0,0 +1,46 @@
+// Package application: evaluation suites run on the agents
+package application
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/korchasa/speelka-agent-go/internal/eval"
+)
+
+// ExecuteEval runs the suite on the initialized agents. The judge uses the model of the suite, or the model
+// of the first agent, through the LLM services the agents share. The arguments of the cases are checked against
+// the input schemas of their tools as for the MCP tool.
+func (a *MCPApp) ExecuteEval(ctx context.Context, suite eval.Suite) (eval.Report, error) {
+	defer a.shutdownTracer()
+	if a.agent == nil {
+		return eval.Report{}, fmt.Errorf("agent not initialized")
+	}
+	var judge llmServiceSpec
+	if suite.UsesJudge() && a.llmService != nil {
+		model := suite.Judge.Model
+		if model == "" {
+			model = a.cfg.GetAgentConfigs()[0].Model
+		}
+		var err error
+		if judge, err = a.llmService(model); err != nil {
+			return eval.Report{}, fmt.Errorf("failed to create judge: %w", err)
+		}
+	}
+	runner := eval.NewRunner(a.cfg.GetAgentConfigs()[0].Tool.Name, judge, a.logger)
+	for _, agentConfig := range a.cfg.GetAgentConfigs() {
+		ag, ok := a.agents[agentConfig.Tool.Name]
+		if !ok {
+			continue
+		}
+		var arguments eval.ArgumentsFunc
+		if tool := agentConfig.Tool; tool.InputSchema != nil {
+			arguments = func(values map[string]any) (map[string]any, error) {
+				return extractArguments(values, tool)
+			}
+		}
+		runner.AddAgent(agentConfig.Tool.Name, ag, arguments)
+	}
+	runner.SetApprover(a.approver(ctx))
+	return runner.Run(ctx, suite)
+}
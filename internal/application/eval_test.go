@@ -0,0 +1,79 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/eval"
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// rejectingJudge fails every answer.
+type rejectingJudge struct{}
+
+func (rejectingJudge) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error) {
+	call, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "verdict",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: tools[0].Name, Arguments: `{"pass": false, "reason": "too short"}`},
+	})
+	return llmtypes.LLMResponse{Calls: []types.CallToolRequest{call}}, err
+}
+
+func TestExecuteEval(t *testing.T) {
+	ag := &mockAgent{callResult: "Paris", callMeta: types.MetaInfo{Cost: 0.01}}
+	a := newRESTTestApp(ag)
+	a.cfg.Agent.LLM.Model = "gpt-4o"
+	var judgeModels []string
+	a.llmService = func(model string) (llmServiceSpec, error) {
+		judgeModels = append(judgeModels, model)
+		return rejectingJudge{}, nil
+	}
+
+	report, err := a.ExecuteEval(context.Background(), eval.Suite{Name: "capitals", Cases: []eval.Case{
+		{Name: "france", Input: "Capital of France?", Assert: []eval.Assertion{{Contains: "Paris"}}},
+	}})
+	require.NoError(t, err)
+	assert.True(t, report.Passed)
+	assert.Equal(t, "Capital of France?", ag.callInput)
+	assert.Empty(t, judgeModels, "no judge without judge assertions")
+
+	report, err = a.ExecuteEval(context.Background(), eval.Suite{Cases: []eval.Case{
+		{Name: "france", Input: "Capital of France?", Assert: []eval.Assertion{{Judge: "Explains the history"}}},
+	}})
+	require.NoError(t, err)
+	assert.False(t, report.Passed)
+	assert.Equal(t, []string{"judge: too short"}, report.Cases[0].Runs[0].Failures)
+	assert.Equal(t, []string{"gpt-4o"}, judgeModels, "the judge defaults to the model of the agent")
+
+	_, err = a.ExecuteEval(context.Background(), eval.Suite{Cases: []eval.Case{{Name: "other", Tool: "research", Input: "hi"}}})
+	assert.ErrorContains(t, err, "unknown tool `research`")
+}
+
+func TestExecuteEval_Arguments(t *testing.T) {
+	ag := &mockAgent{callResult: "ok"}
+	a := newRESTTestApp(ag)
+	a.cfg.Agent.Tools = []configuration.ToolDefinition{{Name: "answer", InputSchema: map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"team": map[string]any{"type": "string"}},
+		"required":   []any{"team"},
+	}}}
+
+	report, err := a.ExecuteEval(context.Background(), eval.Suite{Cases: []eval.Case{
+		{Name: "billing", Input: "hi", Arguments: map[string]any{"team": "billing"}},
+	}})
+	require.NoError(t, err)
+	assert.True(t, report.Passed)
+	assert.Equal(t, map[string]any{"team": "billing"}, ag.callOpts.Arguments)
+
+	_, err = a.ExecuteEval(context.Background(), eval.Suite{Cases: []eval.Case{
+		{Name: "numeric team", Input: "hi", Arguments: map[string]any{"team": 7}},
+	}})
+	assert.ErrorContains(t, err, "case `numeric team`: invalid arguments:")
+}
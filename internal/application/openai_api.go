@@ -0,0 +1,318 @@
+// Package application: OpenAI-compatible chat completions API, served next to MCP
+package application
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/quota"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// Types of OpenAI API errors.
+const (
+	openAIInvalidRequest = "invalid_request_error"
+	openAIRateLimit      = "rate_limit_error"
+	openAIServerError    = "server_error"
+)
+
+// chatCompletionRequest is the part of an OpenAI chat completions request the agent understands.
+type chatCompletionRequest struct {
+	Model         string        `json:"model"`
+	Messages      []chatMessage `json:"messages"`
+	Stream        bool          `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	N     int             `json:"n"`
+	Tools json.RawMessage `json:"tools"`
+}
+
+// chatMessage is a message of the conversation sent by the client.
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the content of the message: a string or an array of text parts.
+func (m chatMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("invalid content of a `%s` message", m.Role)
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("content parts of type `%s` are not supported", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// chatCompletion is a response, or a chunk of a streamed response.
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int         `json:"index"`
+	Message      *chatAnswer `json:"message,omitempty"`
+	Delta        *chatDelta  `json:"delta,omitempty"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type chatAnswer struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatDelta is the part of the answer carried by a chunk.
+type chatDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// chatUsage is the usage of the whole session. Cost is an extension of the OpenAI format.
+type chatUsage struct {
+	PromptTokens            int                `json:"prompt_tokens"`
+	CompletionTokens        int                `json:"completion_tokens"`
+	TotalTokens             int                `json:"total_tokens"`
+	CompletionTokensDetails *completionDetails `json:"completion_tokens_details,omitempty"`
+	Cost                    float64            `json:"cost"`
+}
+
+type completionDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+type openAIErrorResponse struct {
+	Error openAIError `json:"error"`
+}
+
+type openAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Param   string `json:"param,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// serveModels handles `GET /v1/models`: each exposed tool is a model.
+func (a *MCPApp) serveModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, openAIError{Message: fmt.Sprintf("method %s is not allowed", r.Method), Type: openAIInvalidRequest})
+		return
+	}
+	models := make([]map[string]any, 0, len(a.agents))
+	for _, agentConfig := range a.cfg.GetAgentConfigs() {
+		models = append(models, map[string]any{
+			"id":       agentConfig.Tool.Name,
+			"object":   "model",
+			"created":  0,
+			"owned_by": a.cfg.Agent.Name,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+}
+
+// serveChatCompletions handles `POST /v1/chat/completions`. The model names the tool whose agent answers;
+// the messages become the input of a session with the same limits, approvals and tracing as an MCP call.
+// Streamed responses carry the whole answer in one chunk, with progress sent as SSE comments before it.
+func (a *MCPApp) serveChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, openAIError{Message: fmt.Sprintf("method %s is not allowed", r.Method), Type: openAIInvalidRequest})
+		return
+	}
+	var req chatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRunRequestSize)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, openAIError{Message: fmt.Sprintf("invalid request body: %v", err), Type: openAIInvalidRequest})
+		return
+	}
+	if req.N > 1 {
+		writeOpenAIError(w, http.StatusBadRequest, openAIError{Message: "only one choice is supported", Type: openAIInvalidRequest, Param: "n"})
+		return
+	}
+	if tools := bytes.TrimSpace(req.Tools); len(tools) > 0 && string(tools) != "null" && string(tools) != "[]" {
+		writeOpenAIError(w, http.StatusBadRequest, openAIError{Message: "tools are not supported: the agent calls its own MCP tools", Type: openAIInvalidRequest, Param: "tools"})
+		return
+	}
+	ag, agentConfig, err := a.agentForTool(req.Model)
+	if err != nil {
+		writeOpenAIError(w, http.StatusNotFound, openAIError{
+			Message: fmt.Sprintf("the model `%s` does not exist, see /v1/models", req.Model),
+			Type:    openAIInvalidRequest, Param: "model", Code: "model_not_found",
+		})
+		return
+	}
+	input, err := chatInput(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, openAIError{Message: err.Error(), Type: openAIInvalidRequest, Param: "messages"})
+		return
+	}
+
+	// Join the trace of the caller
+	ctx := tracing.Extract(r.Context(), map[string]any{tracing.TraceParentKey: r.Header.Get(tracing.TraceParentKey)})
+	client := auth.ClientFromContext(ctx)
+	if client != "" {
+		a.logger.Infof("Chat completion with model `%s` by client `%s`", req.Model, client)
+	}
+	opts, err := a.sessionOptions(ctx, agentConfig.Tool, nil, "", 0)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, openAIError{Message: err.Error(), Type: openAIInvalidRequest})
+		return
+	}
+	// The client never gets the session ID, so the conversation would be saved for no one
+	opts.Stateless = true
+	done, err := a.admit(ctx, client, agentConfig.RequestBudget, &opts)
+	if err != nil {
+		a.logger.Warnf("Chat completion with model `%s` rejected: %v", req.Model, err)
+		writeOpenAILimitError(w, err)
+		return
+	}
+	var meta types.MetaInfo
+	defer func() { done(meta.CallCost) }()
+
+	completion := chatCompletion{ID: newCompletionID(), Created: time.Now().Unix(), Model: req.Model}
+	var answer string
+	if !req.Stream {
+		answer, meta, err = ag.RunSession(ctx, input, opts)
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, openAIError{Message: err.Error(), Type: openAIServerError})
+			return
+		}
+		completion.Object = "chat.completion"
+		completion.Choices = []chatChoice{{Message: &chatAnswer{Role: "assistant", Content: answer}, FinishReason: stringPtr("stop")}}
+		completion.Usage = chatUsageOf(meta)
+		writeJSON(w, http.StatusOK, completion)
+		return
+	}
+
+	completion.Object = "chat.completion.chunk"
+	chunk := func(delta chatDelta, finishReason *string) chatCompletion {
+		c := completion
+		c.Choices = []chatChoice{{Delta: &delta, FinishReason: finishReason}}
+		return c
+	}
+	stream := newEventStream(w)
+	stream.send("", chunk(chatDelta{Role: "assistant"}, nil))
+	opts.Progress = func(event types.ProgressEvent) {
+		stream.comment(progressMessage(event))
+	}
+	answer, meta, err = ag.RunSession(ctx, input, opts)
+	if err != nil {
+		stream.send("", openAIErrorResponse{Error: openAIError{Message: err.Error(), Type: openAIServerError}})
+		stream.write("", "[DONE]")
+		return
+	}
+	stream.send("", chunk(chatDelta{Content: answer}, nil))
+	stream.send("", chunk(chatDelta{}, stringPtr("stop")))
+	if req.StreamOptions.IncludeUsage {
+		usage := completion
+		usage.Choices = []chatChoice{}
+		usage.Usage = chatUsageOf(meta)
+		stream.send("", usage)
+	}
+	stream.write("", "[DONE]")
+}
+
+// chatInput renders the messages as the input of a session. The agent keeps its own system prompt and chat,
+// so a single user message is passed as it is and a longer conversation as a transcript ending with the last message.
+func chatInput(messages []chatMessage) (string, error) {
+	if len(messages) == 0 {
+		return "", errors.New("messages must not be empty")
+	}
+	last := messages[len(messages)-1]
+	if last.Role != "user" {
+		return "", fmt.Errorf("the last message must be from the user, got `%s`", last.Role)
+	}
+	question, err := last.text()
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(question) == "" {
+		return "", errors.New("the last message is empty")
+	}
+	if len(messages) == 1 {
+		return question, nil
+	}
+	var b strings.Builder
+	b.WriteString("Conversation so far:\n")
+	for _, message := range messages[:len(messages)-1] {
+		text, err := message.text()
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			fmt.Fprintf(&b, "%s: %s\n", message.Role, text)
+		}
+	}
+	b.WriteString("\nReply to the last message:\n")
+	b.WriteString(question)
+	return b.String(), nil
+}
+
+// chatUsageOf returns the usage of a session. Prompt tokens are all tokens of the session except the
+// completion of the final answer, so that the parts add up to the total.
+func chatUsageOf(meta types.MetaInfo) *chatUsage {
+	usage := &chatUsage{
+		PromptTokens:     max(meta.Tokens-meta.CompletionTokens, 0),
+		CompletionTokens: meta.CompletionTokens,
+		TotalTokens:      max(meta.Tokens, meta.CompletionTokens),
+		Cost:             meta.Cost,
+	}
+	if meta.ReasoningTokens > 0 {
+		usage.CompletionTokensDetails = &completionDetails{ReasoningTokens: meta.ReasoningTokens}
+	}
+	return usage
+}
+
+// writeOpenAILimitError answers a call that was not admitted, telling the client when to retry.
+func writeOpenAILimitError(w http.ResponseWriter, err error) {
+	var limitErr *quota.LimitError
+	if errors.As(err, &limitErr) && limitErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+	}
+	writeOpenAIError(w, http.StatusTooManyRequests, openAIError{Message: err.Error(), Type: openAIRateLimit, Code: errorTypeLimitExceeded})
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, err openAIError) {
+	writeJSON(w, status, openAIErrorResponse{Error: err})
+}
+
+func newCompletionID() string {
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	return "chatcmpl-" + hex.EncodeToString(id)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
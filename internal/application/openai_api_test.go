@@ -0,0 +1,182 @@
+package application
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/quota"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveOpenAI(a *MCPApp, ctx context.Context, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	a.apiHandler(configuration.MCPServerConfig{OpenAIEnabled: true}).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeChatCompletions(t *testing.T) {
+	ag := &mockAgent{callResult: "Paris", callMeta: types.MetaInfo{Tokens: 120, CompletionTokens: 20, ReasoningTokens: 5, Cost: 0.002}}
+	a := newRESTTestApp(ag)
+
+	rec := serveOpenAI(a, context.Background(), http.MethodPost, "/v1/chat/completions",
+		`{"model": "answer", "messages": [{"role": "user", "content": "Capital of France?"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "Capital of France?", ag.callInput)
+	var completion map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &completion))
+	assert.Equal(t, "chat.completion", completion["object"])
+	assert.Equal(t, "answer", completion["model"])
+	assert.True(t, strings.HasPrefix(completion["id"].(string), "chatcmpl-"))
+	assert.Equal(t, []any{map[string]any{
+		"index":         0.0,
+		"message":       map[string]any{"role": "assistant", "content": "Paris"},
+		"finish_reason": "stop",
+	}}, completion["choices"])
+	assert.Equal(t, map[string]any{
+		"prompt_tokens":             100.0,
+		"completion_tokens":         20.0,
+		"total_tokens":              120.0,
+		"completion_tokens_details": map[string]any{"reasoning_tokens": 5.0},
+		"cost":                      0.002,
+	}, completion["usage"])
+
+	rec = serveOpenAI(a, context.Background(), http.MethodPost, "/v1/chat/completions", `{"model": "answer", "messages": [
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": [{"type": "text", "text": "Capital of France?"}]},
+		{"role": "assistant", "content": "Paris"},
+		{"role": "user", "content": "And of Italy?"}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "Conversation so far:\nsystem: Be brief.\nuser: Capital of France?\nassistant: Paris\n\nReply to the last message:\nAnd of Italy?", ag.callInput)
+
+	ag.callErr = assert.AnError
+	rec = serveOpenAI(a, context.Background(), http.MethodPost, "/v1/chat/completions",
+		`{"model": "answer", "messages": [{"role": "user", "content": "hi"}]}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"type":"server_error"`)
+}
+
+func TestServeChatCompletions_BadRequests(t *testing.T) {
+	a := newRESTTestApp(&mockAgent{callResult: "ok"})
+	for name, tc := range map[string]struct {
+		body   string
+		status int
+		error  string
+	}{
+		"unknown model":    {`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`, http.StatusNotFound, `"code":"model_not_found"`},
+		"no messages":      {`{"model": "answer", "messages": []}`, http.StatusBadRequest, "messages must not be empty"},
+		"ends with answer": {`{"model": "answer", "messages": [{"role": "assistant", "content": "hi"}]}`, http.StatusBadRequest, "the last message must be from the user"},
+		"image":            {`{"model": "answer", "messages": [{"role": "user", "content": [{"type": "image_url"}]}]}`, http.StatusBadRequest, "content parts of type `image_url` are not supported"},
+		"tools":            {`{"model": "answer", "messages": [{"role": "user", "content": "hi"}], "tools": [{"type": "function"}]}`, http.StatusBadRequest, "tools are not supported"},
+		"several choices":  {`{"model": "answer", "messages": [{"role": "user", "content": "hi"}], "n": 2}`, http.StatusBadRequest, "only one choice is supported"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := serveOpenAI(a, context.Background(), http.MethodPost, "/v1/chat/completions", tc.body)
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.error)
+		})
+	}
+}
+
+func TestServeChatCompletions_SessionOptions(t *testing.T) {
+	ag := &mockAgent{callResult: "ok"}
+	a := newRESTTestApp(ag)
+	ctx := auth.WithClient(context.Background(), "sdk")
+
+	rec := serveOpenAI(a, ctx, http.MethodPost, "/v1/chat/completions", `{"model": "answer", "messages": [{"role": "user", "content": "hi"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "sdk", ag.callOpts.Client)
+	assert.True(t, ag.callOpts.Stateless, "the client cannot continue the session, so it is not saved")
+
+	a.cfg.Agent.Tools = []configuration.ToolDefinition{{Name: "answer", InputSchema: map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"team": map[string]any{"type": "string"}},
+		"required":   []any{"team"},
+	}}}
+	rec = serveOpenAI(a, ctx, http.MethodPost, "/v1/chat/completions", `{"model": "answer", "messages": [{"role": "user", "content": "hi"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"type":"invalid_request_error"`)
+	assert.Contains(t, rec.Body.String(), "invalid arguments:")
+}
+
+func TestServeChatCompletions_Limits(t *testing.T) {
+	a := newRESTTestApp(&mockAgent{callResult: "ok"})
+	limiter, err := quota.NewLimiter(configuration.LimitsConfig{
+		ClientLimits: configuration.ClientLimits{RequestsPerMinute: 1, Burst: 1},
+	}, a.logger)
+	require.NoError(t, err)
+	a.limiter = limiter
+
+	ctx := auth.WithClient(context.Background(), "sdk")
+	body := `{"model": "answer", "messages": [{"role": "user", "content": "hi"}]}`
+	assert.Equal(t, http.StatusOK, serveOpenAI(a, ctx, http.MethodPost, "/v1/chat/completions", body).Code)
+	rec := serveOpenAI(a, ctx, http.MethodPost, "/v1/chat/completions", body)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"type":"rate_limit_error"`)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestServeChatCompletions_Stream(t *testing.T) {
+	ag := &progressAgent{
+		mockAgent: mockAgent{callResult: "Paris", callMeta: types.MetaInfo{Tokens: 30, CompletionTokens: 10}},
+		events:    []types.ProgressEvent{{Kind: types.ProgressEventToolCall, Iteration: 1, ToolName: "search"}},
+	}
+	a := newRESTTestApp(ag)
+	rec := serveOpenAI(a, context.Background(), http.MethodPost, "/v1/chat/completions",
+		`{"model": "answer", "stream": true, "stream_options": {"include_usage": true}, "messages": [{"role": "user", "content": "hi"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	var comments []string
+	var chunks []map[string]any
+	done := false
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, ": "):
+			comments = append(comments, strings.TrimPrefix(line, ": "))
+		case line == "data: [DONE]":
+			done = true
+		case strings.HasPrefix(line, "data: "):
+			var chunk map[string]any
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk))
+			chunks = append(chunks, chunk)
+		}
+	}
+	assert.True(t, done, "the stream ends with [DONE]")
+	assert.Equal(t, []string{"Iteration 1: calling tool `search`"}, comments)
+	require.Len(t, chunks, 4)
+	delta := func(i int) any { return chunks[i]["choices"].([]any)[0].(map[string]any)["delta"] }
+	finish := func(i int) any { return chunks[i]["choices"].([]any)[0].(map[string]any)["finish_reason"] }
+	assert.Equal(t, "chat.completion.chunk", chunks[0]["object"])
+	assert.Equal(t, map[string]any{"role": "assistant"}, delta(0))
+	assert.Equal(t, map[string]any{"content": "Paris"}, delta(1))
+	assert.Nil(t, finish(1))
+	assert.Equal(t, map[string]any{}, delta(2))
+	assert.Equal(t, "stop", finish(2))
+	assert.Empty(t, chunks[3]["choices"])
+	assert.Equal(t, 30.0, chunks[3]["usage"].(map[string]any)["total_tokens"])
+	assert.Equal(t, chunks[0]["id"], chunks[3]["id"], "all chunks share the ID")
+}
+
+func TestServeModels(t *testing.T) {
+	a := newRESTTestApp(&mockAgent{})
+	a.cfg.Agent.Name = "research"
+	rec := serveOpenAI(a, context.Background(), http.MethodGet, "/v1/models", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"object": "list", "data": [{"id": "answer", "object": "model", "created": 0, "owned_by": "research"}]}`, rec.Body.String())
+
+	rec = serveOpenAI(a, context.Background(), http.MethodPost, "/v1/run", `{"input": "hi"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code, "the REST API is not enabled")
+}
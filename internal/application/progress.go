@@ -0,0 +1,69 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+)
+
+const progressNotificationMethod = "notifications/progress"
+
+// notificationSenderSpec sends notifications to the MCP client that issued the current request.
+type notificationSenderSpec interface {
+	SendNotificationToClient(ctx context.Context, method string, data map[string]interface{}) error
+}
+
+// newMCPProgressReporter returns a progress callback that forwards session events to the client
+// as notifications/progress. It returns nil if the request carries no progress token.
+func newMCPProgressReporter(ctx context.Context, req mcp.CallToolRequest, sender notificationSenderSpec, log *logrus.Logger) types.ProgressFunc {
+	if sender == nil || req.Params.Meta == nil || req.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	token := req.Params.Meta.ProgressToken
+	var mu sync.Mutex
+	progress := 0
+	return func(event types.ProgressEvent) {
+		// Serialize sends so the progress value received by the client always increases
+		mu.Lock()
+		defer mu.Unlock()
+		progress++
+		params := map[string]interface{}{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       progressMessage(event),
+			"kind":          event.Kind,
+			"iteration":     event.Iteration,
+			"tokens":        event.Tokens,
+			"cost":          event.Cost,
+		}
+		if event.ToolName != "" {
+			params["tool"] = event.ToolName
+		}
+		if err := sender.SendNotificationToClient(ctx, progressNotificationMethod, params); err != nil {
+			log.Warnf("failed to send progress notification: %v", err)
+		}
+	}
+}
+
+// progressMessage renders a human-readable description of the event.
+func progressMessage(event types.ProgressEvent) string {
+	switch event.Kind {
+	case types.ProgressEventIteration:
+		return fmt.Sprintf("Iteration %d: waiting for LLM (tokens: %d, cost: $%.4f)", event.Iteration, event.Tokens, event.Cost)
+	case types.ProgressEventToolCall:
+		return fmt.Sprintf("Iteration %d: calling tool `%s`", event.Iteration, event.ToolName)
+	case types.ProgressEventToolResult:
+		if event.IsError {
+			return fmt.Sprintf("Iteration %d: tool `%s` failed", event.Iteration, event.ToolName)
+		}
+		return fmt.Sprintf("Iteration %d: tool `%s` finished", event.Iteration, event.ToolName)
+	case types.ProgressEventApproval:
+		return fmt.Sprintf("Iteration %d: waiting for approval to call `%s`", event.Iteration, event.ToolName)
+	default:
+		return fmt.Sprintf("Iteration %d: %s", event.Iteration, event.Kind)
+	}
+}
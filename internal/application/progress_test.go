@@ -0,0 +1,58 @@
+package application
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockNotificationSender struct {
+	mu      sync.Mutex
+	methods []string
+	params  []map[string]interface{}
+}
+
+func (m *mockNotificationSender) SendNotificationToClient(ctx context.Context, method string, data map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.methods = append(m.methods, method)
+	m.params = append(m.params, data)
+	return nil
+}
+
+func TestNewMCPProgressReporter(t *testing.T) {
+	t.Run("no progress token", func(t *testing.T) {
+		req := mcp.CallToolRequest{}
+		assert.Nil(t, newMCPProgressReporter(context.Background(), req, &mockNotificationSender{}, newTestLogger()))
+	})
+
+	t.Run("sends notifications with increasing progress", func(t *testing.T) {
+		sender := &mockNotificationSender{}
+		req := mcp.CallToolRequest{}
+		req.Params.Meta = &mcp.Meta{ProgressToken: "tok-1"}
+		report := newMCPProgressReporter(context.Background(), req, sender, newTestLogger())
+		require.NotNil(t, report)
+
+		report(types.ProgressEvent{Kind: types.ProgressEventIteration, Iteration: 1, Tokens: 10, Cost: 0.01})
+		report(types.ProgressEvent{Kind: types.ProgressEventToolCall, Iteration: 1, ToolName: "search"})
+
+		require.Len(t, sender.params, 2)
+		assert.Equal(t, []string{"notifications/progress", "notifications/progress"}, sender.methods)
+		first := sender.params[0]
+		assert.Equal(t, "tok-1", first["progressToken"])
+		assert.Equal(t, 1, first["progress"])
+		assert.Equal(t, 1, first["iteration"])
+		assert.Equal(t, 10, first["tokens"])
+		assert.Equal(t, 0.01, first["cost"])
+		assert.NotContains(t, first, "tool")
+		second := sender.params[1]
+		assert.Equal(t, 2, second["progress"])
+		assert.Equal(t, "search", second["tool"])
+		assert.Equal(t, "Iteration 1: calling tool `search`", second["message"])
+	})
+}
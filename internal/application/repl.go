@@ -0,0 +1,344 @@
+// Package application: interactive chat with the agent in the terminal
+package application
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// replPreviewChars bounds the tool arguments and results shown in the REPL.
+const replPreviewChars = 200
+
+// replHelp lists the commands of the REPL.
+const replHelp = `Commands:
+  /tools          list the tools the agent can call
+  /cost           show tokens and cost of the chat and of all turns so far
+  /reset          start a new chat
+  /model [name]   show or switch the LLM model; the chat continues
+  /save <file>    save the chat as JSON
+  /prompt         show the system prompt of the chat
+  /exit           quit (or Ctrl+D)`
+
+// replAgentSpec is an agent the REPL chats with.
+type replAgentSpec interface {
+	agentSpec
+	// GetAllTools returns the tools offered to the LLM.
+	GetAllTools(ctx context.Context) ([]mcp.Tool, error)
+	// SystemPrompt returns the system prompt of the saved session, or of a new one if sessionID is empty.
+	SystemPrompt(ctx context.Context, sessionID string) (string, error)
+	// LoadSession returns the saved conversation. The second value is false if it does not exist or has expired.
+	LoadSession(sessionID string) (types.SessionState, bool, error)
+}
+
+// repl is an interactive chat with the agent of the first tool.
+// Responsibility: Keeping one conversation across turns and handling the slash commands
+// Features: Shows tool calls and results as they happen, asks the user to approve tool calls
+type repl struct {
+	app    *MCPApp
+	agent  replAgentSpec
+	config configuration.AgentConfig
+	lines  chan string // Lines of the input, closed at its end
+	inErr  error       // Error of reading the input, set before lines is closed
+	done   chan struct{}
+	out    io.Writer
+	outMu  sync.Mutex
+
+	sessionID   string
+	sessionMeta types.MetaInfo // Totals of the current chat, as of its last turn
+	turns       int
+	totalTokens int
+	totalCost   float64
+}
+
+// ExecuteREPL chats with the agent of the first tool, reading the user's messages and commands from in
+// until it is closed, `/exit` is entered or ctx is done. The chat is kept in a session, so sessions must be enabled.
+func (a *MCPApp) ExecuteREPL(ctx context.Context, in io.Reader, out io.Writer) error {
+	defer a.shutdownTracer()
+	if a.agent == nil {
+		return fmt.Errorf("agent not initialized")
+	}
+	if !a.cfg.GetSessionStoreConfig().Enabled() {
+		return fmt.Errorf("the REPL keeps the chat in a session: sessions must be enabled")
+	}
+	ag, ok := a.agent.(replAgentSpec)
+	if !ok {
+		return fmt.Errorf("the agent does not support the REPL")
+	}
+	r := &repl{
+		app:    a,
+		agent:  ag,
+		config: a.cfg.GetAgentConfigs()[0],
+		lines:  make(chan string),
+		done:   make(chan struct{}),
+		out:    out,
+	}
+	defer close(r.done)
+	go r.read(in)
+	return r.run(ctx)
+}
+
+// read passes the lines of in to r.lines, so that waiting for the user can be interrupted.
+func (r *repl) read(in io.Reader) {
+	defer close(r.lines)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRunRequestSize)
+	for scanner.Scan() {
+		select {
+		case r.lines <- scanner.Text():
+		case <-r.done:
+			return
+		}
+	}
+	r.inErr = scanner.Err()
+}
+
+// readLine returns the next line of the input. It returns false at the end of the input or once ctx is done.
+func (r *repl) readLine(ctx context.Context) (string, bool) {
+	select {
+	case line, ok := <-r.lines:
+		return line, ok
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+func (r *repl) run(ctx context.Context) error {
+	r.printf("Chatting with `%s` on model `%s`. Type /help for commands, /exit or Ctrl+D to quit.\n", r.config.Tool.Name, r.config.Model)
+	for {
+		r.printf("> ")
+		line, ok := r.readLine(ctx)
+		if !ok {
+			r.printf("\n")
+			if ctx.Err() != nil {
+				return nil
+			}
+			return r.inErr
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "/"):
+			if quit := r.command(ctx, line); quit {
+				return nil
+			}
+		default:
+			r.turn(ctx, line)
+		}
+	}
+}
+
+// turn sends one message to the agent and prints the answer.
+func (r *repl) turn(ctx context.Context, input string) {
+	opts := types.SessionOptions{
+		SessionID: r.sessionID,
+		Progress:  r.showProgress,
+		Approve:   r.approver(),
+	}
+	answer, meta, err := r.agent.RunSession(ctx, input, opts)
+	// Continued sessions report the totals of the whole chat
+	previous := types.MetaInfo{}
+	if r.sessionID != "" {
+		previous = r.sessionMeta
+	}
+	r.turns++
+	r.totalTokens += max(meta.Tokens-previous.Tokens, 0)
+	r.totalCost += max(meta.Cost-previous.Cost, 0)
+	if err != nil {
+		// The chat is saved only after an answer, so it stays as it was before this turn
+		r.printf("Error: %v\n", err)
+		return
+	}
+	r.sessionID = meta.SessionID
+	r.sessionMeta = meta
+	r.printf("%s\n", answer)
+	r.printf("(%d tokens, $%.4f, %.1fs)\n", meta.Tokens, meta.Cost, float64(meta.DurationMs)/1000)
+}
+
+// command runs a slash command. It returns true if the REPL should quit.
+func (r *repl) command(ctx context.Context, line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/help":
+		r.printf("%s\n", replHelp)
+	case "/tools":
+		r.listTools(ctx)
+	case "/cost":
+		r.printf("Chat: %d tokens, $%.4f\n", r.sessionMeta.Tokens, r.sessionMeta.Cost)
+		r.printf("All %d turns: %d tokens, $%.4f\n", r.turns, r.totalTokens, r.totalCost)
+	case "/reset":
+		r.reset()
+	case "/model":
+		r.switchModel(arg)
+	case "/save":
+		r.save(arg)
+	case "/prompt":
+		prompt, err := r.agent.SystemPrompt(ctx, r.sessionID)
+		if err != nil {
+			r.printf("Error: %v\n", err)
+			return false
+		}
+		if r.sessionID == "" {
+			r.printf("(no chat yet: rendered with an empty input)\n")
+		}
+		r.printf("%s\n", prompt)
+	default:
+		r.printf("Unknown command `%s`, see /help\n", name)
+	}
+	return false
+}
+
+func (r *repl) listTools(ctx context.Context) {
+	tools, err := r.agent.GetAllTools(ctx)
+	if err != nil {
+		r.printf("Error: %v\n", err)
+		return
+	}
+	for _, tool := range tools {
+		description, _, _ := strings.Cut(strings.TrimSpace(tool.Description), "\n")
+		r.printf("  %s: %s\n", tool.Name, description)
+	}
+}
+
+// reset ends the current chat, so that the next message begins a new one.
+func (r *repl) reset() {
+	if r.sessionID != "" {
+		// The chat is local, so it has no authenticated client
+		if err := r.agent.EndSession(r.sessionID, ""); err != nil {
+			r.app.logger.Warnf("Failed to end session `%s`: %v", r.sessionID, err)
+		}
+	}
+	r.sessionID = ""
+	r.sessionMeta = types.MetaInfo{}
+	r.printf("Started a new chat\n")
+}
+
+// switchModel replaces the agent by one on another model. The chat is kept in the session store, so it continues.
+func (r *repl) switchModel(model string) {
+	if model == "" {
+		r.printf("Model: %s\n", r.config.Model)
+		return
+	}
+	if r.app.newAgent == nil {
+		r.printf("Error: switching models is not supported\n")
+		return
+	}
+	config := r.config
+	config.Model = model
+	ag, err := r.app.newAgent(config)
+	if err != nil {
+		r.printf("Error: %v\n", err)
+		return
+	}
+	replAgent, ok := ag.(replAgentSpec)
+	if !ok {
+		r.printf("Error: the agent does not support the REPL\n")
+		return
+	}
+	r.agent = replAgent
+	r.config = config
+	r.printf("Switched to model `%s`\n", model)
+}
+
+// save writes the saved state of the chat to a JSON file.
+func (r *repl) save(path string) {
+	if path == "" {
+		r.printf("Usage: /save <file>\n")
+		return
+	}
+	if r.sessionID == "" {
+		r.printf("Nothing to save yet\n")
+		return
+	}
+	state, ok, err := r.agent.LoadSession(r.sessionID)
+	if err == nil && !ok {
+		err = fmt.Errorf("session `%s` not found or expired", r.sessionID)
+	}
+	if err != nil {
+		r.printf("Error: %v\n", err)
+		return
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		r.printf("Error: failed to save the chat: %v\n", err)
+		return
+	}
+	r.printf("Saved %d messages to %s\n", len(state.Messages), path)
+}
+
+// showProgress prints tool calls and their results as they happen.
+func (r *repl) showProgress(event types.ProgressEvent) {
+	switch event.Kind {
+	case types.ProgressEventToolCall:
+		args, err := json.Marshal(event.Arguments)
+		if err != nil {
+			args = []byte(fmt.Sprintf("%v", event.Arguments))
+		}
+		r.printf("  -> %s %s\n", event.ToolName, preview(string(args)))
+	case types.ProgressEventToolResult:
+		status := "<-"
+		if event.IsError {
+			status = "<- failed:"
+		}
+		r.printf("  %s %s: %s\n", status, event.ToolName, preview(event.Result))
+	}
+}
+
+// approver returns the callback deciding on calls that require approval: the local hook if configured,
+// otherwise the user of the REPL, who answers on the next input line while the turn runs.
+func (r *repl) approver() types.ApprovalFunc {
+	if r.app.approvalHook != nil {
+		return r.app.approvalHook.Approve
+	}
+	var mu sync.Mutex
+	return func(ctx context.Context, req types.ApprovalRequest) (types.ApprovalDecision, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		r.printf("%s [y/N] ", approvalMessage(req))
+		line, ok := r.readLine(ctx)
+		if !ok {
+			return types.ApprovalDecision{}, fmt.Errorf("no answer from the user")
+		}
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer == "y" || answer == "yes" {
+			return types.ApprovalDecision{Approved: true}, nil
+		}
+		return types.ApprovalDecision{Approved: false, Reason: "rejected in the REPL"}, nil
+	}
+}
+
+// printf writes to the terminal. Progress events may come from several goroutines.
+func (r *repl) printf(format string, args ...any) {
+	r.outMu.Lock()
+	defer r.outMu.Unlock()
+	_, _ = fmt.Fprintf(r.out, format, args...)
+}
+
+// preview returns text on one line, cut to replPreviewChars.
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= replPreviewChars {
+		return text
+	}
+	cut := replPreviewChars
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "..."
+}
@@ -0,0 +1,187 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// replAgent keeps a chat like an agent with a session store and calls a tool on each turn.
+type replAgent struct {
+	model    string
+	sessions map[string][]string
+	inputs   []string
+	ended    []string
+	approval *types.ApprovalDecision
+}
+
+func newREPLAgent(model string) *replAgent {
+	return &replAgent{model: model, sessions: map[string][]string{}}
+}
+
+func (m *replAgent) RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+	m.inputs = append(m.inputs, input)
+	if input == "fail" {
+		return "", types.MetaInfo{}, assert.AnError
+	}
+	if strings.HasPrefix(input, "delete") {
+		decision, err := opts.Approve(ctx, types.ApprovalRequest{ServerID: "files", ToolName: "delete", Arguments: map[string]any{"path": "a.txt"}})
+		if err != nil {
+			return "", types.MetaInfo{}, err
+		}
+		m.approval = &decision
+	}
+	opts.Progress(types.ProgressEvent{Kind: types.ProgressEventToolCall, ToolName: "search", Arguments: map[string]any{"q": input}})
+	opts.Progress(types.ProgressEvent{Kind: types.ProgressEventToolResult, ToolName: "search", Result: "found\n" + input})
+	sessionID := opts.SessionID
+	if sessionID == "" {
+		sessionID = "s" + string(rune('0'+len(m.sessions)+1))
+	}
+	m.sessions[sessionID] = append(m.sessions[sessionID], input)
+	turns := len(m.sessions[sessionID])
+	return m.model + ": " + input, types.MetaInfo{Tokens: 100 * turns, Cost: 0.01 * float64(turns), SessionID: sessionID}, nil
+}
+
+func (m *replAgent) EndSession(sessionID string, client string) error {
+	m.ended = append(m.ended, sessionID)
+	return nil
+}
+
+func (m *replAgent) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
+	return []mcp.Tool{mcp.NewTool("search", mcp.WithDescription("Search the web.\nReturns links.")), finishToolForTest}, nil
+}
+
+func (m *replAgent) SystemPrompt(ctx context.Context, sessionID string) (string, error) {
+	if sessionID == "" {
+		return "You answer: ", nil
+	}
+	return "You answer: " + m.sessions[sessionID][0], nil
+}
+
+func (m *replAgent) LoadSession(sessionID string) (types.SessionState, bool, error) {
+	inputs, ok := m.sessions[sessionID]
+	state := types.SessionState{ID: sessionID}
+	for _, input := range inputs {
+		state.Messages = append(state.Messages, llms.TextParts(llms.ChatMessageTypeHuman, input))
+	}
+	return state, ok, nil
+}
+
+var finishToolForTest = mcp.NewTool("finish", mcp.WithDescription("Answer the user."))
+
+func newREPLTestApp(ag *replAgent) *MCPApp {
+	a := newRESTTestApp(ag)
+	a.cfg.Agent.Sessions.Store = configuration.SessionStoreMemory
+	a.cfg.Agent.LLM.Model = ag.model
+	return a
+}
+
+func runREPL(t *testing.T, a *MCPApp, input string) string {
+	t.Helper()
+	var out strings.Builder
+	require.NoError(t, a.ExecuteREPL(context.Background(), strings.NewReader(input), &out))
+	return out.String()
+}
+
+func TestExecuteREPL(t *testing.T) {
+	ag := newREPLAgent("gpt-4o")
+	a := newREPLTestApp(ag)
+	out := runREPL(t, a, "first\n\nsecond\nfail\n/cost\n/reset\nthird\n/cost\n")
+
+	assert.Equal(t, []string{"first", "second", "fail", "third"}, ag.inputs)
+	assert.Equal(t, []string{"first", "second"}, ag.sessions["s1"], "the chat continues across turns")
+	assert.Equal(t, []string{"third"}, ag.sessions["s2"], "/reset starts a new chat")
+	assert.Equal(t, []string{"s1"}, ag.ended)
+	assert.Contains(t, out, "Chatting with `answer` on model `gpt-4o`")
+	assert.Contains(t, out, "  -> search {\"q\":\"first\"}\n")
+	assert.Contains(t, out, "  <- search: found first\n")
+	assert.Contains(t, out, "gpt-4o: second\n(200 tokens, $0.0200, 0.0s)\n")
+	assert.Contains(t, out, "Error: "+assert.AnError.Error())
+	assert.Contains(t, out, "Chat: 200 tokens, $0.0200\nAll 3 turns: 200 tokens, $0.0200\n")
+	assert.Contains(t, out, "Chat: 100 tokens, $0.0100\nAll 4 turns: 300 tokens, $0.0300\n")
+}
+
+func TestExecuteREPL_Commands(t *testing.T) {
+	ag := newREPLAgent("gpt-4o")
+	a := newREPLTestApp(ag)
+	other := newREPLAgent("claude")
+	other.sessions = ag.sessions
+	var built configuration.AgentConfig
+	a.newAgent = func(cfg configuration.AgentConfig) (agentSpec, error) {
+		built = cfg
+		return other, nil
+	}
+	path := filepath.Join(t.TempDir(), "transcript.json")
+
+	out := runREPL(t, a, strings.Join([]string{
+		"/prompt", "/save " + path, "/tools", "hello", "/prompt", "/model", "/model claude", "again", "/save " + path, "/unknown", "/exit", "ignored",
+	}, "\n"))
+
+	assert.Contains(t, out, "(no chat yet: rendered with an empty input)\nYou answer: \n")
+	assert.Contains(t, out, "Nothing to save yet")
+	assert.Contains(t, out, "  search: Search the web.\n  finish: Answer the user.\n")
+	assert.Contains(t, out, "> You answer: hello\n")
+	assert.Contains(t, out, "Model: gpt-4o\n")
+	assert.Contains(t, out, "Switched to model `claude`")
+	assert.Equal(t, "claude", built.Model)
+	assert.Equal(t, "answer", built.Tool.Name)
+	assert.Contains(t, out, "claude: again\n")
+	assert.Equal(t, []string{"hello", "again"}, ag.sessions["s1"], "the chat continues on the new model")
+	assert.Contains(t, out, "Saved 2 messages to "+path)
+	assert.Contains(t, out, "Unknown command `/unknown`")
+	assert.Equal(t, []string{"hello"}, ag.inputs)
+	assert.Equal(t, []string{"again"}, other.inputs, "/exit stops reading")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var state types.SessionState
+	require.NoError(t, json.Unmarshal(data, &state))
+	assert.Equal(t, "s1", state.ID)
+	assert.Len(t, state.Messages, 2)
+}
+
+func TestExecuteREPL_Approval(t *testing.T) {
+	ag := newREPLAgent("gpt-4o")
+	a := newREPLTestApp(ag)
+	out := runREPL(t, a, "delete it\ny\n")
+	assert.Contains(t, out, "The agent wants to call `delete` on server `files` with arguments {\"path\":\"a.txt\"}. Allow it? [y/N] ")
+	require.NotNil(t, ag.approval)
+	assert.True(t, ag.approval.Approved)
+
+	runREPL(t, a, "delete it\nno\n")
+	assert.False(t, ag.approval.Approved)
+}
+
+func TestExecuteREPL_Errors(t *testing.T) {
+	a := newREPLTestApp(newREPLAgent("gpt-4o"))
+	a.cfg.Agent.Sessions.Store = ""
+	assert.ErrorContains(t, a.ExecuteREPL(context.Background(), strings.NewReader(""), &strings.Builder{}), "sessions must be enabled")
+
+	a = newRESTTestApp(&mockAgent{})
+	a.cfg.Agent.Sessions.Store = configuration.SessionStoreMemory
+	assert.ErrorContains(t, a.ExecuteREPL(context.Background(), strings.NewReader(""), &strings.Builder{}), "does not support the REPL")
+
+	a = newREPLTestApp(newREPLAgent("gpt-4o"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, a.ExecuteREPL(ctx, strings.NewReader("hello\n"), &strings.Builder{}), "a cancelled REPL quits")
+}
+
+func TestPreview(t *testing.T) {
+	assert.Equal(t, "a b c", preview("a\n  b\tc"))
+	long := strings.Repeat("é", replPreviewChars)
+	cut := preview(long)
+	assert.True(t, strings.HasSuffix(cut, "..."))
+	assert.LessOrEqual(t, len(cut), replPreviewChars+3)
+	assert.True(t, strings.HasPrefix(long, strings.TrimSuffix(cut, "...")), "cut on a rune boundary")
+}
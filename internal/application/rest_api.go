@@ -0,0 +1,217 @@
+// Package application: REST API of the agent, served next to MCP
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/quota"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// maxRunRequestSize bounds the body of a REST call.
+const maxRunRequestSize = 10 << 20
+
+// apiHandler routes the enabled HTTP APIs, which the MCP server mounts under /v1/.
+func (a *MCPApp) apiHandler(cfg configuration.MCPServerConfig) http.Handler {
+	mux := http.NewServeMux()
+	if cfg.APIEnabled {
+		mux.HandleFunc("/v1/run", a.serveRun)
+	}
+	if cfg.OpenAIEnabled {
+		mux.HandleFunc("/v1/chat/completions", a.serveChatCompletions)
+		mux.HandleFunc("/v1/models", a.serveModels)
+	}
+	return mux
+}
+
+// runRequest is the body of `POST /v1/run`.
+type runRequest struct {
+	Input string `json:"input"`
+	// Arguments are the arguments of the input schema of the tool, if it has one.
+	Arguments map[string]any `json:"arguments"`
+	Options   runOptions     `json:"options"`
+}
+
+// runOptions are the optional settings of a REST call, the same as the optional arguments of the MCP tool.
+type runOptions struct {
+	// Tool selects the agent when several tools are served; empty runs the first one.
+	Tool      string  `json:"tool"`
+	SessionID string  `json:"session_id"`
+	Budget    float64 `json:"budget"`
+}
+
+// validate checks the input and options that do not depend on the configuration.
+func (r runRequest) validate() error {
+	if r.Input == "" {
+		return fmt.Errorf("empty input")
+	}
+	if r.Options.Budget < 0 {
+		return fmt.Errorf("invalid budget option: expected a positive number, got %v", r.Options.Budget)
+	}
+	return nil
+}
+
+// runProgressEvent is a progress event as streamed to REST clients.
+type runProgressEvent struct {
+	types.ProgressEvent
+	Message string `json:"message"`
+}
+
+// serveRun handles `POST /v1/run` with the same limits, approvals and tracing as an MCP call of the tool.
+// It answers with the JSON of the direct call mode, or, if the client accepts `text/event-stream`,
+// with an SSE stream of `progress` events that ends with a `result` event holding that JSON.
+func (a *MCPApp) serveRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		a.writeRunError(w, http.StatusMethodNotAllowed, "user", fmt.Errorf("method %s is not allowed", r.Method), nil)
+		return
+	}
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRunRequestSize)).Decode(&req); err != nil {
+		a.writeRunError(w, http.StatusBadRequest, "user", fmt.Errorf("invalid request body: %w", err), nil)
+		return
+	}
+	if err := req.validate(); err != nil {
+		a.writeRunError(w, http.StatusBadRequest, "user", err, nil)
+		return
+	}
+	toolName := req.Options.Tool
+	if toolName == "" {
+		toolName = a.cfg.GetAgentConfigs()[0].Tool.Name
+	}
+	ag, agentConfig, err := a.agentForTool(toolName)
+	if err != nil {
+		a.writeRunError(w, http.StatusNotFound, "user", err, nil)
+		return
+	}
+
+	// Join the trace of the caller
+	ctx := tracing.Extract(r.Context(), map[string]any{tracing.TraceParentKey: r.Header.Get(tracing.TraceParentKey)})
+	client := auth.ClientFromContext(ctx)
+	if client != "" {
+		a.logger.Infof("REST API call of tool `%s` by client `%s`", toolName, client)
+	}
+	opts, err := a.sessionOptions(ctx, agentConfig.Tool, req.Arguments, req.Options.SessionID, req.Options.Budget)
+	if err != nil {
+		a.writeRunError(w, http.StatusBadRequest, "user", err, nil)
+		return
+	}
+	done, err := a.admit(ctx, client, agentConfig.RequestBudget, &opts)
+	if err != nil {
+		a.logger.Warnf("REST API call of tool `%s` rejected: %v", toolName, err)
+		a.writeLimitError(w, err)
+		return
+	}
+	var result types.DirectCallResult
+	defer func() { done(result.Meta.CallCost) }()
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		result = a.handleDirectCall(ctx, ag, req.Input, opts)
+		writeJSON(w, runStatus(result), result)
+		return
+	}
+	stream := newEventStream(w)
+	opts.Progress = func(event types.ProgressEvent) {
+		stream.send("progress", runProgressEvent{ProgressEvent: event, Message: progressMessage(event)})
+	}
+	result = a.handleDirectCall(ctx, ag, req.Input, opts)
+	stream.send("result", result)
+}
+
+// writeRunError answers a REST call that did not start a session.
+func (a *MCPApp) writeRunError(w http.ResponseWriter, status int, errType string, err error, details any) {
+	result, _, _ := a.outputErrorAndExit(errType, err)
+	result.Error.Details = details
+	writeJSON(w, status, result)
+}
+
+// writeLimitError answers a REST call that was not admitted, telling the client when to retry.
+func (a *MCPApp) writeLimitError(w http.ResponseWriter, err error) {
+	var limitErr *quota.LimitError
+	if !errors.As(err, &limitErr) {
+		a.writeRunError(w, http.StatusServiceUnavailable, errorTypeLimitExceeded, err, nil)
+		return
+	}
+	details := map[string]any{"limit": limitErr.Limit}
+	if limitErr.RetryAfter > 0 {
+		retryAfter := math.Ceil(limitErr.RetryAfter.Seconds())
+		details["retryAfterSeconds"] = retryAfter
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+	}
+	a.writeRunError(w, http.StatusTooManyRequests, errorTypeLimitExceeded, err, details)
+}
+
+// runStatus returns the HTTP status of a finished REST call.
+func runStatus(result types.DirectCallResult) int {
+	if result.Success {
+		return http.StatusOK
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// eventStream writes server-sent events to a REST client. Safe for concurrent use.
+type eventStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return &eventStream{w: w, flusher: flusher}
+}
+
+// send writes data as the JSON of an event. An empty event name sends an unnamed `message` event.
+func (s *eventStream) send(event string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	s.write(event, string(payload))
+}
+
+// comment writes a comment line, which clients ignore.
+func (s *eventStream) comment(text string) {
+	s.writeRaw(": " + strings.ReplaceAll(text, "\n", " ") + "\n\n")
+}
+
+// write writes one event. Errors are ignored: a client that went away cancels the session through its context.
+func (s *eventStream) write(event, data string) {
+	if event != "" {
+		s.writeRaw("event: " + event + "\ndata: " + data + "\n\n")
+		return
+	}
+	s.writeRaw("data: " + data + "\n\n")
+}
+
+func (s *eventStream) writeRaw(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, _ = io.WriteString(s.w, text)
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
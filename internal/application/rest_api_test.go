@@ -0,0 +1,196 @@
+package application
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/quota"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// progressAgent reports progress events before answering.
+type progressAgent struct {
+	mockAgent
+	events []types.ProgressEvent
+}
+
+func (m *progressAgent) RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+	for _, event := range m.events {
+		if opts.Progress != nil {
+			opts.Progress(event)
+		}
+	}
+	return m.mockAgent.RunSession(ctx, input, opts)
+}
+
+func newRESTTestApp(ag agentSpec) *MCPApp {
+	a := &MCPApp{agent: ag, agents: map[string]agentSpec{"answer": ag}, cfg: &configuration.Configuration{}, logger: newTestLogger()}
+	a.cfg.Agent.Tool.Name = "answer"
+	a.cfg.Agent.Tool.ArgumentName = "text"
+	return a
+}
+
+func postRun(a *MCPApp, ctx context.Context, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/run", strings.NewReader(body)).WithContext(ctx)
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	rec := httptest.NewRecorder()
+	a.serveRun(rec, req)
+	return rec
+}
+
+func decodeRunResult(t *testing.T, rec *httptest.ResponseRecorder) types.DirectCallResult {
+	t.Helper()
+	var result types.DirectCallResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result), rec.Body.String())
+	return result
+}
+
+func TestServeRun(t *testing.T) {
+	ag := &mockAgent{callResult: "42", callMeta: types.MetaInfo{Tokens: 10, Cost: 0.01, SessionID: "sess-1"}}
+	a := newRESTTestApp(ag)
+
+	rec := postRun(a, context.Background(), `{"input": "question", "options": {"session_id": "sess-1", "budget": 0.5}}`, http.Header{
+		tracing.TraceParentKey: {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	result := decodeRunResult(t, rec)
+	assert.True(t, result.Success)
+	assert.Equal(t, "42", result.Result["answer"])
+	assert.Equal(t, "sess-1", result.Meta.SessionID)
+	assert.Equal(t, "sess-1", ag.callOpts.SessionID)
+	assert.Equal(t, 0.5, ag.callOpts.RequestBudget)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		tracing.FormatTraceParent(tracing.SpanContextFromContext(ag.callCtx)), "the session joins the trace of the caller")
+
+	ag.callErr = assert.AnError
+	rec = postRun(a, context.Background(), `{"input": "question"}`, nil)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	result = decodeRunResult(t, rec)
+	assert.False(t, result.Success)
+	assert.Equal(t, "internal", result.Error.Type)
+}
+
+func TestServeRun_BadRequests(t *testing.T) {
+	a := newRESTTestApp(&mockAgent{callResult: "ok"})
+	for name, tc := range map[string]struct {
+		body    string
+		status  int
+		message string
+	}{
+		"invalid JSON":    {`{"input":`, http.StatusBadRequest, "invalid request body"},
+		"empty input":     {`{"input": ""}`, http.StatusBadRequest, "empty input"},
+		"negative budget": {`{"input": "hi", "options": {"budget": -1}}`, http.StatusBadRequest, "invalid budget option"},
+		"unknown tool":    {`{"input": "hi", "options": {"tool": "other"}}`, http.StatusNotFound, "invalid tool name: other"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := postRun(a, context.Background(), tc.body, nil)
+			assert.Equal(t, tc.status, rec.Code)
+			result := decodeRunResult(t, rec)
+			assert.Equal(t, "user", result.Error.Type)
+			assert.Contains(t, result.Error.Message, tc.message)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	a.serveRun(rec, httptest.NewRequest(http.MethodGet, "/v1/run", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+}
+
+func TestServeRun_Arguments(t *testing.T) {
+	ag := &mockAgent{callResult: "ok"}
+	a := newRESTTestApp(ag)
+	a.cfg.Agent.Tools = []configuration.ToolDefinition{{Name: "answer", InputSchema: map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"team": map[string]any{"type": "string"}},
+		"required":   []any{"team"},
+	}}}
+	ctx := auth.WithClient(context.Background(), "cron")
+
+	rec := postRun(a, ctx, `{"input": "hi", "arguments": {"team": "billing", "other": 1}}`, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]any{"team": "billing"}, ag.callOpts.Arguments)
+	assert.Equal(t, "cron", ag.callOpts.Client)
+
+	rec = postRun(a, ctx, `{"input": "hi"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	result := decodeRunResult(t, rec)
+	assert.Equal(t, "user", result.Error.Type)
+	assert.Contains(t, result.Error.Message, "invalid arguments:")
+}
+
+func TestServeRun_Limits(t *testing.T) {
+	a := newRESTTestApp(&mockAgent{callResult: "ok", callMeta: types.MetaInfo{Cost: 1, CallCost: 1}})
+	limiter, err := quota.NewLimiter(configuration.LimitsConfig{
+		ClientLimits: configuration.ClientLimits{DailySpend: 1},
+	}, a.logger)
+	require.NoError(t, err)
+	a.limiter = limiter
+
+	ctx := auth.WithClient(context.Background(), "cron")
+	assert.Equal(t, http.StatusOK, postRun(a, ctx, `{"input": "hi"}`, nil).Code)
+	rec := postRun(a, ctx, `{"input": "hi"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	result := decodeRunResult(t, rec)
+	assert.Equal(t, errorTypeLimitExceeded, result.Error.Type)
+	assert.Equal(t, quota.LimitSpend, result.Error.Details.(map[string]any)["limit"])
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestServeRun_Stream(t *testing.T) {
+	ag := &progressAgent{
+		mockAgent: mockAgent{callResult: "done"},
+		events: []types.ProgressEvent{
+			{Kind: types.ProgressEventIteration, Iteration: 1},
+			{Kind: types.ProgressEventToolCall, Iteration: 1, ToolName: "search"},
+		},
+	}
+	a := newRESTTestApp(ag)
+	rec := postRun(a, context.Background(), `{"input": "hi"}`, http.Header{"Accept": {"text/event-stream"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	type event struct {
+		name string
+		data map[string]any
+	}
+	var events []event
+	scanner := bufio.NewScanner(rec.Body)
+	var current event
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.data))
+		case line == "":
+			events = append(events, current)
+			current = event{}
+		}
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, "progress", events[0].name)
+	assert.Equal(t, "iteration", events[0].data["kind"])
+	assert.Equal(t, "progress", events[1].name)
+	assert.Equal(t, "search", events[1].data["tool"])
+	assert.Equal(t, "Iteration 1: calling tool `search`", events[1].data["message"])
+	assert.Equal(t, "result", events[2].name)
+	assert.Equal(t, true, events[2].data["success"])
+	assert.Equal(t, map[string]any{"answer": "done"}, events[2].data["result"])
+}
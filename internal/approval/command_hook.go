@@ -0,0 +1,73 @@
+// Package approval asks a human whether a tool call may run.
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// exitRejected is the exit code of a hook command that rejects the call.
+	exitRejected = 1
+	// waitDelay is how long to wait for the output of a command after it is killed.
+	waitDelay = time.Second
+)
+
+// CommandHook decides on tool calls by running a local command.
+// Responsibility: Approving tool calls without an MCP client that supports elicitation
+// Features: The request is written to the command's stdin as JSON; exit code 0 approves the call,
+// exit code 1 rejects it with the trimmed stdout as the reason; anything else rejects it as a failure
+type CommandHook struct {
+	cfg configuration.ApprovalHookConfig
+	log *logrus.Logger
+}
+
+// NewCommandHook creates a CommandHook for the configured command.
+func NewCommandHook(cfg configuration.ApprovalHookConfig, log *logrus.Logger) *CommandHook {
+	return &CommandHook{cfg: cfg, log: log}
+}
+
+// Approve runs the command for the request and returns its decision.
+func (h *CommandHook) Approve(ctx context.Context, req types.ApprovalRequest) (types.ApprovalDecision, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return types.ApprovalDecision{}, fmt.Errorf("failed to encode approval request: %w", err)
+	}
+	if h.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, h.cfg.Command, h.cfg.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Do not wait for children of a killed command that still hold its output open
+	cmd.WaitDelay = waitDelay
+	h.log.Debugf("Running approval hook `%s` for tool `%s`", h.cfg.Command, req.ToolName)
+	err = cmd.Run()
+	output := strings.TrimSpace(stdout.String())
+	if err == nil {
+		return types.ApprovalDecision{Approved: true, Reason: output}, nil
+	}
+	if ctx.Err() != nil {
+		return types.ApprovalDecision{}, fmt.Errorf("approval hook did not answer: %w", ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == exitRejected {
+		return types.ApprovalDecision{Approved: false, Reason: output}, nil
+	}
+	return types.ApprovalDecision{}, fmt.Errorf("approval hook failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
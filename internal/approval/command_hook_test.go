@@ -0,0 +1,57 @@
+package approval
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHook(script string, timeout time.Duration) *CommandHook {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return NewCommandHook(configuration.ApprovalHookConfig{
+		Command: "sh",
+		Args:    []string{"-c", script},
+		Timeout: timeout,
+	}, log)
+}
+
+func TestCommandHook_Approve(t *testing.T) {
+	req := types.ApprovalRequest{ServerID: "fs", ToolName: "delete_file", Arguments: map[string]any{"path": "/tmp/x"}}
+
+	t.Run("approved", func(t *testing.T) {
+		// The hook sees the request on stdin
+		hook := newTestHook(`grep -q '"tool":"delete_file"'`, time.Second)
+		decision, err := hook.Approve(context.Background(), req)
+		require.NoError(t, err)
+		assert.True(t, decision.Approved)
+	})
+
+	t.Run("rejected with reason", func(t *testing.T) {
+		hook := newTestHook(`echo "not in /tmp"; exit 1`, time.Second)
+		decision, err := hook.Approve(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, types.ApprovalDecision{Approved: false, Reason: "not in /tmp"}, decision)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		hook := newTestHook(`echo broken >&2; exit 3`, time.Second)
+		_, err := hook.Approve(context.Background(), req)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "broken")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		hook := newTestHook(`sleep 5`, 50*time.Millisecond)
+		_, err := hook.Approve(context.Background(), req)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
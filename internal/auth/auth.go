@@ -0,0 +1,125 @@
+// Package auth authenticates the clients of the HTTP transports.
+// Responsibility: Deciding who sent an HTTP request and passing that identity on in the request context
+// Features: Static bearer tokens, HMAC-signed bearer tokens, htpasswd files with HTTP Basic credentials
+// and client certificates of mutual TLS
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/sirupsen/logrus"
+)
+
+const realm = "speelka-agent"
+
+type clientKey struct{}
+
+// WithClient returns a context that carries the name of the authenticated client.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the name of the authenticated client, or "" if the request was not authenticated.
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// Authenticator identifies the client of a request by one kind of credentials.
+type Authenticator interface {
+	// Authenticate returns the name of the client if the request carries credentials it accepts.
+	Authenticate(r *http.Request) (string, bool)
+}
+
+// Middleware rejects the requests that none of its authenticators accept.
+// Responsibility: Guarding an HTTP handler
+// Features: Without authenticators every request passes anonymously, unless mutual TLS
+// identifies the client by its certificate
+type Middleware struct {
+	authenticators []Authenticator
+	challenges     []string
+	log            *logrus.Logger
+}
+
+// NewMiddleware creates the authenticators of the configuration. With clientCerts set and no other
+// authenticator, clients are named after their verified TLS certificates.
+func NewMiddleware(cfg configuration.HTTPAuthConfig, clientCerts bool, log *logrus.Logger) (*Middleware, error) {
+	m := &Middleware{log: log}
+	if len(cfg.Tokens) > 0 {
+		m.authenticators = append(m.authenticators, NewTokenAuthenticator(cfg.Tokens))
+	}
+	if cfg.HMACSecret != "" {
+		m.authenticators = append(m.authenticators, NewHMACAuthenticator([]byte(cfg.HMACSecret)))
+	}
+	if len(m.authenticators) > 0 {
+		m.challenges = append(m.challenges, `Bearer realm="`+realm+`"`)
+	}
+	if cfg.HtpasswdFile != "" {
+		htpasswd, err := LoadHtpasswd(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		m.authenticators = append(m.authenticators, htpasswd)
+		m.challenges = append(m.challenges, `Basic realm="`+realm+`"`)
+	}
+	if len(m.authenticators) == 0 && clientCerts {
+		m.authenticators = append(m.authenticators, clientCertAuthenticator{})
+	}
+	return m, nil
+}
+
+// Wrap returns a handler that serves the authenticated requests with next and answers the others with 401.
+// The context of an authenticated request carries the name of the client.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	if len(m.authenticators) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range m.authenticators {
+			if client, ok := a.Authenticate(r); ok {
+				next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), client)))
+				return
+			}
+		}
+		m.log.Warnf("[AUTH] Rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		for _, challenge := range m.challenges {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// clientCertAuthenticator names clients after the certificates that the TLS handshake verified.
+type clientCertAuthenticator struct{}
+
+func (clientCertAuthenticator) Authenticate(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	return certificateName(r.TLS.VerifiedChains[0][0])
+}
+
+// certificateName returns the common name of the certificate, or its first DNS name.
+func certificateName(cert *x509.Certificate) (string, bool) {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, true
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0], true
+	}
+	return "", false
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func requestWith(header, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return r
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a := NewTokenAuthenticator(map[string]string{"alice": "secret-a", "bob": "secret-b"})
+
+	client, ok := a.Authenticate(requestWith("Authorization", "Bearer secret-b"))
+	assert.True(t, ok)
+	assert.Equal(t, "bob", client)
+
+	_, ok = a.Authenticate(requestWith("Authorization", "Bearer secret-c"))
+	assert.False(t, ok)
+	_, ok = a.Authenticate(requestWith("Authorization", "Basic secret-a"))
+	assert.False(t, ok, "only bearer tokens are accepted")
+	_, ok = a.Authenticate(requestWith("", ""))
+	assert.False(t, ok)
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("shared")
+	now := time.Unix(1700000000, 0)
+	a := NewHMACAuthenticator(secret)
+	a.now = func() time.Time { return now }
+
+	token := SignToken(secret, "team.alice", now.Add(time.Hour))
+	client, ok := a.Authenticate(requestWith("Authorization", "Bearer "+token))
+	assert.True(t, ok)
+	assert.Equal(t, "team.alice", client, "client names may contain dots")
+
+	tests := map[string]string{
+		"expired":      SignToken(secret, "alice", now.Add(-time.Second)),
+		"other secret": SignToken([]byte("other"), "alice", now.Add(time.Hour)),
+		"tampered":     "mallory" + token[len("team.alice"):],
+		"malformed":    "alice.signature",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, ok := a.Authenticate(requestWith("Authorization", "Bearer "+token))
+			assert.False(t, ok)
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pass-a"), bcrypt.MinCost)
+	require.NoError(t, err)
+	sum := sha1.Sum([]byte("pass-b"))
+	path := writeFile(t, "htpasswd", "# users\n"+
+		"alice:"+string(bcryptHash)+"\n\n"+
+		"bob:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n")
+	a, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+
+	basic := func(user, password string) *http.Request {
+		r := requestWith("", "")
+		r.SetBasicAuth(user, password)
+		return r
+	}
+	client, ok := a.Authenticate(basic("alice", "pass-a"))
+	assert.True(t, ok)
+	assert.Equal(t, "alice", client)
+	client, ok = a.Authenticate(basic("bob", "pass-b"))
+	assert.True(t, ok)
+	assert.Equal(t, "bob", client)
+	_, ok = a.Authenticate(basic("alice", "pass-b"))
+	assert.False(t, ok)
+	_, ok = a.Authenticate(basic("carol", "pass-a"))
+	assert.False(t, ok)
+
+	_, err = LoadHtpasswd(writeFile(t, "md5", "alice:$apr1$abc$def\n"))
+	assert.ErrorContains(t, err, "unsupported hash of user `alice`")
+	_, err = LoadHtpasswd(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientFromContext(r.Context())
+	})
+
+	t.Run("passes the client on", func(t *testing.T) {
+		m, err := NewMiddleware(configuration.HTTPAuthConfig{Tokens: map[string]string{"alice": "secret"}}, false, newTestLogger())
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		m.Wrap(next).ServeHTTP(w, requestWith("Authorization", "Bearer secret"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "alice", seen)
+	})
+
+	t.Run("rejects unknown clients", func(t *testing.T) {
+		m, err := NewMiddleware(configuration.HTTPAuthConfig{HMACSecret: "shared"}, false, newTestLogger())
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		m.Wrap(next).ServeHTTP(w, requestWith("Authorization", "Bearer secret"))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Bearer realm="speelka-agent"`, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("anonymous without authenticators", func(t *testing.T) {
+		m, err := NewMiddleware(configuration.HTTPAuthConfig{}, false, newTestLogger())
+		require.NoError(t, err)
+		seen = "before"
+		w := httptest.NewRecorder()
+		m.Wrap(next).ServeHTTP(w, requestWith("", ""))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "", seen)
+	})
+}
+
+// testPKI is a CA with a server and a client certificate, written to PEM files.
+type testPKI struct {
+	caFile, certFile, keyFile string
+	clientCert                tls.Certificate
+	pool                      *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}, ca, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+	pki := testPKI{
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "server.pem"),
+		keyFile:  filepath.Join(dir, "server-key.pem"),
+		pool:     x509.NewCertPool(),
+	}
+	pki.pool.AddCert(ca)
+	require.NoError(t, os.WriteFile(pki.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600))
+	serverCert, serverKey := issue(2, "server", x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(pki.certFile, serverCert, 0o600))
+	require.NoError(t, os.WriteFile(pki.keyFile, serverKey, 0o600))
+	clientCert, clientKey := issue(3, "ci-runner", x509.ExtKeyUsageClientAuth)
+	pki.clientCert, err = tls.X509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	return pki
+}
+
+func TestServerTLSConfig_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	tlsConfig, err := ServerTLSConfig(configuration.TLSConfig{CertFile: pki.certFile, KeyFile: pki.keyFile, ClientCAFile: pki.caFile})
+	require.NoError(t, err)
+	m, err := NewMiddleware(configuration.HTTPAuthConfig{}, true, newTestLogger())
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, ClientFromContext(r.Context()))
+	})))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      pki.pool,
+		Certificates: []tls.Certificate{pki.clientCert},
+	}}}
+	resp, err := withCert.Get(ts.URL)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "ci-runner", string(body), "the client is named after its certificate")
+
+	withoutCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pki.pool}}}
+	_, err = withoutCert.Get(ts.URL)
+	assert.Error(t, err, "the handshake requires a client certificate")
+}
+
+func TestServerTLSConfig_Disabled(t *testing.T) {
+	tlsConfig, err := ServerTLSConfig(configuration.TLSConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	_, err = ServerTLSConfig(configuration.TLSConfig{CertFile: "missing.pem", KeyFile: "missing-key.pem"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+// Package auth: htpasswd file authenticator
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const shaPrefix = "{SHA}"
+
+// HtpasswdAuthenticator accepts the HTTP Basic credentials of the users of an htpasswd file.
+// Responsibility: Checking user passwords against the hashes of the file
+// Features: bcrypt (`htpasswd -B`) and SHA-1 (`htpasswd -s`) hashes; the file is read once at startup
+type HtpasswdAuthenticator struct {
+	hashes map[string]string // user -> hash
+}
+
+// LoadHtpasswd reads the users of the htpasswd file. Lines that are empty or start with # are skipped.
+func LoadHtpasswd(path string) (*HtpasswdAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer f.Close()
+
+	a := &HtpasswdAuthenticator{hashes: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("htpasswd file %s, line %d: expected `user:hash`", path, line)
+		}
+		if !isBcrypt(hash) && !strings.HasPrefix(hash, shaPrefix) {
+			return nil, fmt.Errorf("htpasswd file %s, line %d: unsupported hash of user `%s`, use bcrypt or {SHA}", path, line, user)
+		}
+		a.hashes[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	return a, nil
+}
+
+// Authenticate checks the Basic credentials of the request and names the client after the user.
+func (a *HtpasswdAuthenticator) Authenticate(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, ok := a.hashes[user]
+	if !ok {
+		return "", false
+	}
+	if isBcrypt(hash) {
+		return user, bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	sum := sha1.Sum([]byte(password))
+	expected := shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+	return user, subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
@@ -0,0 +1,94 @@
+// Package auth: bearer token authenticators
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenAuthenticator accepts a fixed set of bearer tokens.
+type TokenAuthenticator struct {
+	tokens map[string]string // client -> token
+}
+
+// NewTokenAuthenticator creates an authenticator for the tokens of each client.
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: tokens}
+}
+
+// Authenticate compares the token with every configured token in constant time.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (string, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", false
+	}
+	found := ""
+	for client, expected := range a.tokens {
+		if expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			found = client
+		}
+	}
+	return found, found != ""
+}
+
+// HMACAuthenticator accepts bearer tokens signed with a shared secret, so clients can be added
+// without changing the configuration. A token is `<client>.<unix expiry>.<signature>`, where the
+// signature is the hex HMAC-SHA256 of `<client>.<unix expiry>`.
+type HMACAuthenticator struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewHMACAuthenticator creates an authenticator for the tokens signed with the secret.
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{secret: secret, now: time.Now}
+}
+
+// SignToken returns a token for the client that the authenticator of the secret accepts until expires.
+func SignToken(secret []byte, client string, expires time.Time) string {
+	payload := fmt.Sprintf("%s.%d", client, expires.Unix())
+	return payload + "." + sign(secret, payload)
+}
+
+// Authenticate checks the signature and the expiry of the token.
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (string, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", false
+	}
+	// The client name may contain dots, so the token is split from the right
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, signature := token[:i], token[i+1:]
+	j := strings.LastIndex(payload, ".")
+	if j <= 0 {
+		return "", false
+	}
+	client := payload[:j]
+	expires, err := strconv.ParseInt(payload[j+1:], 10, 64)
+	if err != nil {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(a.secret, payload))) {
+		return "", false
+	}
+	if a.now().Unix() >= expires {
+		return "", false
+	}
+	return client, true
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
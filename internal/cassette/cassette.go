@@ -0,0 +1,151 @@
+// Package cassette records the exchanges of agent sessions with the LLM and MCP tools, and replays them offline.
+// Responsibility: Making agent sessions reproducible for debugging and tests
+// Features: Recorder and Player stand in for the LLM service and the MCP connector; the cassette is a JSON file
+package cassette
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// formatVersion is the version of the cassette file format.
+const formatVersion = 1
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+	Version int `json:"version"`
+	// Tools are the connected MCP tools as returned by GetAllTools.
+	Tools []mcp.Tool `json:"tools"`
+	// ToolServers maps tool names to the ID of the server that exposes them.
+	ToolServers map[string]string `json:"tool_servers,omitempty"`
+	// LLM holds the LLM exchanges in the order they were made.
+	LLM []LLMExchange `json:"llm"`
+	// ToolCalls holds the tool calls in the order they finished.
+	ToolCalls []ToolExchange `json:"tool_calls"`
+}
+
+// LLMExchange is one SendRequest call.
+type LLMExchange struct {
+	// Messages are kept encoded: llms.ToolCall loses its function call when decoded.
+	Messages []json.RawMessage `json:"messages"`
+	// ToolNames are the names of the tools offered to the LLM.
+	ToolNames []string                     `json:"tool_names"`
+	Response  LLMResponse                  `json:"response"`
+	Error     string                       `json:"error,omitempty"`
+	Metadata  llmtypes.LLMResponseMetadata `json:"metadata"`
+}
+
+// LLMResponse is the serializable part of llmtypes.LLMResponse.
+type LLMResponse struct {
+	Text  string     `json:"text"`
+	Calls []ToolCall `json:"calls"`
+}
+
+// ToolCall is a tool call requested by the LLM.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolExchange is one ExecuteTool call.
+type ToolExchange struct {
+	Name      string          `json:"name"`
+	Arguments any             `json:"arguments"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette `%s`: %w", path, err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode cassette `%s`: %w", path, err)
+	}
+	if c.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported cassette version %d in `%s`", c.Version, path)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette atomically, so that a crash never leaves a truncated file.
+func (c *Cassette) Save(path string) error {
+	c.Version = formatVersion
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cassette file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cassette `%s`: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cassette `%s`: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save cassette `%s`: %w", path, err)
+	}
+	return nil
+}
+
+// toResponse rebuilds the LLM response of the exchange.
+func (e LLMExchange) toResponse() (llmtypes.LLMResponse, error) {
+	resp := llmtypes.LLMResponse{Text: e.Response.Text, Metadata: e.Metadata}
+	for _, call := range e.Response.Calls {
+		req, err := types.NewCallToolRequest(llms.ToolCall{
+			ID:           call.ID,
+			Type:         "function",
+			FunctionCall: &llms.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+		})
+		if err != nil {
+			return llmtypes.LLMResponse{}, fmt.Errorf("failed to restore tool call `%s`: %w", call.ID, err)
+		}
+		resp.Calls = append(resp.Calls, req)
+	}
+	return resp, nil
+}
+
+// toolNames returns the names of the tools.
+func toolNames(tools []mcp.Tool) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+// encodeMessages encodes each message separately, so that a replay can point at the first differing one.
+func encodeMessages(messages []llms.MessageContent) []json.RawMessage {
+	encoded := make([]json.RawMessage, 0, len(messages))
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			data = []byte("null")
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded
+}
+
+// sameJSON reports whether two values have the same JSON encoding.
+func sameJSON(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
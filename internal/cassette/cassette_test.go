@@ -0,0 +1,200 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type fakeLLM struct {
+	responses []llms.ToolCall
+	calls     int
+}
+
+func (f *fakeLLM) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error) {
+	if f.calls >= len(f.responses) {
+		return llmtypes.LLMResponse{}, errors.New("rate limited")
+	}
+	call, err := types.NewCallToolRequest(f.responses[f.calls])
+	f.calls++
+	if err != nil {
+		return llmtypes.LLMResponse{}, err
+	}
+	return llmtypes.LLMResponse{
+		Text:     "thinking",
+		Calls:    []types.CallToolRequest{call},
+		Metadata: llmtypes.LLMResponseMetadata{Tokens: llmtypes.LLMResponseTokensMetadata{PromptTokens: 10, CompletionTokens: 5}},
+	}, nil
+}
+
+type fakeConnector struct{}
+
+func (f *fakeConnector) InitAndConnectToMCPs(ctx context.Context) error { return nil }
+func (f *fakeConnector) ConnectServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
+	return nil, nil
+}
+func (f *fakeConnector) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
+	return []mcp.Tool{mcp.NewTool("read_file", mcp.WithDescription("Read a file"))}, nil
+}
+func (f *fakeConnector) ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
+	args, _ := call.Params.Arguments.(map[string]interface{})
+	if args["path"] == "missing.txt" {
+		return nil, errors.New("file not found")
+	}
+	return mcp.NewToolResultText("content of " + args["path"].(string)), nil
+}
+func (f *fakeConnector) GetToolServerID(toolName string) (string, bool) { return "fs", true }
+func (f *fakeConnector) Close() error                                   { return nil }
+
+func toolCall(id, name, args string) llms.ToolCall {
+	return llms.ToolCall{ID: id, Type: "function", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}
+}
+
+func quietLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// record runs a two-step session against fakes and returns the cassette path.
+func record(t *testing.T) (string, []llms.MessageContent, []mcp.Tool) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.json")
+	recorder := NewRecorder(path, quietLogger())
+	llm := recorder.LLM(&fakeLLM{responses: []llms.ToolCall{
+		toolCall("1", "read_file", `{"path":"a.txt"}`),
+		toolCall("2", "finish", `{"text":"done"}`),
+	}})
+	connector := recorder.Tools(&fakeConnector{})
+	ctx := context.Background()
+
+	tools, err := connector.GetAllTools(ctx)
+	require.NoError(t, err)
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "read a.txt")}
+	resp, err := llm.SendRequest(ctx, messages, tools)
+	require.NoError(t, err)
+	_, err = connector.ExecuteTool(ctx, resp.Calls[0])
+	require.NoError(t, err)
+	missing, _ := types.NewCallToolRequest(toolCall("3", "read_file", `{"path":"missing.txt"}`))
+	_, err = connector.ExecuteTool(ctx, missing)
+	require.Error(t, err)
+	messages = append(messages,
+		llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{resp.Calls[0].ToLLM()}},
+		llms.TextParts(llms.ChatMessageTypeTool, "content of a.txt"),
+	)
+	_, err = llm.SendRequest(ctx, messages, tools)
+	require.NoError(t, err)
+	return path, messages, tools
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path, messages, tools := record(t)
+	c, err := Load(path)
+	require.NoError(t, err)
+	assert.Len(t, c.LLM, 2)
+	assert.Len(t, c.ToolCalls, 2)
+	assert.Equal(t, map[string]string{"read_file": "fs"}, c.ToolServers)
+
+	player, err := NewPlayer(path, true, quietLogger())
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, player.InitAndConnectToMCPs(ctx))
+	replayedTools, err := player.GetAllTools(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "read_file", replayedTools[0].Name)
+	id, ok := player.GetToolServerID("read_file")
+	assert.True(t, ok)
+	assert.Equal(t, "fs", id)
+
+	resp, err := player.SendRequest(ctx, messages[:1], tools)
+	require.NoError(t, err)
+	assert.Equal(t, "thinking", resp.Text)
+	assert.Equal(t, 10, resp.Metadata.Tokens.PromptTokens)
+	require.Len(t, resp.Calls, 1)
+	assert.Equal(t, "read_file", resp.Calls[0].ToolName())
+
+	result, err := player.ExecuteTool(ctx, resp.Calls[0])
+	require.NoError(t, err)
+	require.Len(t, result.Content, 1)
+	assert.Equal(t, "content of a.txt", result.Content[0].(mcp.TextContent).Text)
+	missing, _ := types.NewCallToolRequest(toolCall("3", "read_file", `{"path":"missing.txt"}`))
+	_, err = player.ExecuteTool(ctx, missing)
+	assert.EqualError(t, err, "file not found")
+
+	resp, err = player.SendRequest(ctx, messages, tools)
+	require.NoError(t, err)
+	assert.Equal(t, "finish", resp.Calls[0].ToolName())
+
+	_, err = player.SendRequest(ctx, messages, tools)
+	assert.ErrorContains(t, err, "no more LLM responses")
+}
+
+// healthConnector is a connector that supervises its servers.
+type healthConnector struct {
+	fakeConnector
+	unhealthy map[string]bool
+}
+
+func (f *healthConnector) IsServerHealthy(serverID string) bool { return !f.unhealthy[serverID] }
+
+func TestRecordingConnector_ServerHealth(t *testing.T) {
+	recorder := NewRecorder(filepath.Join(t.TempDir(), "session.json"), quietLogger())
+	var connector any = recorder.Tools(&healthConnector{unhealthy: map[string]bool{"fs": true}})
+	health, ok := connector.(interface{ IsServerHealthy(serverID string) bool })
+	require.True(t, ok, "the health of the servers is visible through the recorder")
+	assert.False(t, health.IsServerHealthy("fs"))
+	assert.True(t, health.IsServerHealthy("web"))
+
+	assert.True(t, recorder.Tools(&fakeConnector{}).IsServerHealthy("fs"), "connectors without supervision report healthy servers")
+}
+
+func TestReplay_Divergence(t *testing.T) {
+	path, _, tools := record(t)
+	changed := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "read b.txt")}
+	ctx := context.Background()
+
+	lenient, err := NewPlayer(path, false, quietLogger())
+	require.NoError(t, err)
+	resp, err := lenient.SendRequest(ctx, changed, tools)
+	require.NoError(t, err, "divergence is only logged outside strict mode")
+	assert.Equal(t, "read_file", resp.Calls[0].ToolName())
+
+	strict, err := NewPlayer(path, true, quietLogger())
+	require.NoError(t, err)
+	_, err = strict.SendRequest(ctx, changed, tools)
+	assert.ErrorContains(t, err, "LLM request 1 diverges from the cassette: message 1 (human) differs")
+
+	other, _ := types.NewCallToolRequest(toolCall("9", "read_file", `{"path":"b.txt"}`))
+	_, err = strict.ExecuteTool(ctx, other)
+	assert.ErrorContains(t, err, "no recorded result")
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "empty.json")
+	require.NoError(t, (&Cassette{}).Save(path))
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, formatVersion, loaded.Version)
+
+	future := filepath.Join(dir, "future.json")
+	require.NoError(t, os.WriteFile(future, []byte(`{"version": 2}`), 0o644))
+	_, err = Load(future)
+	assert.ErrorContains(t, err, "unsupported cassette version 2")
+
+	_, err = Load(filepath.Join(dir, "absent.json"))
+	assert.Error(t, err)
+}
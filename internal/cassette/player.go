@@ -0,0 +1,133 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Player replays a cassette in place of both the LLM service and the MCP connector.
+// Responsibility: Re-running a recorded session offline
+// Features: LLM responses are returned in the recorded order; tool results are matched by tool name and arguments.
+// A request that differs from the recording is logged as a divergence, or fails in strict mode.
+type Player struct {
+	cassette *Cassette
+	strict   bool
+	nextLLM  int
+	usedTool []bool
+	mu       sync.Mutex
+	log      *logrus.Logger
+}
+
+// NewPlayer loads the cassette at path. In strict mode a divergence from the recording is an error.
+func NewPlayer(path string, strict bool, log *logrus.Logger) (*Player, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Player{cassette: c, strict: strict, usedTool: make([]bool, len(c.ToolCalls)), log: log}, nil
+}
+
+// SendRequest returns the next recorded LLM response.
+func (p *Player) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return llmtypes.LLMResponse{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.nextLLM >= len(p.cassette.LLM) {
+		return llmtypes.LLMResponse{}, fmt.Errorf("cassette has no more LLM responses (%d recorded)", len(p.cassette.LLM))
+	}
+	index := p.nextLLM
+	exchange := p.cassette.LLM[index]
+	p.nextLLM++
+
+	if divergence := diff(exchange, messages, tools); divergence != "" {
+		if p.strict {
+			return llmtypes.LLMResponse{}, fmt.Errorf("LLM request %d diverges from the cassette: %s", index+1, divergence)
+		}
+		p.log.Warnf("LLM request %d diverges from the cassette: %s", index+1, divergence)
+	}
+	if exchange.Error != "" {
+		return llmtypes.LLMResponse{}, errors.New(exchange.Error)
+	}
+	resp, err := exchange.toResponse()
+	if err != nil {
+		return llmtypes.LLMResponse{}, err
+	}
+	resp.RequestMessages = messages
+	return resp, nil
+}
+
+// diff describes the first difference between the recorded request and the replayed one, or returns "".
+func diff(exchange LLMExchange, messages []llms.MessageContent, tools []mcp.Tool) string {
+	if names := toolNames(tools); !sameJSON(names, exchange.ToolNames) {
+		return fmt.Sprintf("tools %v, recorded %v", names, exchange.ToolNames)
+	}
+	encoded := encodeMessages(messages)
+	for i := 0; i < len(encoded) && i < len(exchange.Messages); i++ {
+		if !sameJSON(encoded[i], exchange.Messages[i]) {
+			return fmt.Sprintf("message %d (%s) differs", i+1, messages[i].Role)
+		}
+	}
+	if len(messages) != len(exchange.Messages) {
+		return fmt.Sprintf("%d messages, recorded %d", len(messages), len(exchange.Messages))
+	}
+	return ""
+}
+
+// InitAndConnectToMCPs does nothing: a replayed session needs no MCP servers.
+func (p *Player) InitAndConnectToMCPs(ctx context.Context) error {
+	return nil
+}
+
+// ConnectServer fails, since no servers are connected during replay.
+func (p *Player) ConnectServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
+	return nil, fmt.Errorf("cannot connect to `%s` while replaying a cassette", serverID)
+}
+
+// GetAllTools returns the recorded tools.
+func (p *Player) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
+	return p.cassette.Tools, nil
+}
+
+// GetToolServerID returns the recorded server of the tool.
+func (p *Player) GetToolServerID(toolName string) (string, bool) {
+	id, ok := p.cassette.ToolServers[toolName]
+	return id, ok
+}
+
+// ExecuteTool returns the first unused recorded result of a call with the same tool name and arguments.
+func (p *Player) ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, exchange := range p.cassette.ToolCalls {
+		if p.usedTool[i] || exchange.Name != call.ToolName() || !sameJSON(exchange.Arguments, call.Params.Arguments) {
+			continue
+		}
+		p.usedTool[i] = true
+		if exchange.Error != "" {
+			return nil, errors.New(exchange.Error)
+		}
+		return mcp.ParseCallToolResult(&exchange.Result)
+	}
+	err := fmt.Errorf("cassette has no recorded result for %s", call.String())
+	if !p.strict {
+		p.log.Warnf("Tool call diverges from the cassette: %v", err)
+	}
+	return nil, err
+}
+
+// Close does nothing.
+func (p *Player) Close() error {
+	return nil
+}
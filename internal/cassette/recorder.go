@@ -0,0 +1,160 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// llmServiceSpec sends requests to the LLM.
+type llmServiceSpec interface {
+	SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error)
+}
+
+// toolConnectorSpec executes tools on the connected MCP servers.
+type toolConnectorSpec interface {
+	InitAndConnectToMCPs(ctx context.Context) error
+	ConnectServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error)
+	GetAllTools(ctx context.Context) ([]mcp.Tool, error)
+	ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error)
+	GetToolServerID(toolName string) (string, bool)
+	Close() error
+}
+
+// serverHealthSpec is implemented by connectors that supervise the health of their servers.
+// The agent checks for it with a type assertion, so the recording connector forwards it explicitly.
+type serverHealthSpec interface {
+	IsServerHealthy(serverID string) bool
+}
+
+// Recorder writes the exchanges with the LLM and the MCP tools to a cassette file.
+// Responsibility: Capturing everything a session depends on, so that it can be replayed
+// Features: The file is rewritten after each exchange, so it is complete even if the process is killed;
+// safe for concurrent use
+type Recorder struct {
+	path     string
+	cassette Cassette
+	mu       sync.Mutex
+	log      *logrus.Logger
+}
+
+// NewRecorder creates a recorder that writes to the file at path, replacing it.
+func NewRecorder(path string, log *logrus.Logger) *Recorder {
+	return &Recorder{path: path, log: log}
+}
+
+// LLM returns an LLM service that passes requests to svc and records them.
+func (r *Recorder) LLM(svc llmServiceSpec) *RecordingLLM {
+	return &RecordingLLM{recorder: r, next: svc}
+}
+
+// Tools returns a connector that passes calls to connector and records them.
+func (r *Recorder) Tools(connector toolConnectorSpec) *RecordingConnector {
+	return &RecordingConnector{toolConnectorSpec: connector, recorder: r}
+}
+
+// update changes the cassette and saves it. A failed save is logged, not returned, so recording never breaks a session.
+func (r *Recorder) update(change func(c *Cassette)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(&r.cassette)
+	if err := r.cassette.Save(r.path); err != nil {
+		r.log.Errorf("Failed to record cassette: %v", err)
+	}
+}
+
+// RecordingLLM is an LLM service that records its exchanges.
+type RecordingLLM struct {
+	recorder *Recorder
+	next     llmServiceSpec
+}
+
+// SendRequest sends the request to the wrapped LLM service and records the exchange.
+func (l *RecordingLLM) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error) {
+	resp, err := l.next.SendRequest(ctx, messages, tools)
+	exchange := LLMExchange{
+		Messages:  encodeMessages(messages),
+		ToolNames: toolNames(tools),
+		Response:  LLMResponse{Text: resp.Text},
+		Metadata:  resp.Metadata,
+	}
+	for _, call := range resp.Calls {
+		llmCall := call.ToLLM()
+		if llmCall.FunctionCall == nil {
+			continue
+		}
+		exchange.Response.Calls = append(exchange.Response.Calls, ToolCall{
+			ID:        llmCall.ID,
+			Name:      llmCall.FunctionCall.Name,
+			Arguments: llmCall.FunctionCall.Arguments,
+		})
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	l.recorder.update(func(c *Cassette) {
+		c.LLM = append(c.LLM, exchange)
+	})
+	return resp, err
+}
+
+// RecordingConnector is a tool connector that records the tools and the tool calls.
+type RecordingConnector struct {
+	toolConnectorSpec
+	recorder *Recorder
+}
+
+var _ serverHealthSpec = (*RecordingConnector)(nil)
+
+// IsServerHealthy reports the health of the server as the wrapped connector sees it.
+// A connector that does not supervise its servers reports all of them as healthy.
+func (c *RecordingConnector) IsServerHealthy(serverID string) bool {
+	if health, ok := c.toolConnectorSpec.(serverHealthSpec); ok {
+		return health.IsServerHealthy(serverID)
+	}
+	return true
+}
+
+// GetAllTools returns the tools of the wrapped connector and records them with their servers.
+func (c *RecordingConnector) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
+	tools, err := c.toolConnectorSpec.GetAllTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	servers := make(map[string]string, len(tools))
+	for _, tool := range tools {
+		if id, ok := c.toolConnectorSpec.GetToolServerID(tool.Name); ok {
+			servers[tool.Name] = id
+		}
+	}
+	c.recorder.update(func(cas *Cassette) {
+		cas.Tools = tools
+		cas.ToolServers = servers
+	})
+	return tools, nil
+}
+
+// ExecuteTool executes the call with the wrapped connector and records the call and its result.
+func (c *RecordingConnector) ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
+	result, err := c.toolConnectorSpec.ExecuteTool(ctx, call)
+	exchange := ToolExchange{Name: call.ToolName(), Arguments: call.Params.Arguments}
+	if err != nil {
+		exchange.Error = err.Error()
+	} else if result != nil {
+		if data, marshalErr := json.Marshal(result); marshalErr == nil {
+			exchange.Result = data
+		}
+	}
+	c.recorder.update(func(cas *Cassette) {
+		cas.ToolCalls = append(cas.ToolCalls, exchange)
+	})
+	return result, err
+}
@@ -2,6 +2,7 @@ package chat
 
 import (
 	"fmt"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
 	"github.com/korchasa/speelka-agent-go/internal/llm/cost"
 	types2 "github.com/korchasa/speelka-agent-go/internal/llm/types"
 	"github.com/korchasa/speelka-agent-go/internal/utils/dump"
@@ -36,6 +37,7 @@ const (
 type Chat struct {
 	promptTemplate string
 	argumentName   string
+	arguments      map[string]any // further prompt template values, see SetArguments
 	messagesStack  []llms.MessageContent
 	logger         loggerSpec
 
@@ -50,11 +52,22 @@ type Chat struct {
 
 	// Request budget (USD or token-equivalent)
 	requestBudget float64
+	// callBudget lowers the request budget for this call only; it is not saved with the session
+	callBudget float64
+	// callStartCost is the total cost when the call began, so budgets apply to the cost of this call only
+	callStartCost float64
+
+	// Context-window management
+	catalog    cost.LLMModelsCatalog
+	compaction configuration.CompactionConfig
+	summarizer summarizerSpec
 }
 
 type calculatorSpec interface {
 	// CalculateLLMResponse returns the number of tokens, USD cost, and approximation flag for the given model and LLM response.
 	CalculateLLMResponse(modelName string, resp types2.LLMResponse) (tokens int, cost float64, isApprox bool, err error)
+	// CalculateCost returns the USD cost of the given token usage. It fails for models missing from the catalog.
+	CalculateCost(modelName string, inputTokens, outputTokens int) (float64, error)
 }
 
 type loggerSpec interface {
@@ -85,9 +98,16 @@ func NewChat(model string, promptTemplate string, argumentName string, logger lo
 		},
 		calculator:    calculator,
 		requestBudget: requestBudget,
+		catalog:       cost.NewDefaultCatalog(),
 	}
 }
 
+// SetArguments sets further values of the prompt template, e.g. the arguments of the input schema of the tool.
+// They must be set before Begin.
+func (c *Chat) SetArguments(arguments map[string]any) {
+	c.arguments = arguments
+}
+
 // GetInfo returns a summary of the chat state (tokens, cost, etc.).
 func (c *Chat) GetInfo() types.ChatInfo {
 	return c.info
@@ -108,6 +128,10 @@ func (c *Chat) Begin(input string, tools []mcp.Tool) error {
 		"input":        input,
 		"tools":        toolsDescription,
 	}
+	for name, value := range c.arguments {
+		prompt.InputVariables = append(prompt.InputVariables, name)
+		values[name] = value
+	}
 	result, err := prompt.Format(values)
 	if err != nil {
 		return fmt.Errorf("failed to format prompt: %v", err)
@@ -148,7 +172,7 @@ func (c *Chat) AddAssistantMessage(response types2.LLMResponse) {
 	if isApprox {
 		c.info.IsApproximate = true
 	}
-	c.info.LLMRequests = len(c.llmMessagesHistory)
+	c.info.LLMRequests++
 	c.info.MessageStackLen = len(c.messagesStack)
 
 	c.logger.Debugf("Added assistant message, total tokens: %d, cost: %f, approx: %v", c.info.TotalTokens, c.info.TotalCost, c.info.IsApproximate)
@@ -217,6 +241,40 @@ func (c *Chat) AddToolResult(toolCall types.CallToolRequest, result *mcp.CallToo
 	c.logger.Debugf("Added tool result with %d tokens, total now %d", messageTokens, c.info.TotalTokens)
 }
 
+// AddUserMessage adds a follow-up user message to the chat history.
+func (c *Chat) AddUserMessage(input string) {
+	message := llms.TextParts(llms.ChatMessageTypeHuman, input)
+	tokenEstimator := cost.TokenEstimator{}
+	messageTokens := tokenEstimator.CountTokens(message)
+
+	c.messagesStack = append(c.messagesStack, message)
+	c.info.TotalTokens += messageTokens
+	c.info.MessageStackLen = len(c.messagesStack)
+
+	c.logger.Debugf("Added user message with %d tokens, total now %d", messageTokens, c.info.TotalTokens)
+}
+
+// State returns a copy of the message history and the chat info for saving the conversation.
+func (c *Chat) State() ([]llms.MessageContent, types.ChatInfo) {
+	messages := make([]llms.MessageContent, len(c.messagesStack))
+	copy(messages, c.messagesStack)
+	return messages, c.info
+}
+
+// Restore replaces the message history and counters with a previously saved state.
+// The model name, max tokens and request budget of the current configuration are kept.
+func (c *Chat) Restore(messages []llms.MessageContent, info types.ChatInfo) {
+	c.messagesStack = make([]llms.MessageContent, len(messages))
+	copy(c.messagesStack, messages)
+	info.ModelName = c.info.ModelName
+	info.MaxTokens = c.info.MaxTokens
+	info.RequestBudget = c.info.RequestBudget
+	info.MessageStackLen = len(c.messagesStack)
+	c.info = info
+	c.callStartCost = info.TotalCost
+	c.logger.Debugf("Restored chat with %d messages, total tokens %d, cost %f", len(messages), info.TotalTokens, info.TotalCost)
+}
+
 // BuildPromptPartForToolsDescription generates a formatted description of available tools
 // for inclusion in the system prompt.
 //
@@ -242,10 +300,71 @@ func (c *Chat) BuildPromptPartForToolsDescription(tools []mcp.Tool, template str
 	return strings.Trim(result, " \n"), nil
 }
 
-// ExceededRequestBudget returns true if the total cost exceeds the configured request budget (if > 0)
+// LowerRequestBudget lowers the request budget of this call to the given value if it is lower than the current one.
+// A budget can never be raised this way; zero or negative values are ignored. The configured budget in the
+// chat info, which is saved with the session, is left as it is.
+func (c *Chat) LowerRequestBudget(budget float64) {
+	current := c.RequestBudget()
+	if budget <= 0 || (current > 0 && budget >= current) {
+		return
+	}
+	c.logger.Infof("Request budget of this call lowered from %.4f to %.4f", current, budget)
+	c.callBudget = budget
+}
+
+// RequestBudget returns the budget of this call: the configured one, or the lower one set for the call.
+// Zero means unlimited.
+func (c *Chat) RequestBudget() float64 {
+	if c.callBudget > 0 {
+		return c.callBudget
+	}
+	return c.requestBudget
+}
+
+// CallCost returns the cost of this call: the total cost minus the cost of the restored conversation.
+func (c *Chat) CallCost() float64 {
+	return c.info.TotalCost - c.callStartCost
+}
+
+// EstimateNextRequestCost estimates the cost of sending the current history to the LLM.
+// The prompt is the estimated size of the history; the completion is the average of the previous ones.
+// It returns false if the model is not in the cost catalog.
+func (c *Chat) EstimateNextRequestCost() (float64, bool) {
+	completionTokens := 0
+	if len(c.llmMessagesHistory) > 0 {
+		for _, resp := range c.llmMessagesHistory {
+			completionTokens += resp.Metadata.Tokens.CompletionTokens
+		}
+		completionTokens /= len(c.llmMessagesHistory)
+	}
+	estimate, err := c.calculator.CalculateCost(c.info.ModelName, c.ContextTokens(), completionTokens)
+	if err != nil {
+		c.logger.Debugf("Cannot estimate the cost of the next request: %v", err)
+		return 0, false
+	}
+	return estimate, true
+}
+
+// WouldExceedRequestBudget reports whether the estimated cost of the next request would take the cost of this call
+// over the request budget (if > 0), and returns the estimate. It lets the caller stop before the money is spent.
+func (c *Chat) WouldExceedRequestBudget() (float64, bool) {
+	budget := c.RequestBudget()
+	if budget <= 0 {
+		return 0, false
+	}
+	estimate, ok := c.EstimateNextRequestCost()
+	if !ok || c.CallCost()+estimate <= budget {
+		return estimate, false
+	}
+	c.logger.Warnf("Request budget would be exceeded: call cost %.4f + next request ~%.4f > budget %.4f", c.CallCost(), estimate, budget)
+	return estimate, true
+}
+
+// ExceededRequestBudget returns true if the cost of this call exceeds the request budget (if > 0)
 func (c *Chat) ExceededRequestBudget() bool {
-	if c.requestBudget > 0 && c.info.TotalCost > c.requestBudget {
-		c.logger.Warnf("Request budget exceeded: total cost %.4f > budget %.4f", c.info.TotalCost, c.requestBudget)
+	budget := c.RequestBudget()
+	if budget > 0 && c.CallCost() > budget {
+		c.logger.Warnf("Request budget exceeded: call cost %.4f > budget %.4f", c.CallCost(), budget)
 		return true
 	}
 	return false
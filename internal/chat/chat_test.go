@@ -70,6 +70,15 @@ func TestChat_Begin_SystemPromptAndToolDescription(t *testing.T) {
 	assert.Greater(t, info.TotalTokens, 0)
 }
 
+func TestChat_Begin_Arguments(t *testing.T) {
+	ch := chat.NewChat("gpt-4o", "Classify {{query}} for {{team}}", "query", newTestLogger(), cost.NewCalculator(), 2048, 0.0)
+	ch.SetArguments(map[string]any{"team": "billing"})
+	assert.NoError(t, ch.Begin("the ticket", nil))
+
+	text := ch.GetLLMMessages()[0].Parts[0].(llms.TextContent).Text
+	assert.Equal(t, "Classify the ticket for billing", text)
+}
+
 func TestChat_AddAssistantMessage_TokenCostApproximation(t *testing.T) {
 	log := newTestLogger()
 	calculator := cost.NewCalculator()
@@ -286,3 +295,106 @@ func TestChat_InputSubstitutionInPrompt(t *testing.T) {
 		t.Errorf("No parts in system message")
 	}
 }
+
+func TestChat_StateAndRestore(t *testing.T) {
+	log := newTestLogger()
+	ch := chat.NewChat("gpt-4", "System: {{query}}", "query", log, cost.NewCalculator(), 2048, 1.5)
+	_ = ch.Begin("Hi", nil)
+	ch.AddAssistantMessage(typesllm.LLMResponse{
+		Text: "Hello",
+		Metadata: typesllm.LLMResponseMetadata{
+			Tokens: typesllm.LLMResponseTokensMetadata{TotalTokens: 20},
+			Cost:   0.01,
+		},
+	})
+	messages, info := ch.State()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, 1, info.LLMRequests)
+
+	restored := chat.NewChat("gpt-4o", "System: {{query}}", "query", log, cost.NewCalculator(), 4096, 1.0)
+	restored.Restore(messages, info)
+	restored.AddUserMessage("And now?")
+
+	got := restored.GetInfo()
+	assert.Equal(t, "gpt-4o", got.ModelName, "current model should be kept")
+	assert.Equal(t, 4096, got.MaxTokens, "current max tokens should be kept")
+	assert.Equal(t, 0.01, got.TotalCost)
+	assert.Equal(t, 1.0, got.RequestBudget, "current request budget should be kept")
+	assert.Greater(t, got.TotalTokens, info.TotalTokens)
+	assert.Equal(t, 3, got.MessageStackLen)
+	msgs := restored.GetLLMMessages()
+	assert.Equal(t, llms.ChatMessageTypeHuman, msgs[2].Role)
+
+	// Counters keep growing after restore
+	restored.AddAssistantMessage(typesllm.LLMResponse{
+		Text:     "Still here",
+		Metadata: typesllm.LLMResponseMetadata{Tokens: typesllm.LLMResponseTokensMetadata{TotalTokens: 5}, Cost: 1.6},
+	})
+	assert.Equal(t, 2, restored.GetInfo().LLMRequests)
+	assert.True(t, restored.ExceededRequestBudget(), "current budget should apply")
+	// The restored history is a copy
+	assert.Len(t, messages, 2)
+}
+
+func TestChat_LowerRequestBudget(t *testing.T) {
+	ch := chat.NewChat("gpt-4", "System: {{query}}", "query", newTestLogger(), nil, 2048, 1.0)
+	ch.LowerRequestBudget(2.0)
+	assert.Equal(t, 1.0, ch.RequestBudget(), "budget must not be raised")
+	ch.LowerRequestBudget(0)
+	assert.Equal(t, 1.0, ch.RequestBudget())
+	ch.LowerRequestBudget(0.5)
+	assert.Equal(t, 0.5, ch.RequestBudget())
+	ch.LowerRequestBudget(0.7)
+	assert.Equal(t, 0.5, ch.RequestBudget())
+	_, info := ch.State()
+	assert.Equal(t, 1.0, info.RequestBudget, "the budget of one call is not saved with the session")
+
+	unlimited := chat.NewChat("gpt-4", "System: {{query}}", "query", newTestLogger(), nil, 2048, 0)
+	unlimited.LowerRequestBudget(0.3)
+	assert.Equal(t, 0.3, unlimited.RequestBudget())
+}
+
+func TestChat_RequestBudgetOfRestoredSession(t *testing.T) {
+	response := func(cost float64) typesllm.LLMResponse {
+		return typesllm.LLMResponse{Text: "ok", Metadata: typesllm.LLMResponseMetadata{Tokens: typesllm.LLMResponseTokensMetadata{TotalTokens: 10}, Cost: cost}}
+	}
+	ch := chat.NewChat("gpt-4", "System: {{query}}", "query", newTestLogger(), nil, 2048, 1.0)
+	_ = ch.Begin("Hi", nil)
+	ch.AddAssistantMessage(response(0.8))
+	ch.AddAssistantMessage(response(0.8))
+	assert.True(t, ch.ExceededRequestBudget())
+	messages, info := ch.State()
+
+	restored := chat.NewChat("gpt-4", "System: {{query}}", "query", newTestLogger(), nil, 2048, 1.0)
+	restored.Restore(messages, info)
+	restored.LowerRequestBudget(0.1)
+	assert.Equal(t, 0.0, restored.CallCost())
+	assert.False(t, restored.ExceededRequestBudget(), "earlier calls do not count against this one")
+	restored.AddAssistantMessage(response(0.05))
+	assert.InDelta(t, 0.05, restored.CallCost(), 1e-9)
+	assert.False(t, restored.ExceededRequestBudget())
+	restored.AddAssistantMessage(response(0.06))
+	assert.True(t, restored.ExceededRequestBudget(), "the lowered budget applies to this call")
+	assert.InDelta(t, 1.71, restored.GetInfo().TotalCost, 1e-9, "the session total keeps growing")
+}
+
+func TestChat_WouldExceedRequestBudget(t *testing.T) {
+	// gpt-4 costs $30 per 1M prompt tokens, so a ~2500-token history costs about $0.075
+	ch := chat.NewChat("gpt-4", "System: {{query}}", "query", newTestLogger(), nil, 8192, 0.05)
+	assert.NoError(t, ch.Begin(strings.Repeat("x", 10000), nil))
+	estimate, ok := ch.EstimateNextRequestCost()
+	assert.True(t, ok)
+	assert.InDelta(t, 0.075, estimate, 0.001)
+	_, exceeds := ch.WouldExceedRequestBudget()
+	assert.True(t, exceeds)
+
+	cheap := chat.NewChat("gpt-4", "System: {{query}}", "query", newTestLogger(), nil, 8192, 0.1)
+	assert.NoError(t, cheap.Begin("Hi", nil))
+	_, exceeds = cheap.WouldExceedRequestBudget()
+	assert.False(t, exceeds)
+
+	unknown := chat.NewChat("unknown-model", "System: {{query}}", "query", newTestLogger(), nil, 8192, 0.0001)
+	assert.NoError(t, unknown.Begin(strings.Repeat("x", 10000), nil))
+	_, exceeds = unknown.WouldExceedRequestBudget()
+	assert.False(t, exceeds, "without prices the check is skipped")
+}
@@ -0,0 +1,253 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/llm"
+	"github.com/korchasa/speelka-agent-go/internal/llm/cost"
+	types2 "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	// droppedResultText replaces tool results removed from the history.
+	droppedResultText = "[result removed to fit the context window]"
+
+	// charsPerToken matches the approximation of cost.TokenEstimator.
+	charsPerToken = 4
+
+	summaryPrompt = `You compress the history of a conversation between an AI agent, its tools and the user.
+Write a concise summary that keeps the user's goal, the decisions made, the facts learned from tool results
+and anything still left to do. Call the save_summary tool with the summary.`
+	summaryPrefix = "Summary of the earlier conversation:\n"
+)
+
+// summaryTool receives the summary written by the LLM.
+var summaryTool = mcp.NewTool(
+	"save_summary",
+	mcp.WithDescription("Save the summary of the earlier conversation."),
+	mcp.WithString("text", mcp.Description("The summary"), mcp.Required()),
+)
+
+// summarizerSpec sends requests to the LLM on behalf of the summarize strategy.
+type summarizerSpec interface {
+	SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (types2.LLMResponse, error)
+}
+
+// SetCompaction sets how the history is compacted when it outgrows the context window.
+// summarizer is only used by configuration.CompactionSummarize and may be nil otherwise.
+func (c *Chat) SetCompaction(cfg configuration.CompactionConfig, summarizer summarizerSpec) {
+	c.compaction = cfg
+	c.summarizer = summarizer
+}
+
+// ContextLimit returns the maximum estimated size of the history in tokens: MaxTokens, capped by the
+// MaxPromptTokens of the model if it is in the catalog. Zero means no limit.
+func (c *Chat) ContextLimit() int {
+	limit := c.info.MaxTokens
+	if model, ok := c.catalog.GetModel(c.info.ModelName); ok && model.MaxPromptTokens > 0 {
+		if limit == 0 || model.MaxPromptTokens < limit {
+			limit = model.MaxPromptTokens
+		}
+	}
+	return limit
+}
+
+// ContextTokens returns the estimated size of the history in tokens.
+func (c *Chat) ContextTokens() int {
+	estimator := cost.TokenEstimator{}
+	total := 0
+	for _, message := range c.messagesStack {
+		total += estimator.CountTokens(message)
+	}
+	return total
+}
+
+// Compact shrinks the history with the configured strategy if it is larger than ContextLimit.
+// It returns an error if the history still does not fit afterwards, or does not fit and no strategy is
+// configured; the history is usable either way.
+func (c *Chat) Compact(ctx context.Context) error {
+	limit := c.ContextLimit()
+	if limit == 0 {
+		return nil
+	}
+	before := c.ContextTokens()
+	if before <= limit {
+		return nil
+	}
+	strategy := c.compaction.Strategy
+	if strategy == "" || strategy == configuration.CompactionNone {
+		return fmt.Errorf("history of ~%d tokens exceeds the context limit of %d tokens and compaction is off", before, limit)
+	}
+	c.logger.Infof("History of ~%d tokens exceeds the context limit of %d tokens, compacting with `%s`", before, limit, strategy)
+
+	switch strategy {
+	case configuration.CompactionSummarize:
+		if err := c.summarize(ctx); err != nil {
+			c.logger.Warnf("Failed to summarize the history, dropping old tool results instead: %v", err)
+			c.dropToolResults(limit)
+		}
+	case configuration.CompactionTruncateToolResults:
+		c.truncateToolResults(limit)
+		c.dropToolResults(limit)
+	default:
+		c.dropToolResults(limit)
+	}
+
+	after := c.ContextTokens()
+	c.info.Compactions++
+	c.info.CompactedTokens += before - after
+	c.info.MessageStackLen = len(c.messagesStack)
+	c.logger.Infof("History compacted from ~%d to ~%d tokens", before, after)
+	if after > limit {
+		return fmt.Errorf("history of ~%d tokens does not fit the context limit of %d tokens after compaction", after, limit)
+	}
+	return nil
+}
+
+// compactableEnd returns the index of the first message that must be kept intact.
+func (c *Chat) compactableEnd() int {
+	end := len(c.messagesStack) - c.compaction.KeepRecent
+	if end < 1 {
+		end = 1
+	}
+	return end
+}
+
+// dropToolResults replaces tool results with a placeholder, oldest first, until the history fits the limit.
+// The results stay in place, so that every tool call still has its response.
+func (c *Chat) dropToolResults(limit int) {
+	c.rewriteToolResults(limit, func(content string) string {
+		return droppedResultText
+	})
+}
+
+// truncateToolResults cuts tool results longer than MaxToolResultTokens, oldest first, until the history fits the limit.
+func (c *Chat) truncateToolResults(limit int) {
+	maxChars := c.compaction.MaxToolResultTokens * charsPerToken
+	if maxChars <= 0 {
+		return
+	}
+	c.rewriteToolResults(limit, func(content string) string {
+		return truncateText(content, maxChars)
+	})
+}
+
+// rewriteToolResults applies rewrite to the tool results outside the recent messages until the history fits the limit.
+func (c *Chat) rewriteToolResults(limit int, rewrite func(content string) string) {
+	estimator := cost.TokenEstimator{}
+	total := c.ContextTokens()
+	end := c.compactableEnd()
+	for i := 1; i < end && total > limit; i++ {
+		message := c.messagesStack[i]
+		if message.Role != llms.ChatMessageTypeTool {
+			continue
+		}
+		parts := make([]llms.ContentPart, len(message.Parts))
+		changed := false
+		for j, part := range message.Parts {
+			parts[j] = part
+			if response, ok := part.(llms.ToolCallResponse); ok && response.Content != droppedResultText {
+				if content := rewrite(response.Content); content != response.Content {
+					response.Content = content
+					parts[j] = response
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		rewritten := llms.MessageContent{Role: message.Role, Parts: parts}
+		total += estimator.CountTokens(rewritten) - estimator.CountTokens(message)
+		c.messagesStack[i] = rewritten
+	}
+}
+
+// summarize replaces the messages between the system prompt and the recent messages with a summary written by the LLM.
+func (c *Chat) summarize(ctx context.Context) error {
+	if c.summarizer == nil {
+		return fmt.Errorf("no LLM to summarize with")
+	}
+	end := c.compactableEnd()
+	// A tool result must stay together with its call
+	for end > 1 && end < len(c.messagesStack) && c.messagesStack[end].Role == llms.ChatMessageTypeTool {
+		end--
+	}
+	if end <= 1 {
+		return fmt.Errorf("nothing to summarize")
+	}
+
+	transcript := c.renderTranscript(c.messagesStack[1:end])
+	if maxChars := c.ContextLimit() * charsPerToken * 3 / 4; len(transcript) > maxChars {
+		transcript = truncateText(transcript, maxChars)
+	}
+	args, meta, err := llm.AskForToolCall(ctx, c.summarizer, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, summaryPrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, transcript),
+	}, summaryTool)
+	c.info.TotalTokens += meta.Tokens.TotalTokens
+	c.info.TotalCost += meta.Cost
+	c.info.LLMRequests++
+	if err != nil {
+		return err
+	}
+
+	summary, _ := args["text"].(string)
+	if strings.TrimSpace(summary) == "" {
+		return fmt.Errorf("LLM returned an empty summary")
+	}
+
+	stack := make([]llms.MessageContent, 0, len(c.messagesStack)-end+2)
+	stack = append(stack, c.messagesStack[0], llms.TextParts(llms.ChatMessageTypeHuman, summaryPrefix+summary))
+	stack = append(stack, c.messagesStack[end:]...)
+	c.messagesStack = stack
+	return nil
+}
+
+// renderTranscript renders messages as plain text for the summary request.
+func (c *Chat) renderTranscript(messages []llms.MessageContent) string {
+	maxResultChars := c.compaction.MaxToolResultTokens * charsPerToken
+	var b strings.Builder
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				fmt.Fprintf(&b, "[%s] %s\n", message.Role, p.Text)
+			case llms.ToolCall:
+				if p.FunctionCall != nil {
+					fmt.Fprintf(&b, "[%s] call %s(%s)\n", message.Role, p.FunctionCall.Name, p.FunctionCall.Arguments)
+				}
+			case llms.ToolCallResponse:
+				content := p.Content
+				if maxResultChars > 0 {
+					content = truncateText(content, maxResultChars)
+				}
+				fmt.Fprintf(&b, "[%s] result of %s: %s\n", message.Role, p.Name, content)
+			}
+		}
+	}
+	return b.String()
+}
+
+// truncateText cuts text to at most maxChars bytes on a rune boundary and notes how much was cut.
+// Text that would not get shorter is returned as is.
+func truncateText(text string, maxChars int) string {
+	if len(text) <= maxChars {
+		return text
+	}
+	cut := maxChars
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	truncated := fmt.Sprintf("%s\n[truncated %d characters to fit the context window]", text[:cut], len(text)-cut)
+	if len(truncated) >= len(text) {
+		return text
+	}
+	return truncated
+}
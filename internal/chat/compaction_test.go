@@ -0,0 +1,154 @@
+package chat_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/chat"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	typesllm "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	types "github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type mockSummarizer struct {
+	summary  string
+	err      error
+	messages []llms.MessageContent
+}
+
+func (m *mockSummarizer) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (typesllm.LLMResponse, error) {
+	m.messages = messages
+	if m.err != nil {
+		return typesllm.LLMResponse{}, m.err
+	}
+	call, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:           "s1",
+		Type:         "function",
+		FunctionCall: &llms.FunctionCall{Name: tools[0].Name, Arguments: fmt.Sprintf(`{"text":%q}`, m.summary)},
+	})
+	if err != nil {
+		return typesllm.LLMResponse{}, err
+	}
+	resp := typesllm.LLMResponse{Calls: []types.CallToolRequest{call}}
+	resp.Metadata.Tokens.TotalTokens = 50
+	resp.Metadata.Cost = 0.01
+	return resp, nil
+}
+
+// newLongChat returns a chat with four tool calls whose results are about 250 tokens each.
+func newLongChat(t *testing.T, maxTokens int, compaction configuration.CompactionConfig, summarizer *mockSummarizer) *chat.Chat {
+	ch := chat.NewChat("unknown-model", "System: {{query}}", "query", newTestLogger(), nil, maxTokens, 0.0)
+	if summarizer != nil {
+		ch.SetCompaction(compaction, summarizer)
+	} else {
+		// A nil *mockSummarizer must not become a non-nil interface
+		ch.SetCompaction(compaction, nil)
+	}
+	require.NoError(t, ch.Begin("Hi", nil))
+	for i := 0; i < 4; i++ {
+		call, err := types.NewCallToolRequest(llms.ToolCall{
+			ID:           fmt.Sprintf("c%d", i),
+			Type:         "function",
+			FunctionCall: &llms.FunctionCall{Name: "read", Arguments: `{}`},
+		})
+		require.NoError(t, err)
+		ch.AddToolCall(call)
+		ch.AddToolResult(call, mcp.NewToolResultText(strings.Repeat("x", 1000)))
+	}
+	return ch
+}
+
+func toolResultContent(msg llms.MessageContent) string {
+	if response, ok := msg.Parts[0].(llms.ToolCallResponse); ok {
+		return response.Content
+	}
+	return ""
+}
+
+func TestChat_ContextLimit(t *testing.T) {
+	log := newTestLogger()
+	assert.Equal(t, 0, chat.NewChat("unknown-model", "{{query}}", "query", log, nil, 0, 0).ContextLimit())
+	assert.Equal(t, 1000, chat.NewChat("unknown-model", "{{query}}", "query", log, nil, 1000, 0).ContextLimit())
+	assert.Equal(t, 8192, chat.NewChat("gpt-4", "{{query}}", "query", log, nil, 0, 0).ContextLimit(), "model limit applies without MaxTokens")
+	assert.Equal(t, 8192, chat.NewChat("gpt-4", "{{query}}", "query", log, nil, 100000, 0).ContextLimit(), "model limit caps MaxTokens")
+	assert.Equal(t, 4000, chat.NewChat("gpt-4", "{{query}}", "query", log, nil, 4000, 0).ContextLimit())
+}
+
+func TestChat_Compact(t *testing.T) {
+	t.Run("under the limit", func(t *testing.T) {
+		ch := newLongChat(t, 100000, configuration.CompactionConfig{Strategy: configuration.CompactionDropToolResults}, nil)
+		require.NoError(t, ch.Compact(context.Background()))
+		assert.Zero(t, ch.GetInfo().Compactions)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ch := newLongChat(t, 100, configuration.CompactionConfig{Strategy: configuration.CompactionNone}, nil)
+		before := ch.ContextTokens()
+		assert.Error(t, ch.Compact(context.Background()), "the oversized history is reported")
+		assert.Equal(t, before, ch.ContextTokens())
+		assert.Zero(t, ch.GetInfo().Compactions)
+	})
+
+	t.Run("drop tool results", func(t *testing.T) {
+		ch := newLongChat(t, 800, configuration.CompactionConfig{Strategy: configuration.CompactionDropToolResults, KeepRecent: 2}, nil)
+		require.NoError(t, ch.Compact(context.Background()))
+		msgs := ch.GetLLMMessages()
+		require.Len(t, msgs, 9, "messages are rewritten, not removed")
+		assert.Contains(t, toolResultContent(msgs[2]), "removed to fit the context window")
+		assert.Contains(t, toolResultContent(msgs[8]), strings.Repeat("x", 1000), "recent messages are kept")
+		assert.LessOrEqual(t, ch.ContextTokens(), 800)
+		info := ch.GetInfo()
+		assert.Equal(t, 1, info.Compactions)
+		assert.Greater(t, info.CompactedTokens, 0)
+	})
+
+	t.Run("truncate tool results", func(t *testing.T) {
+		ch := newLongChat(t, 800, configuration.CompactionConfig{
+			Strategy:            configuration.CompactionTruncateToolResults,
+			MaxToolResultTokens: 50,
+		}, nil)
+		require.NoError(t, ch.Compact(context.Background()))
+		msgs := ch.GetLLMMessages()
+		assert.Contains(t, toolResultContent(msgs[2]), "[truncated")
+		assert.Less(t, len(toolResultContent(msgs[2])), 300)
+		assert.LessOrEqual(t, ch.ContextTokens(), 800)
+	})
+
+	t.Run("summarize", func(t *testing.T) {
+		summarizer := &mockSummarizer{summary: "The agent read four files."}
+		ch := newLongChat(t, 800, configuration.CompactionConfig{Strategy: configuration.CompactionSummarize, KeepRecent: 2}, summarizer)
+		require.NoError(t, ch.Compact(context.Background()))
+		msgs := ch.GetLLMMessages()
+		require.Len(t, msgs, 4)
+		assert.Equal(t, llms.ChatMessageTypeSystem, msgs[0].Role)
+		assert.Equal(t, llms.ChatMessageTypeHuman, msgs[1].Role)
+		assert.Contains(t, msgs[1].Parts[0].(llms.TextContent).Text, "The agent read four files.")
+		assert.Equal(t, llms.ChatMessageTypeAI, msgs[2].Role, "the kept tool result stays with its call")
+		assert.Contains(t, summarizer.messages[1].Parts[0].(llms.TextContent).Text, "call read({})")
+		info := ch.GetInfo()
+		assert.Equal(t, 1, info.Compactions)
+		assert.Equal(t, 1, info.LLMRequests)
+		assert.InDelta(t, 0.01, info.TotalCost, 1e-9)
+	})
+
+	t.Run("summarize falls back to dropping", func(t *testing.T) {
+		summarizer := &mockSummarizer{err: errors.New("LLM unavailable")}
+		ch := newLongChat(t, 800, configuration.CompactionConfig{Strategy: configuration.CompactionSummarize, KeepRecent: 2}, summarizer)
+		require.NoError(t, ch.Compact(context.Background()))
+		assert.Contains(t, toolResultContent(ch.GetLLMMessages()[2]), "removed to fit the context window")
+	})
+
+	t.Run("does not fit", func(t *testing.T) {
+		ch := newLongChat(t, 100, configuration.CompactionConfig{Strategy: configuration.CompactionDropToolResults, KeepRecent: 4}, nil)
+		err := ch.Compact(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, 1, ch.GetInfo().Compactions)
+	})
+}
@@ -3,6 +3,11 @@
 // Features: Contains only interfaces and data structures, without implementation
 package configuration
 
+import (
+	"strings"
+	"time"
+)
+
 // AgentConfig represents the configuration for the Agent.
 // Responsibility: Storing all settings needed by the Agent
 // Features: Includes tool configuration, LLM configuration, and chat configuration
@@ -19,4 +24,170 @@ type AgentConfig struct {
 
 	// Agent behavior configuration
 	MaxLLMIterations int
+
+	// RequestBudget is the maximum cost of one call (USD or token-equivalent). Zero means unlimited.
+	RequestBudget float64
+
+	// ToolCalls limits concurrent execution of tool calls from one LLM turn
+	ToolCalls ToolCallsConfig
+
+	// Approval holds the approval policies of tool calls
+	Approval ApprovalConfig
+
+	// Compaction controls how the message history is shrunk when it outgrows the context window
+	Compaction CompactionConfig
+
+	// AllowedTools lists the connected MCP tools the agent may use. Empty means all of them.
+	AllowedTools []string
+}
+
+// IsToolAllowed reports whether the agent may use the connected MCP tool.
+func (c AgentConfig) IsToolAllowed(name string) bool {
+	if len(c.AllowedTools) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedTools {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolDefinition represents one entry of `agent.tools`: a tool exposed by the agent process with its own agent behind it.
+// Responsibility: Storing the per-tool overrides of the agent configuration
+// Features: Empty fields are inherited from `agent.tool`, `agent.llm` and `agent.chat`
+type ToolDefinition struct {
+	Name                string   `koanf:"name" json:"name" yaml:"name"`
+	Description         string   `koanf:"description" json:"description" yaml:"description"`
+	ArgumentName        string   `koanf:"argumentname" json:"argumentName,omitempty" yaml:"argumentName,omitempty"`
+	ArgumentDescription string   `koanf:"argumentdescription" json:"argumentDescription,omitempty" yaml:"argumentDescription,omitempty"`
+	PromptTemplate      string   `koanf:"prompttemplate" json:"promptTemplate,omitempty" yaml:"promptTemplate,omitempty"`
+	Model               string   `koanf:"model" json:"model,omitempty" yaml:"model,omitempty"`
+	MaxLLMIterations    int      `koanf:"maxllmiterations" json:"maxLLMIterations,omitempty" yaml:"maxLLMIterations,omitempty"`
+	AllowedTools        []string `koanf:"allowedtools" json:"allowedTools,omitempty" yaml:"allowedTools,omitempty"`
+	// InputSchema is a JSON Schema object of the arguments taken next to the one of ArgumentName;
+	// each of them is available in the prompt template by its name
+	InputSchema any `koanf:"inputschema" json:"inputSchema,omitempty" yaml:"inputSchema,omitempty"`
+	// OutputSchema is a JSON Schema object of a structured final answer, as in `agent.tool.outputSchema`
+	OutputSchema any `koanf:"outputschema" json:"outputSchema,omitempty" yaml:"outputSchema,omitempty"`
+}
+
+const (
+	// CompactionNone disables compaction; an oversized history is reported and sent as is.
+	CompactionNone = "none"
+	// CompactionDropToolResults replaces the oldest tool results with a placeholder.
+	CompactionDropToolResults = "drop_tool_results"
+	// CompactionTruncateToolResults cuts large tool results, oldest first.
+	CompactionTruncateToolResults = "truncate_tool_results"
+	// CompactionSummarize replaces earlier turns with a summary written by the LLM.
+	CompactionSummarize = "summarize"
+)
+
+// CompactionConfig represents the context-window management of a chat.
+// Responsibility: Storing the compaction strategy and its limits
+// Features: The most recent messages and the system prompt are never compacted
+type CompactionConfig struct {
+	// Strategy is one of the Compaction* constants. Empty, the default, means CompactionNone.
+	Strategy string
+
+	// KeepRecent is the number of latest messages left intact.
+	KeepRecent int
+
+	// MaxToolResultTokens is the size a tool result is truncated to by CompactionTruncateToolResults.
+	MaxToolResultTokens int
+}
+
+// ToolCallsConfig represents the concurrency limits for tool calls.
+// Responsibility: Storing limits for executing tool calls requested in one LLM turn
+// Features: Global and per-server limits, with an opt-out list of servers whose tools must run one at a time
+type ToolCallsConfig struct {
+	// MaxParallel is the maximum number of tool calls executed at once. Values below 1 mean sequential execution.
+	MaxParallel int
+
+	// MaxParallelPerServer is the maximum number of concurrent calls to one MCP server. Zero means no per-server limit.
+	MaxParallelPerServer int
+
+	// SequentialServers lists MCP server IDs whose tools are never called concurrently.
+	SequentialServers []string
+}
+
+const (
+	// ApprovalAuto lets the agent call the tool without asking.
+	ApprovalAuto = "auto"
+	// ApprovalRequire pauses the session until a human approves or rejects the call.
+	ApprovalRequire = "require_approval"
+	// ApprovalDeny rejects every call of the tool.
+	ApprovalDeny = "deny"
+	// ApprovalAnyTool is the key of the policy for tools not listed in MCPServerConnection.Approval.
+	ApprovalAnyTool = "*"
+)
+
+// ApprovalConfig represents the approval policies of tool calls.
+// Responsibility: Deciding which tool calls need a human decision
+// Features: Policies are set per MCP server and tool; anything not configured is called automatically
+type ApprovalConfig struct {
+	// Servers maps MCP server IDs to their tool policies, as in MCPServerConnection.Approval.
+	Servers map[string]map[string]string
+
+	// ToolPrefixes maps MCP server IDs to the prefix of their exposed tool names, as in MCPConnectorConfig.ToolPrefix.
+	ToolPrefixes map[string]string
+}
+
+// Policy returns the approval policy for a tool of the given server. toolName is the exposed name;
+// the prefix of the server is removed before the lookup.
+func (c ApprovalConfig) Policy(serverID, toolName string) string {
+	srv := MCPServerConnection{Approval: c.Servers[serverID]}
+	return srv.ApprovalPolicy(strings.TrimPrefix(toolName, c.ToolPrefixes[serverID]))
+}
+
+// ApprovalHookConfig represents a local command that approves tool calls.
+// Responsibility: Storing the command and its time limit
+// Features: An empty command disables the hook
+type ApprovalHookConfig struct {
+	// Command is the executable to run for each call that requires approval.
+	Command string
+
+	// Args are the arguments passed to the command.
+	Args []string
+
+	// Timeout is the time to wait for a decision. Zero means no limit.
+	Timeout time.Duration
+}
+
+// Enabled reports whether the local approval hook is configured.
+func (c ApprovalHookConfig) Enabled() bool {
+	return c.Command != ""
+}
+
+const (
+	// SessionStoreMemory keeps sessions in process memory.
+	SessionStoreMemory = "memory"
+	// SessionStoreFile keeps sessions in JSON files in SessionStoreConfig.Dir.
+	SessionStoreFile = "file"
+	// EndSessionToolName is the tool the server adds to end sessions; no main tool may be named like it.
+	EndSessionToolName = "end_session"
+)
+
+// reservedArgumentNames are the prompt template variables and the arguments the server adds to main tools;
+// the input schema of a tool may not declare them.
+var reservedArgumentNames = []string{"input", "tools", "session_id", "budget"}
+
+// SessionStoreConfig represents the configuration for multi-turn sessions.
+// Responsibility: Storing the session store type and expiry settings
+// Features: An empty store disables sessions
+type SessionStoreConfig struct {
+	// Store is the store type: SessionStoreMemory, SessionStoreFile, or empty to disable sessions.
+	Store string
+
+	// Dir is the directory for the file store.
+	Dir string
+
+	// TTL is the time after the last update when a session expires. It is positive when sessions are enabled.
+	TTL time.Duration
+}
+
+// Enabled reports whether multi-turn sessions are turned on.
+func (c SessionStoreConfig) Enabled() bool {
+	return c.Store != ""
 }
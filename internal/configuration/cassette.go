@@ -0,0 +1,32 @@
+package configuration
+
+const (
+	// CassetteRecord writes every LLM and tool exchange to the cassette file.
+	CassetteRecord = "record"
+	// CassetteReplay answers LLM requests and tool calls from the cassette file.
+	CassetteReplay = "replay"
+)
+
+// CassetteConfig represents the configuration for recording and replaying sessions.
+// Responsibility: Storing the cassette mode and file
+// Features: An empty mode disables cassettes
+type CassetteConfig struct {
+	// Mode is CassetteRecord, CassetteReplay, or empty.
+	Mode string
+
+	// Path is the cassette file.
+	Path string
+
+	// Strict makes a replayed request that differs from the recording fail instead of only being logged.
+	Strict bool
+}
+
+// Replaying reports whether the LLM and MCP servers are replaced by a cassette.
+func (c CassetteConfig) Replaying() bool {
+	return c.Mode == CassetteReplay
+}
+
+// Recording reports whether exchanges are written to a cassette.
+func (c CassetteConfig) Recording() bool {
+	return c.Mode == CassetteRecord
+}
@@ -1,7 +1,12 @@
 package configuration
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/korchasa/speelka-agent-go/internal/utils/log_formatter"
 
 	"github.com/sirupsen/logrus"
@@ -22,11 +27,56 @@ type Configuration struct {
 				BufferSize int  `koanf:"buffersize" json:"bufferSize" yaml:"bufferSize"`
 			} `koanf:"stdio"`
 			HTTP struct {
-				Enabled bool   `koanf:"enabled"`
-				Host    string `koanf:"host"`
-				Port    int    `koanf:"port"`
+				Enabled bool           `koanf:"enabled"`
+				Host    string         `koanf:"host"`
+				Port    int            `koanf:"port"`
+				Path    string         `koanf:"path"`
+				TLS     TLSConfig      `koanf:"tls" json:"tls" yaml:"tls"`
+				Auth    HTTPAuthConfig `koanf:"auth" json:"auth" yaml:"auth"`
 			} `koanf:"http"`
+			StreamableHTTP struct {
+				Enabled bool           `koanf:"enabled"`
+				Host    string         `koanf:"host"`
+				Port    int            `koanf:"port"`
+				Path    string         `koanf:"path"`
+				TLS     TLSConfig      `koanf:"tls" json:"tls" yaml:"tls"`
+				Auth    HTTPAuthConfig `koanf:"auth" json:"auth" yaml:"auth"`
+			} `koanf:"streamablehttp" json:"streamableHttp" yaml:"streamableHttp"`
 		} `koanf:"transports"`
+		Cassette struct {
+			Mode   string `koanf:"mode"`
+			Path   string `koanf:"path"`
+			Strict bool   `koanf:"strict"`
+		} `koanf:"cassette"`
+		Limits struct {
+			MaxConcurrentSessions int                     `koanf:"maxconcurrentsessions" json:"maxConcurrentSessions" yaml:"maxConcurrentSessions"`
+			MaxQueuedSessions     int                     `koanf:"maxqueuedsessions" json:"maxQueuedSessions" yaml:"maxQueuedSessions"`
+			QueueTimeout          float64                 `koanf:"queuetimeout" json:"queueTimeout" yaml:"queueTimeout"`
+			RequestsPerMinute     float64                 `koanf:"requestsperminute" json:"requestsPerMinute" yaml:"requestsPerMinute"`
+			Burst                 int                     `koanf:"burst"`
+			DailySpend            float64                 `koanf:"dailyspend" json:"dailySpend" yaml:"dailySpend"`
+			LedgerFile            string                  `koanf:"ledgerfile" json:"ledgerFile" yaml:"ledgerFile"`
+			Clients               map[string]ClientLimits `koanf:"clients"`
+		} `koanf:"limits"`
+		API struct {
+			Enabled bool `koanf:"enabled"`
+		} `koanf:"api"`
+		OpenAI struct {
+			Enabled bool `koanf:"enabled"`
+		} `koanf:"openai" json:"openai" yaml:"openai"`
+		Metrics struct {
+			Enabled bool   `koanf:"enabled"`
+			Host    string `koanf:"host"`
+			Port    int    `koanf:"port"`
+			Path    string `koanf:"path"`
+		} `koanf:"metrics"`
+		Tracing struct {
+			Exporter    string            `koanf:"exporter"`
+			Endpoint    string            `koanf:"endpoint"`
+			Headers     map[string]string `koanf:"headers"`
+			Path        string            `koanf:"path"`
+			ServiceName string            `koanf:"servicename" json:"serviceName" yaml:"serviceName"`
+		} `koanf:"tracing"`
 	} `koanf:"runtime"`
 	Agent struct {
 		Name    string `koanf:"name"`
@@ -36,12 +86,35 @@ type Configuration struct {
 			Description         string `koanf:"description"`
 			ArgumentName        string `koanf:"argumentname" json:"argumentName" yaml:"argumentName"`
 			ArgumentDescription string `koanf:"argumentdescription" json:"argumentDescription" yaml:"argumentDescription"`
+			// OutputSchema is a JSON Schema object, or a JSON string holding one when set from the environment
+			OutputSchema any `koanf:"outputschema" json:"outputSchema,omitempty" yaml:"outputSchema,omitempty"`
 		} `koanf:"tool"`
-		Chat struct {
+		// Tools, if not empty, replaces Tool with several tools served by one process
+		Tools []ToolDefinition `koanf:"tools" json:"tools,omitempty" yaml:"tools,omitempty"`
+		Chat  struct {
 			MaxTokens        int     `koanf:"maxtokens" json:"maxTokens" yaml:"maxTokens"`
 			MaxLLMIterations int     `koanf:"maxllmiterations" json:"maxLLMIterations" yaml:"maxLLMIterations"`
 			RequestBudget    float64 `koanf:"requestbudget" json:"requestBudget" yaml:"requestBudget"`
+			ToolCalls        struct {
+				MaxParallel          int `koanf:"maxparallel" json:"maxParallel" yaml:"maxParallel"`
+				MaxParallelPerServer int `koanf:"maxparallelperserver" json:"maxParallelPerServer" yaml:"maxParallelPerServer"`
+			} `koanf:"toolcalls" json:"toolCalls" yaml:"toolCalls"`
+			Compaction struct {
+				Strategy            string `koanf:"strategy" json:"strategy" yaml:"strategy"`
+				KeepRecent          int    `koanf:"keeprecent" json:"keepRecent" yaml:"keepRecent"`
+				MaxToolResultTokens int    `koanf:"maxtoolresulttokens" json:"maxToolResultTokens" yaml:"maxToolResultTokens"`
+			} `koanf:"compaction" json:"compaction" yaml:"compaction"`
 		} `koanf:"chat"`
+		Sessions struct {
+			Store string  `koanf:"store"`
+			Dir   string  `koanf:"dir"`
+			TTL   float64 `koanf:"ttl"`
+		} `koanf:"sessions"`
+		Approval struct {
+			Command string   `koanf:"command"`
+			Args    []string `koanf:"args"`
+			Timeout float64  `koanf:"timeout"`
+		} `koanf:"approval"`
 		LLM struct {
 			Provider       string  `koanf:"provider"`
 			Model          string  `koanf:"model"`
@@ -56,10 +129,18 @@ type Configuration struct {
 				BackoffMultiplier float64 `koanf:"backoffmultiplier" json:"backoffMultiplier" yaml:"backoffMultiplier"`
 			} `koanf:"retry"`
 			IsMaxTokensSet bool `koanf:"ismaxtokensset" json:"isMaxTokensSet" yaml:"isMaxTokensSet"`
+			// Script is the script file of the fake provider
+			Script string `koanf:"script" json:"script,omitempty" yaml:"script,omitempty"`
 		} `koanf:"llm"`
 		Connections struct {
-			McpServers map[string]MCPServerConnection `koanf:"mcpservers" json:"mcpServers" yaml:"mcpServers"`
-			Retry      struct {
+			McpServers      map[string]MCPServerConnection `koanf:"mcpservers" json:"mcpServers" yaml:"mcpServers"`
+			ToolNaming      string                         `koanf:"toolnaming" json:"toolNaming" yaml:"toolNaming"`
+			OnToolCollision string                         `koanf:"ontoolcollision" json:"onToolCollision" yaml:"onToolCollision"`
+			HealthCheck     struct {
+				Interval float64 `koanf:"interval"`
+				Timeout  float64 `koanf:"timeout"`
+			} `koanf:"healthcheck" json:"healthCheck" yaml:"healthCheck"`
+			Retry struct {
 				MaxRetries        int     `koanf:"maxretries" json:"maxRetries" yaml:"maxRetries"`
 				InitialBackoff    float64 `koanf:"initialbackoff" json:"initialBackoff" yaml:"initialBackoff"`
 				MaxBackoff        float64 `koanf:"maxbackoff" json:"maxBackoff" yaml:"maxBackoff"`
@@ -82,12 +163,117 @@ func (c *Configuration) GetAgentConfig() AgentConfig {
 			Description:         c.Agent.Tool.Description,
 			ArgumentName:        c.Agent.Tool.ArgumentName,
 			ArgumentDescription: c.Agent.Tool.ArgumentDescription,
+			OutputSchema:        c.outputSchema(),
 		},
 		Model:                c.Agent.LLM.Model,
 		SystemPromptTemplate: c.Agent.LLM.PromptTemplate,
 		MaxTokens:            c.Agent.Chat.MaxTokens,
 		MaxLLMIterations:     c.Agent.Chat.MaxLLMIterations,
+		RequestBudget:        c.Agent.Chat.RequestBudget,
+		ToolCalls: ToolCallsConfig{
+			MaxParallel:          c.Agent.Chat.ToolCalls.MaxParallel,
+			MaxParallelPerServer: c.Agent.Chat.ToolCalls.MaxParallelPerServer,
+			SequentialServers:    c.sequentialServers(),
+		},
+		Approval: ApprovalConfig{
+			Servers:      c.approvalPolicies(),
+			ToolPrefixes: c.GetMCPConnectorConfig().ToolPrefixes(),
+		},
+		Compaction: CompactionConfig{
+			Strategy:            c.Agent.Chat.Compaction.Strategy,
+			KeepRecent:          c.Agent.Chat.Compaction.KeepRecent,
+			MaxToolResultTokens: c.Agent.Chat.Compaction.MaxToolResultTokens,
+		},
+	}
+}
+
+// GetAgentConfigs returns the configuration of the agent behind each exposed tool, in the order of `agent.tools`.
+// Without `agent.tools` it returns the single agent of `agent.tool`.
+func (c *Configuration) GetAgentConfigs() []AgentConfig {
+	base := c.GetAgentConfig()
+	if len(c.Agent.Tools) == 0 {
+		return []AgentConfig{base}
+	}
+	configs := make([]AgentConfig, 0, len(c.Agent.Tools))
+	for _, def := range c.Agent.Tools {
+		agentConfig := base
+		agentConfig.Tool = MCPServerToolConfig{
+			Name:                def.Name,
+			Description:         def.Description,
+			ArgumentName:        firstNonEmpty(def.ArgumentName, base.Tool.ArgumentName),
+			ArgumentDescription: firstNonEmpty(def.ArgumentDescription, base.Tool.ArgumentDescription),
+		}
+		agentConfig.Tool.InputSchema, _ = parseSchema(def.InputSchema)
+		agentConfig.Tool.OutputSchema, _ = parseSchema(def.OutputSchema)
+		agentConfig.Model = firstNonEmpty(def.Model, base.Model)
+		agentConfig.SystemPromptTemplate = firstNonEmpty(def.PromptTemplate, base.SystemPromptTemplate)
+		if def.MaxLLMIterations > 0 {
+			agentConfig.MaxLLMIterations = def.MaxLLMIterations
+		}
+		agentConfig.AllowedTools = def.AllowedTools
+		configs = append(configs, agentConfig)
+	}
+	return configs
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// outputSchema returns the output schema of the final answer, or nil if it is not set or invalid.
+// Validate reports an invalid schema.
+func (c *Configuration) outputSchema() map[string]any {
+	schema, _ := parseSchema(c.Agent.Tool.OutputSchema)
+	return schema
+}
+
+// parseSchema accepts a schema as an object from a config file or as a JSON string from the environment.
+func parseSchema(v any) (map[string]any, error) {
+	switch s := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return s, nil
+	case string:
+		if strings.TrimSpace(s) == "" {
+			return nil, nil
+		}
+		var schema map[string]any
+		if err := json.Unmarshal([]byte(s), &schema); err != nil {
+			return nil, fmt.Errorf("schema is not a JSON object: %w", err)
+		}
+		return schema, nil
+	default:
+		return nil, fmt.Errorf("schema must be an object, got %T", v)
+	}
+}
+
+// approvalPolicies returns the tool approval policies of MCP servers that have any
+func (c *Configuration) approvalPolicies() map[string]map[string]string {
+	policies := make(map[string]map[string]string)
+	for id, srv := range c.Agent.Connections.McpServers {
+		if len(srv.Approval) > 0 {
+			policies[id] = srv.Approval
+		}
+	}
+	return policies
+}
+
+// sequentialServers returns the IDs of MCP servers that opted out of concurrent tool calls
+func (c *Configuration) sequentialServers() []string {
+	var ids []string
+	for id, srv := range c.Agent.Connections.McpServers {
+		if srv.Sequential {
+			ids = append(ids, id)
+		}
 	}
+	sort.Strings(ids)
+	return ids
 }
 
 // GetLLMConfig converts *Configuration to LLMConfig
@@ -106,6 +292,7 @@ func (c *Configuration) GetLLMConfig() LLMConfig {
 			MaxBackoff:        c.Agent.LLM.Retry.MaxBackoff,
 			BackoffMultiplier: c.Agent.LLM.Retry.BackoffMultiplier,
 		},
+		Script: c.Agent.LLM.Script,
 	}
 }
 
@@ -118,6 +305,17 @@ func (c *Configuration) GetMCPServerConfig() MCPServerConfig {
 			Enabled: c.Runtime.Transports.HTTP.Enabled,
 			Host:    c.Runtime.Transports.HTTP.Host,
 			Port:    c.Runtime.Transports.HTTP.Port,
+			Path:    c.Runtime.Transports.HTTP.Path,
+			TLS:     c.Runtime.Transports.HTTP.TLS,
+			Auth:    c.Runtime.Transports.HTTP.Auth,
+		},
+		StreamableHTTP: HTTPConfig{
+			Enabled: c.Runtime.Transports.StreamableHTTP.Enabled,
+			Host:    c.Runtime.Transports.StreamableHTTP.Host,
+			Port:    c.Runtime.Transports.StreamableHTTP.Port,
+			Path:    c.Runtime.Transports.StreamableHTTP.Path,
+			TLS:     c.Runtime.Transports.StreamableHTTP.TLS,
+			Auth:    c.Runtime.Transports.StreamableHTTP.Auth,
 		},
 		Stdio: StdioConfig{
 			Enabled:    c.Runtime.Transports.Stdio.Enabled,
@@ -128,8 +326,91 @@ func (c *Configuration) GetMCPServerConfig() MCPServerConfig {
 			Description:         c.Agent.Tool.Description,
 			ArgumentName:        c.Agent.Tool.ArgumentName,
 			ArgumentDescription: c.Agent.Tool.ArgumentDescription,
+			OutputSchema:        c.outputSchema(),
 		},
-		MCPLogEnabled: !c.Runtime.Log.DisableMCP,
+		Metrics: MetricsConfig{
+			Enabled: c.Runtime.Metrics.Enabled,
+			Host:    c.Runtime.Metrics.Host,
+			Port:    c.Runtime.Metrics.Port,
+			Path:    c.Runtime.Metrics.Path,
+		},
+		APIEnabled:      c.Runtime.API.Enabled,
+		OpenAIEnabled:   c.Runtime.OpenAI.Enabled,
+		Tools:           c.mainTools(),
+		MCPLogEnabled:   !c.Runtime.Log.DisableMCP,
+		SessionsEnabled: c.GetSessionStoreConfig().Enabled(),
+	}
+}
+
+// mainTools returns the tools of `agent.tools`, or nil if the list is empty
+func (c *Configuration) mainTools() []MCPServerToolConfig {
+	if len(c.Agent.Tools) == 0 {
+		return nil
+	}
+	var tools []MCPServerToolConfig
+	for _, agentConfig := range c.GetAgentConfigs() {
+		tools = append(tools, agentConfig.Tool)
+	}
+	return tools
+}
+
+// GetSessionStoreConfig converts *Configuration to SessionStoreConfig
+func (c *Configuration) GetSessionStoreConfig() SessionStoreConfig {
+	return SessionStoreConfig{
+		Store: c.Agent.Sessions.Store,
+		Dir:   c.Agent.Sessions.Dir,
+		TTL:   time.Duration(c.Agent.Sessions.TTL * float64(time.Second)),
+	}
+}
+
+// GetCassetteConfig converts *Configuration to CassetteConfig
+func (c *Configuration) GetCassetteConfig() CassetteConfig {
+	return CassetteConfig{
+		Mode:   c.Runtime.Cassette.Mode,
+		Path:   c.Runtime.Cassette.Path,
+		Strict: c.Runtime.Cassette.Strict,
+	}
+}
+
+// GetLimitsConfig converts *Configuration to LimitsConfig
+func (c *Configuration) GetLimitsConfig() LimitsConfig {
+	limits := c.Runtime.Limits
+	return LimitsConfig{
+		MaxConcurrentSessions: limits.MaxConcurrentSessions,
+		MaxQueuedSessions:     limits.MaxQueuedSessions,
+		QueueTimeout:          time.Duration(limits.QueueTimeout * float64(time.Second)),
+		ClientLimits: ClientLimits{
+			RequestsPerMinute: limits.RequestsPerMinute,
+			Burst:             limits.Burst,
+			DailySpend:        limits.DailySpend,
+		},
+		LedgerFile: limits.LedgerFile,
+		Clients:    limits.Clients,
+	}
+}
+
+// GetTracingConfig converts *Configuration to TracingConfig. The service is named after the agent by default.
+func (c *Configuration) GetTracingConfig() TracingConfig {
+	tracing := c.Runtime.Tracing
+	serviceName := tracing.ServiceName
+	if serviceName == "" {
+		serviceName = c.Agent.Name
+	}
+	return TracingConfig{
+		Exporter:    tracing.Exporter,
+		Endpoint:    tracing.Endpoint,
+		Headers:     tracing.Headers,
+		Path:        tracing.Path,
+		ServiceName: serviceName,
+	}
+}
+
+// GetApprovalHookConfig converts *Configuration to ApprovalHookConfig
+func (c *Configuration) GetApprovalHookConfig() ApprovalHookConfig {
+	return ApprovalHookConfig{
+		Command: c.Agent.Approval.Command,
+		Args:    c.Agent.Approval.Args,
+		Timeout: time.Duration(c.Agent.Approval.Timeout * float64(time.Second)),
 	}
 }
 
@@ -143,6 +424,12 @@ func (c *Configuration) GetMCPConnectorConfig() MCPConnectorConfig {
 			MaxBackoff:        c.Agent.Connections.Retry.MaxBackoff,
 			BackoffMultiplier: c.Agent.Connections.Retry.BackoffMultiplier,
 		},
+		ToolNaming:      c.Agent.Connections.ToolNaming,
+		OnToolCollision: c.Agent.Connections.OnToolCollision,
+		HealthCheck: HealthCheckConfig{
+			Interval: time.Duration(c.Agent.Connections.HealthCheck.Interval * float64(time.Second)),
+			Timeout:  time.Duration(c.Agent.Connections.HealthCheck.Timeout * float64(time.Second)),
+		},
 	}
 }
 
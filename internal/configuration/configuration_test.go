@@ -2,6 +2,7 @@ package configuration
 
 import (
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -59,6 +60,7 @@ func TestConfiguration_Converters(t *testing.T) {
 		assert.Equal(t, "prompt {{arg1}}", agentCfg.SystemPromptTemplate)
 		assert.Equal(t, 1234, agentCfg.MaxTokens)
 		assert.Equal(t, 7, agentCfg.MaxLLMIterations)
+		assert.Equal(t, 1.23, agentCfg.RequestBudget)
 	})
 
 	t.Run("GetLLMConfig", func(t *testing.T) {
@@ -166,6 +168,21 @@ func TestGetAgentConfig(t *testing.T) {
 	assert.Equal(t, 3, ac.MaxLLMIterations)
 }
 
+func TestGetAgentConfig_ToolCalls(t *testing.T) {
+	c := &Configuration{}
+	c.Agent.Chat.ToolCalls.MaxParallel = 8
+	c.Agent.Chat.ToolCalls.MaxParallelPerServer = 3
+	c.Agent.Connections.McpServers = map[string]MCPServerConnection{
+		"fs":    {Command: "fs", Sequential: true},
+		"time":  {Command: "time"},
+		"shell": {Command: "sh", Sequential: true},
+	}
+	ac := c.GetAgentConfig()
+	assert.Equal(t, 8, ac.ToolCalls.MaxParallel)
+	assert.Equal(t, 3, ac.ToolCalls.MaxParallelPerServer)
+	assert.Equal(t, []string{"fs", "shell"}, ac.ToolCalls.SequentialServers)
+}
+
 func TestGetLLMConfig(t *testing.T) {
 	c := &Configuration{}
 	c.Agent.LLM.Provider = "prov"
@@ -202,6 +219,10 @@ func TestGetMCPServerConfig(t *testing.T) {
 	c.Runtime.Transports.HTTP.Port = 123
 	c.Runtime.Transports.Stdio.Enabled = false
 	c.Runtime.Transports.Stdio.BufferSize = 10
+	c.Runtime.Transports.StreamableHTTP.Enabled = true
+	c.Runtime.Transports.StreamableHTTP.Host = "host"
+	c.Runtime.Transports.StreamableHTTP.Port = 123
+	c.Runtime.Transports.StreamableHTTP.Path = "/agent"
 	c.Agent.Tool.Name = "tool"
 	c.Agent.Tool.Description = "desc"
 	c.Agent.Tool.ArgumentName = "arg"
@@ -215,6 +236,8 @@ func TestGetMCPServerConfig(t *testing.T) {
 	assert.Equal(t, 123, cfg.HTTP.Port)
 	assert.False(t, cfg.Stdio.Enabled)
 	assert.Equal(t, 10, cfg.Stdio.BufferSize)
+	assert.Equal(t, HTTPConfig{Enabled: true, Host: "host", Port: 123, Path: "/agent"}, cfg.StreamableHTTP)
+	assert.Equal(t, "host:123", cfg.StreamableHTTP.Addr())
 	assert.Equal(t, "tool", cfg.Tool.Name)
 	assert.Equal(t, "desc", cfg.Tool.Description)
 	assert.Equal(t, "arg", cfg.Tool.ArgumentName)
@@ -237,3 +260,55 @@ func TestGetMCPConnectorConfig(t *testing.T) {
 	assert.Equal(t, 3.0, cfg.RetryConfig.MaxBackoff)
 	assert.Equal(t, 4.0, cfg.RetryConfig.BackoffMultiplier)
 }
+
+func TestGetSessionStoreConfig(t *testing.T) {
+	c := &Configuration{}
+	assert.False(t, c.GetSessionStoreConfig().Enabled())
+	assert.False(t, c.GetMCPServerConfig().SessionsEnabled)
+	c.Agent.Sessions.Store = "file"
+	c.Agent.Sessions.Dir = "/var/lib/agent"
+	c.Agent.Sessions.TTL = 90
+	cfg := c.GetSessionStoreConfig()
+	assert.True(t, cfg.Enabled())
+	assert.Equal(t, "file", cfg.Store)
+	assert.Equal(t, "/var/lib/agent", cfg.Dir)
+	assert.Equal(t, 90*time.Second, cfg.TTL)
+	assert.True(t, c.GetMCPServerConfig().SessionsEnabled)
+}
+
+func TestGetAgentConfig_Approval(t *testing.T) {
+	c := &Configuration{}
+	c.Agent.Connections.McpServers = map[string]MCPServerConnection{
+		"fs":   {Command: "fs", Approval: map[string]string{"write_file": ApprovalRequire, "*": ApprovalDeny}},
+		"time": {Command: "time"},
+	}
+	ac := c.GetAgentConfig()
+	assert.Equal(t, ApprovalRequire, ac.Approval.Policy("fs", "write_file"))
+	assert.Equal(t, ApprovalDeny, ac.Approval.Policy("fs", "delete_file"))
+	assert.Equal(t, ApprovalAuto, ac.Approval.Policy("time", "now"))
+	assert.Equal(t, ApprovalAuto, ac.Approval.Policy("unknown", "tool"))
+}
+
+func TestGetApprovalHookConfig(t *testing.T) {
+	c := &Configuration{}
+	assert.False(t, c.GetApprovalHookConfig().Enabled())
+	c.Agent.Approval.Command = "/usr/local/bin/approve"
+	c.Agent.Approval.Args = []string{"--strict"}
+	c.Agent.Approval.Timeout = 30
+	cfg := c.GetApprovalHookConfig()
+	assert.True(t, cfg.Enabled())
+	assert.Equal(t, []string{"--strict"}, cfg.Args)
+	assert.Equal(t, 30*time.Second, cfg.Timeout)
+}
+
+func TestGetAgentConfig_Compaction(t *testing.T) {
+	c := &Configuration{}
+	c.Agent.Chat.Compaction.Strategy = CompactionTruncateToolResults
+	c.Agent.Chat.Compaction.KeepRecent = 6
+	c.Agent.Chat.Compaction.MaxToolResultTokens = 500
+	assert.Equal(t, CompactionConfig{
+		Strategy:            CompactionTruncateToolResults,
+		KeepRecent:          6,
+		MaxToolResultTokens: 500,
+	}, c.GetAgentConfig().Compaction)
+}
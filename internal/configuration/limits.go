@@ -0,0 +1,51 @@
+package configuration
+
+import "time"
+
+// LimitsConfig represents the limits on the tool calls that run the agent.
+// Responsibility: Storing the concurrency cap, the rate limits and the spend caps
+// Features: Zero disables a limit; rate and spend limits apply per client and can be overridden per client
+type LimitsConfig struct {
+	// MaxConcurrentSessions caps the sessions that run at once across all clients.
+	MaxConcurrentSessions int
+
+	// MaxQueuedSessions is how many calls may wait for a free session slot; further calls are rejected.
+	MaxQueuedSessions int
+
+	// QueueTimeout is how long a call waits for a free session slot.
+	QueueTimeout time.Duration
+
+	// ClientLimits are the rate and spend limits of every client without its own entry in Clients.
+	ClientLimits
+
+	// LedgerFile keeps the spend of the clients across restarts. Empty keeps it in memory.
+	LedgerFile string
+
+	// Clients overrides the rate and spend limits of single clients, by client name.
+	Clients map[string]ClientLimits
+}
+
+// ClientLimits represents the limits of one client.
+type ClientLimits struct {
+	// RequestsPerMinute is the sustained rate of calls.
+	RequestsPerMinute float64 `koanf:"requestsperminute" json:"requestsPerMinute,omitempty" yaml:"requestsPerMinute,omitempty"`
+
+	// Burst is how many calls may be made at once before the rate applies. Defaults to a minute's worth.
+	Burst int `koanf:"burst" json:"burst,omitempty" yaml:"burst,omitempty"`
+
+	// DailySpend caps the cost in USD of the calls of the last 24 hours.
+	DailySpend float64 `koanf:"dailyspend" json:"dailySpend,omitempty" yaml:"dailySpend,omitempty"`
+}
+
+// Enabled reports whether any limit is set.
+func (c LimitsConfig) Enabled() bool {
+	return c.MaxConcurrentSessions > 0 || c.RequestsPerMinute > 0 || c.DailySpend > 0 || len(c.Clients) > 0
+}
+
+// ForClient returns the limits of the client.
+func (c LimitsConfig) ForClient(client string) ClientLimits {
+	if limits, ok := c.Clients[client]; ok {
+		return limits
+	}
+	return c.ClientLimits
+}
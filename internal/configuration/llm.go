@@ -4,7 +4,7 @@ package configuration
 // Responsibility: Storing all settings for working with the language model
 // Features: Includes parameters for connecting to the provider, model settings, and prompt templates
 type LLMConfig struct {
-	// Provider - name of the LLM provider (e.g., "openai", "anthropic").
+	// Provider - name of the LLM provider (e.g., "openai", "anthropic", "fake").
 	Provider string
 
 	// Model - name of the LLM model to use.
@@ -30,8 +30,14 @@ type LLMConfig struct {
 
 	// RetryConfig - configuration for retry attempts on failed requests.
 	RetryConfig RetryConfig
+
+	// Script - script file of the fake provider.
+	Script string
 }
 
+// LLMProviderFake answers from a script file instead of an LLM API, for offline tests of configurations.
+const LLMProviderFake = "fake"
+
 // RetryConfig represents the configuration for retry attempts on failed requests.
 // Responsibility: Configuring the retry strategy
 // Features: Defines the number of attempts and wait time between them
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/knadh/koanf/parsers/json"
@@ -13,6 +14,7 @@ import (
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
+	"github.com/korchasa/speelka-agent-go/internal/utils/jsonschema"
 
 	goyaml "gopkg.in/yaml.v3"
 )
@@ -100,6 +102,39 @@ func (cm *Manager) Validate() error {
 	if err := cm.validatePrompt(cm.config); err != nil {
 		validationErrors = append(validationErrors, err.Error())
 	}
+	if err := cm.validateSessions(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateApproval(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateCompaction(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateOutputSchema(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateTools(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateCassette(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateConnections(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateHTTPTransports(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateMetrics(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateLimits(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if err := cm.validateTracing(cm.config); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
 	if len(validationErrors) > 0 {
 		return fmt.Errorf("%s", strings.Join(validationErrors, "; "))
 	}
@@ -110,13 +145,23 @@ func (cm *Manager) validateAgent(config *Configuration) error {
 	if config.Agent.Name == "" {
 		return fmt.Errorf("agent name is required")
 	}
+	if config.Agent.Chat.RequestBudget < 0 {
+		return fmt.Errorf("request budget must not be negative")
+	}
 	return nil
 }
 
+// validateTool checks the single tool of `agent.tool`. With `agent.tools` it is not exposed, so only validateTools
+// checks the fields that the tools inherit from it.
 func (cm *Manager) validateTool(config *Configuration) error {
+	if len(config.Agent.Tools) > 0 {
+		return nil
+	}
 	var errs []string
 	if config.Agent.Tool.Name == "" {
 		errs = append(errs, "Tool name is required")
+	} else if config.Agent.Tool.Name == EndSessionToolName {
+		errs = append(errs, fmt.Sprintf("Tool name `%s` is reserved", EndSessionToolName))
 	}
 	if config.Agent.Tool.Description == "" {
 		errs = append(errs, "Tool description is required")
@@ -135,9 +180,13 @@ func (cm *Manager) validateTool(config *Configuration) error {
 
 func (cm *Manager) validateLLM(config *Configuration) error {
 	var errs []string
-	if config.Agent.LLM.APIKey == "" {
+	// A replayed session never reaches the LLM provider, and the fake provider needs no key
+	if config.Agent.LLM.APIKey == "" && config.Runtime.Cassette.Mode != CassetteReplay && config.Agent.LLM.Provider != LLMProviderFake {
 		errs = append(errs, "LLM API key is required")
 	}
+	if config.Agent.LLM.Provider == LLMProviderFake && config.Agent.LLM.Script == "" {
+		errs = append(errs, "LLM script is required for the fake provider")
+	}
 	if config.Agent.LLM.Provider == "" {
 		errs = append(errs, "LLM provider is required")
 	}
@@ -153,8 +202,9 @@ func (cm *Manager) validateLLM(config *Configuration) error {
 	return nil
 }
 
+// validatePrompt checks the prompt template for the single tool; validateTools checks it for each of `agent.tools`.
 func (cm *Manager) validatePrompt(config *Configuration) error {
-	if config.Agent.LLM.PromptTemplate != "" {
+	if config.Agent.LLM.PromptTemplate != "" && len(config.Agent.Tools) == 0 {
 		err := cm.validatePromptTemplate(config.Agent.LLM.PromptTemplate, config.Agent.Tool.ArgumentName)
 		if err != nil {
 			return fmt.Errorf("invalid prompt template: %v", err)
@@ -163,6 +213,296 @@ func (cm *Manager) validatePrompt(config *Configuration) error {
 	return nil
 }
 
+func (cm *Manager) validateSessions(config *Configuration) error {
+	switch config.Agent.Sessions.Store {
+	case "", SessionStoreMemory:
+	case SessionStoreFile:
+		if config.Agent.Sessions.Dir == "" {
+			return fmt.Errorf("sessions dir is required for the `%s` store", SessionStoreFile)
+		}
+	default:
+		return fmt.Errorf("unknown sessions store `%s`", config.Agent.Sessions.Store)
+	}
+	if config.Agent.Sessions.TTL < 0 {
+		return fmt.Errorf("sessions ttl must not be negative")
+	}
+	if config.Agent.Sessions.Store != "" && config.Agent.Sessions.TTL == 0 {
+		// Each call without a session ID starts a session, so sessions that never expire would fill the store
+		return fmt.Errorf("sessions ttl must be positive for the `%s` store", config.Agent.Sessions.Store)
+	}
+	return nil
+}
+
+func (cm *Manager) validateApproval(config *Configuration) error {
+	var errs []string
+	for id, srv := range config.Agent.Connections.McpServers {
+		for tool, policy := range srv.Approval {
+			switch policy {
+			case ApprovalAuto, ApprovalRequire, ApprovalDeny:
+			default:
+				errs = append(errs, fmt.Sprintf("unknown approval policy `%s` for tool `%s` of server `%s`", policy, tool, id))
+			}
+		}
+	}
+	if config.Agent.Approval.Timeout < 0 {
+		errs = append(errs, "approval timeout must not be negative")
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (cm *Manager) validateCassette(config *Configuration) error {
+	cassette := config.Runtime.Cassette
+	switch cassette.Mode {
+	case "":
+		return nil
+	case CassetteRecord, CassetteReplay:
+	default:
+		return fmt.Errorf("unknown cassette mode `%s`", cassette.Mode)
+	}
+	if cassette.Path == "" {
+		return fmt.Errorf("cassette path is required in `%s` mode", cassette.Mode)
+	}
+	return nil
+}
+
+func (cm *Manager) validateTracing(config *Configuration) error {
+	tracing := config.GetTracingConfig()
+	switch tracing.Exporter {
+	case "":
+	case TracingOTLP:
+		if tracing.Endpoint == "" {
+			return fmt.Errorf("tracing endpoint is required for the `%s` exporter", tracing.Exporter)
+		}
+	case TracingFile:
+		if tracing.Path == "" {
+			return fmt.Errorf("tracing path is required for the `%s` exporter", tracing.Exporter)
+		}
+	case TracingStdout:
+		if config.Runtime.Transports.Stdio.Enabled {
+			return fmt.Errorf("the `%s` tracing exporter would corrupt the stdio transport, use the `%s` exporter instead", tracing.Exporter, TracingFile)
+		}
+	default:
+		return fmt.Errorf("unknown tracing exporter `%s`", tracing.Exporter)
+	}
+	return nil
+}
+
+func (cm *Manager) validateConnections(config *Configuration) error {
+	connections := config.Agent.Connections
+	switch connections.ToolNaming {
+	case "", ToolNamingPrefixed:
+	default:
+		return fmt.Errorf("unknown tool naming `%s`", connections.ToolNaming)
+	}
+	switch connections.OnToolCollision {
+	case "", ToolCollisionError, ToolCollisionWarn:
+	default:
+		return fmt.Errorf("unknown tool collision handling `%s`", connections.OnToolCollision)
+	}
+	if connections.HealthCheck.Interval < 0 || connections.HealthCheck.Timeout < 0 {
+		return fmt.Errorf("health check interval and timeout must not be negative")
+	}
+	var errs []string
+	for id, srv := range connections.McpServers {
+		switch srv.Transport {
+		case "":
+		case TransportStdio:
+			if srv.Command == "" {
+				errs = append(errs, fmt.Sprintf("server `%s` uses the `%s` transport but has no command", id, srv.Transport))
+			}
+		case TransportSSE, TransportStreamableHTTP:
+			if srv.URL == "" {
+				errs = append(errs, fmt.Sprintf("server `%s` uses the `%s` transport but has no url", id, srv.Transport))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("unknown transport `%s` of server `%s`", srv.Transport, id))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateHTTPTransports checks the TLS and authentication settings of the HTTP transports.
+func (cm *Manager) validateHTTPTransports(config *Configuration) error {
+	transports := config.Runtime.Transports
+	var errs []string
+	for _, t := range []struct {
+		name string
+		tls  TLSConfig
+		auth HTTPAuthConfig
+	}{
+		{"http", transports.HTTP.TLS, transports.HTTP.Auth},
+		{"streamableHttp", transports.StreamableHTTP.TLS, transports.StreamableHTTP.Auth},
+	} {
+		if (t.tls.CertFile == "") != (t.tls.KeyFile == "") {
+			errs = append(errs, fmt.Sprintf("transport `%s` needs both tls.certFile and tls.keyFile", t.name))
+		}
+		if t.tls.ClientCAFile != "" && t.tls.CertFile == "" {
+			errs = append(errs, fmt.Sprintf("transport `%s` needs a TLS certificate for tls.clientCAFile", t.name))
+		}
+		for client, token := range t.auth.Tokens {
+			if token == "" {
+				errs = append(errs, fmt.Sprintf("transport `%s` has an empty token for client `%s`", t.name, client))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateMetrics checks the port and path of the metrics endpoint. Collisions with the transports are
+// checked by the MCP server.
+func (cm *Manager) validateMetrics(config *Configuration) error {
+	metrics := config.GetMCPServerConfig().Metrics
+	if !metrics.Enabled {
+		return nil
+	}
+	if metrics.Port < 0 {
+		return fmt.Errorf("metrics port must not be negative")
+	}
+	if !strings.HasPrefix(metrics.Path, "/") {
+		return fmt.Errorf("metrics path `%s` must start with a slash", metrics.Path)
+	}
+	return nil
+}
+
+func (cm *Manager) validateLimits(config *Configuration) error {
+	limits := config.GetLimitsConfig()
+	if limits.MaxConcurrentSessions < 0 || limits.MaxQueuedSessions < 0 || limits.QueueTimeout < 0 {
+		return fmt.Errorf("limits maxConcurrentSessions, maxQueuedSessions and queueTimeout must not be negative")
+	}
+	var errs []string
+	check := func(name string, c ClientLimits) {
+		if c.RequestsPerMinute < 0 || c.Burst < 0 || c.DailySpend < 0 {
+			errs = append(errs, fmt.Sprintf("limits of %s must not be negative", name))
+		}
+	}
+	check("all clients", limits.ClientLimits)
+	for client, c := range limits.Clients {
+		check(fmt.Sprintf("client `%s`", client), c)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (cm *Manager) validateCompaction(config *Configuration) error {
+	compaction := config.Agent.Chat.Compaction
+	switch compaction.Strategy {
+	case "", CompactionNone, CompactionDropToolResults, CompactionTruncateToolResults, CompactionSummarize:
+	default:
+		return fmt.Errorf("unknown compaction strategy: %s", compaction.Strategy)
+	}
+	if compaction.KeepRecent < 0 {
+		return fmt.Errorf("compaction keepRecent must not be negative")
+	}
+	if compaction.MaxToolResultTokens < 0 {
+		return fmt.Errorf("compaction maxToolResultTokens must not be negative")
+	}
+	return nil
+}
+
+func (cm *Manager) validateOutputSchema(config *Configuration) error {
+	return checkOutputSchema(config.Agent.Tool.OutputSchema)
+}
+
+// validateTools checks the entries of `agent.tools` together with the settings they inherit.
+func (cm *Manager) validateTools(config *Configuration) error {
+	var errs []string
+	seen := make(map[string]bool)
+	for i, def := range config.Agent.Tools {
+		if def.Name == "" {
+			errs = append(errs, fmt.Sprintf("tools[%d]: name is required", i))
+			continue
+		}
+		if def.Name == EndSessionToolName {
+			errs = append(errs, fmt.Sprintf("tool `%s`: name is reserved", def.Name))
+		}
+		if seen[def.Name] {
+			errs = append(errs, fmt.Sprintf("tool `%s` is defined more than once", def.Name))
+		}
+		seen[def.Name] = true
+		if def.Description == "" {
+			errs = append(errs, fmt.Sprintf("tool `%s`: description is required", def.Name))
+		}
+		if def.MaxLLMIterations < 0 {
+			errs = append(errs, fmt.Sprintf("tool `%s`: maxLLMIterations must not be negative", def.Name))
+		}
+		if err := checkOutputSchema(def.OutputSchema); err != nil {
+			errs = append(errs, fmt.Sprintf("tool `%s`: %v", def.Name, err))
+		}
+		if err := checkInputSchema(def.InputSchema); err != nil {
+			errs = append(errs, fmt.Sprintf("tool `%s`: %v", def.Name, err))
+		}
+	}
+	if len(errs) == 0 && len(config.Agent.Tools) > 0 {
+		for _, agentConfig := range config.GetAgentConfigs() {
+			if agentConfig.Tool.ArgumentName == "" {
+				errs = append(errs, fmt.Sprintf("tool `%s`: argument name is required", agentConfig.Tool.Name))
+				continue
+			}
+			if err := cm.validatePromptTemplate(agentConfig.SystemPromptTemplate, agentConfig.Tool.ArgumentName); err != nil {
+				errs = append(errs, fmt.Sprintf("tool `%s`: invalid prompt template: %v", agentConfig.Tool.Name, err))
+			}
+			if _, ok := agentConfig.Tool.InputProperties()[agentConfig.Tool.ArgumentName]; ok {
+				errs = append(errs, fmt.Sprintf("tool `%s`: input schema declares the argument `%s` of argumentName", agentConfig.Tool.Name, agentConfig.Tool.ArgumentName))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// checkOutputSchema checks an output schema in any of the forms accepted by parseSchema.
+func checkOutputSchema(v any) error {
+	schema, err := parseSchema(v)
+	if err != nil || schema == nil {
+		return err
+	}
+	if schema["type"] != "object" {
+		return fmt.Errorf("output schema must have `type: object`, since it becomes the input schema of the finish tool")
+	}
+	if err := jsonschema.Check(schema); err != nil {
+		return fmt.Errorf("invalid output schema: %w", err)
+	}
+	return nil
+}
+
+// checkInputSchema checks an input schema in any of the forms accepted by parseSchema.
+func checkInputSchema(v any) error {
+	schema, err := parseSchema(v)
+	if err != nil || schema == nil {
+		return err
+	}
+	if schema["type"] != "object" {
+		return fmt.Errorf("input schema must have `type: object`, since its properties become arguments of the tool")
+	}
+	if err := jsonschema.Check(schema); err != nil {
+		return fmt.Errorf("invalid input schema: %w", err)
+	}
+	properties := MCPServerToolConfig{InputSchema: schema}.InputProperties()
+	for _, name := range reservedArgumentNames {
+		if _, ok := properties[name]; ok {
+			return fmt.Errorf("input schema declares the reserved argument `%s`", name)
+		}
+	}
+	return nil
+}
+
 func (cm *Manager) validatePromptTemplate(template string, argumentName string) error {
 	if strings.TrimSpace(template) == "" {
 		return fmt.Errorf("prompt template cannot be empty")
@@ -210,13 +550,43 @@ func RedactedCopy(config *Configuration) *Configuration {
 		for k, v := range cpy.Agent.Connections.McpServers {
 			redacted := v
 			redacted.APIKey = "***REDACTED***"
+			if len(v.Headers) > 0 {
+				redacted.Headers = make(map[string]string, len(v.Headers))
+				for name := range v.Headers {
+					redacted.Headers[name] = "***REDACTED***"
+				}
+			}
 			redactedServers[k] = redacted
 		}
 		cpy.Agent.Connections.McpServers = redactedServers
 	}
+	cpy.Runtime.Transports.HTTP.Auth = redactedAuth(cpy.Runtime.Transports.HTTP.Auth)
+	cpy.Runtime.Transports.StreamableHTTP.Auth = redactedAuth(cpy.Runtime.Transports.StreamableHTTP.Auth)
+	if len(cpy.Runtime.Tracing.Headers) > 0 {
+		headers := make(map[string]string, len(cpy.Runtime.Tracing.Headers))
+		for name := range cpy.Runtime.Tracing.Headers {
+			headers[name] = "***REDACTED***"
+		}
+		cpy.Runtime.Tracing.Headers = headers
+	}
 	return &cpy
 }
 
+// redactedAuth returns a copy of the authentication settings with the tokens and the HMAC secret masked.
+func redactedAuth(auth HTTPAuthConfig) HTTPAuthConfig {
+	if len(auth.Tokens) > 0 {
+		tokens := make(map[string]string, len(auth.Tokens))
+		for client := range auth.Tokens {
+			tokens[client] = "***REDACTED***"
+		}
+		auth.Tokens = tokens
+	}
+	if auth.HMACSecret != "" {
+		auth.HMACSecret = "***REDACTED***"
+	}
+	return auth
+}
+
 // GetAgentConfig returns the business AgentConfig structure based on rawConfig
 // While rawConfig is not filled by loaders, use cm.config for backward compatibility
 func (cm *Manager) GetAgentConfig() AgentConfig {
@@ -224,18 +594,7 @@ func (cm *Manager) GetAgentConfig() AgentConfig {
 	if cm.config == nil {
 		return AgentConfig{}
 	}
-	return AgentConfig{
-		Tool: MCPServerToolConfig{
-			Name:                cm.config.Agent.Tool.Name,
-			Description:         cm.config.Agent.Tool.Description,
-			ArgumentName:        cm.config.Agent.Tool.ArgumentName,
-			ArgumentDescription: cm.config.Agent.Tool.ArgumentDescription,
-		},
-		Model:                cm.config.Agent.LLM.Model,
-		SystemPromptTemplate: cm.config.Agent.LLM.PromptTemplate,
-		MaxTokens:            cm.config.Agent.Chat.MaxTokens,
-		MaxLLMIterations:     cm.config.Agent.Chat.MaxLLMIterations,
-	}
+	return cm.config.GetAgentConfig()
 }
 
 // getDefaultConfigMap returns default values for configuration as map[string]interface{}
@@ -256,8 +615,47 @@ func getDefaultConfigMap() map[string]interface{} {
 					"enabled": false,
 					"host":    "localhost",
 					"port":    3000,
+					"path":    "",
+				},
+				"streamableHttp": map[string]interface{}{
+					"enabled": false,
+					"host":    "localhost",
+					"port":    3000,
+					"path":    "/mcp",
 				},
 			},
+			"cassette": map[string]interface{}{
+				"mode":   "",
+				"path":   "",
+				"strict": false,
+			},
+			"limits": map[string]interface{}{
+				"maxConcurrentSessions": 0,
+				"maxQueuedSessions":     0,
+				"queueTimeout":          30.0,
+				"requestsPerMinute":     0.0,
+				"burst":                 0,
+				"dailySpend":            0.0,
+				"ledgerFile":            "",
+			},
+			"api": map[string]interface{}{
+				"enabled": false,
+			},
+			"openai": map[string]interface{}{
+				"enabled": false,
+			},
+			"metrics": map[string]interface{}{
+				"enabled": false,
+				"host":    "localhost",
+				"port":    0,
+				"path":    "/metrics",
+			},
+			"tracing": map[string]interface{}{
+				"exporter":    "",
+				"endpoint":    "http://localhost:4318",
+				"path":        "",
+				"serviceName": "",
+			},
 		},
 		"agent": map[string]interface{}{
 			"name":    "speelka-agent",
@@ -272,6 +670,24 @@ func getDefaultConfigMap() map[string]interface{} {
 				"maxTokens":        8192,
 				"maxLLMIterations": 100,
 				"requestBudget":    1.0,
+				"toolCalls": map[string]interface{}{
+					"maxParallel":          4,
+					"maxParallelPerServer": 2,
+				},
+				"compaction": map[string]interface{}{
+					"strategy":            "",
+					"keepRecent":          4,
+					"maxToolResultTokens": 2000,
+				},
+			},
+			"sessions": map[string]interface{}{
+				"store": "",
+				"dir":   "",
+				"ttl":   3600.0,
+			},
+			"approval": map[string]interface{}{
+				"command": "",
+				"timeout": 300.0,
 			},
 			"llm": map[string]interface{}{
 				"provider":       "openai",
@@ -293,7 +709,13 @@ func getDefaultConfigMap() map[string]interface{} {
 					"maxBackoff":        30.0,
 					"backoffMultiplier": 2.0,
 				},
-				"mcpServers": map[string]interface{}{},
+				"mcpServers":      map[string]interface{}{},
+				"toolNaming":      "",
+				"onToolCollision": ToolCollisionError,
+				"healthCheck": map[string]interface{}{
+					"interval": 30.0,
+					"timeout":  5.0,
+				},
 			},
 		},
 	}
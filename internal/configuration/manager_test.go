@@ -2,8 +2,10 @@ package configuration
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,6 +27,9 @@ func TestManager_LoadConfiguration_Defaults(t *testing.T) {
 	if cfg.Runtime.Log.DefaultLevel != "info" {
 		t.Errorf("expected default log level, got %s", cfg.Runtime.Log.DefaultLevel)
 	}
+	if cfg.Agent.Chat.Compaction.Strategy != "" {
+		t.Errorf("expected compaction to be off by default, got %s", cfg.Agent.Chat.Compaction.Strategy)
+	}
 }
 
 func TestManager_LoadConfiguration_YAMLFile(t *testing.T) {
@@ -103,6 +108,27 @@ func TestManager_ValidateConfiguration(t *testing.T) {
 	assert.Contains(t, err.Error(), "agent name is required")
 }
 
+func TestManager_ValidateConfiguration_ToolsOnly(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Agent.Name = "TestAgent"
+	cfg.Agent.LLM.Provider = "openai"
+	cfg.Agent.LLM.Model = "gpt-4"
+	cfg.Agent.LLM.APIKey = "test-api-key"
+	cfg.Agent.LLM.PromptTemplate = "Answer {{text}}. Tools: {{tools}}"
+	cfg.Agent.Tools = []ToolDefinition{
+		{Name: "summarize", Description: "Summarize", ArgumentName: "text"},
+		{Name: "classify", Description: "Classify", ArgumentName: "ticket", PromptTemplate: "Classify {{ticket}}. Tools: {{tools}}"},
+	}
+	mgr.config = cfg
+	assert.NoError(t, mgr.Validate(), "agent.tool is not needed with agent.tools")
+
+	cfg.Agent.Tools[0].ArgumentName = ""
+	err := mgr.Validate()
+	assert.ErrorContains(t, err, "tool `summarize`: argument name is required")
+	assert.NotContains(t, err.Error(), "Tool name is required")
+}
+
 func TestRedactedCopy(t *testing.T) {
 	orig := &Configuration{}
 	orig.Agent.LLM.APIKey = "super-secret-llm-key"
@@ -160,6 +186,8 @@ func TestManager_GetAgentConfig_InlineStruct(t *testing.T) {
 	assert.Equal(t, "gpt-4", agentCfg.Model)
 	assert.Equal(t, 8192, agentCfg.MaxTokens)
 	assert.Equal(t, 100, agentCfg.MaxLLMIterations)
+	assert.Equal(t, 4, agentCfg.ToolCalls.MaxParallel)
+	assert.Equal(t, 2, agentCfg.ToolCalls.MaxParallelPerServer)
 }
 
 func TestManager_FullConfig_Parse_YAML_JSON_Env(t *testing.T) {
@@ -180,6 +208,9 @@ agent:
     maxTokens: 111
     maxLLMIterations: 222
     requestBudget: 1.23
+    toolCalls:
+      maxParallel: 6
+      maxParallelPerServer: 3
   llm:
     provider: "openai"
     model: "yaml-model"
@@ -195,6 +226,7 @@ agent:
       test:
         url: "http://yaml-server"
         apiKey: "yaml-server-key"
+        sequential: true
     retry:
       maxRetries: 5
       initialBackoff: 2.2
@@ -255,6 +287,9 @@ runtime:
 		assert.Equal(t, 111, cfg.Agent.Chat.MaxTokens)
 		assert.Equal(t, 222, cfg.Agent.Chat.MaxLLMIterations)
 		assert.Equal(t, 1.23, cfg.Agent.Chat.RequestBudget)
+		assert.Equal(t, 6, cfg.Agent.Chat.ToolCalls.MaxParallel)
+		assert.Equal(t, 3, cfg.Agent.Chat.ToolCalls.MaxParallelPerServer)
+		assert.True(t, cfg.Agent.Connections.McpServers["test"].Sequential)
 		assert.Equal(t, "desc from yaml", cfg.Agent.Tool.Description)
 		assert.Equal(t, "desc arg", cfg.Agent.Tool.ArgumentDescription)
 		assert.Equal(t, "YAML template {{input}}", cfg.Agent.LLM.PromptTemplate)
@@ -326,3 +361,454 @@ func TestEnvKeyToPath(t *testing.T) {
 		}
 	}
 }
+
+func TestManager_ValidateSessions(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	assert.NoError(t, mgr.validateSessions(cfg))
+	cfg.Agent.Sessions.Store = "memory"
+	assert.Error(t, mgr.validateSessions(cfg), "sessions must expire")
+	cfg.Agent.Sessions.TTL = 3600
+	assert.NoError(t, mgr.validateSessions(cfg))
+	cfg.Agent.Sessions.Store = "file"
+	assert.Error(t, mgr.validateSessions(cfg), "file store requires dir")
+	cfg.Agent.Sessions.Dir = "/tmp/sessions"
+	assert.NoError(t, mgr.validateSessions(cfg))
+	cfg.Agent.Sessions.Store = "redis"
+	assert.Error(t, mgr.validateSessions(cfg))
+	cfg.Agent.Sessions.Store = "memory"
+	cfg.Agent.Sessions.TTL = -1
+	assert.Error(t, mgr.validateSessions(cfg))
+}
+
+func TestManager_ValidateApproval(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Agent.Connections.McpServers = map[string]MCPServerConnection{
+		"fs": {Command: "fs", Approval: map[string]string{"read_file": "auto", "write_file": "require_approval", "*": "deny"}},
+	}
+	assert.NoError(t, mgr.validateApproval(cfg))
+	cfg.Agent.Approval.Timeout = -1
+	assert.Error(t, mgr.validateApproval(cfg))
+	cfg.Agent.Approval.Timeout = 10
+	cfg.Agent.Connections.McpServers["fs"].Approval["delete_file"] = "ask"
+	err := mgr.validateApproval(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown approval policy `ask` for tool `delete_file` of server `fs`")
+}
+
+func TestManager_LoadConfiguration_Approval(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "testconfig-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	yamlContent := []byte(`
+agent:
+  approval:
+    command: "approve.sh"
+  connections:
+    mcpServers:
+      fs:
+        command: "fs"
+        approval:
+          read_file: auto
+          "*": require_approval
+`)
+	if _, err := tmpfile.Write(yamlContent); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	mgr := NewConfigurationManager()
+	if err := mgr.LoadConfiguration(context.Background(), tmpfile.Name()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := mgr.GetConfiguration()
+	policies := cfg.GetAgentConfig().Approval
+	assert.Equal(t, ApprovalAuto, policies.Policy("fs", "read_file"))
+	assert.Equal(t, ApprovalRequire, policies.Policy("fs", "write_file"))
+	hook := cfg.GetApprovalHookConfig()
+	assert.Equal(t, "approve.sh", hook.Command)
+	assert.Equal(t, 300*time.Second, hook.Timeout)
+}
+
+func TestManager_ValidateCompaction(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	assert.NoError(t, mgr.validateCompaction(cfg))
+	cfg.Agent.Chat.Compaction.Strategy = CompactionSummarize
+	assert.NoError(t, mgr.validateCompaction(cfg))
+	cfg.Agent.Chat.Compaction.KeepRecent = -1
+	assert.Error(t, mgr.validateCompaction(cfg))
+	cfg.Agent.Chat.Compaction.KeepRecent = 4
+	cfg.Agent.Chat.Compaction.Strategy = "forget_everything"
+	assert.Error(t, mgr.validateCompaction(cfg))
+}
+
+func TestManager_ValidateOutputSchema(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	assert.NoError(t, mgr.validateOutputSchema(cfg))
+	cfg.Agent.Tool.OutputSchema = map[string]any{"type": "object", "properties": map[string]any{"title": map[string]any{"type": "string"}}}
+	assert.NoError(t, mgr.validateOutputSchema(cfg))
+	cfg.Agent.Tool.OutputSchema = `{"type":"object","required":["title"]}`
+	assert.NoError(t, mgr.validateOutputSchema(cfg))
+	cfg.Agent.Tool.OutputSchema = `{"type":"array"}`
+	assert.Error(t, mgr.validateOutputSchema(cfg), "the finish tool needs an object schema")
+	cfg.Agent.Tool.OutputSchema = `{"type":`
+	assert.Error(t, mgr.validateOutputSchema(cfg))
+	cfg.Agent.Tool.OutputSchema = map[string]any{"type": "object", "required": "title"}
+	assert.Error(t, mgr.validateOutputSchema(cfg))
+}
+
+func TestManager_LoadConfiguration_OutputSchema(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "testconfig-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	yamlContent := []byte(`
+agent:
+  tool:
+    outputSchema:
+      type: object
+      properties:
+        title:
+          type: string
+        score:
+          type: integer
+          maximum: 10
+      required: [title]
+`)
+	if _, err := tmpfile.Write(yamlContent); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	mgr := NewConfigurationManager()
+	if err := mgr.LoadConfiguration(context.Background(), tmpfile.Name()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	schema := mgr.GetConfiguration().GetAgentConfig().Tool.OutputSchema
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, []any{"title"}, schema["required"])
+	assert.Contains(t, schema["properties"], "score")
+	assert.Equal(t, schema, mgr.GetConfiguration().GetMCPServerConfig().Tool.OutputSchema)
+
+	t.Setenv("SPL_AGENT_TOOL_OUTPUTSCHEMA", `{"type":"object","properties":{"summary":{"type":"string"}}}`)
+	if err := mgr.LoadConfiguration(context.Background(), ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	schema = mgr.GetConfiguration().GetAgentConfig().Tool.OutputSchema
+	assert.Contains(t, schema["properties"], "summary")
+}
+
+func TestManager_LoadConfiguration_Tools(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "testconfig-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	yamlContent := []byte(`
+agent:
+  llm:
+    model: "gpt-4o"
+    promptTemplate: "Help with {{input}}. Tools: {{tools}}"
+  tools:
+    - name: summarize
+      description: Summarize a document
+      allowedTools: [read_file]
+    - name: classify
+      description: Classify a ticket
+      argumentName: ticket
+      argumentDescription: The ticket text
+      promptTemplate: "Classify {{ticket}} for {{team}}"
+      model: gpt-4o-mini
+      maxLLMIterations: 3
+      inputSchema:
+        type: object
+        properties:
+          team: { type: string }
+        required: [team]
+`)
+	if _, err := tmpfile.Write(yamlContent); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	mgr := NewConfigurationManager()
+	if err := mgr.LoadConfiguration(context.Background(), tmpfile.Name()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := mgr.GetConfiguration()
+	configs := cfg.GetAgentConfigs()
+	if assert.Len(t, configs, 2) {
+		summarize, classify := configs[0], configs[1]
+		assert.Equal(t, "summarize", summarize.Tool.Name)
+		assert.Equal(t, "input", summarize.Tool.ArgumentName, "inherited from agent.tool")
+		assert.Equal(t, "gpt-4o", summarize.Model)
+		assert.Equal(t, 100, summarize.MaxLLMIterations)
+		assert.Equal(t, []string{"read_file"}, summarize.AllowedTools)
+		assert.True(t, summarize.IsToolAllowed("read_file"))
+		assert.False(t, summarize.IsToolAllowed("write_file"))
+
+		assert.Equal(t, "ticket", classify.Tool.ArgumentName)
+		assert.Equal(t, "Classify {{ticket}} for {{team}}", classify.SystemPromptTemplate)
+		assert.Equal(t, map[string]any{"team": map[string]any{"type": "string"}}, classify.Tool.InputProperties())
+		assert.Nil(t, summarize.Tool.InputSchema)
+		assert.Equal(t, "gpt-4o-mini", classify.Model)
+		assert.Equal(t, 3, classify.MaxLLMIterations)
+		assert.True(t, classify.IsToolAllowed("write_file"), "no allowedTools means all tools")
+	}
+	serverCfg := cfg.GetMCPServerConfig()
+	assert.Len(t, serverCfg.MainTools(), 2)
+	assert.Equal(t, "classify", serverCfg.MainTools()[1].Name)
+}
+
+func TestManager_ValidateTools(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Agent.Tool.ArgumentName = "input"
+	cfg.Agent.LLM.PromptTemplate = "Answer {{input}}"
+	assert.NoError(t, mgr.validateTools(cfg))
+
+	cfg.Agent.Tools = []ToolDefinition{
+		{Name: "summarize", Description: "Summarize"},
+		{Name: "classify", Description: "Classify", ArgumentName: "ticket", PromptTemplate: "Classify {{ticket}}"},
+	}
+	assert.NoError(t, mgr.validateTools(cfg))
+
+	cfg.Agent.Tools[1].ArgumentName = "text"
+	err := mgr.validateTools(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tool `classify`: invalid prompt template")
+
+	cfg.Agent.Tools = []ToolDefinition{
+		{Name: "summarize", Description: "Summarize"},
+		{Name: "summarize"},
+		{Description: "Unnamed"},
+		{Name: "extract", Description: "Extract", MaxLLMIterations: -1, OutputSchema: `{"type":"string"}`},
+		{Name: "end_session", Description: "End"},
+		{Name: "tag", Description: "Tag", InputSchema: map[string]any{"type": "object", "properties": map[string]any{"budget": map[string]any{"type": "number"}}}},
+		{Name: "rank", Description: "Rank", InputSchema: map[string]any{"type": "array"}},
+	}
+	err = mgr.validateTools(cfg)
+	assert.Error(t, err)
+	for _, want := range []string{
+		"tool `summarize` is defined more than once",
+		"tool `summarize`: description is required",
+		"tools[2]: name is required",
+		"tool `extract`: maxLLMIterations must not be negative",
+		"tool `extract`: output schema must have `type: object`",
+		"tool `end_session`: name is reserved",
+		"tool `tag`: input schema declares the reserved argument `budget`",
+		"tool `rank`: input schema must have `type: object`",
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	cfg.Agent.Tools = []ToolDefinition{
+		{Name: "classify", Description: "Classify", InputSchema: map[string]any{"type": "object", "properties": map[string]any{"input": map[string]any{}}}},
+	}
+	assert.ErrorContains(t, mgr.validateTools(cfg), "input schema declares the reserved argument `input`")
+	cfg.Agent.Tools[0].ArgumentName = "ticket"
+	cfg.Agent.Tools[0].PromptTemplate = "Classify {{ticket}}"
+	cfg.Agent.Tools[0].InputSchema = map[string]any{"type": "object", "properties": map[string]any{"ticket": map[string]any{}}}
+	assert.ErrorContains(t, mgr.validateTools(cfg), "tool `classify`: input schema declares the argument `ticket` of argumentName")
+}
+
+func TestManager_ValidateCassette(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	assert.NoError(t, mgr.validateCassette(cfg))
+	cfg.Runtime.Cassette.Mode = "record"
+	assert.Error(t, mgr.validateCassette(cfg), "record mode requires path")
+	cfg.Runtime.Cassette.Path = "session.cassette.json"
+	assert.NoError(t, mgr.validateCassette(cfg))
+	cfg.Runtime.Cassette.Mode = "replay"
+	assert.NoError(t, mgr.validateCassette(cfg))
+	cfg.Runtime.Cassette.Mode = "rewind"
+	assert.Error(t, mgr.validateCassette(cfg))
+}
+
+func TestManager_ValidateLLM_ReplayWithoutAPIKey(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Agent.LLM.Provider = "openai"
+	cfg.Agent.LLM.Model = "gpt-4"
+	cfg.Agent.LLM.PromptTemplate = "{{input}} {{tools}}"
+	assert.Error(t, mgr.validateLLM(cfg))
+	cfg.Runtime.Cassette.Mode = "replay"
+	assert.NoError(t, mgr.validateLLM(cfg))
+}
+
+func TestManager_ValidateLLM_Fake(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Agent.LLM.Provider = LLMProviderFake
+	cfg.Agent.LLM.Model = "gpt-4o-mini"
+	cfg.Agent.LLM.PromptTemplate = "{{input}} {{tools}}"
+	assert.EqualError(t, mgr.validateLLM(cfg), "LLM script is required for the fake provider")
+	cfg.Agent.LLM.Script = "script.yaml"
+	assert.NoError(t, mgr.validateLLM(cfg), "no API key is needed")
+}
+
+func TestMCPConnectorConfig_ToolPrefix(t *testing.T) {
+	cfg := MCPConnectorConfig{McpServers: map[string]MCPServerConnection{"fs": {ToolPrefix: "local_"}, "github": {}}}
+	assert.Equal(t, "local_", cfg.ToolPrefix("fs"))
+	assert.Equal(t, "", cfg.ToolPrefix("github"))
+	cfg.ToolNaming = ToolNamingPrefixed
+	assert.Equal(t, "local_", cfg.ToolPrefix("fs"))
+	assert.Equal(t, "github__", cfg.ToolPrefix("github"))
+	assert.Equal(t, map[string]string{"fs": "local_", "github": "github__"}, cfg.ToolPrefixes())
+
+	approval := ApprovalConfig{
+		Servers:      map[string]map[string]string{"github": {"delete_repo": ApprovalDeny}},
+		ToolPrefixes: cfg.ToolPrefixes(),
+	}
+	assert.Equal(t, ApprovalDeny, approval.Policy("github", "github__delete_repo"))
+	assert.Equal(t, ApprovalAuto, approval.Policy("github", "github__search"))
+}
+
+func TestManager_ValidateConnections(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	assert.NoError(t, mgr.validateConnections(cfg))
+	cfg.Agent.Connections.ToolNaming = "prefixed"
+	cfg.Agent.Connections.OnToolCollision = "warn"
+	assert.NoError(t, mgr.validateConnections(cfg))
+	cfg.Agent.Connections.ToolNaming = "dotted"
+	assert.Error(t, mgr.validateConnections(cfg))
+	cfg.Agent.Connections.ToolNaming = ""
+	cfg.Agent.Connections.OnToolCollision = "ignore"
+	assert.Error(t, mgr.validateConnections(cfg))
+	cfg.Agent.Connections.OnToolCollision = ""
+	cfg.Agent.Connections.HealthCheck.Interval = -1
+	assert.Error(t, mgr.validateConnections(cfg))
+}
+
+func TestManager_ValidateConnections_Transport(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Agent.Connections.McpServers = map[string]MCPServerConnection{
+		"auto":  {URL: "http://localhost/mcp"},
+		"http":  {Transport: TransportStreamableHTTP, URL: "http://localhost/mcp"},
+		"sse":   {Transport: TransportSSE, URL: "http://localhost/sse"},
+		"stdio": {Transport: TransportStdio, Command: "server"},
+	}
+	assert.NoError(t, mgr.validateConnections(cfg))
+
+	cfg.Agent.Connections.McpServers["bad"] = MCPServerConnection{Transport: "websocket", URL: "ws://localhost"}
+	cfg.Agent.Connections.McpServers["nourl"] = MCPServerConnection{Transport: TransportStreamableHTTP, Command: "server"}
+	err := mgr.validateConnections(cfg)
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Contains(t, err.Error(), "unknown transport `websocket` of server `bad`")
+	assert.Contains(t, err.Error(), "server `nourl` uses the `streamable-http` transport but has no url")
+}
+
+func TestRedactedCopy_Headers(t *testing.T) {
+	orig := &Configuration{}
+	orig.Agent.Connections.McpServers = map[string]MCPServerConnection{
+		"srv": {URL: "http://srv", Headers: map[string]string{"X-Api-Token": "secret"}},
+	}
+	redacted := RedactedCopy(orig)
+	assert.Equal(t, "***REDACTED***", redacted.Agent.Connections.McpServers["srv"].Headers["X-Api-Token"])
+	assert.Equal(t, "secret", orig.Agent.Connections.McpServers["srv"].Headers["X-Api-Token"], "the original is not changed")
+}
+
+func TestManager_ValidateHTTPTransports(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Runtime.Transports.HTTP.TLS = TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}
+	cfg.Runtime.Transports.HTTP.Auth = HTTPAuthConfig{Tokens: map[string]string{"alice": "secret"}}
+	assert.NoError(t, mgr.validateHTTPTransports(cfg))
+
+	cfg.Runtime.Transports.StreamableHTTP.TLS = TLSConfig{KeyFile: "key.pem", ClientCAFile: "ca.pem"}
+	cfg.Runtime.Transports.StreamableHTTP.Auth = HTTPAuthConfig{Tokens: map[string]string{"bob": ""}}
+	err := mgr.validateHTTPTransports(cfg)
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Contains(t, err.Error(), "transport `streamableHttp` needs both tls.certFile and tls.keyFile")
+	assert.Contains(t, err.Error(), "transport `streamableHttp` needs a TLS certificate for tls.clientCAFile")
+	assert.Contains(t, err.Error(), "transport `streamableHttp` has an empty token for client `bob`")
+}
+
+func TestRedactedCopy_HTTPAuth(t *testing.T) {
+	orig := &Configuration{}
+	orig.Runtime.Transports.HTTP.Auth = HTTPAuthConfig{Tokens: map[string]string{"alice": "secret"}, HMACSecret: "shared"}
+	redacted := RedactedCopy(orig)
+	assert.Equal(t, "***REDACTED***", redacted.Runtime.Transports.HTTP.Auth.Tokens["alice"])
+	assert.Equal(t, "***REDACTED***", redacted.Runtime.Transports.HTTP.Auth.HMACSecret)
+	assert.Equal(t, "secret", orig.Runtime.Transports.HTTP.Auth.Tokens["alice"], "the original is not changed")
+	assert.NotContains(t, fmt.Sprintf("%+v", orig.Runtime.Transports.HTTP.Auth), "secret", "logging does not leak secrets")
+}
+
+func TestManager_ValidateMetrics(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Runtime.Metrics.Path = "metrics"
+	assert.NoError(t, mgr.validateMetrics(cfg), "disabled metrics are not checked")
+
+	cfg.Runtime.Metrics.Enabled = true
+	err := mgr.validateMetrics(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "metrics path `metrics` must start with a slash")
+	}
+	cfg.Runtime.Metrics.Path = "/metrics"
+	cfg.Runtime.Metrics.Port = -1
+	err = mgr.validateMetrics(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "metrics port must not be negative")
+	}
+	cfg.Runtime.Metrics.Port = 9090
+	assert.NoError(t, mgr.validateMetrics(cfg))
+}
+
+func TestManager_ValidateLimits(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	cfg.Runtime.Limits.MaxConcurrentSessions = 4
+	cfg.Runtime.Limits.RequestsPerMinute = 10
+	cfg.Runtime.Limits.Clients = map[string]ClientLimits{"ci": {DailySpend: 5}}
+	assert.NoError(t, mgr.validateLimits(cfg))
+
+	cfg.Runtime.Limits.Clients["bad"] = ClientLimits{Burst: -1}
+	err := mgr.validateLimits(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "limits of client `bad` must not be negative")
+	}
+
+	cfg.Runtime.Limits.QueueTimeout = -1
+	assert.Error(t, mgr.validateLimits(cfg))
+}
+
+func TestManager_ValidateTracing(t *testing.T) {
+	mgr := NewConfigurationManager()
+	cfg := &Configuration{}
+	assert.NoError(t, mgr.validateTracing(cfg), "tracing is disabled by default")
+
+	cfg.Runtime.Tracing.Exporter = TracingOTLP
+	cfg.Runtime.Tracing.Endpoint = "http://localhost:4318"
+	assert.NoError(t, mgr.validateTracing(cfg))
+
+	cfg.Runtime.Tracing.Exporter = TracingFile
+	err := mgr.validateTracing(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "tracing path is required for the `file` exporter")
+	}
+
+	cfg.Runtime.Tracing.Exporter = TracingStdout
+	assert.NoError(t, mgr.validateTracing(cfg))
+	cfg.Runtime.Transports.Stdio.Enabled = true
+	err = mgr.validateTracing(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "would corrupt the stdio transport")
+	}
+
+	cfg.Runtime.Tracing.Exporter = "jaeger"
+	assert.Error(t, mgr.validateTracing(cfg))
+}
@@ -1,5 +1,13 @@
 package configuration
 
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
 // ParameterSpec represents the specification of a parameter.
 type ParameterSpec struct {
 	// Type is the data type of the parameter.
@@ -31,6 +39,97 @@ type HTTPConfig struct {
 
 	// Port is the port to listen on.
 	Port int
+
+	// Path is where the transport is served: the endpoint of Streamable HTTP,
+	// or the prefix of the `/sse` and `/message` endpoints of SSE.
+	Path string
+
+	// TLS enables HTTPS when a certificate is set.
+	TLS TLSConfig
+
+	// Auth lists the accepted credentials. Without any, requests are not authenticated.
+	Auth HTTPAuthConfig
+}
+
+// TLSConfig represents the TLS settings of an HTTP listener.
+// Responsibility: Storing the certificate files of the listener
+// Features: A client CA turns on mutual TLS: clients must present a certificate signed by it
+type TLSConfig struct {
+	// CertFile is the PEM certificate (chain) of the server.
+	CertFile string `koanf:"certfile" json:"certFile,omitempty" yaml:"certFile,omitempty"`
+
+	// KeyFile is the PEM private key of the certificate.
+	KeyFile string `koanf:"keyfile" json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+
+	// ClientCAFile is the PEM bundle of the CAs that sign client certificates. Empty disables mutual TLS.
+	ClientCAFile string `koanf:"clientcafile" json:"clientCAFile,omitempty" yaml:"clientCAFile,omitempty"`
+}
+
+// Enabled reports whether the listener serves HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// HTTPAuthConfig represents the credentials accepted by an HTTP transport.
+// Responsibility: Storing the settings of each authentication scheme
+// Features: Schemes can be combined; a request is accepted if any of them accepts it
+type HTTPAuthConfig struct {
+	// Tokens maps client names to static bearer tokens.
+	Tokens map[string]string `koanf:"tokens" json:"tokens,omitempty" yaml:"tokens,omitempty"`
+
+	// HMACSecret accepts bearer tokens `<client>.<unix expiry>.<hex HMAC-SHA256 of "<client>.<unix expiry>">` signed with it.
+	HMACSecret string `koanf:"hmacsecret" json:"hmacSecret,omitempty" yaml:"hmacSecret,omitempty"`
+
+	// HtpasswdFile accepts HTTP Basic credentials of the users in the file (bcrypt or {SHA} hashes).
+	HtpasswdFile string `koanf:"htpasswdfile" json:"htpasswdFile,omitempty" yaml:"htpasswdFile,omitempty"`
+}
+
+// Enabled reports whether requests must be authenticated.
+func (c HTTPAuthConfig) Enabled() bool {
+	return len(c.Tokens) > 0 || c.HMACSecret != "" || c.HtpasswdFile != ""
+}
+
+// String describes the configuration without its secrets, so it can be logged.
+func (c HTTPAuthConfig) String() string {
+	clients := make([]string, 0, len(c.Tokens))
+	for client := range c.Tokens {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	return fmt.Sprintf("{TokenClients:%v HMAC:%t HtpasswdFile:%s}", clients, c.HMACSecret != "", c.HtpasswdFile)
+}
+
+// Addr returns the host:port to listen on.
+func (c HTTPConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// MetricsConfig represents the configuration for the Prometheus metrics endpoint.
+// Responsibility: Storing where the metrics are served
+// Features: Port 0 serves the metrics on the Streamable HTTP or HTTP transport behind its authentication,
+// any other port on a separate plain HTTP admin listener, which also works in stdio mode
+type MetricsConfig struct {
+	// Enabled determines if the metrics endpoint is served.
+	Enabled bool
+
+	// Host is the host of the admin listener.
+	Host string
+
+	// Port is the port of the admin listener, 0 to serve the metrics on an HTTP transport.
+	Port int
+
+	// Path is the path of the metrics endpoint.
+	Path string
+}
+
+// Addr returns the address of the admin listener.
+func (c MetricsConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// AdminListener reports whether the metrics are served on their own listener.
+func (c MetricsConfig) AdminListener() bool {
+	return c.Enabled && c.Port != 0
 }
 
 // StdioConfig represents the configuration for stdio transport.
@@ -55,20 +154,52 @@ type MCPServerConfig struct {
 	// Version is the version string of the server.
 	Version string
 
-	// HTTP contains configuration for HTTP transport.
+	// HTTP contains configuration for the HTTP SSE transport.
 	HTTP HTTPConfig
 
+	// StreamableHTTP contains configuration for the Streamable HTTP transport.
+	// It may share the host and port of HTTP if the paths differ.
+	StreamableHTTP HTTPConfig
+
 	// Stdio contains configuration for stdio transport.
 	Stdio StdioConfig
 
-	// Tools is a list of tools available on this server.
+	// Metrics contains configuration for the Prometheus metrics endpoint.
+	Metrics MetricsConfig
+
+	// APIEnabled determines if the REST API is served next to MCP on the Streamable HTTP or HTTP transport.
+	APIEnabled bool
+
+	// OpenAIEnabled determines if the OpenAI-compatible chat completions API is served next to the REST API.
+	OpenAIEnabled bool
+
+	// Tool is the main tool of the agent.
 	Tool MCPServerToolConfig
 
+	// Tools, if not empty, are the main tools served instead of Tool, each backed by its own agent.
+	Tools []MCPServerToolConfig
+
 	// Debug determines if debug mode is enabled.
 	Debug bool
 
 	// MCPLogEnabled determines if MCP logging is enabled.
 	MCPLogEnabled bool
+
+	// SessionsEnabled determines if the main tool accepts a session ID and the end session tool is exposed.
+	SessionsEnabled bool
+}
+
+// APIsEnabled reports whether any HTTP API is served next to MCP.
+func (c MCPServerConfig) APIsEnabled() bool {
+	return c.APIEnabled || c.OpenAIEnabled
+}
+
+// MainTools returns the tools that run the agent: Tools, or Tool if the list is empty.
+func (c MCPServerConfig) MainTools() []MCPServerToolConfig {
+	if len(c.Tools) > 0 {
+		return c.Tools
+	}
+	return []MCPServerToolConfig{c.Tool}
 }
 
 type MCPServerToolConfig struct {
@@ -80,6 +211,16 @@ type MCPServerToolConfig struct {
 	ArgumentName string
 	// ArgumentDescription is the description of the argument for the tool.
 	ArgumentDescription string
+	// InputSchema is the JSON Schema of the arguments taken next to ArgumentName. Nil means there are none.
+	InputSchema map[string]any
+	// OutputSchema is the JSON Schema of the structured final answer. Nil means a free-text answer.
+	OutputSchema map[string]any
+}
+
+// InputProperties returns the properties of the input schema: the arguments taken next to ArgumentName.
+func (t MCPServerToolConfig) InputProperties() map[string]any {
+	properties, _ := t.InputSchema["properties"].(map[string]any)
+	return properties
 }
 
 // MCPConnectorConfig represents the configuration for the MCP connector.
@@ -92,6 +233,69 @@ type MCPConnectorConfig struct {
 
 	// RetryConfig is the configuration for retrying failed connections.
 	RetryConfig RetryConfig
+
+	// ToolNaming is ToolNamingPrefixed to expose every tool as `<server ID>__<tool>`, or empty to keep the names
+	// servers export. A server's ToolPrefix takes precedence in both modes.
+	ToolNaming string
+
+	// OnToolCollision is ToolCollisionError or ToolCollisionWarn: what to do when two servers expose the same tool name.
+	OnToolCollision string
+
+	// HealthCheck configures the supervision of connected servers.
+	HealthCheck HealthCheckConfig
+}
+
+// HealthCheckConfig represents the configuration for supervising MCP servers.
+// Responsibility: Storing how often servers are pinged and how long to wait for an answer
+// Features: A zero interval disables periodic checks; failed tool calls and calls to an unhealthy server
+// still trigger a check, and unreachable servers are retried with a backoff
+type HealthCheckConfig struct {
+	// Interval is the time between pings of each server.
+	Interval time.Duration
+
+	// Timeout is the time to wait for a ping response.
+	Timeout time.Duration
+}
+
+const (
+	// ToolNamingPrefixed exposes tools as `<server ID>__<tool>`.
+	ToolNamingPrefixed = "prefixed"
+	// ToolNameSeparator joins the server ID and the tool name in ToolNamingPrefixed mode.
+	// LLM providers only allow letters, digits, `_` and `-` in tool names.
+	ToolNameSeparator = "__"
+	// ToolCollisionError fails the startup when two servers expose the same tool name.
+	ToolCollisionError = "error"
+	// ToolCollisionWarn logs a collision and keeps the tool of the server whose ID sorts first.
+	ToolCollisionWarn = "warn"
+
+	// TransportStdio runs the server as a subprocess and talks to it over stdin/stdout.
+	TransportStdio = "stdio"
+	// TransportSSE connects to a server with the HTTP+SSE transport of MCP 2024-11-05.
+	TransportSSE = "sse"
+	// TransportStreamableHTTP connects to a server with the Streamable HTTP transport of MCP 2025-03-26.
+	TransportStreamableHTTP = "streamable-http"
+)
+
+// ToolPrefix returns the prefix added to the names of the tools of the server.
+func (c MCPConnectorConfig) ToolPrefix(serverID string) string {
+	if srv, ok := c.McpServers[serverID]; ok && srv.ToolPrefix != "" {
+		return srv.ToolPrefix
+	}
+	if c.ToolNaming == ToolNamingPrefixed {
+		return serverID + ToolNameSeparator
+	}
+	return ""
+}
+
+// ToolPrefixes returns the tool name prefixes of the servers that have one.
+func (c MCPConnectorConfig) ToolPrefixes() map[string]string {
+	prefixes := make(map[string]string)
+	for id := range c.McpServers {
+		if prefix := c.ToolPrefix(id); prefix != "" {
+			prefixes[id] = prefix
+		}
+	}
+	return prefixes
 }
 
 // MCPServerConnection represents a connection to an MCP server.
@@ -102,12 +306,19 @@ type MCPConnectorConfig struct {
 //   - IncludeTools: If set, only these tool names will be exported from this server.
 //   - ExcludeTools: If set, these tool names will be excluded from export from this server.
 type MCPServerConnection struct {
+	// Transport is TransportStdio, TransportSSE or TransportStreamableHTTP. If empty, it is detected:
+	// stdio for a Command, SSE for a URL ending in `/sse`, otherwise Streamable HTTP with a fallback to SSE.
+	Transport string `json:"transport,omitempty" yaml:"transport,omitempty"`
+
 	// URL is the URL of the MCP server (for HTTP transport).
 	URL string `json:"url" yaml:"url"`
 
 	// APIKey is the API key for authenticating with the server (for HTTP transport).
 	APIKey string `json:"apiKey" yaml:"apiKey"`
 
+	// Headers are sent with every request to the server (for HTTP transport). They override the APIKey authorization.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
 	// Command is the command to execute for stdio transport.
 	Command string `json:"command" yaml:"command"`
 
@@ -125,6 +336,17 @@ type MCPServerConnection struct {
 
 	// Timeout is the tool call timeout for this server, in seconds. If zero, the default is used.
 	Timeout float64 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	// Sequential disables concurrent tool calls to this server. Use it for servers whose tools are not safe to run in parallel.
+	Sequential bool `json:"sequential,omitempty" yaml:"sequential,omitempty"`
+
+	// ToolPrefix is added to the names of the tools of this server, e.g. "github_" exposes `search` as `github_search`.
+	// IncludeTools, ExcludeTools and Approval use the names the server exports.
+	ToolPrefix string `json:"toolPrefix,omitempty" yaml:"toolPrefix,omitempty"`
+
+	// Approval maps tool names to an approval policy: ApprovalAuto, ApprovalRequire or ApprovalDeny.
+	// The "*" key sets the policy for tools that are not listed. Tools without a policy are called automatically.
+	Approval map[string]string `json:"approval,omitempty" yaml:"approval,omitempty"`
 }
 
 // IsToolAllowed determines if a tool is allowed based on IncludeTools and ExcludeTools.
@@ -154,6 +376,17 @@ func (c *MCPServerConnection) IsToolAllowed(name string) bool {
 	return true
 }
 
+// ApprovalPolicy returns the approval policy for the tool, falling back to the "*" entry and then to ApprovalAuto.
+func (c *MCPServerConnection) ApprovalPolicy(toolName string) string {
+	if policy, ok := c.Approval[toolName]; ok {
+		return policy
+	}
+	if policy, ok := c.Approval[ApprovalAnyTool]; ok {
+		return policy
+	}
+	return ApprovalAuto
+}
+
 // MCPServerConfigForTest returns a sample configuration for MCPServer used in tests.
 func MCPServerConfigForTest() MCPServerConfig {
 	return MCPServerConfig{
@@ -0,0 +1,35 @@
+package configuration
+
+const (
+	// TracingOTLP sends spans to an OpenTelemetry collector with OTLP over HTTP.
+	TracingOTLP = "otlp"
+	// TracingFile appends spans to a file as JSON lines.
+	TracingFile = "file"
+	// TracingStdout writes spans to stdout as JSON lines.
+	TracingStdout = "stdout"
+)
+
+// TracingConfig represents the configuration for trace export.
+// Responsibility: Storing where the spans of sessions, LLM requests and tool calls are exported
+// Features: An empty exporter disables tracing; the trace context is passed through MCP `_meta` either way
+type TracingConfig struct {
+	// Exporter is TracingOTLP, TracingFile, TracingStdout, or empty.
+	Exporter string
+
+	// Endpoint is the base URL of the OTLP/HTTP collector; spans are posted to `<endpoint>/v1/traces`.
+	Endpoint string
+
+	// Headers are sent with every OTLP request, e.g. for authentication.
+	Headers map[string]string
+
+	// Path is the file of the file exporter.
+	Path string
+
+	// ServiceName identifies the agent in the traces.
+	ServiceName string
+}
+
+// Enabled reports whether spans are exported.
+func (c TracingConfig) Enabled() bool {
+	return c.Exporter != ""
+}
@@ -27,6 +27,8 @@ const (
 	ErrorCategoryExternal
 	// ErrorCategoryInternal represents an internal error.
 	ErrorCategoryInternal
+	// ErrorCategoryCancelled represents an operation stopped because the caller cancelled it.
+	ErrorCategoryCancelled
 )
 
 // AppError represents an application error with a category and optional cause.
@@ -102,6 +104,17 @@ func IsTransient(err error) bool {
 	return false
 }
 
+// IsCancelled checks if an error means that the operation was cancelled by the caller.
+// Responsibility: Distinguishing cancellation from failures so that it is not retried or reported as an error of the agent
+// Features: Recognizes both AppError with the cancelled category and a bare context.Canceled anywhere in the chain
+func IsCancelled(err error) bool {
+	var appErr *AppError
+	if errors.As(err, &appErr) && appErr.category == ErrorCategoryCancelled {
+		return true
+	}
+	return errors.Is(err, context.Canceled)
+}
+
 // RetryConfig defines the configuration for retry with exponential backoff.
 // Responsibility: Storing parameters for the retry strategy
 // Features: Contains the maximum number of attempts, initial delay, multiplier, and maximum delay
@@ -114,6 +127,8 @@ type RetryConfig struct {
 	BackoffMultiplier float64
 	// MaxBackoff - maximum delay.
 	MaxBackoff time.Duration
+	// OnRetry, if set, is called before each retry attempt with its number (starting at 1) and the previous error.
+	OnRetry func(attempt int, err error)
 }
 
 // RetryWithBackoff retries a function with exponential backoff.
@@ -128,6 +143,11 @@ func RetryWithBackoff(ctx context.Context, fn func() error, config RetryConfig)
 		return nil
 	}
 
+	// Don't retry if the caller has gone away
+	if ctx.Err() != nil {
+		return WrapError(ctx.Err(), "operation cancelled", ErrorCategoryCancelled)
+	}
+
 	// Don't retry if the error is not transient
 	if !IsTransient(err) {
 		return err
@@ -140,12 +160,18 @@ func RetryWithBackoff(ctx context.Context, fn func() error, config RetryConfig)
 	for attempt := 0; attempt < config.MaxRetries; attempt++ {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return WrapError(ctx.Err(), "operation cancelled", ErrorCategoryCancelled)
 		case <-time.After(backoff):
 			fmt.Printf("[RETRY] Attempt %d/%d, waiting %v, retrying after error: %v\n", attempt+1, config.MaxRetries, backoff, err)
+			if config.OnRetry != nil {
+				config.OnRetry(attempt+1, err)
+			}
 			if err = fn(); err == nil {
 				return nil
 			}
+			if ctx.Err() != nil {
+				return WrapError(ctx.Err(), "operation cancelled", ErrorCategoryCancelled)
+			}
 
 			// Don't retry if the error is not transient
 			if !IsTransient(err) {
@@ -112,6 +112,17 @@ func TestRetryWithBackoff_Exhausted(t *testing.T) {
 	}
 }
 
+func TestRetryWithBackoff_OnRetry(t *testing.T) {
+	var attempts []int
+	_ = RetryWithBackoff(context.Background(), func() error {
+		return NewError("tmp", ErrorCategoryTransient)
+	}, RetryConfig{MaxRetries: 2, InitialBackoff: 1 * time.Millisecond, BackoffMultiplier: 2, MaxBackoff: 10 * time.Millisecond,
+		OnRetry: func(attempt int, err error) { attempts = append(attempts, attempt) }})
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("expected OnRetry for attempts 1 and 2, got %v", attempts)
+	}
+}
+
 func TestRetryWithBackoff_NonTransient(t *testing.T) {
 	calls := 0
 	err := RetryWithBackoff(context.Background(), func() error {
@@ -126,6 +137,34 @@ func TestRetryWithBackoff_NonTransient(t *testing.T) {
 	}
 }
 
+func TestRetryWithBackoff_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := RetryWithBackoff(ctx, func() error {
+		calls++
+		cancel()
+		return NewError("tmp", ErrorCategoryTransient)
+	}, RetryConfig{MaxRetries: 3, InitialBackoff: 1 * time.Millisecond, BackoffMultiplier: 2, MaxBackoff: 10 * time.Millisecond})
+	if !IsCancelled(err) {
+		t.Errorf("expected cancelled error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestIsCancelled(t *testing.T) {
+	if !IsCancelled(NewError("stop", ErrorCategoryCancelled)) {
+		t.Error("IsCancelled should return true for cancelled error")
+	}
+	if !IsCancelled(WrapError(context.Canceled, "call failed", ErrorCategoryInternal)) {
+		t.Error("IsCancelled should return true for wrapped context.Canceled")
+	}
+	if IsCancelled(NewError("tmp", ErrorCategoryTransient)) {
+		t.Error("IsCancelled should return false for transient error")
+	}
+}
+
 func TestSanitizeError(t *testing.T) {
 	cases := []struct {
 		in   error
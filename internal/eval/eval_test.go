@@ -0,0 +1,290 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// scriptedAgent answers each input with its script: tool calls in the first iteration, then the answer.
+// An input without a script loops until the session is cancelled.
+type scriptedAgent struct {
+	scripts map[string]script
+	opts    []types.SessionOptions
+}
+
+type script struct {
+	tools  []string
+	answer string
+	cost   float64
+	err    error
+}
+
+func (m *scriptedAgent) RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+	m.opts = append(m.opts, opts)
+	s, ok := m.scripts[input]
+	for iteration := 1; ; iteration++ {
+		if ctx.Err() != nil {
+			return "", types.MetaInfo{Cost: 0.01 * float64(iteration-1)}, ctx.Err()
+		}
+		opts.Progress(types.ProgressEvent{Kind: types.ProgressEventIteration, Iteration: iteration})
+		if ok && iteration == 1 {
+			for _, tool := range s.tools {
+				opts.Progress(types.ProgressEvent{Kind: types.ProgressEventToolCall, Iteration: iteration, ToolName: tool})
+			}
+		}
+		if ok && iteration == 2 {
+			return s.answer, types.MetaInfo{Tokens: 100, Cost: s.cost, DurationMs: 1500}, s.err
+		}
+	}
+}
+
+// scriptedJudge passes answers that contain `Paris`.
+type scriptedJudge struct {
+	requests [][]llms.MessageContent
+}
+
+func (m *scriptedJudge) SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error) {
+	m.requests = append(m.requests, messages)
+	prompt := messages[1].Parts[0].(llms.TextContent).Text
+	answer := prompt[strings.Index(prompt, "Answer of the agent:"):]
+	call, err := types.NewCallToolRequest(llms.ToolCall{
+		ID:   "verdict",
+		Type: "function",
+		FunctionCall: &llms.FunctionCall{
+			Name:      tools[0].Name,
+			Arguments: `{"pass": ` + map[bool]string{true: "true", false: "false"}[strings.Contains(answer, "Paris")] + `, "reason": "checked the city"}`,
+		},
+	})
+	if err != nil {
+		return llmtypes.LLMResponse{}, err
+	}
+	return llmtypes.LLMResponse{Calls: []types.CallToolRequest{call}, Metadata: llmtypes.LLMResponseMetadata{Cost: 0.001}}, nil
+}
+
+func TestParseSuite(t *testing.T) {
+	suite, err := ParseSuite([]byte(`
+name: geography
+repetitions: 2
+judge:
+  model: gpt-4o-mini
+cases:
+  - name: capital
+    input: Capital of France?
+    assert:
+      - contains: Paris
+      - regex: "(?i)paris"
+      - judge: Names Paris
+    toolCalls:
+      expected: [search]
+      forbidden: [delete]
+    maxCost: 0.05
+    maxIterations: 3
+    repetitions: 5
+  - input: Capital of Italy as JSON?
+    tool: research
+    arguments:
+      country: Italy
+    assert:
+      - jsonSchema:
+          type: object
+          required: [city]
+          properties:
+            city: {type: string, minLength: 2}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "geography", suite.Name)
+	assert.Equal(t, "gpt-4o-mini", suite.Judge.Model)
+	require.Len(t, suite.Cases, 2)
+	assert.Equal(t, []string{"search"}, suite.Cases[0].ToolCalls.Expected)
+	assert.Equal(t, []string{"delete"}, suite.Cases[0].ToolCalls.Forbidden)
+	assert.Equal(t, 0.05, suite.Cases[0].MaxCost)
+	assert.Equal(t, 5, suite.Cases[0].runs(suite))
+	assert.Equal(t, "case 2", suite.Cases[1].Name)
+	assert.Equal(t, "research", suite.Cases[1].Tool)
+	assert.Equal(t, map[string]any{"country": "Italy"}, suite.Cases[1].Arguments)
+	assert.Equal(t, 2, suite.Cases[1].runs(suite))
+	assert.True(t, suite.UsesJudge())
+
+	for name, tc := range map[string]struct {
+		yaml  string
+		error string
+	}{
+		"no cases":       {`name: empty`, "no cases"},
+		"unknown key":    {"cases:\n  - input: hi\n    asert: []", "field asert not found"},
+		"empty input":    {"cases:\n  - name: a\n    input: ' '", "case `a`: empty input"},
+		"duplicate name": {"cases:\n  - {name: a, input: hi}\n  - {name: a, input: hi}", "case `a`: duplicate name"},
+		"two kinds":      {"cases:\n  - input: hi\n    assert:\n      - {contains: a, regex: b}", "assertion 1: exactly one of"},
+		"no kind":        {"cases:\n  - input: hi\n    assert:\n      - {}", "assertion 1: exactly one of"},
+		"bad regex":      {"cases:\n  - input: hi\n    assert:\n      - regex: '('", "invalid regex"},
+		"bad schema":     {"cases:\n  - input: hi\n    assert:\n      - jsonSchema: {type: thing}", "invalid jsonSchema"},
+		"negative cost":  {"cases:\n  - {input: hi, maxCost: -1}", "maxCost must not be negative"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseSuite([]byte(tc.yaml))
+			assert.ErrorContains(t, err, tc.error)
+		})
+	}
+}
+
+func TestRunner_Run(t *testing.T) {
+	ag := &scriptedAgent{scripts: map[string]script{
+		"france":  {tools: []string{"search"}, answer: "Paris", cost: 0.01},
+		"italy":   {tools: []string{"search", "delete"}, answer: `{"city": "Rome"}`, cost: 0.2},
+		"spain":   {answer: "Madrid"},
+		"failing": {err: errors.New("LLM is down")},
+	}}
+	judge := &scriptedJudge{}
+	runner := NewRunner("answer", judge, newTestLogger())
+	runner.AddAgent("answer", ag, nil)
+	suite := Suite{Name: "geography", Repetitions: 2, Cases: []Case{
+		{Name: "passing", Input: "france", Assert: []Assertion{{Contains: "Paris"}, {Regex: "^P"}, {Judge: "Names Paris"}}, MaxCost: 0.05, MaxIterations: 3},
+		{Name: "failing checks", Input: "italy", Assert: []Assertion{
+			{JSONSchema: map[string]any{"type": "object", "required": []any{"city", "country"}}},
+			{Contains: "Paris"},
+		}, MaxCost: 0.1, Repetitions: 1},
+		{Name: "judge fails", Input: "spain", Assert: []Assertion{{Judge: "Names Paris"}}, Repetitions: 1},
+		{Name: "session error", Input: "failing", Assert: []Assertion{{Contains: "x"}}, Repetitions: 1},
+		{Name: "runaway", Input: "loop", MaxIterations: 3, Repetitions: 1},
+	}}
+	suite.Cases[0].ToolCalls.Expected = []string{"search"}
+	suite.Cases[1].ToolCalls.Expected = []string{"fetch"}
+	suite.Cases[1].ToolCalls.Forbidden = []string{"delete"}
+
+	report, err := runner.Run(context.Background(), suite)
+	require.NoError(t, err)
+	assert.False(t, report.Passed)
+	assert.Equal(t, 6, report.Runs)
+	assert.Equal(t, 4, report.FailedRuns)
+	require.Len(t, report.Cases, 5)
+
+	passing := report.Cases[0]
+	assert.True(t, passing.Passed)
+	assert.Equal(t, 2, passing.PassedRuns)
+	require.Len(t, passing.Runs, 2)
+	assert.Equal(t, []string{"search"}, passing.Runs[0].ToolCalls)
+	assert.Equal(t, 2, passing.Runs[0].Iterations)
+	assert.Equal(t, 0.001, passing.Runs[0].JudgeCost)
+	assert.Equal(t, 0.05, ag.opts[0].RequestBudget, "the max cost is the budget of the session")
+	assert.Contains(t, judge.requests[0][1].Parts[0].(llms.TextContent).Text, "Rubric:\nNames Paris")
+
+	assert.Equal(t, []string{
+		"cost $0.2000 exceeds $0.1000",
+		"expected a call of `fetch`",
+		"called forbidden tool `delete`",
+		"answer does not match the schema: $: missing required property `country`",
+		`answer does not contain "Paris"`,
+	}, report.Cases[1].Runs[0].Failures)
+	assert.Equal(t, []string{"judge: checked the city"}, report.Cases[2].Runs[0].Failures)
+	assert.Equal(t, []string{"session failed: LLM is down"}, report.Cases[3].Runs[0].Failures)
+	assert.Equal(t, "LLM is down", report.Cases[3].Runs[0].Error)
+	runaway := report.Cases[4].Runs[0]
+	assert.Equal(t, []string{"needed more than 3 iterations"}, runaway.Failures)
+	assert.Equal(t, 4, runaway.Iterations, "the session is stopped at the first iteration over the limit")
+	assert.InDelta(t, 0.01*2+0.2+0.04+0.003, report.Cost, 1e-9, "the sessions and the judge")
+
+	_, err = runner.Run(context.Background(), Suite{Cases: []Case{{Name: "other", Tool: "research", Input: "hi"}}})
+	assert.ErrorContains(t, err, "case `other`: unknown tool `research`")
+}
+
+func TestRunner_JSONSchemaOfStructuredAnswer(t *testing.T) {
+	runner := NewRunner("answer", nil, newTestLogger())
+	runner.AddAgent("answer", agentFunc(func(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+		return "not JSON", types.MetaInfo{StructuredContent: map[string]any{"count": 3}}, nil
+	}), nil)
+	schema := map[string]any{"type": "object", "properties": map[string]any{"count": map[string]any{"type": "integer", "maximum": 2}}}
+	report, err := runner.Run(context.Background(), Suite{Cases: []Case{{Name: "count", Input: "hi", Assert: []Assertion{{JSONSchema: schema}, {Judge: "anything"}}}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"answer does not match the schema: $.count: must be <= 2",
+		"judge failed: no judge LLM configured",
+	}, report.Cases[0].Runs[0].Failures)
+}
+
+func TestRunner_Arguments(t *testing.T) {
+	var got map[string]any
+	ag := agentFunc(func(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+		got = opts.Arguments
+		return "ok", types.MetaInfo{}, nil
+	})
+	runner := NewRunner("answer", nil, newTestLogger())
+	runner.AddAgent("answer", ag, func(arguments map[string]any) (map[string]any, error) {
+		if _, ok := arguments["team"]; !ok {
+			return nil, errors.New("invalid arguments: $: missing required property `team`")
+		}
+		return map[string]any{"team": arguments["team"]}, nil
+	})
+	runner.AddAgent("plain", ag, nil)
+
+	report, err := runner.Run(context.Background(), Suite{Cases: []Case{{Name: "billing", Input: "hi", Arguments: map[string]any{"team": "billing", "extra": 1}}}})
+	require.NoError(t, err)
+	assert.True(t, report.Passed)
+	assert.Equal(t, map[string]any{"team": "billing"}, got, "the session gets the checked arguments")
+
+	_, err = runner.Run(context.Background(), Suite{Cases: []Case{{Name: "no team", Input: "hi"}}})
+	assert.ErrorContains(t, err, "case `no team`: invalid arguments: $: missing required property `team`")
+	_, err = runner.Run(context.Background(), Suite{Cases: []Case{{Name: "plain", Tool: "plain", Input: "hi", Arguments: map[string]any{"team": "billing"}}}})
+	assert.ErrorContains(t, err, "case `plain`: tool `plain` has no input schema for arguments")
+}
+
+type agentFunc func(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error)
+
+func (f agentFunc) RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error) {
+	return f(ctx, input, opts)
+}
+
+func TestReport_Write(t *testing.T) {
+	report := Report{Suite: "geography", Runs: 3, FailedRuns: 1, DurationMs: 4200, Cases: []CaseReport{
+		{Name: "capital", Passed: false, PassedRuns: 1, Runs: []RunReport{
+			{Repetition: 1, Passed: true, Answer: "Paris", Meta: types.MetaInfo{DurationMs: 1500}},
+			{Repetition: 2, Answer: "Lyon", Failures: []string{`answer does not contain "Paris"`, "judge: wrong city"}},
+		}},
+		{Name: "json", Passed: true, PassedRuns: 1, Runs: []RunReport{{Repetition: 1, Passed: true, Answer: "{}"}}},
+	}}
+
+	var xmlOut bytes.Buffer
+	require.NoError(t, report.WriteJUnit(&xmlOut))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="geography" tests="3" failures="1" time="4.200">
+    <testcase name="capital #1" classname="geography" time="1.500">
+      <system-out>Paris</system-out>
+    </testcase>
+    <testcase name="capital #2" classname="geography" time="0.000">
+      <failure message="answer does not contain &#34;Paris&#34;">answer does not contain &#34;Paris&#34;&#xA;judge: wrong city</failure>
+      <system-out>Lyon</system-out>
+    </testcase>
+    <testcase name="json" classname="geography" time="0.000">
+      <system-out>{}</system-out>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, xmlOut.String())
+
+	var jsonOut bytes.Buffer
+	require.NoError(t, report.WriteJSON(&jsonOut))
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(jsonOut.Bytes(), &decoded))
+	assert.Equal(t, 1.0, decoded["failed_runs"])
+	run := decoded["cases"].([]any)[0].(map[string]any)["runs"].([]any)[1].(map[string]any)
+	assert.Equal(t, []any{`answer does not contain "Paris"`, "judge: wrong city"}, run["failures"])
+	assert.Contains(t, run, "meta")
+}
@@ -0,0 +1,114 @@
+package eval
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// Report is the outcome of a suite.
+type Report struct {
+	Suite      string       `json:"suite"`
+	Passed     bool         `json:"passed"`
+	Runs       int          `json:"runs"`
+	FailedRuns int          `json:"failed_runs"`
+	Cost       float64      `json:"cost"` // Of the sessions and the judge
+	DurationMs int64        `json:"duration_ms"`
+	Cases      []CaseReport `json:"cases"`
+}
+
+// CaseReport is the outcome of a case. It passes if all its runs pass.
+type CaseReport struct {
+	Name       string      `json:"name"`
+	Passed     bool        `json:"passed"`
+	PassedRuns int         `json:"passed_runs"`
+	Runs       []RunReport `json:"runs"`
+}
+
+// RunReport is the outcome of one run of a case.
+type RunReport struct {
+	Repetition int            `json:"repetition"`
+	Passed     bool           `json:"passed"`
+	Failures   []string       `json:"failures,omitempty"`
+	Answer     string         `json:"answer"`
+	Error      string         `json:"error,omitempty"`
+	ToolCalls  []string       `json:"tool_calls"`
+	Iterations int            `json:"iterations"`
+	JudgeCost  float64        `json:"judge_cost,omitempty"`
+	Meta       types.MetaInfo `json:"meta"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML with a test case per run, so CI systems can show each failure.
+// Repeated cases are named with the number of the run.
+func (r Report) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{Name: r.Suite, Tests: r.Runs, Failures: r.FailedRuns, Time: seconds(r.DurationMs)}
+	for _, c := range r.Cases {
+		for _, run := range c.Runs {
+			name := c.Name
+			if len(c.Runs) > 1 {
+				name = fmt.Sprintf("%s #%d", c.Name, run.Repetition)
+			}
+			testCase := junitCase{
+				Name:      name,
+				ClassName: r.Suite,
+				Time:      seconds(run.Meta.DurationMs),
+				SystemOut: run.Answer,
+			}
+			if !run.Passed {
+				testCase.Failure = &junitFailure{Message: run.Failures[0], Text: strings.Join(run.Failures, "\n")}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
@@ -0,0 +1,287 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/llm"
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/korchasa/speelka-agent-go/internal/utils/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// judgePrompt is the system prompt of the LLM judge.
+const judgePrompt = `You grade the answer of an AI agent against a rubric.
+Call ` + "`submit_verdict`" + ` with pass set to true only if the answer satisfies every point of the rubric, and explain the decision in reason.`
+
+// verdictTool receives the verdict of the judge.
+var verdictTool = mcp.NewTool(
+	"submit_verdict",
+	mcp.WithDescription("Submit the verdict on the answer."),
+	mcp.WithBoolean("pass", mcp.Description("Whether the answer satisfies the rubric"), mcp.Required()),
+	mcp.WithString("reason", mcp.Description("Why the answer passes or fails"), mcp.Required()),
+)
+
+// agentSpec runs the sessions of a case.
+type agentSpec interface {
+	RunSession(ctx context.Context, input string, opts types.SessionOptions) (string, types.MetaInfo, error)
+}
+
+// ArgumentsFunc checks the arguments of a case against the input schema of a tool and returns the ones
+// passed to the session.
+type ArgumentsFunc func(arguments map[string]any) (map[string]any, error)
+
+// judgeSpec is the LLM grading the `judge` assertions.
+type judgeSpec interface {
+	SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error)
+}
+
+// Runner runs suites against the agents.
+// Responsibility: Running each case through agent sessions and checking the expectations
+// Features: Repeats cases, follows the session through progress events to see its tool calls and iterations,
+// stops sessions that exceed the iteration limit, grades answers with an LLM judge
+type Runner struct {
+	agents      map[string]agentSpec
+	arguments   map[string]ArgumentsFunc // nil for tools without an input schema
+	defaultTool string
+	judge       judgeSpec          // nil fails the `judge` assertions
+	approve     types.ApprovalFunc // nil rejects the calls that require approval
+	log         *logrus.Logger
+}
+
+// NewRunner creates a runner without agents. Cases without a tool run on the agent of defaultTool.
+func NewRunner(defaultTool string, judge judgeSpec, log *logrus.Logger) *Runner {
+	return &Runner{
+		agents:      make(map[string]agentSpec),
+		arguments:   make(map[string]ArgumentsFunc),
+		defaultTool: defaultTool,
+		judge:       judge,
+		log:         log,
+	}
+}
+
+// AddAgent makes the agent run the cases of the tool it serves. arguments checks the arguments of the cases;
+// nil means the tool takes none.
+func (r *Runner) AddAgent(tool string, ag agentSpec, arguments ArgumentsFunc) {
+	r.agents[tool] = ag
+	r.arguments[tool] = arguments
+}
+
+// SetApprover makes the sessions ask approve about the tool calls that require approval.
+func (r *Runner) SetApprover(approve types.ApprovalFunc) {
+	r.approve = approve
+}
+
+// Run runs every case of the suite the number of times it asks for. It fails only if a case names an unknown tool
+// or has invalid arguments; failed sessions and expectations are reported.
+func (r *Runner) Run(ctx context.Context, suite Suite) (Report, error) {
+	arguments := make([]map[string]any, len(suite.Cases))
+	for i, c := range suite.Cases {
+		if _, err := r.agentFor(c); err != nil {
+			return Report{}, fmt.Errorf("case `%s`: %w", c.Name, err)
+		}
+		values, err := r.argumentsFor(c)
+		if err != nil {
+			return Report{}, fmt.Errorf("case `%s`: %w", c.Name, err)
+		}
+		arguments[i] = values
+	}
+	start := time.Now()
+	report := Report{Suite: suite.Name, Passed: true}
+	for i, c := range suite.Cases {
+		ag, _ := r.agentFor(c)
+		caseReport := CaseReport{Name: c.Name, Passed: true}
+		for repetition := 1; repetition <= c.runs(suite); repetition++ {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			run := r.runCase(ctx, ag, c, arguments[i], repetition)
+			r.log.Infof("Eval case `%s` run %d: passed %t, cost $%.4f", c.Name, repetition, run.Passed, run.Meta.Cost)
+			caseReport.Runs = append(caseReport.Runs, run)
+			report.Runs++
+			report.Cost += run.Meta.Cost + run.JudgeCost
+			if run.Passed {
+				caseReport.PassedRuns++
+			} else {
+				caseReport.Passed = false
+				report.FailedRuns++
+			}
+		}
+		report.Passed = report.Passed && caseReport.Passed
+		report.Cases = append(report.Cases, caseReport)
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+	return report, nil
+}
+
+func (r *Runner) agentFor(c Case) (agentSpec, error) {
+	ag, ok := r.agents[r.toolOf(c)]
+	if !ok {
+		return nil, fmt.Errorf("unknown tool `%s`", r.toolOf(c))
+	}
+	return ag, nil
+}
+
+// argumentsFor returns the checked arguments of the case.
+func (r *Runner) argumentsFor(c Case) (map[string]any, error) {
+	check := r.arguments[r.toolOf(c)]
+	if check == nil {
+		if len(c.Arguments) > 0 {
+			return nil, fmt.Errorf("tool `%s` has no input schema for arguments", r.toolOf(c))
+		}
+		return nil, nil
+	}
+	return check(c.Arguments)
+}
+
+// toolOf returns the tool whose agent runs the case.
+func (r *Runner) toolOf(c Case) string {
+	if c.Tool == "" {
+		return r.defaultTool
+	}
+	return c.Tool
+}
+
+// runCase runs one session of the case with the arguments and checks it.
+func (r *Runner) runCase(ctx context.Context, ag agentSpec, c Case, arguments map[string]any, repetition int) RunReport {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	run := RunReport{Repetition: repetition, ToolCalls: []string{}}
+	opts := types.SessionOptions{
+		RequestBudget: c.MaxCost,
+		Arguments:     arguments,
+		Approve:       r.approve,
+		Progress: func(event types.ProgressEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch event.Kind {
+			case types.ProgressEventIteration:
+				run.Iterations = event.Iteration
+				if c.MaxIterations > 0 && event.Iteration > c.MaxIterations {
+					cancel()
+				}
+			case types.ProgressEventToolCall:
+				run.ToolCalls = append(run.ToolCalls, event.ToolName)
+			}
+		},
+	}
+	answer, meta, err := ag.RunSession(ctx, c.Input, opts)
+	mu.Lock()
+	defer mu.Unlock()
+	run.Answer = answer
+	run.Meta = meta
+	fail := func(format string, args ...any) {
+		run.Failures = append(run.Failures, fmt.Sprintf(format, args...))
+	}
+
+	iterationsExceeded := c.MaxIterations > 0 && run.Iterations > c.MaxIterations
+	if iterationsExceeded {
+		fail("needed more than %d iterations", c.MaxIterations)
+	}
+	if err != nil {
+		run.Error = err.Error()
+		if !iterationsExceeded {
+			fail("session failed: %v", err)
+		}
+	}
+	if c.MaxCost > 0 && meta.Cost > c.MaxCost {
+		fail("cost $%.4f exceeds $%.4f", meta.Cost, c.MaxCost)
+	}
+	for _, tool := range c.ToolCalls.Expected {
+		if !slices.Contains(run.ToolCalls, tool) {
+			fail("expected a call of `%s`", tool)
+		}
+	}
+	for _, tool := range c.ToolCalls.Forbidden {
+		if slices.Contains(run.ToolCalls, tool) {
+			fail("called forbidden tool `%s`", tool)
+		}
+	}
+	if err == nil {
+		for _, assertion := range c.Assert {
+			if failure := r.check(ctx, assertion, c.Input, answer, meta, &run); failure != "" {
+				run.Failures = append(run.Failures, failure)
+			}
+		}
+	}
+	run.Passed = len(run.Failures) == 0
+	return run
+}
+
+// check returns why the answer fails the assertion, or an empty string if it passes.
+func (r *Runner) check(ctx context.Context, assertion Assertion, input, answer string, meta types.MetaInfo, run *RunReport) string {
+	switch {
+	case assertion.Contains != "":
+		if !strings.Contains(answer, assertion.Contains) {
+			return fmt.Sprintf("answer does not contain %q", assertion.Contains)
+		}
+	case assertion.Regex != "":
+		// Compiled when the suite was loaded
+		if !regexp.MustCompile(assertion.Regex).MatchString(answer) {
+			return fmt.Sprintf("answer does not match /%s/", assertion.Regex)
+		}
+	case assertion.JSONSchema != nil:
+		var value any = meta.StructuredContent
+		if meta.StructuredContent == nil {
+			if err := json.Unmarshal([]byte(answer), &value); err != nil {
+				return fmt.Sprintf("answer is not JSON: %v", err)
+			}
+		}
+		if violations := jsonschema.Validate(assertion.JSONSchema, normalize(value)); len(violations) > 0 {
+			return fmt.Sprintf("answer does not match the schema: %s", strings.Join(violations, "; "))
+		}
+	case assertion.Judge != "":
+		pass, reason, err := r.askJudge(ctx, assertion.Judge, input, answer, run)
+		if err != nil {
+			return fmt.Sprintf("judge failed: %v", err)
+		}
+		if !pass {
+			return fmt.Sprintf("judge: %s", reason)
+		}
+	}
+	return ""
+}
+
+// askJudge asks the LLM judge whether the answer satisfies the rubric. The cost of the request is added to the run.
+func (r *Runner) askJudge(ctx context.Context, rubric, input, answer string, run *RunReport) (bool, string, error) {
+	if r.judge == nil {
+		return false, "", fmt.Errorf("no judge LLM configured")
+	}
+	args, meta, err := llm.AskForToolCall(ctx, r.judge, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, judgePrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf("Rubric:\n%s\n\nRequest to the agent:\n%s\n\nAnswer of the agent:\n%s", rubric, input, answer)),
+	}, verdictTool)
+	run.JudgeCost += meta.Cost
+	if err != nil {
+		return false, "", err
+	}
+	pass, ok := args["pass"].(bool)
+	if !ok {
+		return false, "", fmt.Errorf("verdict without `pass`")
+	}
+	reason, _ := args["reason"].(string)
+	return pass, reason, nil
+}
+
+// normalize converts the structured answer to decoded JSON, as the schema validation expects.
+func normalize(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,185 @@
+// Package eval runs regression suites against the agents.
+// Responsibility: Loading evaluation suites, running their cases through agent sessions and reporting the outcome
+// Features: Assertions on the answer (substring, regular expression, JSON Schema, LLM judge), expected and
+// forbidden tool calls, cost and iteration limits, repeated runs, JSON and JUnit XML reports
+package eval
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/korchasa/speelka-agent-go/internal/utils/jsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// Suite is a set of evaluation cases.
+type Suite struct {
+	Name string `yaml:"name"`
+	// Repetitions is the number of runs of each case that does not set its own. Zero means one.
+	Repetitions int `yaml:"repetitions"`
+	// Judge configures the LLM grading the `judge` assertions.
+	Judge JudgeConfig `yaml:"judge"`
+	Cases []Case      `yaml:"cases"`
+}
+
+// JudgeConfig configures the LLM judge.
+type JudgeConfig struct {
+	// Model is the model of the judge. Empty uses the model of the first agent.
+	Model string `yaml:"model"`
+}
+
+// Case is one input and the expectations on the session it starts.
+type Case struct {
+	Name string `yaml:"name"`
+	// Tool selects the agent by the name of the tool it serves. Empty uses the first one.
+	Tool  string `yaml:"tool"`
+	Input string `yaml:"input"`
+	// Arguments are the arguments of the input schema of the tool. They are checked against the schema
+	// before the suite runs.
+	Arguments map[string]any `yaml:"arguments"`
+	Assert    []Assertion    `yaml:"assert"`
+	ToolCalls struct {
+		// Expected tools must be called at least once.
+		Expected []string `yaml:"expected"`
+		// Forbidden tools must not be called.
+		Forbidden []string `yaml:"forbidden"`
+	} `yaml:"toolCalls"`
+	// MaxCost fails runs that cost more, and is passed to the session as its request budget. Zero means no limit.
+	MaxCost float64 `yaml:"maxCost"`
+	// MaxIterations fails runs that need more LLM iterations; such sessions are stopped. Zero means no limit.
+	MaxIterations int `yaml:"maxIterations"`
+	// Repetitions overrides the number of runs of the suite for this case.
+	Repetitions int `yaml:"repetitions"`
+}
+
+// Assertion is a check of the answer. Exactly one of its fields is set.
+type Assertion struct {
+	// Contains is a substring of the answer.
+	Contains string `yaml:"contains"`
+	// Regex is a regular expression matching the answer.
+	Regex string `yaml:"regex"`
+	// JSONSchema validates the answer parsed as JSON, or the structured answer if the agent has an output schema.
+	JSONSchema map[string]any `yaml:"jsonSchema"`
+	// Judge is a rubric the LLM judge grades the answer against.
+	Judge string `yaml:"judge"`
+}
+
+// LoadSuite reads and validates a suite from a YAML file.
+func LoadSuite(path string) (Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Suite{}, fmt.Errorf("failed to read suite: %w", err)
+	}
+	return ParseSuite(data)
+}
+
+// ParseSuite parses and validates a suite. Unknown keys are rejected, so that a typo does not disable a check.
+func ParseSuite(data []byte) (Suite, error) {
+	var suite Suite
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&suite); err != nil && !errors.Is(err, io.EOF) {
+		return Suite{}, fmt.Errorf("failed to parse suite: %w", err)
+	}
+	if err := suite.Validate(); err != nil {
+		return Suite{}, err
+	}
+	return suite, nil
+}
+
+// Validate checks the suite and names the unnamed cases after their position.
+func (s *Suite) Validate() error {
+	var errs []string
+	if len(s.Cases) == 0 {
+		errs = append(errs, "no cases")
+	}
+	if s.Repetitions < 0 {
+		errs = append(errs, "repetitions must not be negative")
+	}
+	names := make(map[string]bool)
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case %d", i+1)
+		}
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Sprintf("case `%s`: %s", c.Name, fmt.Sprintf(format, args...)))
+		}
+		if names[c.Name] {
+			fail("duplicate name")
+		}
+		names[c.Name] = true
+		if strings.TrimSpace(c.Input) == "" {
+			fail("empty input")
+		}
+		if c.MaxCost < 0 {
+			fail("maxCost must not be negative")
+		}
+		if c.MaxIterations < 0 {
+			fail("maxIterations must not be negative")
+		}
+		if c.Repetitions < 0 {
+			fail("repetitions must not be negative")
+		}
+		for j, assertion := range c.Assert {
+			if err := assertion.validate(); err != nil {
+				fail("assertion %d: %v", j+1, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid suite: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// UsesJudge reports whether any case has a `judge` assertion.
+func (s *Suite) UsesJudge() bool {
+	for _, c := range s.Cases {
+		for _, assertion := range c.Assert {
+			if assertion.Judge != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runs returns the number of runs of the case.
+func (c Case) runs(suite Suite) int {
+	switch {
+	case c.Repetitions > 0:
+		return c.Repetitions
+	case suite.Repetitions > 0:
+		return suite.Repetitions
+	}
+	return 1
+}
+
+func (a Assertion) validate() error {
+	set := 0
+	for _, isSet := range []bool{a.Contains != "", a.Regex != "", a.JSONSchema != nil, a.Judge != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of contains, regex, jsonSchema and judge must be set")
+	}
+	if a.Regex != "" {
+		if _, err := regexp.Compile(a.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	if a.JSONSchema != nil {
+		if err := jsonschema.Check(a.JSONSchema); err != nil {
+			return fmt.Errorf("invalid jsonSchema: %w", err)
+		}
+	}
+	return nil
+}
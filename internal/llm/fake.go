@@ -0,0 +1,218 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
+	"github.com/korchasa/speelka-agent-go/internal/llm/cost"
+	"github.com/tmc/langchaingo/llms"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeScript is the script of the fake provider: the responses it gives instead of an LLM.
+type fakeScript struct {
+	// Turns are tried in order; the first one matching the request answers it.
+	Turns []fakeTurn `yaml:"turns"`
+}
+
+// fakeTurn is a scripted response. It answers a request that matches all of its conditions;
+// a turn without conditions answers any request.
+type fakeTurn struct {
+	// Turn is the number of the request in the session, starting at 1. Zero matches any request.
+	Turn int `yaml:"turn"`
+	// Match is a regular expression the text of the last message of the request must match.
+	Match     string         `yaml:"match"`
+	Text      string         `yaml:"text"`
+	ToolCalls []fakeToolCall `yaml:"toolCalls"`
+	// Usage overrides the token usage, which is otherwise estimated from the lengths of the request and the response.
+	Usage struct {
+		PromptTokens     int `yaml:"promptTokens"`
+		CompletionTokens int `yaml:"completionTokens"`
+	} `yaml:"usage"`
+
+	match *regexp.Regexp
+}
+
+// fakeToolCall is a tool call of a scripted response.
+type fakeToolCall struct {
+	Name      string         `yaml:"name"`
+	Arguments map[string]any `yaml:"arguments"`
+}
+
+// loadFakeScript reads and validates a script from a YAML or JSON file. Unknown keys are rejected.
+func loadFakeScript(path string) (fakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fakeScript{}, fmt.Errorf("failed to read script: %w", err)
+	}
+	var script fakeScript
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&script); err != nil && !errors.Is(err, io.EOF) {
+		return fakeScript{}, fmt.Errorf("failed to parse script: %w", err)
+	}
+	if err := script.compile(); err != nil {
+		return fakeScript{}, fmt.Errorf("invalid script %s: %w", path, err)
+	}
+	return script, nil
+}
+
+// compile validates the turns and compiles their regular expressions.
+func (s *fakeScript) compile() error {
+	if len(s.Turns) == 0 {
+		return fmt.Errorf("no turns")
+	}
+	var errs []string
+	for i := range s.Turns {
+		turn := &s.Turns[i]
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Sprintf("turn %d: %s", i+1, fmt.Sprintf(format, args...)))
+		}
+		if turn.Turn < 0 {
+			fail("turn must not be negative")
+		}
+		if turn.Match != "" {
+			var err error
+			if turn.match, err = regexp.Compile(turn.Match); err != nil {
+				fail("invalid match: %v", err)
+			}
+		}
+		if len(turn.ToolCalls) == 0 {
+			fail("at least one tool call is required")
+		}
+		for _, call := range turn.ToolCalls {
+			if call.Name == "" {
+				fail("tool call without a name")
+			}
+		}
+		if turn.Usage.PromptTokens < 0 || turn.Usage.CompletionTokens < 0 {
+			fail("usage must not be negative")
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fakeModel is an llms.Model answering from a script, so that whole configurations run without network.
+// Responsibility: Replacing the LLM API client of the fake provider
+// Features: Selects the turn by the number of the request in the session or by the last message,
+// reports synthetic token usage for cost accounting. It keeps no state, so sessions can run concurrently.
+type fakeModel struct {
+	script fakeScript
+}
+
+func newFakeModel(script fakeScript) *fakeModel {
+	return &fakeModel{script: script}
+}
+
+// GenerateContent answers with the first turn of the script that matches the request.
+func (m *fakeModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	number := turnNumber(messages)
+	last := ""
+	if len(messages) > 0 {
+		last = messageText(messages[len(messages)-1])
+	}
+	for _, turn := range m.script.Turns {
+		if turn.Turn != 0 && turn.Turn != number {
+			continue
+		}
+		if turn.match != nil && !turn.match.MatchString(last) {
+			continue
+		}
+		return turn.response(number, messages)
+	}
+	// A script that does not cover the session will not do better on a retry
+	return nil, error_handling.NewError(
+		fmt.Sprintf("no scripted turn matches request %d of the session", number),
+		error_handling.ErrorCategoryValidation,
+	)
+}
+
+// Call answers a single prompt. It is part of llms.Model.
+func (m *fakeModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// response builds the response of the turn to the request with the given number.
+func (t fakeTurn) response(number int, messages []llms.MessageContent) (*llms.ContentResponse, error) {
+	calls := make([]llms.ToolCall, len(t.ToolCalls))
+	completion := len(t.Text)
+	for i, call := range t.ToolCalls {
+		arguments := []byte("{}")
+		if call.Arguments != nil {
+			var err error
+			if arguments, err = json.Marshal(call.Arguments); err != nil {
+				return nil, error_handling.WrapError(err, fmt.Sprintf("invalid arguments of scripted call `%s`", call.Name), error_handling.ErrorCategoryValidation)
+			}
+		}
+		calls[i] = llms.ToolCall{
+			ID:           fmt.Sprintf("fake_%d_%d", number, i+1),
+			Type:         "function",
+			FunctionCall: &llms.FunctionCall{Name: call.Name, Arguments: string(arguments)},
+		}
+		completion += len(call.Name) + len(arguments)
+	}
+
+	promptTokens, completionTokens := t.Usage.PromptTokens, t.Usage.CompletionTokens
+	if promptTokens == 0 {
+		estimator := cost.TokenEstimator{}
+		for _, message := range messages {
+			promptTokens += estimator.CountTokens(message)
+		}
+	}
+	if completionTokens == 0 {
+		completionTokens = max(completion/4, 1)
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+		Content:   t.Text,
+		ToolCalls: calls,
+		FuncCall:  calls[0].FunctionCall,
+		GenerationInfo: map[string]any{
+			"PromptTokens":     promptTokens,
+			"CompletionTokens": completionTokens,
+			"TotalTokens":      promptTokens + completionTokens,
+		},
+	}}}, nil
+}
+
+// turnNumber returns the number of the request in the session: one more than the responses already in it.
+// The chat keeps each response as an assistant text message, and each tool call as a separate assistant message.
+func turnNumber(messages []llms.MessageContent) int {
+	number := 1
+	for _, message := range messages {
+		if message.Role != llms.ChatMessageTypeAI {
+			continue
+		}
+		for _, part := range message.Parts {
+			if _, ok := part.(llms.TextContent); ok {
+				number++
+				break
+			}
+		}
+	}
+	return number
+}
+
+// messageText joins the text and tool results of a message.
+func messageText(message llms.MessageContent) string {
+	var texts []string
+	for _, part := range message.Parts {
+		switch p := part.(type) {
+		case llms.TextContent:
+			texts = append(texts, p.Text)
+		case llms.ToolCallResponse:
+			texts = append(texts, p.Content)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const testScript = `
+turns:
+  - turn: 1
+    text: Looking up the time
+    toolCalls:
+      - name: get_time
+        arguments: {timezone: Asia/Tokyo}
+      - name: get_weather
+  - match: "(?i)error"
+    toolCalls:
+      - name: finish
+        arguments: {text: The lookup failed}
+    usage: {promptTokens: 1000, completionTokens: 100}
+  - toolCalls:
+      - name: finish
+        arguments: {text: It is noon in Tokyo}
+`
+
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o600))
+	return path
+}
+
+func newFakeService(t *testing.T, script string) *LLMService {
+	t.Helper()
+	svc, err := NewLLMService(configuration.LLMConfig{
+		Provider:    configuration.LLMProviderFake,
+		Model:       "gpt-4o-mini",
+		Script:      writeScript(t, script),
+		RetryConfig: configuration.RetryConfig{MaxRetries: 3, InitialBackoff: 10, BackoffMultiplier: 2, MaxBackoff: 30},
+	}, newTestLogger())
+	require.NoError(t, err, "no API key is needed")
+	return svc
+}
+
+func TestLLMService_Fake(t *testing.T) {
+	svc := newFakeService(t, testScript)
+	ctx := context.Background()
+	messages := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, "You tell the time."),
+		llms.TextParts(llms.ChatMessageTypeHuman, "What time is it in Tokyo?"),
+	}
+
+	resp, err := svc.SendRequest(ctx, messages, []mcp.Tool{})
+	require.NoError(t, err)
+	assert.Equal(t, "Looking up the time", resp.Text)
+	require.Len(t, resp.Calls, 2)
+	assert.Equal(t, "get_time", resp.Calls[0].ToolName())
+	assert.Equal(t, map[string]any{"timezone": "Asia/Tokyo"}, resp.Calls[0].Params.Arguments)
+	assert.Equal(t, "fake_1_1", resp.Calls[0].ID)
+	assert.Equal(t, "get_weather", resp.Calls[1].ToolName())
+	assert.Equal(t, map[string]any{}, resp.Calls[1].Params.Arguments)
+	tokens := resp.Metadata.Tokens
+	assert.Positive(t, tokens.PromptTokens, "usage is estimated")
+	assert.Positive(t, tokens.CompletionTokens)
+	assert.Equal(t, tokens.PromptTokens+tokens.CompletionTokens, tokens.TotalTokens)
+	assert.Positive(t, resp.Metadata.Cost, "cost is computed for the configured model")
+
+	// The chat keeps the response and the tool calls and results
+	messages = append(messages,
+		llms.TextParts(llms.ChatMessageTypeAI, resp.Text),
+		llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{resp.Calls[0].ToLLM()}},
+		llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{llms.ToolCallResponse{ToolCallID: resp.Calls[0].ID, Name: "get_time", Content: "12:00"}}},
+	)
+	resp, err = svc.SendRequest(ctx, messages, []mcp.Tool{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"text": "It is noon in Tokyo"}, resp.Calls[0].Params.Arguments, "the turn without conditions")
+	assert.Equal(t, "fake_2_1", resp.Calls[0].ID)
+
+	messages[len(messages)-1].Parts[0] = llms.ToolCallResponse{ToolCallID: "fake_1_1", Name: "get_time", Content: "Error: unknown timezone"}
+	resp, err = svc.SendRequest(ctx, messages, []mcp.Tool{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"text": "The lookup failed"}, resp.Calls[0].Params.Arguments, "matched by the last message")
+	assert.Equal(t, 1000, resp.Metadata.Tokens.PromptTokens)
+	assert.Equal(t, 100, resp.Metadata.Tokens.CompletionTokens)
+	assert.InDelta(t, 1000*0.15/1e6+100*0.6/1e6, resp.Metadata.Cost, 1e-12)
+}
+
+func TestLLMService_Fake_NoMatchingTurn(t *testing.T) {
+	svc := newFakeService(t, "turns:\n  - turn: 2\n    toolCalls: [{name: finish}]\n")
+	start := time.Now()
+	_, err := svc.SendRequest(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}, []mcp.Tool{})
+	assert.ErrorContains(t, err, "no scripted turn matches request 1 of the session")
+	assert.False(t, error_handling.IsTransient(err))
+	assert.Less(t, time.Since(start), time.Second, "not retried")
+}
+
+func TestLoadFakeScript(t *testing.T) {
+	_, err := NewLLMService(configuration.LLMConfig{Provider: configuration.LLMProviderFake, Model: "gpt-4o", Script: filepath.Join(t.TempDir(), "missing.yaml")}, newTestLogger())
+	assert.ErrorContains(t, err, "failed to read script")
+
+	for name, tc := range map[string]struct {
+		script string
+		error  string
+	}{
+		"empty":        {"", "no turns"},
+		"unknown key":  {"turns:\n  - toolCall: []", "field toolCall not found"},
+		"no tool call": {"turns:\n  - text: hi", "turn 1: at least one tool call is required"},
+		"no name":      {"turns:\n  - toolCalls: [{arguments: {a: 1}}]", "turn 1: tool call without a name"},
+		"bad match":    {"turns:\n  - match: '('\n    toolCalls: [{name: a}]", "turn 1: invalid match"},
+		"negative":     {"turns:\n  - turn: -1\n    toolCalls: [{name: a}]", "turn 1: turn must not be negative"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadFakeScript(writeScript(t, tc.script))
+			assert.ErrorContains(t, err, tc.error)
+		})
+	}
+}
@@ -1,14 +1,17 @@
 // Package llm_service provides functionality for interacting with large language model (LLM) services.
-// Responsibility: Interacting with various LLM providers (OpenAI, Anthropic)
+// Responsibility: Interacting with various LLM providers (OpenAI, Anthropic, and a scripted fake for offline tests)
 // Features: Sends requests, processes responses, formats prompts, supports retry strategy
 package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
 	"github.com/korchasa/speelka-agent-go/internal/llm/cost"
 	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/korchasa/speelka-agent-go/internal/metrics"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
 	"github.com/korchasa/speelka-agent-go/internal/types"
 	"github.com/korchasa/speelka-agent-go/internal/utils/dump"
 	"github.com/korchasa/speelka-agent-go/internal/utils/tools"
@@ -30,6 +33,16 @@ type LLMService struct {
 	client     llms.Model
 	logger     loggerSpec
 	calculator calculatorSpec
+	metrics    metricsSpec     // optional
+	tracer     *tracing.Tracer // nil disables tracing
+}
+
+// metricsSpec records the metrics of LLM requests.
+type metricsSpec interface {
+	// LLMRequest records a finished request with its token usage and cost.
+	LLMRequest(model, outcome string, duration time.Duration, promptTokens, completionTokens, reasoningTokens int, cost float64)
+	// LLMRetry records a retry of a request.
+	LLMRetry(model string)
 }
 
 type calculatorSpec interface {
@@ -66,7 +79,7 @@ func NewLLMService(cfg configuration.LLMConfig, logger loggerSpec) (*LLMService,
 			error_handling.ErrorCategoryValidation,
 		)
 	}
-	if cfg.APIKey == "" {
+	if cfg.APIKey == "" && cfg.Provider != configuration.LLMProviderFake {
 		return nil, error_handling.NewError(
 			"API key is required",
 			error_handling.ErrorCategoryValidation,
@@ -100,6 +113,16 @@ func NewLLMService(cfg configuration.LLMConfig, logger loggerSpec) (*LLMService,
 				error_handling.ErrorCategoryInternal,
 			)
 		}
+	case configuration.LLMProviderFake:
+		script, err := loadFakeScript(s.config.Script)
+		if err != nil {
+			return nil, error_handling.WrapError(
+				err,
+				"failed to initialize fake client",
+				error_handling.ErrorCategoryValidation,
+			)
+		}
+		s.client = newFakeModel(script)
 	default:
 		return nil, error_handling.NewError(
 			fmt.Sprintf("unsupported provider: %s", s.config.Provider),
@@ -113,9 +136,19 @@ func NewLLMService(cfg configuration.LLMConfig, logger loggerSpec) (*LLMService,
 
 }
 
+// SetMetrics makes the service record the metrics of its requests.
+func (s *LLMService) SetMetrics(m metricsSpec) {
+	s.metrics = m
+}
+
+// SetTracer makes the service trace each attempt of its requests.
+func (s *LLMService) SetTracer(t *tracing.Tracer) {
+	s.tracer = t
+}
+
 // SendRequest sends a request to the LLM with the given prompt and tools
 // Responsibility: Communication with the LLM API and getting a response
-// Features: Uses a retry strategy to handle transient errors
+// Features: Uses a retry strategy to handle transient errors, stops as soon as the context is cancelled
 func (s *LLMService) SendRequest(ctx context.Context, messages []llms.MessageContent, toolsForLLM []mcp.Tool) (llmtypes.LLMResponse, error) {
 	if s.client == nil {
 		return llmtypes.LLMResponse{}, error_handling.NewError(
@@ -123,6 +156,13 @@ func (s *LLMService) SendRequest(ctx context.Context, messages []llms.MessageCon
 			error_handling.ErrorCategoryValidation,
 		)
 	}
+	if err := ctx.Err(); err != nil {
+		return llmtypes.LLMResponse{}, error_handling.WrapError(
+			err,
+			"LLM request cancelled",
+			error_handling.ErrorCategoryCancelled,
+		)
+	}
 
 	llmTools, err := tools.ConvertToolsToLLM(toolsForLLM)
 	if err != nil {
@@ -140,8 +180,17 @@ func (s *LLMService) SendRequest(ctx context.Context, messages []llms.MessageCon
 	var response *llms.ContentResponse
 	var message string
 	var llmsCalls []llms.ToolCall
-	sendFn := func() error {
-		var err error
+	attempt := 0
+	sendFn := func() (err error) {
+		attempt++
+		attemptCtx, span := s.tracer.Start(ctx, "llm.request", tracing.KindClient)
+		span.SetAttribute("llm.model", s.config.Model)
+		span.SetAttribute("llm.provider", s.config.Provider)
+		span.SetAttribute("llm.attempt", attempt)
+		defer func() {
+			span.RecordError(err)
+			span.End()
+		}()
 		// Prepare options for LLM
 		options := []llms.CallOption{
 			llms.WithTools(llmTools),
@@ -176,10 +225,15 @@ func (s *LLMService) SendRequest(ctx context.Context, messages []llms.MessageCon
 			joinedDetails,
 		)
 		startGen := time.Now()
-		response, err = s.client.GenerateContent(ctx, messages, options...)
+		response, err = s.client.GenerateContent(attemptCtx, messages, options...)
 		genDuration := time.Since(startGen)
 		if err != nil {
 			s.logger.Errorf("<< [LLM] GenerateContent error after %v: %v", genDuration, err)
+			// Keep the category of errors the client already classified, like a script of the fake provider that ran out
+			var appErr *error_handling.AppError
+			if errors.As(err, &appErr) {
+				return err
+			}
 			// Wrap the error to categorize it as transient for retry attempts
 			return error_handling.WrapError(
 				err,
@@ -213,9 +267,21 @@ func (s *LLMService) SendRequest(ctx context.Context, messages []llms.MessageCon
 		InitialBackoff:    time.Duration(s.config.RetryConfig.InitialBackoff * float64(time.Second)),
 		BackoffMultiplier: s.config.RetryConfig.BackoffMultiplier,
 		MaxBackoff:        time.Duration(s.config.RetryConfig.MaxBackoff * float64(time.Second)),
+		OnRetry: func(int, error) {
+			if s.metrics != nil {
+				s.metrics.LLMRetry(s.config.Model)
+			}
+		},
 	})
 	durationMs := time.Since(startTime).Milliseconds()
 	if err != nil {
+		if s.metrics != nil {
+			outcome := metrics.OutcomeError
+			if error_handling.IsCancelled(err) {
+				outcome = metrics.OutcomeCancelled
+			}
+			s.metrics.LLMRequest(s.config.Model, outcome, time.Since(startTime), 0, 0, 0, 0)
+		}
 		// Clean confidential information from the error
 		sanitizedErr := error_handling.SanitizeError(err)
 		return llmtypes.LLMResponse{}, sanitizedErr
@@ -294,5 +360,8 @@ func (s *LLMService) SendRequest(ctx context.Context, messages []llms.MessageCon
 		}
 		llmResp.Metadata.Cost = amount
 	}
+	if s.metrics != nil {
+		s.metrics.LLMRequest(s.config.Model, metrics.OutcomeOK, time.Since(startTime), promptTokens, completionTokens, reasoningTokens, llmResp.Metadata.Cost)
+	}
 	return llmResp, nil
 }
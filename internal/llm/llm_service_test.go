@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"testing"
+	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
 	"github.com/korchasa/speelka-agent-go/internal/llm/cost"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/sirupsen/logrus"
@@ -154,3 +156,37 @@ func TestLLMService_SendRequest_ConvertToolsError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to convert tools to LLM tools")
 	assert.Empty(t, resp.Text)
 }
+
+type blockingLLM struct {
+	llms.Model
+}
+
+func (m *blockingLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestLLMService_SendRequest_Cancelled(t *testing.T) {
+	svc := &LLMService{
+		client: &blockingLLM{},
+		logger: newTestLogger(),
+		config: configuration.LLMConfig{RetryConfig: configuration.RetryConfig{
+			MaxRetries:        3,
+			InitialBackoff:    1,
+			BackoffMultiplier: 2,
+			MaxBackoff:        5,
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := svc.SendRequest(ctx, []llms.MessageContent{}, []mcp.Tool{})
+	assert.True(t, error_handling.IsCancelled(err), "expected cancelled error, got %v", err)
+	assert.Less(t, time.Since(start), time.Second, "cancelled request must not be retried")
+
+	_, err = svc.SendRequest(ctx, []llms.MessageContent{}, []mcp.Tool{})
+	assert.True(t, error_handling.IsCancelled(err))
+}
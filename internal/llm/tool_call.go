@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+
+	llmtypes "github.com/korchasa/speelka-agent-go/internal/llm/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// requesterSpec sends requests to the LLM.
+type requesterSpec interface {
+	SendRequest(ctx context.Context, messages []llms.MessageContent, tools []mcp.Tool) (llmtypes.LLMResponse, error)
+}
+
+// AskForToolCall sends the messages with the tool as the only one available and returns the arguments of the call.
+// The LLM service always asks the provider for a tool call, so a request that wants a plain answer, such as a
+// summary or a verdict, offers one tool whose arguments hold that answer.
+// The metadata is returned even when the LLM did not call the tool, since the request has been paid for.
+func AskForToolCall(ctx context.Context, svc requesterSpec, messages []llms.MessageContent, tool mcp.Tool) (map[string]any, llmtypes.LLMResponseMetadata, error) {
+	resp, err := svc.SendRequest(ctx, messages, []mcp.Tool{tool})
+	if err != nil {
+		return nil, resp.Metadata, err
+	}
+	for _, call := range resp.Calls {
+		if call.ToolName() != tool.Name {
+			continue
+		}
+		args, _ := call.Params.Arguments.(map[string]any)
+		if args == nil {
+			args = map[string]any{}
+		}
+		return args, resp.Metadata, nil
+	}
+	return nil, resp.Metadata, fmt.Errorf("the LLM did not call `%s`", tool.Name)
+}
@@ -0,0 +1,27 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestAskForToolCall(t *testing.T) {
+	tool := mcp.NewTool("save_summary", mcp.WithString("text", mcp.Required()))
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "Summarize")}
+
+	svc := newFakeService(t, "turns:\n  - toolCalls: [{name: save_summary, arguments: {text: short}}]\n    usage: {promptTokens: 1000, completionTokens: 100}\n")
+	args, meta, err := AskForToolCall(context.Background(), svc, messages, tool)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"text": "short"}, args)
+	assert.Equal(t, 1100, meta.Tokens.TotalTokens)
+
+	svc = newFakeService(t, "turns:\n  - toolCalls: [{name: finish}]\n    usage: {promptTokens: 1000, completionTokens: 100}\n")
+	_, meta, err = AskForToolCall(context.Background(), svc, messages, tool)
+	assert.ErrorContains(t, err, "the LLM did not call `save_summary`")
+	assert.Equal(t, 1100, meta.Tokens.TotalTokens, "the request is accounted for")
+}
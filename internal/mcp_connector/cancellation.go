@@ -0,0 +1,87 @@
+// Package mcp_connector: propagation of cancelled tool calls to MCP servers
+package mcp_connector
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	cancelledNotificationMethod = "notifications/cancelled"
+	// cancelNotificationTimeout bounds the delivery of notifications/cancelled, the request context is already done by then
+	cancelNotificationTimeout = 5 * time.Second
+)
+
+// cancellableTransport wraps an MCP client transport and tells the server about abandoned tool calls.
+// Responsibility: Sending notifications/cancelled when the context of an in-flight tools/call ends
+// Features: Covers both cancellation by the caller and the per-server call timeout
+type cancellableTransport struct {
+	transport.Interface
+	serverID string
+	log      *logrus.Logger
+}
+
+// newCancellableTransport wraps the transport of the server with the given ID.
+func newCancellableTransport(inner transport.Interface, serverID string, log *logrus.Logger) *cancellableTransport {
+	return &cancellableTransport{
+		Interface: inner,
+		serverID:  serverID,
+		log:       log,
+	}
+}
+
+// SendRequest sends the request and, if its context ends before the response arrives, cancels it on the server.
+func (t *cancellableTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	resp, err := t.Interface.SendRequest(ctx, request)
+	if err != nil && ctx.Err() != nil && request.Method == string(mcp.MethodToolsCall) {
+		t.notifyCancelled(request.ID, ctx.Err().Error())
+	}
+	return resp, err
+}
+
+// notifyCancelled sends notifications/cancelled for the request with the given ID.
+func (t *cancellableTransport) notifyCancelled(id mcp.RequestId, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelNotificationTimeout)
+	defer cancel()
+	notification := mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: cancelledNotificationMethod,
+			Params: mcp.NotificationParams{
+				AdditionalFields: map[string]any{
+					"requestId": id,
+					"reason":    reason,
+				},
+			},
+		},
+	}
+	if err := t.Interface.SendNotification(ctx, notification); err != nil {
+		t.log.Warnf("[MCP-CONNECT] failed to cancel request %s on server '%s': %v", id.String(), t.serverID, err)
+		return
+	}
+	t.log.Infof("[MCP-CONNECT] request %s cancelled on server '%s': %s", id.String(), t.serverID, reason)
+}
+
+// Stderr returns the stderr of the wrapped stdio transport, or nil for other transports.
+func (t *cancellableTransport) Stderr() io.Reader {
+	if stdio, ok := t.Interface.(*transport.Stdio); ok {
+		return stdio.Stderr()
+	}
+	return nil
+}
+
+// clientStderr returns the stderr of the server process behind a stdio client.
+func clientStderr(c *client.Client) (io.Reader, bool) {
+	if t, ok := c.GetTransport().(interface{ Stderr() io.Reader }); ok {
+		if stderr := t.Stderr(); stderr != nil {
+			return stderr, true
+		}
+	}
+	return nil, false
+}
@@ -3,16 +3,21 @@ package mcp_connector
 
 import (
 	"context"
-	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+
 	"github.com/korchasa/speelka-agent-go/internal/error_handling"
 	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/pkg/errors"
 )
 
-// ConnectServer connects to an MCP server using HTTP or stdio transport.
+// ConnectServer connects to an MCP server using stdio, SSE or Streamable HTTP transport.
 // Delegates to transport-specific methods.
 func (mc *MCPConnector) ConnectServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
 	startTime := time.Now()
@@ -21,7 +26,7 @@ func (mc *MCPConnector) ConnectServer(ctx context.Context, serverID string, serv
 	var err error
 
 	switch {
-	case serverConfig.Command != "":
+	case serverConfig.Transport == configuration.TransportStdio || (serverConfig.Transport == "" && serverConfig.Command != ""):
 		mcpClient, err = mc.connectStdioServer(ctx, serverID, serverConfig)
 		if err != nil {
 			return nil, error_handling.WrapError(
@@ -51,72 +56,112 @@ func (mc *MCPConnector) ConnectServer(ctx context.Context, serverID string, serv
 	return mcpClient, nil
 }
 
-// connectStdioServer creates and initializes a stdio MCP client, saves capabilities, and sets up logging.
+// connectStdioServer creates and initializes a stdio MCP client.
 func (mc *MCPConnector) connectStdioServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
 	mc.log.Debugf("[MCP-CONNECT] connectStdioServer: serverID='%s', command='%s', args=%v, env=%v", serverID, serverConfig.Command, serverConfig.Args, serverConfig.Environment)
-	mcpClient, err := client.NewStdioMCPClient(
+	stdioTransport := transport.NewStdio(
 		serverConfig.Command,
 		serverConfig.Environment,
 		serverConfig.Args...,
 	)
+	return mc.initializeClient(ctx, serverID, stdioTransport, serverConfig, "connectStdioServer")
+}
+
+// connectHTTPServer creates and initializes an HTTP MCP client with the configured transport.
+// Without a configured transport, a URL ending in `/sse` uses SSE; any other URL tries Streamable HTTP first
+// and falls back to SSE for servers that only speak the older protocol.
+func (mc *MCPConnector) connectHTTPServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
+	switch serverConfig.Transport {
+	case configuration.TransportSSE:
+		return mc.connectSSEServer(ctx, serverID, serverConfig)
+	case configuration.TransportStreamableHTTP:
+		return mc.connectStreamableHTTPServer(ctx, serverID, serverConfig)
+	}
+	if strings.HasSuffix(strings.TrimRight(serverConfig.URL, "/"), "/sse") {
+		return mc.connectSSEServer(ctx, serverID, serverConfig)
+	}
+	mcpClient, err := mc.connectStreamableHTTPServer(ctx, serverID, serverConfig)
+	if err == nil || ctx.Err() != nil {
+		return mcpClient, err
+	}
+	mc.log.Infof("[MCP-CONNECT] Server '%s' does not accept Streamable HTTP (%v), falling back to SSE", serverID, err)
+	return mc.connectSSEServer(ctx, serverID, serverConfig)
+}
+
+// connectSSEServer creates and initializes an HTTP+SSE MCP client.
+func (mc *MCPConnector) connectSSEServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
+	headers := httpHeaders(serverConfig)
+	mc.log.Debugf("[MCP-CONNECT] connectSSEServer: serverID='%s', url='%s', headers=%v", serverID, serverConfig.URL, headerNames(headers))
+	sseTransport, err := transport.NewSSE(
+		serverConfig.URL,
+		transport.WithHeaders(headers),
+	)
 	if err != nil {
-		mc.log.Errorf("[MCP-CONNECT] [ERROR] connectStdioServer: failed to create client for server '%s': %v", serverID, err)
+		mc.log.Errorf("[MCP-CONNECT] [ERROR] connectSSEServer: failed to create client for server '%s': %v", serverID, err)
 		return nil, error_handling.WrapError(
 			err,
-			"failed to create stdio MCP client",
+			"failed to create HTTP MCP client",
 			error_handling.ErrorCategoryExternal,
 		)
 	}
-	initCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	initRequest := mcp.InitializeRequest{}
-	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
-	initRequest.Params.ClientInfo = mcp.Implementation{
-		Name:    "speelka-agent",
-		Version: "1.0.0",
-	}
-	initStart := time.Now()
-	mc.log.Debugf("[MCP-CONNECT] connectStdioServer: initializing client for server '%s' at %s", serverID, initStart.Format(time.RFC3339Nano))
-	initResult, err := mcpClient.Initialize(initCtx, initRequest)
-	initDuration := time.Since(initStart)
+	return mc.initializeClient(ctx, serverID, sseTransport, serverConfig, "connectSSEServer")
+}
+
+// connectStreamableHTTPServer creates and initializes a Streamable HTTP MCP client.
+// The client resumes broken response streams and starts a new session when the server ends the old one.
+func (mc *MCPConnector) connectStreamableHTTPServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
+	headers := httpHeaders(serverConfig)
+	mc.log.Debugf("[MCP-CONNECT] connectStreamableHTTPServer: serverID='%s', url='%s', headers=%v", serverID, serverConfig.URL, headerNames(headers))
+	httpTransport, err := newStreamableTransport(serverConfig.URL, headers, serverID, mc.log)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			mc.log.Errorf("[MCP-CONNECT] [ERROR] connectStdioServer: initialization for server '%s' timed out after %s", serverID, initDuration)
-		} else {
-			mc.log.Errorf("[MCP-CONNECT] [ERROR] connectStdioServer: initialization for server '%s' failed after %s: %v", serverID, initDuration, err)
-		}
+		mc.log.Errorf("[MCP-CONNECT] [ERROR] connectStreamableHTTPServer: failed to create client for server '%s': %v", serverID, err)
 		return nil, error_handling.WrapError(
 			err,
-			"failed to initialize MCP client",
-			error_handling.ErrorCategoryInternal,
+			"failed to create Streamable HTTP MCP client",
+			error_handling.ErrorCategoryExternal,
 		)
 	}
-	mc.log.Debugf("[MCP-CONNECT] connectStdioServer: initialization for server '%s' completed in %s", serverID, initDuration)
-	mc.log.Debugf("[MCP-CONNECT] connectStdioServer: acquiring dataLock for server '%s' at %s", serverID, time.Now().Format(time.RFC3339Nano))
-	mc.dataLock.Lock()
-	mc.capabilities[serverID] = initResult.Capabilities
-	mc.dataLock.Unlock()
-	mc.log.Debugf("[MCP-CONNECT] connectStdioServer: capabilities for server '%s' set to %v", serverID, initResult.Capabilities)
-	mc.setupLoggingRoute(serverID, mcpClient, initResult.Capabilities, serverConfig)
+	sessions := newSessionTransport(httpTransport, serverID, mc.log)
+	mcpClient, err := mc.initializeClient(ctx, serverID, sessions, serverConfig, "connectStreamableHTTPServer")
+	if err != nil {
+		return nil, err
+	}
+	mc.log.Infof("[MCP-CONNECT] Server '%s' opened session %q", serverID, sessions.GetSessionId())
 	return mcpClient, nil
 }
 
-// connectHTTPServer creates and initializes an HTTP/SSE MCP client, saves capabilities, and sets up logging.
-func (mc *MCPConnector) connectHTTPServer(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
-	headers := make(map[string]string)
+// httpHeaders returns the headers sent to an HTTP server: the APIKey authorization and the configured headers.
+func httpHeaders(serverConfig configuration.MCPServerConnection) map[string]string {
+	headers := make(map[string]string, len(serverConfig.Headers)+1)
 	if serverConfig.APIKey != "" {
 		headers["Authorization"] = "Bearer " + serverConfig.APIKey
 	}
-	mc.log.Debugf("[MCP-CONNECT] connectHTTPServer: serverID='%s', url='%s', headers=%v", serverID, serverConfig.URL, headers)
-	mcpClient, err := client.NewSSEMCPClient(
-		serverConfig.URL,
-		client.WithHeaders(headers),
-	)
-	if err != nil {
-		mc.log.Errorf("[MCP-CONNECT] [ERROR] connectHTTPServer: failed to create client for server '%s': %v", serverID, err)
+	for name, value := range serverConfig.Headers {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return headers
+}
+
+// headerNames returns the sorted names of the headers, for logging without their values.
+func headerNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initializeClient starts the transport, initializes the MCP session, saves capabilities, and sets up logging.
+// caller names the connect method in the log.
+func (mc *MCPConnector) initializeClient(ctx context.Context, serverID string, tr transport.Interface, serverConfig configuration.MCPServerConnection, caller string) (client.MCPClient, error) {
+	mcpClient := client.NewClient(newCancellableTransport(tr, serverID, mc.log))
+	// The transport outlives the connect call, so it is not started with ctx
+	if err := mcpClient.Start(context.Background()); err != nil {
+		mc.log.Errorf("[MCP-CONNECT] [ERROR] %s: failed to start transport for server '%s': %v", caller, serverID, err)
 		return nil, error_handling.WrapError(
 			err,
-			"failed to create HTTP MCP client",
+			"failed to start MCP transport",
 			error_handling.ErrorCategoryExternal,
 		)
 	}
@@ -129,26 +174,28 @@ func (mc *MCPConnector) connectHTTPServer(ctx context.Context, serverID string,
 		Version: "1.0.0",
 	}
 	initStart := time.Now()
-	mc.log.Debugf("[MCP-CONNECT] connectHTTPServer: initializing client for server '%s' at %s", serverID, initStart.Format(time.RFC3339Nano))
+	mc.log.Debugf("[MCP-CONNECT] %s: initializing client for server '%s' at %s", caller, serverID, initStart.Format(time.RFC3339Nano))
 	initResult, err := mcpClient.Initialize(initCtx, initRequest)
 	initDuration := time.Since(initStart)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
-			mc.log.Errorf("[MCP-CONNECT] [ERROR] connectHTTPServer: initialization for server '%s' timed out after %s", serverID, initDuration)
+			mc.log.Errorf("[MCP-CONNECT] [ERROR] %s: initialization for server '%s' timed out after %s", caller, serverID, initDuration)
 		} else {
-			mc.log.Errorf("[MCP-CONNECT] [ERROR] connectHTTPServer: initialization for server '%s' failed after %s: %v", serverID, initDuration, err)
+			mc.log.Errorf("[MCP-CONNECT] [ERROR] %s: initialization for server '%s' failed after %s: %v", caller, serverID, initDuration, err)
 		}
+		_ = mcpClient.Close()
 		return nil, error_handling.WrapError(
 			err,
 			"failed to initialize MCP client",
 			error_handling.ErrorCategoryInternal,
 		)
 	}
-	mc.log.Debugf("[MCP-CONNECT] connectHTTPServer: initialization for server '%s' completed in %s", serverID, initDuration)
-	mc.log.Debugf("[MCP-CONNECT] connectHTTPServer: acquiring dataLock for server '%s' at %s", serverID, time.Now().Format(time.RFC3339Nano))
+	mc.log.Debugf("[MCP-CONNECT] %s: initialization for server '%s' completed in %s", caller, serverID, initDuration)
+	mc.log.Debugf("[MCP-CONNECT] %s: acquiring dataLock for server '%s' at %s", caller, serverID, time.Now().Format(time.RFC3339Nano))
 	mc.dataLock.Lock()
 	mc.capabilities[serverID] = initResult.Capabilities
 	mc.dataLock.Unlock()
+	mc.log.Debugf("[MCP-CONNECT] %s: capabilities for server '%s' set to %v", caller, serverID, initResult.Capabilities)
 	mc.setupLoggingRoute(serverID, mcpClient, initResult.Capabilities, serverConfig)
 	return mcpClient, nil
 }
@@ -0,0 +1,297 @@
+package mcp_connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamableServer is a minimal in-process MCP server speaking the Streamable HTTP transport.
+// It answers tools/call with an SSE stream and everything else with JSON.
+// With breakStreams, the stream of tools/call ends after a progress event, and the response is sent on the
+// GET that resumes it. With notifications, a GET without Last-Event-ID opens a stream that carries them.
+type streamableServer struct {
+	mu            sync.Mutex
+	sessions      map[string]bool
+	nextSession   int
+	initializes   int
+	lastHeaders   http.Header
+	seenSessions  []string
+	breakStreams  bool
+	pending       map[string][]byte // session ID -> response not sent on the broken stream
+	lastEventIDs  []string
+	toolNames     []string
+	notifications chan string
+}
+
+func newStreamableServer(t *testing.T) (*streamableServer, *httptest.Server) {
+	s := &streamableServer{sessions: map[string]bool{}, pending: map[string][]byte{}, toolNames: []string{"echo"}}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// expireSessions forgets all sessions, as a restarted server would.
+func (s *streamableServer) expireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = map[string]bool{}
+}
+
+func (s *streamableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.serveGet(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		delete(s.sessions, sessionID)
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg struct {
+		ID     *json.RawMessage `json:"id"`
+		Method string           `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lastHeaders = r.Header.Clone()
+
+	if msg.Method == string(mcp.MethodInitialize) {
+		s.initializes++
+		s.nextSession++
+		sessionID = fmt.Sprintf("session-%d", s.nextSession)
+		s.sessions[sessionID] = true
+		w.Header().Set("Mcp-Session-Id", sessionID)
+		writeJSONRPC(w, msg.ID, map[string]any{
+			"protocolVersion": mcp.LATEST_PROTOCOL_VERSION,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "streamable", "version": "1.0.0"},
+		})
+		return
+	}
+	if !s.sessions[sessionID] {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	s.seenSessions = append(s.seenSessions, sessionID)
+	if msg.ID == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	switch msg.Method {
+	case string(mcp.MethodToolsList):
+		tools := make([]map[string]any, 0, len(s.toolNames))
+		for _, name := range s.toolNames {
+			tools = append(tools, map[string]any{"name": name, "inputSchema": map[string]any{"type": "object"}})
+		}
+		writeJSONRPC(w, msg.ID, map[string]any{"tools": tools})
+	case string(mcp.MethodToolsCall):
+		result, _ := json.Marshal(map[string]any{
+			"jsonrpc": mcp.JSONRPC_VERSION,
+			"id":      msg.ID,
+			"result":  map[string]any{"content": []map[string]any{{"type": "text", "text": "echo from " + sessionID}}},
+		})
+		w.Header().Set("Content-Type", "text/event-stream")
+		if s.breakStreams {
+			s.pending[sessionID] = result
+			_, _ = fmt.Fprintf(w, "id: 1\nevent: message\ndata: %s\n\n",
+				`{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":1,"progress":1}}`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", result)
+	default:
+		writeJSONRPC(w, msg.ID, map[string]any{})
+	}
+}
+
+// serveGet resumes a broken tools/call stream, or streams the notifications until the client leaves.
+func (s *streamableServer) serveGet(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	s.mu.Lock()
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		defer s.mu.Unlock()
+		response, ok := s.pending[sessionID]
+		if !ok {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		delete(s.pending, sessionID)
+		s.lastEventIDs = append(s.lastEventIDs, lastEventID)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprintf(w, "id: 2\nevent: message\ndata: %s\n\n", response)
+		return
+	}
+	notifications := s.notifications
+	s.mu.Unlock()
+	if notifications == nil {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case method := <-notifications:
+			_, _ = fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":%q}\n\n", method)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func writeJSONRPC(w http.ResponseWriter, id *json.RawMessage, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": mcp.JSONRPC_VERSION, "id": id, "result": result})
+}
+
+func newHTTPConnector(t *testing.T, srv configuration.MCPServerConnection) *MCPConnector {
+	t.Helper()
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{
+		McpServers: map[string]configuration.MCPServerConnection{"srv": srv},
+	}, log)
+	require.NoError(t, mc.InitAndConnectToMCPs(context.Background()))
+	t.Cleanup(func() { _ = mc.Close() })
+	return mc
+}
+
+func callEcho(t *testing.T, mc *MCPConnector) string {
+	t.Helper()
+	call := types.CallToolRequest{}
+	call.Params.Name = "echo"
+	result, err := mc.ExecuteTool(context.Background(), call)
+	require.NoError(t, err)
+	require.Len(t, result.Content, 1)
+	return result.Content[0].(mcp.TextContent).Text
+}
+
+func TestConnectServer_StreamableHTTP(t *testing.T) {
+	fake, ts := newStreamableServer(t)
+	mc := newHTTPConnector(t, configuration.MCPServerConnection{
+		Transport: configuration.TransportStreamableHTTP,
+		URL:       ts.URL + "/mcp",
+		APIKey:    "key",
+		Headers:   map[string]string{"x-tenant": "acme"},
+	})
+
+	tools, err := mc.GetAllTools(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tools, 1)
+	assert.Equal(t, "echo", tools[0].Name)
+	assert.Equal(t, "echo from session-1", callEcho(t, mc), "the SSE response is read")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, "acme", fake.lastHeaders.Get("X-Tenant"))
+	assert.Equal(t, "Bearer key", fake.lastHeaders.Get("Authorization"))
+	assert.NotEmpty(t, fake.seenSessions)
+	for _, id := range fake.seenSessions {
+		assert.Equal(t, "session-1", id, "requests carry the session ID")
+	}
+}
+
+func TestConnectServer_StreamableHTTP_ResumesEndedSession(t *testing.T) {
+	fake, ts := newStreamableServer(t)
+	mc := newHTTPConnector(t, configuration.MCPServerConnection{URL: ts.URL + "/mcp"})
+	assert.Equal(t, "echo from session-1", callEcho(t, mc))
+
+	fake.expireSessions()
+
+	assert.Equal(t, "echo from session-2", callEcho(t, mc), "the call is repeated in a new session")
+	assert.Equal(t, "echo from session-2", callEcho(t, mc))
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, 2, fake.initializes)
+}
+
+func TestConnectServer_StreamableHTTP_ResumesBrokenStream(t *testing.T) {
+	fake, ts := newStreamableServer(t)
+	fake.breakStreams = true
+	mc := newHTTPConnector(t, configuration.MCPServerConnection{URL: ts.URL + "/mcp"})
+
+	assert.Equal(t, "echo from session-1", callEcho(t, mc), "the response comes on the resumed stream")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, []string{"1"}, fake.lastEventIDs, "the stream is resumed after the last event")
+	assert.Equal(t, 1, fake.initializes, "the session is kept")
+}
+
+func TestConnectServer_StreamableHTTP_ListensForNotifications(t *testing.T) {
+	fake, ts := newStreamableServer(t)
+	fake.notifications = make(chan string)
+	mc := newHTTPConnector(t, configuration.MCPServerConnection{URL: ts.URL + "/mcp"})
+
+	fake.mu.Lock()
+	fake.toolNames = []string{"echo", "reverse"}
+	fake.mu.Unlock()
+	select {
+	case fake.notifications <- string(mcp.MethodNotificationToolsListChanged):
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not open the notification stream")
+	}
+
+	assert.Eventually(t, func() bool {
+		tools, err := mc.GetAllTools(context.Background())
+		return err == nil && len(tools) == 2
+	}, 5*time.Second, 10*time.Millisecond, "the tools are listed again after the notification")
+}
+
+func TestConnectServer_FallsBackToSSE(t *testing.T) {
+	mcpServer := server.NewMCPServer("legacy", "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo over sse"), nil
+	})
+	ts := server.NewTestServer(mcpServer, server.WithSSEEndpoint("/events"))
+	// Registered before the connector's cleanup, so the SSE stream is closed first
+	t.Cleanup(ts.Close)
+
+	mc := newHTTPConnector(t, configuration.MCPServerConnection{URL: ts.URL + "/events"})
+
+	assert.Equal(t, "echo over sse", callEcho(t, mc))
+}
+
+func TestConnectServer_ExplicitTransportMismatch(t *testing.T) {
+	_, ts := newStreamableServer(t)
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{}, log)
+
+	_, err := mc.ConnectServer(context.Background(), "srv", configuration.MCPServerConnection{
+		Transport: configuration.TransportSSE,
+		URL:       ts.URL + "/mcp",
+	})
+
+	assert.Error(t, err, "an explicit transport is not auto-detected")
+}
+
+func TestHTTPHeaders(t *testing.T) {
+	headers := httpHeaders(configuration.MCPServerConnection{
+		APIKey:  "key",
+		Headers: map[string]string{"authorization": "Token other", "x-tenant": "acme"},
+	})
+	assert.Equal(t, map[string]string{"Authorization": "Token other", "X-Tenant": "acme"}, headers)
+}
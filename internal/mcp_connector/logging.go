@@ -54,7 +54,7 @@ func (mc *MCPConnector) setupLoggingRoute(serverID string, mcpClient client.MCPC
 	} else if serverConfig.Command != "" {
 		// Fallback: read stderr of child process (only for stdio)
 		if stdioClient, ok := mcpClient.(*client.Client); ok {
-			if stderr, ok := client.GetStderr(stdioClient); ok && stderr != nil {
+			if stderr, ok := clientStderr(stdioClient); ok && stderr != nil {
 				go func() {
 					scanner := bufio.NewScanner(stderr)
 					for scanner.Scan() {
@@ -9,10 +9,14 @@ import (
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
 	"github.com/korchasa/speelka-agent-go/internal/utils/dump"
 	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/error_handling"
+	"github.com/korchasa/speelka-agent-go/internal/metrics"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
 	"github.com/korchasa/speelka-agent-go/internal/types"
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -25,23 +29,67 @@ import (
 type MCPConnector struct {
 	config       configuration.MCPConnectorConfig
 	clients      map[string]client.MCPClient
-	tools        map[string][]mcp.Tool
+	tools        map[string][]mcp.Tool             // allowed tools per server, as listed
+	toolIndex    map[string]string                 // exposed tool name -> server, see indexTools
 	capabilities map[string]mcp.ServerCapabilities // capabilities per server
+	health       map[string]*ServerHealth          // health state per server, see supervisor.go
 	dataLock     sync.RWMutex
 	log          *logrus.Logger
+
+	// connect creates a client for a server; it is ConnectServer except in tests
+	connect        func(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error)
+	checkQueue     chan string
+	queuedChecks   map[string]bool     // servers in checkQueue
+	rechecks       map[string]*recheck // backoff of unreachable servers
+	recheckDelay   time.Duration       // first backoff delay; defaultRecheckDelay except in tests
+	reconnecting   map[string]bool     // servers with a reconnect in progress
+	reconnects     sync.WaitGroup      // running reconnects
+	stopSupervisor context.CancelFunc
+	supervisorDone chan struct{}   // closed when the supervisor returns
+	metrics        metricsSpec     // optional
+	tracer         *tracing.Tracer // nil disables tracing
+}
+
+// metricsSpec records the metrics of tool calls and server health.
+type metricsSpec interface {
+	// ToolCall records a finished call of a tool of a server with its outcome: ok, error, timeout or cancelled.
+	ToolCall(server, tool, outcome string, duration time.Duration)
+	// ServerHealth records the health of a server.
+	ServerHealth(server string, healthy bool)
+	// ServerReconnected records a successful reconnection to a server.
+	ServerReconnected(server string)
 }
 
 // NewMCPConnector creates a new instance of MCPConnector
 // Responsibility: Factory method for creating an MCP connector
 // Features: Returns a simple instance without initialization
 func NewMCPConnector(config configuration.MCPConnectorConfig, log *logrus.Logger) *MCPConnector {
-	return &MCPConnector{
+	mc := &MCPConnector{
 		clients:      make(map[string]client.MCPClient),
 		tools:        make(map[string][]mcp.Tool),
+		toolIndex:    make(map[string]string),
 		capabilities: make(map[string]mcp.ServerCapabilities),
+		health:       make(map[string]*ServerHealth),
+		checkQueue:   make(chan string, checkQueueSize),
+		queuedChecks: make(map[string]bool),
+		rechecks:     make(map[string]*recheck),
+		reconnecting: make(map[string]bool),
+		recheckDelay: defaultRecheckDelay,
 		config:       config,
 		log:          log,
 	}
+	mc.connect = mc.ConnectServer
+	return mc
+}
+
+// SetMetrics makes the connector record the metrics of its tool calls and servers.
+func (mc *MCPConnector) SetMetrics(m metricsSpec) {
+	mc.metrics = m
+}
+
+// SetTracer makes the connector trace its tool calls.
+func (mc *MCPConnector) SetTracer(t *tracing.Tracer) {
+	mc.tracer = t
 }
 
 // InitAndConnectToMCPs connects to all configured MCP servers.
@@ -56,14 +104,78 @@ func (mc *MCPConnector) InitAndConnectToMCPs(ctx context.Context) error {
 		}
 		mc.log.Debugf("[MCP-CONNECT] Finished connectAndRegisterServer: %s at %s", serverID, time.Now().Format(time.RFC3339Nano))
 	}
+	if err := mc.resolveToolCollisions(); err != nil {
+		return err
+	}
 	mc.log.Infof("Connected to %d MCP servers", len(mc.clients))
+	if len(mc.clients) > 0 && mc.stopSupervisor == nil {
+		mc.startSupervisor()
+	}
 	return nil
 }
 
+// resolveToolCollisions rebuilds the tool index and checks that no two servers expose a tool with the same name.
+func (mc *MCPConnector) resolveToolCollisions() error {
+	mc.dataLock.Lock()
+	defer mc.dataLock.Unlock()
+	return mc.indexTools()
+}
+
+// setServerTools replaces the tool list of the server and rebuilds the tool index under one lock, so that
+// no call sees the new list with the old index. Collisions are only logged: the server is already in use.
+// dataLock must be held.
+func (mc *MCPConnector) setServerTools(serverID string, tools []mcp.Tool) {
+	mc.tools[serverID] = tools
+	if err := mc.indexTools(); err != nil {
+		mc.log.Warnf("[MCP-CONNECT] After updating the tools of `%s`: %v", serverID, err)
+	}
+}
+
+// indexTools rebuilds the index of tool names from the tool lists of the servers. dataLock must be held.
+// With ToolCollisionWarn the tool of the server whose ID sorts first is used, so routing never depends on
+// map order. The lists are kept as listed, so a hidden tool comes back once the other server drops its own.
+func (mc *MCPConnector) indexTools() error {
+	index := make(map[string]string)
+	var collisions []string
+	for _, serverID := range mc.serverIDs() {
+		for _, tool := range mc.tools[serverID] {
+			if owner, taken := index[tool.Name]; taken {
+				collisions = append(collisions, fmt.Sprintf("`%s` is exposed by `%s` and `%s`", tool.Name, owner, serverID))
+				continue
+			}
+			index[tool.Name] = serverID
+		}
+	}
+	mc.toolIndex = index
+	if len(collisions) == 0 {
+		return nil
+	}
+	if mc.config.OnToolCollision == configuration.ToolCollisionWarn {
+		for _, collision := range collisions {
+			mc.log.Warnf("Tool name collision: %s; the tool of the first server is used", collision)
+		}
+		return nil
+	}
+	return error_handling.NewError(
+		fmt.Sprintf("tool name collisions: %s; set `toolPrefix` on the servers or `toolNaming: prefixed`", strings.Join(collisions, ", ")),
+		error_handling.ErrorCategoryValidation,
+	)
+}
+
+// serverIDs returns the IDs of the servers with registered tools, sorted.
+func (mc *MCPConnector) serverIDs() []string {
+	ids := make([]string, 0, len(mc.tools))
+	for id := range mc.tools {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // connectAndRegisterServer handles connection and tool registration for a single server.
 func (mc *MCPConnector) connectAndRegisterServer(ctx context.Context, serverID string, srvCfg configuration.MCPServerConnection) error {
 	mc.log.Infof("[MCP-CONNECT] Server config: %s", dump.SDump(srvCfg))
-	mcpClient, err := mc.ConnectServer(ctx, serverID, srvCfg)
+	mcpClient, err := mc.connect(ctx, serverID, srvCfg)
 	if err != nil {
 		return error_handling.WrapError(
 			err,
@@ -72,19 +184,40 @@ func (mc *MCPConnector) connectAndRegisterServer(ctx context.Context, serverID s
 		)
 	}
 
+	mc.watchToolList(serverID, mcpClient)
+	filteredTools, err := mc.listServerTools(ctx, serverID, mcpClient, srvCfg)
+	if err != nil {
+		return err
+	}
+	mc.dataLock.Lock()
+	mc.clients[serverID] = mcpClient
+	mc.tools[serverID] = filteredTools
+	mc.health[serverID] = &ServerHealth{Healthy: true}
+	mc.dataLock.Unlock()
+	if mc.metrics != nil {
+		mc.metrics.ServerHealth(serverID, true)
+	}
+	mc.log.Infof("Connected to MCP server `%s` with %d tools", serverID, len(filteredTools))
+	return nil
+}
+
+// listServerTools returns the allowed tools of the server under their exposed names.
+func (mc *MCPConnector) listServerTools(ctx context.Context, serverID string, mcpClient client.MCPClient, srvCfg configuration.MCPServerConnection) ([]mcp.Tool, error) {
 	toolsResp, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
 	if err != nil {
-		return error_handling.WrapError(
+		return nil, error_handling.WrapError(
 			err,
 			fmt.Sprintf("failed to list tools from MCP server %s", serverID),
 			error_handling.ErrorCategoryExternal,
 		)
 	}
 	filteredTools := mc.filterAllowedTools(serverID, toolsResp.Tools, srvCfg)
-	mc.clients[serverID] = mcpClient
-	mc.tools[serverID] = filteredTools
-	mc.log.Infof("Connected to MCP server `%s` with %d tools", serverID, len(filteredTools))
-	return nil
+	if prefix := mc.config.ToolPrefix(serverID); prefix != "" {
+		for i := range filteredTools {
+			filteredTools[i].Name = prefix + filteredTools[i].Name
+		}
+	}
+	return filteredTools, nil
 }
 
 // filterAllowedTools filters tools based on server config.
@@ -106,9 +239,13 @@ func (mc *MCPConnector) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
 	mc.dataLock.RLock()
 	defer mc.dataLock.RUnlock()
 
-	allTools := make([]mcp.Tool, 0)
-	for _, tools := range mc.tools {
-		allTools = append(allTools, tools...)
+	allTools := make([]mcp.Tool, 0, len(mc.toolIndex))
+	for _, serverID := range mc.serverIDs() {
+		for _, tool := range mc.tools[serverID] {
+			if mc.toolIndex[tool.Name] == serverID {
+				allTools = append(allTools, tool)
+			}
+		}
 	}
 	return allTools, nil
 }
@@ -116,42 +253,109 @@ func (mc *MCPConnector) GetAllTools(ctx context.Context) ([]mcp.Tool, error) {
 // ExecuteTool executes a tool on an MCP server.
 func (mc *MCPConnector) ExecuteTool(ctx context.Context, call types.CallToolRequest) (*mcp.CallToolResult, error) {
 	mc.log.Infof("ExecuteTool called for tool: %s at %s", call.ToolName(), time.Now().Format(time.RFC3339Nano))
+	// The lock is released before the call: a slow server must not hold it, or the supervisor waiting for
+	// the write lock would block every other call behind it
 	mc.dataLock.RLock()
-	defer mc.dataLock.RUnlock()
-
 	serverID, mcpClient, err := mc.findServerAndClient(call.Params.Name)
+	healthy := err == nil && mc.isServerHealthy(serverID)
+	mc.dataLock.RUnlock()
 	if err != nil {
 		mc.log.Warnf("FindServerAndClient failed: %v", err)
 		return nil, err
 	}
+	if !healthy {
+		mc.requestCheck(serverID)
+		return nil, error_handling.NewError(
+			fmt.Sprintf("MCP server `%s` of tool `%s` is unavailable, reconnecting", serverID, call.Params.Name),
+			error_handling.ErrorCategoryExternal,
+		)
+	}
 
 	callTimeout := mc.getCallTimeout(serverID)
 	mc.log.Debugf("[MCP-CONNECT] About to callToolWithTimeout: tool=%s, serverID=%s, timeout=%.2fs, at=%s", call.ToolName(), serverID, callTimeout.Seconds(), time.Now().Format(time.RFC3339Nano))
 	mc.logToolExecutionStart(call, serverID, callTimeout.Seconds())
 
-	result, execErr, timedOut := mc.callToolWithTimeout(ctx, mcpClient, call, callTimeout)
+	// The server knows the tool by the name it exports
+	downstream := call
+	downstream.Params.Name = strings.TrimPrefix(call.Params.Name, mc.config.ToolPrefix(serverID))
+	ctx, span := mc.tracer.Start(ctx, "mcp.tool_call", tracing.KindClient)
+	defer span.End()
+	span.SetAttribute("mcp.server", serverID)
+	span.SetAttribute("mcp.tool", downstream.Params.Name)
+	downstream.Params.Meta = withTraceContext(ctx, call.Params.Meta)
+	start := time.Now()
+	result, execErr, timedOut := mc.callToolWithTimeout(ctx, mcpClient, downstream, callTimeout)
+	outcome := toolCallOutcome(result, execErr, timedOut)
+	span.SetAttribute("mcp.outcome", outcome)
+	span.RecordError(execErr)
+	if mc.metrics != nil {
+		mc.metrics.ToolCall(serverID, downstream.Params.Name, outcome, time.Since(start))
+	}
+	if timedOut || (execErr != nil && ctx.Err() == nil) {
+		// A dead transport shows up as errors or timeouts; let the supervisor find out
+		mc.requestCheck(serverID)
+	}
 	return mc.handleToolExecutionResult(call, serverID, callTimeout.Seconds(), result, execErr, timedOut)
 }
 
-func (mc *MCPConnector) findServerAndClient(toolName string) (string, client.MCPClient, error) {
-	for serverID, serverTools := range mc.tools {
-		for _, tool := range serverTools {
-			if tool.Name == toolName {
-				mcpClient, exists := mc.clients[serverID]
-				if !exists {
-					return "", nil, error_handling.NewError(
-						fmt.Sprintf("not connected to server: %s", serverID),
-						error_handling.ErrorCategoryValidation,
-					)
-				}
-				return serverID, mcpClient, nil
-			}
+// withTraceContext returns a copy of the request meta with the trace context of ctx, so that the spans of
+// the MCP server, e.g. a nested agent, join the trace. The meta is returned as is if there is no trace.
+func withTraceContext(ctx context.Context, meta *mcp.Meta) *mcp.Meta {
+	if !tracing.SpanContextFromContext(ctx).IsValid() {
+		return meta
+	}
+	traced := &mcp.Meta{AdditionalFields: map[string]any{}}
+	if meta != nil {
+		traced.ProgressToken = meta.ProgressToken
+		for key, value := range meta.AdditionalFields {
+			traced.AdditionalFields[key] = value
 		}
 	}
-	return "", nil, error_handling.NewError(
-		fmt.Sprintf("tool `%s` not found", toolName),
-		error_handling.ErrorCategoryValidation,
-	)
+	tracing.Inject(ctx, traced.AdditionalFields)
+	return traced
+}
+
+// toolCallOutcome classifies a finished tool call for the metrics.
+func toolCallOutcome(result *mcp.CallToolResult, execErr error, timedOut bool) string {
+	switch {
+	case timedOut:
+		return metrics.OutcomeTimeout
+	case errors.Is(execErr, context.Canceled):
+		return metrics.OutcomeCancelled
+	case execErr != nil || (result != nil && result.IsError):
+		return metrics.OutcomeError
+	}
+	return metrics.OutcomeOK
+}
+
+// GetToolServerID returns the ID of the server that exposes the tool with the given name.
+func (mc *MCPConnector) GetToolServerID(toolName string) (string, bool) {
+	mc.dataLock.RLock()
+	defer mc.dataLock.RUnlock()
+
+	serverID, _, err := mc.findServerAndClient(toolName)
+	if err != nil {
+		return "", false
+	}
+	return serverID, true
+}
+
+func (mc *MCPConnector) findServerAndClient(toolName string) (string, client.MCPClient, error) {
+	serverID, ok := mc.toolIndex[toolName]
+	if !ok {
+		return "", nil, error_handling.NewError(
+			fmt.Sprintf("tool `%s` not found", toolName),
+			error_handling.ErrorCategoryValidation,
+		)
+	}
+	mcpClient, exists := mc.clients[serverID]
+	if !exists {
+		return "", nil, error_handling.NewError(
+			fmt.Sprintf("not connected to server: %s", serverID),
+			error_handling.ErrorCategoryValidation,
+		)
+	}
+	return serverID, mcpClient, nil
 }
 
 func (mc *MCPConnector) getCallTimeout(serverID string) time.Duration {
@@ -172,6 +376,13 @@ func (mc *MCPConnector) handleToolExecutionResult(call types.CallToolRequest, se
 	}
 	if execErr != nil {
 		mc.logToolError(call, serverID, timeoutSec, execErr)
+		if errors.Is(execErr, context.Canceled) {
+			return nil, error_handling.WrapError(
+				execErr,
+				fmt.Sprintf("call of tool `%s` cancelled", call.Params.Name),
+				error_handling.ErrorCategoryCancelled,
+			)
+		}
 		return nil, error_handling.WrapError(
 			execErr,
 			fmt.Sprintf("failed to call tool `%s`", call.Params.Name),
@@ -190,7 +401,7 @@ func (mc *MCPConnector) logToolExecutionStart(call types.CallToolRequest, server
 	mc.log.Debugf(">>> Details: %s", call.Params.Arguments)
 }
 
-// callToolWithTimeout calls the tool with a timeout.
+// callToolWithTimeout calls the tool with a timeout. It returns early with the context error if ctx is cancelled.
 func (mc *MCPConnector) callToolWithTimeout(ctx context.Context, mcpClient client.MCPClient, call types.CallToolRequest, callTimeout time.Duration) (*mcp.CallToolResult, error, bool) {
 	mc.log.Debugf("[MCP-CONNECT] callToolWithTimeout: tool=%s, timeout=%s, at=%s", call.ToolName(), callTimeout, time.Now().Format(time.RFC3339Nano))
 	ctxWithCancel, cancel := context.WithCancel(ctx)
@@ -224,6 +435,10 @@ func (mc *MCPConnector) callToolWithTimeout(ctx context.Context, mcpClient clien
 		mc.log.Warnf("[MCP-CONNECT] callToolWithTimeout: timeout for tool=%s at %s", call.ToolName(), time.Now().Format(time.RFC3339Nano))
 		cancel()
 		return nil, nil, true
+	case <-ctx.Done():
+		// The deferred cancel stops the client call, and the transport cancels the request on the server
+		mc.log.Warnf("[MCP-CONNECT] callToolWithTimeout: cancelled tool=%s at %s", call.ToolName(), time.Now().Format(time.RFC3339Nano))
+		return nil, ctx.Err(), false
 	}
 }
 
@@ -256,6 +471,12 @@ func (mc *MCPConnector) logToolError(call types.CallToolRequest, serverID string
 
 // Close closes all client connections.
 func (mc *MCPConnector) Close() error {
+	if mc.stopSupervisor != nil {
+		mc.stopSupervisor()
+		// No reconnect starts after the supervisor returns, and the running ones must not swap in new clients
+		<-mc.supervisorDone
+		mc.reconnects.Wait()
+	}
 	mc.log.Debugf("[MCP-CONNECT] Close: acquiring dataLock at %s", time.Now().Format(time.RFC3339Nano))
 	mc.dataLock.Lock()
 	defer mc.dataLock.Unlock()
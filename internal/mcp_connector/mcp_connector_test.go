@@ -9,7 +9,11 @@ import (
 	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
+	"github.com/korchasa/speelka-agent-go/internal/tracing"
 	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -183,7 +187,7 @@ func Test_ExecuteTool_success(t *testing.T) {
 	log, _ := newTestLogger()
 	mc := NewMCPConnector(configuration.MCPConnectorConfig{McpServers: map[string]configuration.MCPServerConnection{"srv": {}}}, log)
 	mc.clients["srv"] = &mockMCPClient{callResult: &mcp.CallToolResult{Result: mcp.Result{Meta: map[string]any{"ok": true}}}}
-	mc.tools["srv"] = []mcp.Tool{{Name: "foo"}}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
 	call := types.CallToolRequest{}
 	call.Params.Name = "foo"
 	res, err := mc.ExecuteTool(context.Background(), call)
@@ -206,7 +210,7 @@ func Test_ExecuteTool_timeout(t *testing.T) {
 	log, _ := newTestLogger()
 	mc := NewMCPConnector(configuration.MCPConnectorConfig{McpServers: map[string]configuration.MCPServerConnection{"srv": {Timeout: 0.01}}}, log)
 	mc.clients["srv"] = &slowClient{}
-	mc.tools["srv"] = []mcp.Tool{{Name: "foo"}}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
 	call := types.CallToolRequest{}
 	call.Params.Name = "foo"
 	_, err := mc.ExecuteTool(context.Background(), call)
@@ -219,7 +223,7 @@ func Test_ExecuteTool_error(t *testing.T) {
 	log, _ := newTestLogger()
 	mc := NewMCPConnector(configuration.MCPConnectorConfig{McpServers: map[string]configuration.MCPServerConnection{"srv": {}}}, log)
 	mc.clients["srv"] = &mockMCPClient{callErr: fmt.Errorf("fail call")}
-	mc.tools["srv"] = []mcp.Tool{{Name: "foo"}}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
 	call := types.CallToolRequest{}
 	call.Params.Name = "foo"
 	_, err := mc.ExecuteTool(context.Background(), call)
@@ -247,8 +251,8 @@ func Test_GetAllTools_emptyAndFilled(t *testing.T) {
 	if len(tools) != 0 {
 		t.Errorf("expected 0 tools, got %d", len(tools))
 	}
-	mc.tools["srv1"] = []mcp.Tool{{Name: "foo"}}
-	mc.tools["srv2"] = []mcp.Tool{{Name: "bar"}}
+	mc.setServerTools("srv1", []mcp.Tool{{Name: "foo"}})
+	mc.setServerTools("srv2", []mcp.Tool{{Name: "bar"}})
 	tools, _ = mc.GetAllTools(context.Background())
 	if len(tools) != 2 {
 		t.Errorf("expected 2 tools, got %d", len(tools))
@@ -289,7 +293,7 @@ func Test_findServerAndClient(t *testing.T) {
 	log, _ := newTestLogger()
 	mc := NewMCPConnector(configuration.MCPConnectorConfig{}, log)
 	mc.clients["srv"] = &mockMCPClient{}
-	mc.tools["srv"] = []mcp.Tool{{Name: "foo"}}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
 	t.Run("found", func(t *testing.T) {
 		serverID, client, err := mc.findServerAndClient("foo")
 		if err != nil || serverID != "srv" || client == nil {
@@ -302,7 +306,7 @@ func Test_findServerAndClient(t *testing.T) {
 			t.Errorf("expected not found error, got %v", err)
 		}
 	})
-	mc.tools["srv2"] = []mcp.Tool{{Name: "baz"}}
+	mc.setServerTools("srv2", []mcp.Tool{{Name: "baz"}})
 	t.Run("no client", func(t *testing.T) {
 		_, _, err := mc.findServerAndClient("baz")
 		if err == nil || err.Error() != "not connected to server: srv2" {
@@ -311,6 +315,19 @@ func Test_findServerAndClient(t *testing.T) {
 	})
 }
 
+func Test_GetToolServerID(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{}, log)
+	mc.clients["srv"] = &mockMCPClient{}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
+	if id, ok := mc.GetToolServerID("foo"); !ok || id != "srv" {
+		t.Errorf("expected srv, got %q, %v", id, ok)
+	}
+	if _, ok := mc.GetToolServerID("bar"); ok {
+		t.Error("expected unknown tool to be not found")
+	}
+}
+
 func Test_getCallTimeout(t *testing.T) {
 	log, _ := newTestLogger()
 	cfg := configuration.MCPConnectorConfig{
@@ -376,3 +393,199 @@ func Test_getCallTimeout_CustomAndDefault(t *testing.T) {
 	timeout = mc.getCallTimeout("unknown")
 	assert.Equal(t, 30*time.Second, timeout)
 }
+
+type blockingClient struct{ mockMCPClient }
+
+func (b *blockingClient) CallTool(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func Test_ExecuteTool_cancelled(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{McpServers: map[string]configuration.MCPServerConnection{"srv": {}}}, log)
+	mc.clients["srv"] = &blockingClient{}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
+	call := types.CallToolRequest{}
+	call.Params.Name = "foo"
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := mc.ExecuteTool(ctx, call)
+	assert.True(t, error_handling.IsCancelled(err), "expected cancelled error, got: %v", err)
+}
+
+// fakeTransport blocks every request until its context ends and records sent notifications.
+type fakeTransport struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (f *fakeTransport) Start(ctx context.Context) error { return nil }
+func (f *fakeTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+func (f *fakeTransport) SendNotification(ctx context.Context, notification mcp.JSONRPCNotification) error {
+	f.notifications <- notification
+	return nil
+}
+func (f *fakeTransport) SetNotificationHandler(handler func(notification mcp.JSONRPCNotification)) {}
+func (f *fakeTransport) Close() error                                                              { return nil }
+
+func Test_cancellableTransport_SendRequest(t *testing.T) {
+	log, _ := newTestLogger()
+	inner := &fakeTransport{notifications: make(chan mcp.JSONRPCNotification, 1)}
+	tr := newCancellableTransport(inner, "srv", log)
+
+	t.Run("tools/call sends notifications/cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := tr.SendRequest(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(int64(7)), Method: string(mcp.MethodToolsCall)})
+		assert.ErrorIs(t, err, context.Canceled)
+		select {
+		case n := <-inner.notifications:
+			assert.Equal(t, cancelledNotificationMethod, n.Method)
+			assert.Equal(t, mcp.NewRequestId(int64(7)), n.Params.AdditionalFields["requestId"])
+		default:
+			t.Fatal("expected notifications/cancelled")
+		}
+	})
+
+	t.Run("other methods are not cancelled on the server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _ = tr.SendRequest(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(int64(8)), Method: string(mcp.MethodToolsList)})
+		assert.Len(t, inner.notifications, 0)
+	})
+}
+
+type namingClient struct {
+	mockMCPClient
+	calledName string
+	calledMeta *mcp.Meta
+}
+
+func (n *namingClient) CallTool(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	n.calledName = req.Params.Name
+	n.calledMeta = req.Params.Meta
+	return mcp.NewToolResultText("ok"), nil
+}
+
+func Test_ExecuteTool_stripsToolPrefix(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{
+		McpServers: map[string]configuration.MCPServerConnection{"github": {}, "fs": {ToolPrefix: "local_"}},
+		ToolNaming: configuration.ToolNamingPrefixed,
+	}, log)
+	github := &namingClient{}
+	fs := &namingClient{}
+	mc.clients["github"] = github
+	mc.clients["fs"] = fs
+	mc.setServerTools("github", []mcp.Tool{{Name: "github__search"}})
+	mc.setServerTools("fs", []mcp.Tool{{Name: "local_search"}})
+
+	call := types.CallToolRequest{}
+	call.Params.Name = "github__search"
+	_, err := mc.ExecuteTool(context.Background(), call)
+	assert.NoError(t, err)
+	assert.Equal(t, "search", github.calledName)
+
+	call.Params.Name = "local_search"
+	_, err = mc.ExecuteTool(context.Background(), call)
+	assert.NoError(t, err)
+	assert.Equal(t, "search", fs.calledName)
+}
+
+func Test_ExecuteTool_propagatesTraceContext(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{McpServers: map[string]configuration.MCPServerConnection{"srv": {}}}, log)
+	srv := &namingClient{}
+	mc.clients["srv"] = srv
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
+	call := types.CallToolRequest{}
+	call.Params.Name = "foo"
+	call.Params.Meta = &mcp.Meta{ProgressToken: "p1"}
+
+	_, err := mc.ExecuteTool(context.Background(), call)
+	assert.NoError(t, err)
+	assert.Same(t, call.Params.Meta, srv.calledMeta, "the meta is passed as is without a trace")
+
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := tracing.Extract(context.Background(), map[string]any{tracing.TraceParentKey: traceParent})
+	_, err = mc.ExecuteTool(ctx, call)
+	assert.NoError(t, err)
+	assert.Equal(t, mcp.ProgressToken("p1"), srv.calledMeta.ProgressToken)
+	assert.Equal(t, traceParent, srv.calledMeta.AdditionalFields[tracing.TraceParentKey], "the trace of the caller reaches the server")
+	assert.Nil(t, call.Params.Meta.AdditionalFields, "the meta of the call is not changed")
+}
+
+func Test_resolveToolCollisions(t *testing.T) {
+	newConnector := func(onCollision string) *MCPConnector {
+		log, _ := newTestLogger()
+		mc := NewMCPConnector(configuration.MCPConnectorConfig{OnToolCollision: onCollision}, log)
+		mc.setServerTools("b", []mcp.Tool{{Name: "search"}, {Name: "read_file"}})
+		mc.setServerTools("a", []mcp.Tool{{Name: "search"}})
+		mc.clients["a"] = &mockMCPClient{}
+		mc.clients["b"] = &mockMCPClient{}
+		return mc
+	}
+
+	t.Run("error by default", func(t *testing.T) {
+		err := newConnector("").resolveToolCollisions()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "`search` is exposed by `a` and `b`")
+	})
+
+	t.Run("warn keeps the first server", func(t *testing.T) {
+		mc := newConnector(configuration.ToolCollisionWarn)
+		assert.NoError(t, mc.resolveToolCollisions())
+		id, ok := mc.GetToolServerID("search")
+		assert.True(t, ok)
+		assert.Equal(t, "a", id)
+		tools, _ := mc.GetAllTools(context.Background())
+		assert.Len(t, tools, 2)
+	})
+}
+
+// recordingMetrics records the metrics reported by the connector.
+type recordingMetrics struct {
+	calls      []string
+	health     map[string]bool
+	reconnects int
+}
+
+func (r *recordingMetrics) ToolCall(server, tool, outcome string, duration time.Duration) {
+	r.calls = append(r.calls, server+"/"+tool+":"+outcome)
+}
+func (r *recordingMetrics) ServerHealth(server string, healthy bool) {
+	if r.health == nil {
+		r.health = make(map[string]bool)
+	}
+	r.health[server] = healthy
+}
+func (r *recordingMetrics) ServerReconnected(server string) { r.reconnects++ }
+
+func Test_ExecuteTool_metrics(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{McpServers: map[string]configuration.MCPServerConnection{"srv": {Timeout: 0.01}}}, log)
+	m := &recordingMetrics{}
+	mc.SetMetrics(m)
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
+	call := types.CallToolRequest{}
+	call.Params.Name = "foo"
+
+	for _, c := range []client.MCPClient{
+		&mockMCPClient{callResult: mcp.NewToolResultText("ok")},
+		&mockMCPClient{callResult: mcp.NewToolResultError("failed")},
+		&mockMCPClient{callErr: fmt.Errorf("fail call")},
+		&slowClient{},
+	} {
+		mc.clients["srv"] = c
+		_, _ = mc.ExecuteTool(context.Background(), call)
+	}
+	mc.clients["srv"] = &blockingClient{}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	_, _ = mc.ExecuteTool(ctx, call)
+
+	assert.Equal(t, []string{"srv/foo:ok", "srv/foo:error", "srv/foo:error", "srv/foo:timeout", "srv/foo:cancelled"}, m.calls)
+}
@@ -0,0 +1,85 @@
+// Package mcp_connector: new sessions for the Streamable HTTP transport
+package mcp_connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+)
+
+const initializedNotificationMethod = "notifications/initialized"
+
+// sessionTransport wraps a Streamable HTTP transport and opens a new session when the server forgets the old one.
+// Responsibility: Keeping a Streamable HTTP connection usable across server restarts and expired sessions
+// Features: Repeats the initialize handshake of the client and retries the failed request once;
+// concurrent requests that hit the same expired session share one new session
+type sessionTransport struct {
+	*streamableTransport
+	serverID string
+	log      *logrus.Logger
+
+	mu          sync.Mutex
+	initRequest *transport.JSONRPCRequest
+}
+
+// newSessionTransport wraps the transport of the server with the given ID.
+func newSessionTransport(inner *streamableTransport, serverID string, log *logrus.Logger) *sessionTransport {
+	return &sessionTransport{
+		streamableTransport: inner,
+		serverID:            serverID,
+		log:                 log,
+	}
+}
+
+// SendRequest sends the request, and if the session has ended, sends it again in a new session.
+func (t *sessionTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	if request.Method == string(mcp.MethodInitialize) {
+		t.mu.Lock()
+		t.initRequest = &request
+		t.mu.Unlock()
+		return t.streamableTransport.SendRequest(ctx, request)
+	}
+
+	resp, err := t.streamableTransport.SendRequest(ctx, request)
+	if err == nil || !errors.Is(err, errSessionTerminated) {
+		return resp, err
+	}
+	if resumeErr := t.resume(ctx); resumeErr != nil {
+		return nil, fmt.Errorf("%v; failed to open a new session: %w", err, resumeErr)
+	}
+	return t.streamableTransport.SendRequest(ctx, request)
+}
+
+// resume repeats the initialize handshake unless another request has already opened a new session.
+func (t *sessionTransport) resume(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.initRequest == nil {
+		return fmt.Errorf("the session was never initialized")
+	}
+	if t.GetSessionId() != "" {
+		return nil
+	}
+	t.log.Warnf("[MCP-CONNECT] Server `%s` ended the session, opening a new one", t.serverID)
+	resp, err := t.streamableTransport.SendRequest(ctx, *t.initRequest)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("initialize: %s", resp.Error.Message)
+	}
+	notification := mcp.JSONRPCNotification{
+		JSONRPC:      mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{Method: initializedNotificationMethod},
+	}
+	if err := t.streamableTransport.SendNotification(ctx, notification); err != nil {
+		return err
+	}
+	t.log.Infof("[MCP-CONNECT] Server `%s` opened session %q", t.serverID, t.GetSessionId())
+	return nil
+}
@@ -0,0 +1,403 @@
+// Package mcp_connector: Streamable HTTP client transport with stream resumption
+package mcp_connector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mcpSessionIDHeader = "Mcp-Session-Id"
+	lastEventIDHeader  = "Last-Event-ID"
+	// maxStreamResumes bounds the reconnections of one response stream.
+	maxStreamResumes = 3
+	// defaultResumeDelay is the wait before reconnecting to a broken response stream.
+	defaultResumeDelay = 500 * time.Millisecond
+	// closeSessionTimeout bounds the DELETE that ends the session on Close.
+	closeSessionTimeout = 5 * time.Second
+)
+
+// errSessionTerminated is returned when the server answers 404 for the session.
+var errSessionTerminated = errors.New("session terminated (404), need to re-initialize")
+
+// streamableTransport is a client transport for Streamable HTTP servers (MCP 2025-03-26). The vendored mcp-go
+// transport drops the IDs of SSE events and gives no access to the HTTP requests, so it cannot resume a stream.
+// Responsibility: Sending JSON-RPC messages over HTTP POST and reading the JSON or SSE answers
+// Features: Keeps the session ID the server assigns; when the SSE stream of a request breaks before its
+// response, reconnects with GET and Last-Event-ID to receive the rest. After the initialize handshake, listens
+// for server notifications on the standalone GET stream if the server offers one. Batches are not supported
+type streamableTransport struct {
+	url         string
+	headers     map[string]string
+	httpClient  *http.Client
+	serverID    string
+	log         *logrus.Logger
+	resumeDelay time.Duration // defaultResumeDelay except in tests
+
+	sessionID           atomic.Value // string
+	notifyMu            sync.RWMutex
+	notificationHandler func(mcp.JSONRPCNotification)
+	closed              chan struct{}
+	closeOnce           sync.Once
+}
+
+// newStreamableTransport creates a transport for the server at the URL that sends the headers with every request.
+func newStreamableTransport(serverURL string, headers map[string]string, serverID string, log *logrus.Logger) (*streamableTransport, error) {
+	if _, err := url.Parse(serverURL); err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	t := &streamableTransport{
+		url:         serverURL,
+		headers:     headers,
+		httpClient:  &http.Client{},
+		serverID:    serverID,
+		log:         log,
+		resumeDelay: defaultResumeDelay,
+		closed:      make(chan struct{}),
+	}
+	t.sessionID.Store("")
+	return t, nil
+}
+
+// Start does nothing: every message is a request of its own.
+func (t *streamableTransport) Start(ctx context.Context) error {
+	return nil
+}
+
+// GetSessionId returns the session ID the server assigned on initialize, or "" if there is none.
+func (t *streamableTransport) GetSessionId() string {
+	return t.sessionID.Load().(string)
+}
+
+// SetNotificationHandler sets the handler of the notifications sent on response streams and the GET stream.
+func (t *streamableTransport) SetNotificationHandler(handler func(mcp.JSONRPCNotification)) {
+	t.notifyMu.Lock()
+	defer t.notifyMu.Unlock()
+	t.notificationHandler = handler
+}
+
+// SendRequest sends the request and waits for its response, which comes as JSON or at the end of an SSE stream.
+func (t *streamableTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	ctx, cancel := t.withClose(ctx)
+	defer cancel()
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	sessionID := t.GetSessionId()
+	resp, err := t.do(ctx, http.MethodPost, bytes.NewReader(body), sessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+	case http.StatusNotFound:
+		t.sessionID.CompareAndSwap(sessionID, "")
+		return nil, errSessionTerminated
+	default:
+		data, _ := io.ReadAll(resp.Body)
+		var response transport.JSONRPCResponse
+		if err := json.Unmarshal(data, &response); err == nil && !response.ID.IsNil() {
+			return &response, nil
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, data)
+	}
+	if request.Method == string(mcp.MethodInitialize) {
+		if id := resp.Header.Get(mcpSessionIDHeader); id != "" {
+			t.sessionID.Store(id)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var response transport.JSONRPCResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if response.ID.IsNil() {
+			return nil, fmt.Errorf("response should contain RPC id: %v", response)
+		}
+		return &response, nil
+	case "text/event-stream":
+		return t.readResponseStream(ctx, resp.Body)
+	default:
+		return nil, fmt.Errorf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+}
+
+// readResponseStream reads the SSE stream of a request until its response. A stream that breaks after an
+// event with an ID is resumed from that event, up to maxStreamResumes times.
+func (t *streamableTransport) readResponseStream(ctx context.Context, body io.ReadCloser) (*transport.JSONRPCResponse, error) {
+	lastEventID := ""
+	for resumes := 0; ; resumes++ {
+		response, err := t.readStream(body, &lastEventID)
+		_ = body.Close()
+		if response != nil {
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if lastEventID == "" {
+			return nil, fmt.Errorf("the response stream ended before the response and cannot be resumed: %w", err)
+		}
+		if resumes == maxStreamResumes {
+			return nil, fmt.Errorf("the response stream ended before the response %d times: %w", resumes+1, err)
+		}
+		t.log.Warnf("[MCP-CONNECT] Response stream of server `%s` broke (%v), resuming after event %q", t.serverID, err, lastEventID)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(t.resumeDelay):
+		}
+		if body, err = t.resumeStream(ctx, lastEventID); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// resumeStream asks the server for the events of the session after lastEventID.
+func (t *streamableTransport) resumeStream(ctx context.Context, lastEventID string) (io.ReadCloser, error) {
+	sessionID := t.GetSessionId()
+	resp, err := t.do(ctx, http.MethodGet, nil, sessionID, map[string]string{lastEventIDHeader: lastEventID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resume the response stream: %w", err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		t.sessionID.CompareAndSwap(sessionID, "")
+		return nil, errSessionTerminated
+	case resp.StatusCode != http.StatusOK || mediaType != "text/event-stream":
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to resume the response stream: status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// readStream passes the notifications of an SSE stream to the handler until the stream ends or a response
+// comes, which is returned. lastEventID is updated with the ID of each event that has one.
+func (t *streamableTransport) readStream(body io.Reader, lastEventID *string) (*transport.JSONRPCResponse, error) {
+	return t.readEvents(bufio.NewReader(body), lastEventID)
+}
+
+// readEvents is readStream on a reader that can be read further after a response.
+func (t *streamableTransport) readEvents(reader *bufio.Reader, lastEventID *string) (*transport.JSONRPCResponse, error) {
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			// An empty line ends the event
+			if len(data) > 0 {
+				if response := t.dispatchEvent(strings.Join(data, "\n")); response != nil {
+					return response, nil
+				}
+			}
+			data = nil
+		case field == "id":
+			*lastEventID = value
+		case field == "data":
+			data = append(data, value)
+		}
+	}
+}
+
+// dispatchEvent passes a notification to the handler, or returns the response.
+func (t *streamableTransport) dispatchEvent(data string) *transport.JSONRPCResponse {
+	var message transport.JSONRPCResponse
+	if err := json.Unmarshal([]byte(data), &message); err != nil {
+		t.log.Warnf("[MCP-CONNECT] Invalid message from server `%s`: %v", t.serverID, err)
+		return nil
+	}
+	if !message.ID.IsNil() {
+		return &message
+	}
+	var notification mcp.JSONRPCNotification
+	if err := json.Unmarshal([]byte(data), &notification); err != nil {
+		t.log.Warnf("[MCP-CONNECT] Invalid notification from server `%s`: %v", t.serverID, err)
+		return nil
+	}
+	t.notifyMu.RLock()
+	defer t.notifyMu.RUnlock()
+	if t.notificationHandler != nil {
+		t.notificationHandler(notification)
+	}
+	return nil
+}
+
+// SendNotification sends a notification, which the server only acknowledges.
+func (t *streamableTransport) SendNotification(ctx context.Context, notification mcp.JSONRPCNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	resp, err := t.do(ctx, http.MethodPost, bytes.NewReader(body), t.GetSessionId(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("notification failed with status %d: %s", resp.StatusCode, data)
+	}
+	if notification.Method == initializedNotificationMethod {
+		go t.listen(t.GetSessionId())
+	}
+	return nil
+}
+
+// listen reads the standalone GET stream of the session and passes its notifications to the handler.
+// A broken stream is reopened after the last event it sent. Listening stops when the transport is closed,
+// the session ends, or the server does not offer the stream (405).
+func (t *streamableTransport) listen(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	ctx, cancel := t.withClose(context.Background())
+	defer cancel()
+	lastEventID := ""
+	for {
+		var extra map[string]string
+		if lastEventID != "" {
+			extra = map[string]string{lastEventIDHeader: lastEventID}
+		}
+		resp, err := t.do(ctx, http.MethodGet, nil, sessionID, extra)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			t.log.Warnf("[MCP-CONNECT] Failed to open the notification stream of server `%s`: %v", t.serverID, err)
+		} else {
+			mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			switch {
+			case resp.StatusCode == http.StatusMethodNotAllowed:
+				resp.Body.Close()
+				t.log.Debugf("[MCP-CONNECT] Server `%s` does not offer a notification stream", t.serverID)
+				return
+			case resp.StatusCode == http.StatusNotFound:
+				resp.Body.Close()
+				t.sessionID.CompareAndSwap(sessionID, "")
+				return
+			case resp.StatusCode != http.StatusOK || mediaType != "text/event-stream":
+				resp.Body.Close()
+				t.log.Warnf("[MCP-CONNECT] Server `%s` refused the notification stream: status %d", t.serverID, resp.StatusCode)
+				return
+			}
+			reader := bufio.NewReader(resp.Body)
+			for {
+				message, err := t.readEvents(reader, &lastEventID)
+				if err != nil {
+					break
+				}
+				// Requests of the server are not answered by this client
+				t.log.Debugf("[MCP-CONNECT] Ignoring message %v on the notification stream of server `%s`", message.ID, t.serverID)
+			}
+			resp.Body.Close()
+		}
+		if ctx.Err() != nil || t.GetSessionId() != sessionID {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.resumeDelay):
+		}
+	}
+}
+
+// Close cancels the running requests and ends the session on the server.
+func (t *streamableTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		sessionID := t.GetSessionId()
+		if sessionID == "" {
+			return
+		}
+		t.sessionID.Store("")
+		// The server may be gone, so the client does not wait for it
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), closeSessionTimeout)
+			defer cancel()
+			resp, err := t.do(ctx, http.MethodDelete, nil, sessionID, nil)
+			if err != nil {
+				t.log.Debugf("[MCP-CONNECT] Ending session %q of server `%s`: %v", sessionID, t.serverID, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	})
+	return nil
+}
+
+// do sends an HTTP request with the configured headers, the session ID and the extra headers.
+func (t *streamableTransport) do(ctx context.Context, method string, body io.Reader, sessionID string, extra map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+	} else {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if sessionID != "" {
+		req.Header.Set(mcpSessionIDHeader, sessionID)
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range extra {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	return resp, nil
+}
+
+// withClose returns a context that is also cancelled when the transport is closed.
+func (t *streamableTransport) withClose(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-t.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+var _ transport.Interface = (*streamableTransport)(nil)
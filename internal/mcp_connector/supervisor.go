@@ -0,0 +1,325 @@
+// Package mcp_connector: health supervision and reconnection of MCP servers
+package mcp_connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/error_handling"
+)
+
+const (
+	// defaultPingTimeout is used when the health check timeout is not configured.
+	defaultPingTimeout = 5 * time.Second
+	// checkQueueSize bounds the pending on-demand checks; further requests are dropped while the queue is full.
+	checkQueueSize = 16
+	// defaultRecheckDelay is the first delay before an unreachable server is checked again; it doubles with
+	// each failed reconnect up to maxRecheckDelay.
+	defaultRecheckDelay = 30 * time.Second
+	maxRecheckDelay     = 10 * time.Minute
+)
+
+// ServerHealth is the health state of a connected MCP server.
+type ServerHealth struct {
+	// Healthy is false from a failed check or call until the server is reconnected.
+	Healthy bool
+	// LastError is the error of the last failed check, reconnect or call.
+	LastError string
+	// LastCheck is the time of the last ping.
+	LastCheck time.Time
+	// Reconnects is the number of successful reconnections.
+	Reconnects int
+}
+
+// ServerHealth returns the health state of each connected server.
+func (mc *MCPConnector) ServerHealth() map[string]ServerHealth {
+	mc.dataLock.RLock()
+	defer mc.dataLock.RUnlock()
+
+	states := make(map[string]ServerHealth, len(mc.health))
+	for id, state := range mc.health {
+		states[id] = *state
+	}
+	return states
+}
+
+// IsServerHealthy reports whether the server is usable. Servers without a health state are considered healthy.
+func (mc *MCPConnector) IsServerHealthy(serverID string) bool {
+	mc.dataLock.RLock()
+	defer mc.dataLock.RUnlock()
+	return mc.isServerHealthy(serverID)
+}
+
+func (mc *MCPConnector) isServerHealthy(serverID string) bool {
+	state, ok := mc.health[serverID]
+	return !ok || state.Healthy
+}
+
+// startSupervisor starts the goroutine that pings the servers and reconnects broken ones. Close stops it.
+func (mc *MCPConnector) startSupervisor() {
+	ctx, cancel := context.WithCancel(context.Background())
+	mc.stopSupervisor = cancel
+	mc.supervisorDone = make(chan struct{})
+	go func() {
+		defer close(mc.supervisorDone)
+		mc.supervise(ctx)
+	}()
+}
+
+// supervise checks all servers every health check interval, and single servers on request.
+func (mc *MCPConnector) supervise(ctx context.Context) {
+	var tick <-chan time.Time
+	if interval := mc.config.HealthCheck.Interval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			for _, serverID := range mc.connectedServerIDs() {
+				mc.checkServer(ctx, serverID)
+			}
+		case serverID := <-mc.checkQueue:
+			mc.dataLock.Lock()
+			delete(mc.queuedChecks, serverID)
+			mc.dataLock.Unlock()
+			mc.checkServer(ctx, serverID)
+		}
+	}
+}
+
+// requestCheck asks the supervisor to check the server soon, e.g. after a failed call. A server is queued
+// at most once, so a burst of failing calls leads to one check.
+func (mc *MCPConnector) requestCheck(serverID string) {
+	mc.dataLock.Lock()
+	defer mc.dataLock.Unlock()
+	if mc.queuedChecks[serverID] {
+		return
+	}
+	select {
+	case mc.checkQueue <- serverID:
+		mc.queuedChecks[serverID] = true
+	default:
+	}
+}
+
+// scheduleRecheck checks the server again after a delay that doubles with each failed reconnect, so that
+// a server comes back even without periodic checks or calls. One recheck per server is pending at a time.
+func (mc *MCPConnector) scheduleRecheck(ctx context.Context, serverID string) {
+	mc.dataLock.Lock()
+	defer mc.dataLock.Unlock()
+	r, ok := mc.rechecks[serverID]
+	if !ok {
+		r = &recheck{delay: mc.recheckDelay}
+		mc.rechecks[serverID] = r
+	}
+	if r.timer != nil {
+		return
+	}
+	mc.log.Infof("[MCP-CONNECT] Checking server `%s` again in %s", serverID, r.delay)
+	r.timer = time.AfterFunc(r.delay, func() {
+		mc.dataLock.Lock()
+		r.timer = nil
+		mc.dataLock.Unlock()
+		if ctx.Err() == nil {
+			mc.requestCheck(serverID)
+		}
+	})
+	r.delay = min(r.delay*2, maxRecheckDelay)
+}
+
+// cancelRecheck drops the pending recheck and the backoff of a server that is healthy again.
+func (mc *MCPConnector) cancelRecheck(serverID string) {
+	mc.dataLock.Lock()
+	defer mc.dataLock.Unlock()
+	if r, ok := mc.rechecks[serverID]; ok {
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		delete(mc.rechecks, serverID)
+	}
+}
+
+// recheck is the backoff of an unreachable server.
+type recheck struct {
+	delay time.Duration // before the next recheck
+	timer *time.Timer   // pending recheck, nil if none
+}
+
+// connectedServerIDs returns the IDs of the servers with a client.
+func (mc *MCPConnector) connectedServerIDs() []string {
+	mc.dataLock.RLock()
+	defer mc.dataLock.RUnlock()
+	ids := make([]string, 0, len(mc.clients))
+	for _, id := range mc.serverIDs() {
+		if _, ok := mc.clients[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// checkServer pings the server and starts a reconnect if the ping fails.
+// A server that is being reconnected is not checked.
+func (mc *MCPConnector) checkServer(ctx context.Context, serverID string) {
+	mc.dataLock.RLock()
+	mcpClient, ok := mc.clients[serverID]
+	reconnecting := mc.reconnecting[serverID]
+	mc.dataLock.RUnlock()
+	if !ok || reconnecting {
+		return
+	}
+
+	timeout := mc.config.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := mcpClient.Ping(pingCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	mc.updateHealth(serverID, func(state *ServerHealth) {
+		state.LastCheck = time.Now()
+		if err == nil {
+			state.Healthy = true
+		}
+	})
+	if err == nil {
+		mc.cancelRecheck(serverID)
+		return
+	}
+
+	mc.log.Warnf("[MCP-CONNECT] Server `%s` failed the health check: %v", serverID, err)
+	mc.markUnhealthy(serverID, err)
+	mc.startReconnect(ctx, serverID)
+}
+
+// startReconnect reconnects the server in its own goroutine, so that a server that takes long to come back
+// does not hold up the checks of the others. At most one reconnect per server runs at a time.
+func (mc *MCPConnector) startReconnect(ctx context.Context, serverID string) {
+	mc.dataLock.Lock()
+	if mc.reconnecting[serverID] {
+		mc.dataLock.Unlock()
+		return
+	}
+	mc.reconnecting[serverID] = true
+	mc.reconnects.Add(1)
+	mc.dataLock.Unlock()
+
+	go func() {
+		defer mc.reconnects.Done()
+		defer func() {
+			mc.dataLock.Lock()
+			delete(mc.reconnecting, serverID)
+			mc.dataLock.Unlock()
+		}()
+		if err := mc.reconnect(ctx, serverID); err != nil {
+			mc.log.Errorf("[MCP-CONNECT] Failed to reconnect to server `%s`: %v", serverID, err)
+			mc.markUnhealthy(serverID, err)
+			if ctx.Err() == nil {
+				mc.scheduleRecheck(ctx, serverID)
+			}
+			return
+		}
+		mc.cancelRecheck(serverID)
+	}()
+}
+
+// markUnhealthy records the failure of the server, so that its tools are hidden and its calls fail fast.
+func (mc *MCPConnector) markUnhealthy(serverID string, err error) {
+	mc.updateHealth(serverID, func(state *ServerHealth) {
+		state.Healthy = false
+		state.LastError = err.Error()
+	})
+}
+
+func (mc *MCPConnector) updateHealth(serverID string, update func(state *ServerHealth)) {
+	mc.dataLock.Lock()
+	defer mc.dataLock.Unlock()
+	state, ok := mc.health[serverID]
+	if !ok {
+		state = &ServerHealth{Healthy: true}
+		mc.health[serverID] = state
+	}
+	update(state)
+	if mc.metrics != nil {
+		mc.metrics.ServerHealth(serverID, state.Healthy)
+	}
+}
+
+// reconnect replaces the client of the server, retrying with the backoff of `agent.connections.retry`.
+func (mc *MCPConnector) reconnect(ctx context.Context, serverID string) error {
+	retry := mc.config.RetryConfig
+	backoff := time.Duration(retry.InitialBackoff * float64(time.Second))
+	maxBackoff := time.Duration(retry.MaxBackoff * float64(time.Second))
+	var err error
+	for attempt := 0; attempt <= retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			mc.log.Infof("[MCP-CONNECT] Reconnecting to server `%s` in %s (attempt %d/%d)", serverID, backoff, attempt, retry.MaxRetries)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = time.Duration(float64(backoff) * retry.BackoffMultiplier)
+			if maxBackoff > 0 && backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if err = mc.replaceClient(ctx, serverID); err == nil {
+			return nil
+		}
+		mc.log.Warnf("[MCP-CONNECT] Reconnect to server `%s` failed: %v", serverID, err)
+	}
+	return err
+}
+
+// replaceClient connects to the server again, lists its tools, and swaps the new client in for the old one.
+func (mc *MCPConnector) replaceClient(ctx context.Context, serverID string) error {
+	srvCfg, ok := mc.config.McpServers[serverID]
+	if !ok {
+		return error_handling.NewError(fmt.Sprintf("unknown MCP server %s", serverID), error_handling.ErrorCategoryValidation)
+	}
+	mcpClient, err := mc.connect(ctx, serverID, srvCfg)
+	if err != nil {
+		return err
+	}
+	mc.watchToolList(serverID, mcpClient)
+	tools, err := mc.listServerTools(ctx, serverID, mcpClient, srvCfg)
+	if err != nil {
+		_ = mcpClient.Close()
+		return err
+	}
+
+	mc.dataLock.Lock()
+	old := mc.clients[serverID]
+	mc.clients[serverID] = mcpClient
+	// The server may come back with other tools
+	mc.setServerTools(serverID, tools)
+	state, ok := mc.health[serverID]
+	if !ok {
+		state = &ServerHealth{}
+		mc.health[serverID] = state
+	}
+	state.Healthy = true
+	state.Reconnects++
+	mc.dataLock.Unlock()
+	if mc.metrics != nil {
+		mc.metrics.ServerHealth(serverID, true)
+		mc.metrics.ServerReconnected(serverID)
+	}
+
+	if old != nil {
+		if err := old.Close(); err != nil {
+			mc.log.Debugf("[MCP-CONNECT] Closing the old client of server `%s`: %v", serverID, err)
+		}
+	}
+	mc.log.Infof("[MCP-CONNECT] Reconnected to server `%s` with %d tools", serverID, len(tools))
+	return nil
+}
@@ -0,0 +1,242 @@
+package mcp_connector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pingClient struct {
+	mockMCPClient
+	pingErr error
+	tools   []mcp.Tool
+	closed  bool
+}
+
+func (p *pingClient) Ping(ctx context.Context) error { return p.pingErr }
+func (p *pingClient) ListTools(ctx context.Context, req mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	return &mcp.ListToolsResult{Tools: p.tools}, nil
+}
+func (p *pingClient) Close() error {
+	p.closed = true
+	return nil
+}
+
+func newSupervisedConnector(t *testing.T, dead *pingClient, connect func() (client.MCPClient, error)) *MCPConnector {
+	t.Helper()
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{
+		McpServers:  map[string]configuration.MCPServerConnection{"srv": {Command: "srv"}},
+		RetryConfig: configuration.RetryConfig{MaxRetries: 1, InitialBackoff: 0.001, MaxBackoff: 0.001, BackoffMultiplier: 2},
+		HealthCheck: configuration.HealthCheckConfig{Timeout: time.Second},
+	}, log)
+	mc.connect = func(ctx context.Context, serverID string, serverConfig configuration.MCPServerConnection) (client.MCPClient, error) {
+		return connect()
+	}
+	mc.clients["srv"] = dead
+	mc.setServerTools("srv", []mcp.Tool{{Name: "foo"}})
+	mc.health["srv"] = &ServerHealth{Healthy: true}
+	return mc
+}
+
+func Test_checkServer_reconnects(t *testing.T) {
+	dead := &pingClient{pingErr: fmt.Errorf("transport closed")}
+	fresh := &pingClient{tools: []mcp.Tool{{Name: "foo"}, {Name: "bar"}}}
+	mc := newSupervisedConnector(t, dead, func() (client.MCPClient, error) { return fresh, nil })
+
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+
+	health := mc.ServerHealth()["srv"]
+	assert.True(t, health.Healthy)
+	assert.Equal(t, 1, health.Reconnects)
+	assert.Equal(t, "transport closed", health.LastError)
+	assert.True(t, dead.closed, "the broken client is closed")
+	tools, _ := mc.GetAllTools(context.Background())
+	assert.Len(t, tools, 2, "tools are listed again")
+}
+
+func Test_checkServer_reconnectFails(t *testing.T) {
+	dead := &pingClient{pingErr: fmt.Errorf("transport closed")}
+	attempts := 0
+	mc := newSupervisedConnector(t, dead, func() (client.MCPClient, error) {
+		attempts++
+		return nil, fmt.Errorf("command not found")
+	})
+
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+
+	assert.Equal(t, 2, attempts, "first attempt and one retry")
+	assert.False(t, mc.IsServerHealthy("srv"))
+	assert.Equal(t, "command not found", mc.ServerHealth()["srv"].LastError)
+
+	call := types.CallToolRequest{}
+	call.Params.Name = "foo"
+	_, err := mc.ExecuteTool(context.Background(), call)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "MCP server `srv` of tool `foo` is unavailable")
+}
+
+func Test_ExecuteTool_unhealthyServerRequestsCheck(t *testing.T) {
+	mc := newSupervisedConnector(t, &pingClient{}, nil)
+	mc.health["srv"].Healthy = false
+	call := types.CallToolRequest{}
+	call.Params.Name = "foo"
+
+	for range 3 {
+		_, err := mc.ExecuteTool(context.Background(), call)
+		require.Error(t, err)
+	}
+
+	assert.Len(t, mc.checkQueue, 1, "a burst of failing calls queues one check")
+	assert.Equal(t, "srv", <-mc.checkQueue)
+}
+
+func Test_checkServer_reconnectFailsSchedulesRecheck(t *testing.T) {
+	var connectErr error = fmt.Errorf("connection refused")
+	mc := newSupervisedConnector(t, &pingClient{pingErr: fmt.Errorf("transport closed")}, func() (client.MCPClient, error) {
+		if connectErr != nil {
+			return nil, connectErr
+		}
+		return &pingClient{}, nil
+	})
+	mc.recheckDelay = time.Millisecond
+
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+	select {
+	case serverID := <-mc.checkQueue:
+		assert.Equal(t, "srv", serverID)
+	case <-time.After(time.Second):
+		t.Fatal("the unreachable server is not checked again")
+	}
+	mc.dataLock.RLock()
+	assert.Equal(t, 2*time.Millisecond, mc.rechecks["srv"].delay, "the next recheck waits longer")
+	mc.dataLock.RUnlock()
+
+	connectErr = nil
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+	assert.True(t, mc.IsServerHealthy("srv"))
+	assert.Empty(t, mc.rechecks, "the backoff is reset")
+}
+
+// heldClient answers tool calls once released.
+type heldClient struct {
+	pingClient
+	started, release chan struct{}
+}
+
+func (c *heldClient) CallTool(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	close(c.started)
+	<-c.release
+	return mcp.NewToolResultText("done"), nil
+}
+
+func Test_ExecuteTool_slowCallDoesNotBlockSupervisor(t *testing.T) {
+	slow := &heldClient{started: make(chan struct{}), release: make(chan struct{})}
+	mc := newSupervisedConnector(t, &slow.pingClient, nil)
+	mc.clients["srv"] = slow
+	call := types.CallToolRequest{}
+	call.Params.Name = "foo"
+	done := make(chan error, 1)
+	go func() {
+		_, err := mc.ExecuteTool(context.Background(), call)
+		done <- err
+	}()
+	<-slow.started
+
+	checked := make(chan struct{})
+	go func() {
+		mc.checkServer(context.Background(), "srv")
+		_, _ = mc.GetAllTools(context.Background())
+		close(checked)
+	}()
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("the health check and other calls waited for a running tool call")
+	}
+	close(slow.release)
+	assert.NoError(t, <-done)
+}
+
+func Test_checkServer_reconnectDoesNotBlockOtherServers(t *testing.T) {
+	release := make(chan struct{})
+	connects := 0
+	mc := newSupervisedConnector(t, &pingClient{pingErr: fmt.Errorf("transport closed")}, func() (client.MCPClient, error) {
+		connects++
+		<-release
+		return &pingClient{}, nil
+	})
+	mc.config.McpServers["other"] = configuration.MCPServerConnection{Command: "other"}
+	mc.clients["other"] = &pingClient{}
+
+	checked := make(chan struct{})
+	go func() {
+		mc.checkServer(context.Background(), "srv")
+		mc.checkServer(context.Background(), "srv")
+		mc.checkServer(context.Background(), "other")
+		close(checked)
+	}()
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("the checks waited for the reconnect of another server")
+	}
+	assert.False(t, mc.ServerHealth()["other"].LastCheck.IsZero(), "the other server is checked")
+
+	close(release)
+	mc.reconnects.Wait()
+	assert.Equal(t, 1, connects, "a server being reconnected is not checked again")
+	assert.True(t, mc.IsServerHealthy("srv"))
+}
+
+func Test_checkServer_healthy(t *testing.T) {
+	alive := &pingClient{}
+	mc := newSupervisedConnector(t, alive, func() (client.MCPClient, error) {
+		t.Fatal("a healthy server is not reconnected")
+		return nil, nil
+	})
+
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+
+	health := mc.ServerHealth()["srv"]
+	assert.True(t, health.Healthy)
+	assert.False(t, health.LastCheck.IsZero())
+	assert.False(t, alive.closed)
+}
+
+func Test_checkServer_metrics(t *testing.T) {
+	dead := &pingClient{pingErr: fmt.Errorf("transport closed")}
+	var connectErr error = fmt.Errorf("connection refused")
+	mc := newSupervisedConnector(t, dead, func() (client.MCPClient, error) {
+		if connectErr != nil {
+			return nil, connectErr
+		}
+		return &pingClient{}, nil
+	})
+	m := &recordingMetrics{}
+	mc.SetMetrics(m)
+
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+	assert.False(t, m.health["srv"])
+	assert.Zero(t, m.reconnects)
+
+	connectErr = nil
+	mc.checkServer(context.Background(), "srv")
+	mc.reconnects.Wait()
+	assert.True(t, m.health["srv"])
+	assert.Equal(t, 1, m.reconnects)
+}
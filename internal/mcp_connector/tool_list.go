@@ -0,0 +1,50 @@
+// Package mcp_connector: refreshing the tool lists of servers that announce changes
+package mcp_connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolListTimeout bounds the re-listing of tools after a change notification.
+const toolListTimeout = 30 * time.Second
+
+// watchToolList re-lists the tools of the server whenever it sends notifications/tools/list_changed.
+func (mc *MCPConnector) watchToolList(serverID string, mcpClient client.MCPClient) {
+	mcpClient.OnNotification(func(notification mcp.JSONRPCNotification) {
+		if notification.Method != mcp.MethodNotificationToolsListChanged {
+			return
+		}
+		mc.log.Infof("[MCP-CONNECT] Server `%s` changed its tools", serverID)
+		// The handler runs on the transport's reader, which must not wait for the ListTools response
+		go mc.refreshTools(serverID, mcpClient)
+	})
+}
+
+// refreshTools lists the tools of the server again and replaces the cached ones.
+// It does nothing if mcpClient is no longer the client of the server, e.g. after a reconnect.
+func (mc *MCPConnector) refreshTools(serverID string, mcpClient client.MCPClient) {
+	srvCfg, ok := mc.config.McpServers[serverID]
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), toolListTimeout)
+	defer cancel()
+	tools, err := mc.listServerTools(ctx, serverID, mcpClient, srvCfg)
+	if err != nil {
+		mc.log.Warnf("[MCP-CONNECT] Failed to refresh the tools of server `%s`: %v", serverID, err)
+		return
+	}
+
+	mc.dataLock.Lock()
+	if mc.clients[serverID] != mcpClient {
+		mc.dataLock.Unlock()
+		return
+	}
+	mc.setServerTools(serverID, tools)
+	mc.dataLock.Unlock()
+	mc.log.Infof("[MCP-CONNECT] Server `%s` now has %d tools", serverID, len(tools))
+}
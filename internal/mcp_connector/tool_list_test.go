@@ -0,0 +1,106 @@
+package mcp_connector
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+)
+
+type listChangingClient struct {
+	mockMCPClient
+	mu       sync.Mutex
+	tools    []mcp.Tool
+	handlers []func(mcp.JSONRPCNotification)
+}
+
+func (c *listChangingClient) ListTools(ctx context.Context, req mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &mcp.ListToolsResult{Tools: c.tools}, nil
+}
+
+func (c *listChangingClient) OnNotification(handler func(mcp.JSONRPCNotification)) {
+	c.handlers = append(c.handlers, handler)
+}
+
+func (c *listChangingClient) setTools(tools ...mcp.Tool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tools = tools
+}
+
+func (c *listChangingClient) notify(method string) {
+	for _, handler := range c.handlers {
+		handler(mcp.JSONRPCNotification{Notification: mcp.Notification{Method: method}})
+	}
+}
+
+func Test_watchToolList(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{
+		McpServers: map[string]configuration.MCPServerConnection{"plugins": {Command: "host", ExcludeTools: []string{"secret"}, ToolPrefix: "p_"}},
+	}, log)
+	cl := &listChangingClient{tools: []mcp.Tool{{Name: "foo"}}}
+	mc.clients["plugins"] = cl
+	mc.setServerTools("plugins", []mcp.Tool{{Name: "p_foo"}})
+	mc.watchToolList("plugins", cl)
+
+	cl.setTools(mcp.Tool{Name: "foo"}, mcp.Tool{Name: "bar"}, mcp.Tool{Name: "secret"})
+	cl.notify("notifications/message")
+	cl.notify(mcp.MethodNotificationToolsListChanged)
+
+	assert.Eventually(t, func() bool {
+		tools, _ := mc.GetAllTools(context.Background())
+		return len(tools) == 2
+	}, time.Second, 10*time.Millisecond)
+	tools, _ := mc.GetAllTools(context.Background())
+	assert.Equal(t, "p_foo", tools[0].Name)
+	assert.Equal(t, "p_bar", tools[1].Name)
+	id, ok := mc.GetToolServerID("p_bar")
+	assert.True(t, ok)
+	assert.Equal(t, "plugins", id)
+}
+
+func Test_refreshTools_ignoresReplacedClient(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{
+		McpServers: map[string]configuration.MCPServerConnection{"srv": {Command: "srv"}},
+	}, log)
+	old := &listChangingClient{tools: []mcp.Tool{{Name: "stale"}}}
+	mc.clients["srv"] = &listChangingClient{}
+	mc.setServerTools("srv", []mcp.Tool{{Name: "current"}})
+
+	mc.refreshTools("srv", old)
+
+	tools, _ := mc.GetAllTools(context.Background())
+	assert.Equal(t, []mcp.Tool{{Name: "current"}}, tools)
+}
+
+func Test_refreshTools_restoresShadowedTool(t *testing.T) {
+	log, _ := newTestLogger()
+	mc := NewMCPConnector(configuration.MCPConnectorConfig{
+		McpServers:      map[string]configuration.MCPServerConnection{"a": {Command: "a"}, "b": {Command: "b"}},
+		OnToolCollision: configuration.ToolCollisionWarn,
+	}, log)
+	a := &listChangingClient{}
+	mc.clients["a"] = a
+	mc.clients["b"] = &listChangingClient{}
+	mc.setServerTools("a", []mcp.Tool{{Name: "search"}})
+	mc.setServerTools("b", []mcp.Tool{{Name: "search"}, {Name: "read_file"}})
+	id, _ := mc.GetToolServerID("search")
+	assert.Equal(t, "a", id)
+
+	a.setTools()
+	mc.refreshTools("a", a)
+
+	id, ok := mc.GetToolServerID("search")
+	assert.True(t, ok)
+	assert.Equal(t, "b", id, "the tool of the other server is used again")
+	tools, _ := mc.GetAllTools(context.Background())
+	assert.Len(t, tools, 2)
+}
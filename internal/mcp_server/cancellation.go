@@ -0,0 +1,129 @@
+// Package mcp_server: cancellation of tool calls by the client
+package mcp_server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const cancelledNotificationMethod = "notifications/cancelled"
+
+// requestIDKey is the context key of the slot that receives the JSON-RPC ID of the request being handled.
+type requestIDKey struct{}
+
+// requestIDSlot holds the ID of the request being handled.
+// mcp-go passes the ID to hooks but not to tool handlers, so the BeforeCallTool hook fills it in for the handler.
+type requestIDSlot struct {
+	id string
+}
+
+// withRequestIDSlot returns a context with an empty slot for the ID of the message about to be handled.
+func withRequestIDSlot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, &requestIDSlot{})
+}
+
+// requestKey returns the canonical form of a JSON-RPC request ID, so that 7 and 7.0 match.
+func requestKey(id any) string {
+	if reqID, ok := id.(mcp.RequestId); ok {
+		return reqID.String()
+	}
+	return mcp.NewRequestId(id).String()
+}
+
+// inFlightRequests keeps the cancel functions of the tool calls in progress.
+// Responsibility: Finding the call a client wants to cancel
+// Features: Calls are grouped by client session, so that a disconnect cancels all of them
+type inFlightRequests struct {
+	mu      sync.Mutex
+	cancels map[string]map[string]context.CancelFunc // session ID -> request ID -> cancel
+}
+
+func newInFlightRequests() *inFlightRequests {
+	return &inFlightRequests{cancels: make(map[string]map[string]context.CancelFunc)}
+}
+
+func (r *inFlightRequests) add(sessionID, requestID string, cancel context.CancelFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancels[sessionID] == nil {
+		r.cancels[sessionID] = make(map[string]context.CancelFunc)
+	}
+	r.cancels[sessionID][requestID] = cancel
+}
+
+func (r *inFlightRequests) remove(sessionID, requestID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels[sessionID], requestID)
+	if len(r.cancels[sessionID]) == 0 {
+		delete(r.cancels, sessionID)
+	}
+}
+
+// cancel cancels the request and reports whether it was in progress.
+func (r *inFlightRequests) cancel(sessionID, requestID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.cancels[sessionID][requestID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// cancelSession cancels all requests of the session and returns their number.
+func (r *inFlightRequests) cancelSession(sessionID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cancel := range r.cancels[sessionID] {
+		cancel()
+	}
+	return len(r.cancels[sessionID])
+}
+
+// trackRequest returns a context that is cancelled when the client cancels the current request or disconnects.
+// The returned function must be called when the request is done.
+func (s *MCPServer) trackRequest(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	slot, _ := ctx.Value(requestIDKey{}).(*requestIDSlot)
+	session := server.ClientSessionFromContext(ctx)
+	if slot == nil || slot.id == "" || session == nil {
+		return ctx, cancel
+	}
+	sessionID, requestID := session.SessionID(), slot.id
+	s.requests.add(sessionID, requestID, cancel)
+	return ctx, func() {
+		s.requests.remove(sessionID, requestID)
+		cancel()
+	}
+}
+
+// addCancellationHooks adds the hooks that let the client cancel tool calls.
+func (s *MCPServer) addCancellationHooks(hooks *server.Hooks) {
+	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest) {
+		if slot, ok := ctx.Value(requestIDKey{}).(*requestIDSlot); ok {
+			slot.id = requestKey(id)
+		}
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		if n := s.requests.cancelSession(session.SessionID()); n > 0 {
+			s.log.Warnf("MCPServer: client session %s disconnected, cancelled %d calls", session.SessionID(), n)
+		}
+	})
+}
+
+// handleCancelledNotification cancels the tool call named in a notifications/cancelled from the client.
+func (s *MCPServer) handleCancelledNotification(ctx context.Context, notification mcp.JSONRPCNotification) {
+	session := server.ClientSessionFromContext(ctx)
+	requestID, ok := notification.Params.AdditionalFields["requestId"]
+	if session == nil || !ok || requestID == nil {
+		return
+	}
+	key := requestKey(requestID)
+	if s.requests.cancel(session.SessionID(), key) {
+		s.log.Infof("MCPServer: request %s cancelled by the client: %v", key, notification.Params.AdditionalFields["reason"])
+	}
+}
@@ -0,0 +1,102 @@
+// Package mcp_server: requests sent by the server to its clients
+package mcp_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// clientResponse is a response of the client to a request sent by the server.
+type clientResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// clientRequests keeps the requests a session sent to its client until their responses come.
+// mcp-go sessions cannot send requests, so the stdio and Streamable HTTP sessions use this instead.
+type clientRequests struct {
+	nextID  atomic.Int64
+	mu      sync.Mutex
+	pending map[string]chan clientResponse // request ID -> waiter of the response
+	closed  error
+}
+
+func newClientRequests() *clientRequests {
+	return &clientRequests{pending: make(map[string]chan clientResponse)}
+}
+
+// send writes a request to the client with write and waits for its result.
+// If ctx is done first, the client is told to cancel the request.
+func (r *clientRequests) send(ctx context.Context, method string, params any, write func(message any) error) (json.RawMessage, error) {
+	id := fmt.Sprintf("speelka-%d", r.nextID.Add(1))
+	key := requestKey(id)
+	waiter := make(chan clientResponse, 1)
+	r.mu.Lock()
+	if r.closed != nil {
+		r.mu.Unlock()
+		return nil, r.closed
+	}
+	r.pending[key] = waiter
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, key)
+		r.mu.Unlock()
+	}()
+
+	message := map[string]any{"jsonrpc": mcp.JSONRPC_VERSION, "id": id, "method": method, "params": params}
+	if err := write(message); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", method, err)
+	}
+	select {
+	case response, ok := <-waiter:
+		if !ok {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return nil, r.closed
+		}
+		if response.Error != nil {
+			return nil, fmt.Errorf("%s failed: %s (code %d)", method, response.Error.Message, response.Error.Code)
+		}
+		return response.Result, nil
+	case <-ctx.Done():
+		_ = write(mcp.JSONRPCNotification{
+			JSONRPC: mcp.JSONRPC_VERSION,
+			Notification: mcp.Notification{
+				Method: cancelledNotificationMethod,
+				Params: mcp.NotificationParams{AdditionalFields: map[string]any{"requestId": id, "reason": ctx.Err().Error()}},
+			},
+		})
+		return nil, ctx.Err()
+	}
+}
+
+// deliver passes a response of the client to the request waiting for it.
+func (r *clientRequests) deliver(id any, response clientResponse) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	waiter, ok := r.pending[requestKey(id)]
+	if ok {
+		waiter <- response
+	}
+	return ok
+}
+
+// close fails the requests waiting for a response and all later ones.
+func (r *clientRequests) close(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = err
+	for key, waiter := range r.pending {
+		close(waiter)
+		delete(r.pending, key)
+	}
+}
@@ -0,0 +1,82 @@
+// Package mcp_server: elicitation of user input through the MCP client
+package mcp_server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const elicitationMethod = "elicitation/create"
+
+// Elicitation actions returned by the client.
+const (
+	ElicitationAccept  = "accept"
+	ElicitationDecline = "decline"
+	ElicitationCancel  = "cancel"
+)
+
+// ErrElicitationUnsupported is returned by Elicit when the client of the request cannot be asked for input.
+var ErrElicitationUnsupported = errors.New("the client does not support elicitation")
+
+// ElicitationResult is the answer of the user to an elicitation request.
+type ElicitationResult struct {
+	Action  string         `json:"action"`
+	Content map[string]any `json:"content,omitempty"`
+}
+
+// elicitingSession is a client session that can send requests to its client.
+type elicitingSession interface {
+	// canElicit reports whether the request in ctx can ask the client for input.
+	canElicit(ctx context.Context) bool
+	// request sends a request to the client and waits for its result.
+	request(ctx context.Context, method string, params any) (json.RawMessage, error)
+}
+
+// declaresElicitation reports whether the initialize request declares the elicitation capability of the client.
+func declaresElicitation(raw []byte) bool {
+	var request struct {
+		Params struct {
+			Capabilities struct {
+				Elicitation json.RawMessage `json:"elicitation"`
+			} `json:"capabilities"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return false
+	}
+	elicitation := request.Params.Capabilities.Elicitation
+	return len(elicitation) > 0 && string(elicitation) != "null"
+}
+
+// SupportsElicitation reports whether the client that issued the request in ctx can be asked for input.
+// Clients that declared the elicitation capability can: over stdio, and over Streamable HTTP during a tool
+// call answered with an SSE stream, which carries the request.
+func (s *MCPServer) SupportsElicitation(ctx context.Context) bool {
+	session, ok := server.ClientSessionFromContext(ctx).(elicitingSession)
+	return ok && session.canElicit(ctx)
+}
+
+// Elicit asks the user of the client that issued the request in ctx for input matching the JSON schema.
+// It blocks until the user answers or ctx is done.
+func (s *MCPServer) Elicit(ctx context.Context, message string, schema map[string]any) (ElicitationResult, error) {
+	session, ok := server.ClientSessionFromContext(ctx).(elicitingSession)
+	if !ok || !session.canElicit(ctx) {
+		return ElicitationResult{}, ErrElicitationUnsupported
+	}
+	raw, err := session.request(ctx, elicitationMethod, map[string]any{
+		"message":         message,
+		"requestedSchema": schema,
+	})
+	if err != nil {
+		return ElicitationResult{}, err
+	}
+	var result ElicitationResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return ElicitationResult{}, fmt.Errorf("invalid elicitation result: %w", err)
+	}
+	return result, nil
+}
@@ -1,16 +1,24 @@
 // Package mcp_server provides functionality for the MCP server.
 // Responsibility: Implementation of the MCP server for processing client requests
-// Features: Supports two operating modes - HTTP (daemon) and stdio
+// Features: Serves any combination of stdio, HTTP SSE and Streamable HTTP at once
 package mcp_server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/korchasa/speelka-agent-go/internal/utils/log_levels"
 
+	"github.com/korchasa/speelka-agent-go/internal/auth"
 	"github.com/korchasa/speelka-agent-go/internal/configuration"
 	"github.com/sirupsen/logrus"
 
@@ -20,64 +28,267 @@ import (
 
 const (
 	setLevelToolName = "logging/setLevel"
+	// EndSessionToolName is the name of the tool that deletes a saved multi-turn session.
+	EndSessionToolName = configuration.EndSessionToolName
+	// SessionIDArgumentName is the optional argument of the main tool that continues a saved session.
+	SessionIDArgumentName = "session_id"
+	// BudgetArgumentName is the optional argument of the main tool that lowers the request budget for one call.
+	BudgetArgumentName = "budget"
+	// APIPrefix is where the REST and OpenAI-compatible APIs are served.
+	APIPrefix = "/v1/"
+	// shutdownTimeout bounds the shutdown of the HTTP listeners when Serve returns.
+	shutdownTimeout = 5 * time.Second
 )
 
 // MCPServer implements an MCP server for handling client requests and managing the lifecycle of tools.
 // Thread-safe for public methods. All external dependencies are injected via the constructor (DI).
 type MCPServer struct {
-	server     *server.MCPServer             // Internal MCP server
-	cfg        configuration.MCPServerConfig // Server configuration
-	log        *logrus.Logger                // Logger (DI)
-	sseServer  *server.SSEServer             // HTTP SSE server (optional)
-	isHttpMode bool                          // true if HTTP is enabled, false if Stdio is enabled)
-	mu         sync.Mutex                    // Protects the state of server/sseServer
+	server      *server.MCPServer             // Internal MCP server; set by NewMCPServer and kept after Stop
+	cfg         configuration.MCPServerConfig // Server configuration
+	log         *logrus.Logger                // Logger (DI)
+	sseServer   *server.SSEServer             // HTTP SSE handler (optional)
+	streamable  *streamableHTTPHandler        // Streamable HTTP handler (optional)
+	httpServers []*http.Server                // One listener per distinct host:port
+	stopServe   context.CancelFunc            // Ends the running Serve
+	requests    *inFlightRequests             // Tool calls that the client can cancel
+	metrics     http.Handler                  // Serves the metrics (optional)
+	api         http.Handler                  // Serves the HTTP APIs under APIPrefix (optional)
+	mu          sync.Mutex                    // Protects the state of sseServer/streamable/httpServers
 }
 
 // NewMCPServer creates a new instance of MCPServer with the given configuration and logger.
 // All dependencies are injected via parameters (Dependency Injection).
 func NewMCPServer(cfg configuration.MCPServerConfig, log *logrus.Logger) (*MCPServer, error) {
 	var err error
-	var opts []server.ServerOption
-	if cfg.MCPLogEnabled {
-		opts = append(opts, server.WithLogging())
+	mcps := &MCPServer{
+		cfg:      cfg,
+		log:      log,
+		requests: newInFlightRequests(),
 	}
+
+	hooks := &server.Hooks{}
 	if cfg.Debug {
-		opts = append(opts, server.WithHooks((&MCPServer{cfg: cfg, log: log}).BuildHooks()))
+		hooks = mcps.BuildHooks()
+	}
+	mcps.addCancellationHooks(hooks)
+	opts := []server.ServerOption{server.WithHooks(hooks)}
+	if cfg.MCPLogEnabled {
+		opts = append(opts, server.WithLogging())
 	}
 
-	mcpSrv := server.NewMCPServer(
+	mcps.server = server.NewMCPServer(
 		cfg.Name,
 		cfg.Version,
 		opts...,
 	)
-
-	mcps := &MCPServer{
-		server: mcpSrv,
-		cfg:    cfg,
-		log:    log,
-	}
+	mcps.server.AddNotificationHandler(cancelledNotificationMethod, mcps.handleCancelledNotification)
 
 	log.Infof("MCPServer: server created with config: %+v", cfg)
-	mcps.isHttpMode, err = getIsHttpMode(cfg)
-	if err != nil {
+	if err = validateTransports(cfg); err != nil {
 		return nil, fmt.Errorf("failed to validate config: %w", err)
 	}
 
 	return mcps, nil
 }
 
-// Serve starts the MCP server in daemon (HTTP SSE) or script (stdio) mode.
-// Thread-safe. Releases resources before completion.
+// SetMetricsHandler sets the handler of the metrics endpoint. It must be called before Serve.
+func (s *MCPServer) SetMetricsHandler(h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = h
+}
+
+// SetAPIHandler sets the handler of the HTTP APIs, mounted under APIPrefix. It must be called before Serve.
+func (s *MCPServer) SetAPIHandler(h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.api = h
+}
+
+// Serve runs all enabled transports on one MCP server until ctx is done, Stop is called or a listener fails.
+// If stdio is the only transport, Serve also returns when its input is closed; otherwise the HTTP transports
+// keep serving. Thread-safe. Releases resources before completion.
 func (s *MCPServer) Serve(ctx context.Context, handler server.ToolHandlerFunc) error {
 	// Register tools immediately
+	s.registerTools(handler)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.stopServe = cancel
+	s.mu.Unlock()
+
+	listeners, err := s.listen(ctx)
+	if err != nil {
+		return err
+	}
+	httpErrs := make(chan error, len(listeners))
+	s.mu.Lock()
+	for i, ln := range listeners {
+		srv := s.httpServers[i]
+		if s.cfg.Metrics.AdminListener() && srv.Addr == s.cfg.Metrics.Addr() {
+			s.log.Infof("Serving metrics on %s%s", ln.Addr(), s.cfg.Metrics.Path)
+		} else {
+			s.log.Infof("Serving MCP over %s on %s", protocolName(srv.TLSConfig), ln.Addr())
+		}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				httpErrs <- fmt.Errorf("failed to serve HTTP MCP server on %s: %w", srv.Addr, err)
+			}
+		}()
+	}
+	s.mu.Unlock()
+
+	var stdioDone chan error
+	if s.cfg.Stdio.Enabled {
+		s.log.Info("Serving MCP over stdio")
+		stdioDone = make(chan error, 1)
+		go func() {
+			stdioDone <- s.initStdioServer(ctx, handler)
+		}()
+	}
+
+	var serveErr error
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case serveErr = <-httpErrs:
+			running = false
+		case err := <-stdioDone:
+			stdioDone = nil
+			if err != nil && !errors.Is(err, context.Canceled) {
+				serveErr = fmt.Errorf("failed to start Stdio MCP Server: %w", err)
+				running = false
+			} else if !s.cfg.HTTP.Enabled && !s.cfg.StreamableHTTP.Enabled {
+				running = false
+			} else {
+				s.log.Info("Stdio input closed, the HTTP transports keep serving")
+			}
+		}
+	}
+	cancel()
+	if stdioDone != nil {
+		<-stdioDone
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	s.mu.Lock()
+	s.shutdownTransports(shutdownCtx)
+	s.mu.Unlock()
+	s.log.Infof("MSP Server: finished")
+	return serveErr
+}
+
+// listen opens one listener per distinct address of the enabled HTTP transports, plus the admin listener
+// of the metrics if it has its own port. The HTTP APIs are served on the main HTTP transport. Transports on the same address share a listener and are told apart by path.
+// The servers stop serving streams when ctx is done.
+func (s *MCPServer) listen(ctx context.Context) ([]net.Listener, error) {
+	muxes := make(map[string]*http.ServeMux)
+	tlsConfigs := make(map[string]*tls.Config)
+	mux := func(cfg configuration.HTTPConfig) (*http.ServeMux, *auth.Middleware, error) {
+		if muxes[cfg.Addr()] == nil {
+			tlsConfig, err := auth.ServerTLSConfig(cfg.TLS)
+			if err != nil {
+				return nil, nil, err
+			}
+			muxes[cfg.Addr()] = http.NewServeMux()
+			tlsConfigs[cfg.Addr()] = tlsConfig
+		}
+		authn, err := auth.NewMiddleware(cfg.Auth, cfg.TLS.ClientCAFile != "", s.log)
+		if err != nil {
+			return nil, nil, err
+		}
+		return muxes[cfg.Addr()], authn, nil
+	}
+	if s.cfg.HTTP.Enabled {
+		m, authn, err := mux(s.cfg.HTTP)
+		if err == nil {
+			err = s.initSSEServer(m, authn)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to start HTTP MCP server: %w", err)
+		}
+	}
+	if s.cfg.StreamableHTTP.Enabled {
+		m, authn, err := mux(s.cfg.StreamableHTTP)
+		if err == nil {
+			err = s.initStreamableHTTPServer(m, authn)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to start Streamable HTTP MCP server: %w", err)
+		}
+	}
+	if s.cfg.Metrics.Enabled && s.metrics != nil {
+		if s.cfg.Metrics.AdminListener() {
+			m := http.NewServeMux()
+			m.Handle(s.cfg.Metrics.Path, s.metrics)
+			muxes[s.cfg.Metrics.Addr()] = m
+		} else {
+			transport := mainHTTPTransport(s.cfg)
+			m, authn, err := mux(transport)
+			if err != nil {
+				return nil, fmt.Errorf("failed to serve metrics: %w", err)
+			}
+			m.Handle(s.cfg.Metrics.Path, authn.Wrap(s.metrics))
+			s.log.Infof("Metrics served at %s on %s", s.cfg.Metrics.Path, transport.Addr())
+		}
+	}
+	if s.cfg.APIsEnabled() && s.api != nil {
+		transport := mainHTTPTransport(s.cfg)
+		m, authn, err := mux(transport)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serve the HTTP APIs: %w", err)
+		}
+		m.Handle(APIPrefix, authn.Wrap(s.api))
+		s.log.Infof("HTTP APIs served at %s on %s", APIPrefix, transport.Addr())
+	}
+
+	addrs := make([]string, 0, len(muxes))
+	for addr := range muxes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	var listeners []net.Listener
+	var servers []*http.Server
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		if tlsConfigs[addr] != nil {
+			ln = tls.NewListener(ln, tlsConfigs[addr])
+		}
+		listeners = append(listeners, ln)
+		servers = append(servers, &http.Server{
+			Addr:      addr,
+			Handler:   muxes[addr],
+			TLSConfig: tlsConfigs[addr],
+			// Long-lived SSE streams end with ctx, otherwise Shutdown would wait for them
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		})
+	}
+	s.mu.Lock()
+	s.httpServers = servers
+	s.mu.Unlock()
+	return listeners, nil
+}
+
+// registerTools adds the tools to the server. Calls of the main and end session tools go to the handler.
+func (s *MCPServer) registerTools(handler server.ToolHandlerFunc) {
 	for _, tool := range s.buildTools() {
 		var h server.ToolHandlerFunc = nil
-		if tool.Name == s.cfg.Tool.Name {
+		if s.isMainTool(tool.Name) || tool.Name == EndSessionToolName {
 			h = func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				s.log.AddHook(&LogHook{server: s, ctx: ctx})
 				if handler == nil {
 					return nil, fmt.Errorf("main tool handler is not set for '%s'", tool.Name)
 				}
+				ctx, done := s.trackRequest(ctx)
+				defer done()
 				res, err := handler(ctx, req)
 				return res, err
 			}
@@ -99,34 +310,40 @@ func (s *MCPServer) Serve(ctx context.Context, handler server.ToolHandlerFunc) e
 		}
 		s.server.AddTool(tool, h)
 	}
+}
 
-	if s.isHttpMode {
-		s.log.Info("Running in daemon mode with HTTP SSE MCP server")
-		if err := s.initSSEServer(handler); err != nil {
-			return fmt.Errorf("failed to start HTTP MCP server: %w", err)
-		}
-	} else {
-		s.log.Info("Running in script mode with stdio MCP server")
-		if err := s.initStdioServer(ctx, handler); err != nil {
-			return fmt.Errorf("failed to start Stdio MCP Server: %w", err)
-		}
+// initSSEServer mounts the HTTP SSE MCP server on mux behind authn.
+func (s *MCPServer) initSSEServer(mux *http.ServeMux, authn *auth.Middleware) error {
+	if s.server == nil {
+		return fmt.Errorf("server is not *server.MCPServer")
 	}
-	s.log.Infof("MSP Server: finished")
+	scheme := "http"
+	if s.cfg.HTTP.TLS.Enabled() {
+		scheme = "https"
+	}
+	baseUrl := fmt.Sprintf("%s://%s", scheme, s.cfg.HTTP.Addr())
+	s.sseServer = server.NewSSEServer(s.server,
+		server.WithBaseURL(baseUrl),
+		server.WithStaticBasePath(s.cfg.HTTP.Path),
+		server.WithSSEContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+			return withRequestIDSlot(ctx)
+		}),
+	)
+	mux.Handle(s.sseServer.CompleteSsePath(), authn.Wrap(liftStructuredContentSSE(s.sseServer)))
+	mux.Handle(s.sseServer.CompleteMessagePath(), authn.Wrap(s.sseServer))
+	s.log.Infof("MCP SSE server initialized at %s", s.sseServer.CompleteSsePath())
 	return nil
 }
 
-// initSSEServer initializes and starts the HTTP SSE MCP server.
-func (s *MCPServer) initSSEServer(handler server.ToolHandlerFunc) error {
+// initStreamableHTTPServer mounts the Streamable HTTP MCP server on mux behind authn.
+func (s *MCPServer) initStreamableHTTPServer(mux *http.ServeMux, authn *auth.Middleware) error {
 	if s.server == nil {
 		return fmt.Errorf("server is not *server.MCPServer")
 	}
-	s.log.Info("MCP SSE server initialized successfully")
-	addr := fmt.Sprintf("%s:%d", s.cfg.HTTP.Host, s.cfg.HTTP.Port)
-	baseUrl := fmt.Sprintf("http://%s:%d", s.cfg.HTTP.Host, s.cfg.HTTP.Port)
-	s.sseServer = server.NewSSEServer(s.server, server.WithBaseURL(baseUrl))
-	if err := s.sseServer.Start(addr); err != nil {
-		return fmt.Errorf("failed to serve SSE MCP server: %w", err)
-	}
+	s.streamable = newStreamableHTTPHandler(s)
+	path := streamableHTTPPath(s.cfg.StreamableHTTP)
+	mux.Handle(path, authn.Wrap(s.streamable))
+	s.log.Infof("MCP Streamable HTTP server initialized at %s", path)
 	return nil
 }
 
@@ -136,17 +353,60 @@ func (s *MCPServer) initStdioServer(ctx context.Context, handler server.ToolHand
 		return fmt.Errorf("server is not *server.MCPServer")
 	}
 	s.log.Info("MCP Stdio server initialized successfully")
-	return server.NewStdioServer(s.server).Listen(ctx, os.Stdin, os.Stdout)
+	return s.serveStdio(ctx, os.Stdin, os.Stdout)
 }
 
-// buildMainTool creates the main tool for the server.
-func (s *MCPServer) buildMainTool() mcp.Tool {
-	return mcp.NewTool(s.cfg.Tool.Name,
-		mcp.WithDescription(s.cfg.Tool.Description),
-		mcp.WithString(s.cfg.Tool.ArgumentName,
-			mcp.Description(s.cfg.Tool.ArgumentDescription),
+// isMainTool reports whether the tool runs the agent.
+func (s *MCPServer) isMainTool(name string) bool {
+	for _, tool := range s.cfg.MainTools() {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMainTool creates a tool that runs the agent.
+func (s *MCPServer) buildMainTool(tool configuration.MCPServerToolConfig) mcp.Tool {
+	opts := []mcp.ToolOption{
+		mcp.WithDescription(tool.Description),
+		mcp.WithString(tool.ArgumentName,
+			mcp.Description(tool.ArgumentDescription),
 			mcp.Required(),
 		),
+		mcp.WithNumber(BudgetArgumentName,
+			mcp.Description("Optional maximum cost of this call in USD. It can lower the budget configured for the agent, but not raise it."),
+		),
+	}
+	if s.cfg.SessionsEnabled {
+		opts = append(opts, mcp.WithString(SessionIDArgumentName,
+			mcp.Description("Optional ID of a previous conversation to continue. Omit it to start a new conversation."),
+		))
+	}
+	t := mcp.NewTool(tool.Name, opts...)
+	// The arguments of the input schema come next to the main one
+	for name, property := range tool.InputProperties() {
+		t.InputSchema.Properties[name] = property
+	}
+	if required, ok := tool.InputSchema["required"].([]any); ok {
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				t.InputSchema.Required = append(t.InputSchema.Required, s)
+			}
+		}
+	}
+	return t
+}
+
+// buildEndSessionTool creates a tool for deleting a saved conversation.
+func (s *MCPServer) buildEndSessionTool() mcp.Tool {
+	var names []string
+	for _, tool := range s.cfg.MainTools() {
+		names = append(names, fmt.Sprintf("`%s`", tool.Name))
+	}
+	return mcp.NewTool(EndSessionToolName,
+		mcp.WithDescription(fmt.Sprintf("End a conversation started with %s and delete its history.", strings.Join(names, " or "))),
+		mcp.WithString(SessionIDArgumentName, mcp.Required(), mcp.Description("ID of the conversation to end")),
 	)
 }
 
@@ -162,16 +422,33 @@ func (s *MCPServer) buildLoggingTool() mcp.Tool {
 func (s *MCPServer) Stop(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.sseServer != nil {
-		if err := s.sseServer.Shutdown(ctx); err != nil {
-			s.log.Warnf("Error stopping SSE server: %v", err)
-		}
-		s.sseServer = nil
+	if s.stopServe != nil {
+		s.stopServe()
+		s.stopServe = nil
 	}
-	s.server = nil
+	// The server itself is kept: requests that are still finishing and notifications sent from other
+	// goroutines use it without the lock
+	s.shutdownTransports(ctx)
 	return nil
 }
 
+// shutdownTransports closes the HTTP listeners and ends the Streamable HTTP sessions. The caller holds mu.
+func (s *MCPServer) shutdownTransports(ctx context.Context) {
+	for _, srv := range s.httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.log.Warnf("Error stopping HTTP server on %s: %v", srv.Addr, err)
+			// Drop the connections that did not finish in time
+			_ = srv.Close()
+		}
+	}
+	s.httpServers = nil
+	s.sseServer = nil
+	if s.streamable != nil {
+		s.streamable.close(ctx)
+		s.streamable = nil
+	}
+}
+
 // BuildHooks creates a set of hooks for logging MCP events.
 // Used for debugging and extending server behavior.
 func (s *MCPServer) BuildHooks() *server.Hooks {
@@ -234,22 +511,127 @@ func (s *MCPServer) GetServer() *server.MCPServer {
 
 // buildTools returns a list of all tools to register on the server.
 func (s *MCPServer) buildTools() []mcp.Tool {
-	tools := []mcp.Tool{s.buildMainTool()}
+	var tools []mcp.Tool
+	for _, tool := range s.cfg.MainTools() {
+		tools = append(tools, s.buildMainTool(tool))
+	}
+	if s.cfg.SessionsEnabled {
+		tools = append(tools, s.buildEndSessionTool())
+	}
 	if s.cfg.MCPLogEnabled {
 		tools = append(tools, s.buildLoggingTool())
 	}
 	return tools
 }
 
-func getIsHttpMode(cfg configuration.MCPServerConfig) (bool, error) {
+// validateTransports checks that at least one transport is enabled, that HTTP transports on the same
+// address do not claim the same path and share the TLS settings of their listener, and that the metrics
+// and API endpoints do not collide with a transport.
+func validateTransports(cfg configuration.MCPServerConfig) error {
+	if !cfg.HTTP.Enabled && !cfg.StreamableHTTP.Enabled && !cfg.Stdio.Enabled {
+		return fmt.Errorf("at least one of the stdio, HTTP and Streamable HTTP transports must be enabled")
+	}
+	if err := validateMetrics(cfg); err != nil {
+		return err
+	}
+	if err := validateAPI(cfg); err != nil {
+		return err
+	}
+	if cfg.HTTP.Enabled && cfg.StreamableHTTP.Enabled && cfg.HTTP.Addr() == cfg.StreamableHTTP.Addr() {
+		if cfg.HTTP.TLS != cfg.StreamableHTTP.TLS {
+			return fmt.Errorf("the HTTP and Streamable HTTP transports share %s, so they need the same TLS settings", cfg.HTTP.Addr())
+		}
+		ssePrefix := ""
+		if base := strings.Trim(cfg.HTTP.Path, "/"); base != "" {
+			ssePrefix = "/" + base
+		}
+		path := streamableHTTPPath(cfg.StreamableHTTP)
+		if path == ssePrefix+"/sse" || path == ssePrefix+"/message" {
+			return fmt.Errorf("the Streamable HTTP path %s is taken by the SSE transport on %s", path, cfg.HTTP.Addr())
+		}
+	}
+	return nil
+}
+
+// validateMetrics checks that the metrics endpoint has an address or path of its own.
+func validateMetrics(cfg configuration.MCPServerConfig) error {
+	if !cfg.Metrics.Enabled {
+		return nil
+	}
+	if cfg.Metrics.AdminListener() {
+		for _, transport := range []configuration.HTTPConfig{cfg.HTTP, cfg.StreamableHTTP} {
+			if transport.Enabled && transport.Addr() == cfg.Metrics.Addr() {
+				return fmt.Errorf("the metrics listener and an HTTP transport share %s; set the metrics port to 0 to serve them on the transport", cfg.Metrics.Addr())
+			}
+		}
+		return nil
+	}
+	if !cfg.HTTP.Enabled && !cfg.StreamableHTTP.Enabled {
+		return fmt.Errorf("the metrics need a port of their own when no HTTP transport is enabled")
+	}
+	if mainTransportPaths(cfg)[cfg.Metrics.Path] {
+		return fmt.Errorf("the metrics path %s is taken by an MCP transport", cfg.Metrics.Path)
+	}
+	if cfg.APIsEnabled() && strings.HasPrefix(cfg.Metrics.Path, APIPrefix) {
+		return fmt.Errorf("the metrics path %s is taken by the HTTP APIs under %s", cfg.Metrics.Path, APIPrefix)
+	}
+	return nil
+}
+
+// validateAPI checks that the HTTP APIs have an HTTP transport to be served on and the prefix to themselves.
+func validateAPI(cfg configuration.MCPServerConfig) error {
+	if !cfg.APIsEnabled() {
+		return nil
+	}
+	if !cfg.HTTP.Enabled && !cfg.StreamableHTTP.Enabled {
+		return fmt.Errorf("the HTTP APIs need the HTTP or Streamable HTTP transport")
+	}
+	for path := range mainTransportPaths(cfg) {
+		if strings.HasPrefix(path, APIPrefix) {
+			return fmt.Errorf("the MCP transport path %s is inside %s, where the HTTP APIs are served", path, APIPrefix)
+		}
+	}
+	return nil
+}
+
+// mainHTTPTransport returns the transport that also serves the HTTP APIs and the metrics without an admin port:
+// Streamable HTTP if it is enabled, otherwise HTTP SSE.
+func mainHTTPTransport(cfg configuration.MCPServerConfig) configuration.HTTPConfig {
+	if cfg.StreamableHTTP.Enabled {
+		return cfg.StreamableHTTP
+	}
+	return cfg.HTTP
+}
+
+// mainTransportPaths returns the paths the MCP transports take on the address of the main HTTP transport.
+func mainTransportPaths(cfg configuration.MCPServerConfig) map[string]bool {
+	taken := map[string]bool{}
+	if cfg.StreamableHTTP.Enabled {
+		taken[streamableHTTPPath(cfg.StreamableHTTP)] = true
+	}
+	if cfg.HTTP.Enabled && (!cfg.StreamableHTTP.Enabled || cfg.HTTP.Addr() == cfg.StreamableHTTP.Addr()) {
+		ssePrefix := ""
+		if base := strings.Trim(cfg.HTTP.Path, "/"); base != "" {
+			ssePrefix = "/" + base
+		}
+		taken[ssePrefix+"/sse"] = true
+		taken[ssePrefix+"/message"] = true
+	}
+	return taken
+}
 
-	isHttpEnabled := cfg.HTTP.Enabled
-	isStdioEnabled := cfg.Stdio.Enabled
-	if isHttpEnabled && isStdioEnabled {
-		return false, fmt.Errorf("both HTTP and Stdio modes cannot be enabled at the same time")
+// protocolName names the protocol of a listener for the logs.
+func protocolName(tlsConfig *tls.Config) string {
+	if tlsConfig == nil {
+		return "HTTP"
 	}
-	if !isHttpEnabled && !isStdioEnabled {
-		return false, fmt.Errorf("either HTTP or Stdio mode must be enabled")
+	return "HTTPS"
+}
+
+// streamableHTTPPath returns the endpoint of the Streamable HTTP transport.
+func streamableHTTPPath(cfg configuration.HTTPConfig) string {
+	if cfg.Path == "" {
+		return DefaultStreamableHTTPPath
 	}
-	return isHttpEnabled, nil
+	return "/" + strings.Trim(cfg.Path, "/")
 }
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -278,6 +280,84 @@ func TestMCPServer_buildTools(t *testing.T) {
 	}
 }
 
+func TestMCPServer_buildTools_Sessions(t *testing.T) {
+	cfg := configuration.MCPServerConfigForTest()
+	cfg.SessionsEnabled = true
+	srv, err := NewMCPServer(cfg, newTestLogger())
+	if err != nil {
+		t.Fatalf("failed to create MCPServer: %v", err)
+	}
+	tools := map[string]mcp.Tool{}
+	for _, tool := range srv.buildTools() {
+		tools[tool.Name] = tool
+	}
+	mainTool, ok := tools["test-tool"]
+	if !ok {
+		t.Fatal("main tool not found in buildTools")
+	}
+	if _, ok := mainTool.InputSchema.Properties[SessionIDArgumentName]; !ok {
+		t.Errorf("main tool should accept %s", SessionIDArgumentName)
+	}
+	for _, required := range mainTool.InputSchema.Required {
+		if required == SessionIDArgumentName {
+			t.Errorf("%s should be optional", SessionIDArgumentName)
+		}
+	}
+	if _, ok := tools[EndSessionToolName]; !ok {
+		t.Errorf("%s tool not found in buildTools", EndSessionToolName)
+	}
+
+	cfg.SessionsEnabled = false
+	srv, _ = NewMCPServer(cfg, newTestLogger())
+	for _, tool := range srv.buildTools() {
+		if tool.Name == EndSessionToolName {
+			t.Errorf("%s tool should not be exposed when sessions are disabled", EndSessionToolName)
+		}
+	}
+}
+
+func TestMCPServer_buildTools_SeveralMainTools(t *testing.T) {
+	cfg := configuration.MCPServerConfigForTest()
+	cfg.SessionsEnabled = true
+	cfg.Tools = []configuration.MCPServerToolConfig{
+		{Name: "summarize", Description: "Summarize a text", ArgumentName: "text", ArgumentDescription: "Text"},
+		{Name: "classify", Description: "Classify a text", ArgumentName: "input", ArgumentDescription: "Text", InputSchema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"team": map[string]any{"type": "string"}, "urgent": map[string]any{"type": "boolean"}},
+			"required":   []any{"team"},
+		}},
+	}
+	srv, err := NewMCPServer(cfg, newTestLogger())
+	if err != nil {
+		t.Fatalf("failed to create MCPServer: %v", err)
+	}
+	tools := map[string]mcp.Tool{}
+	for _, tool := range srv.buildTools() {
+		tools[tool.Name] = tool
+	}
+	if _, ok := tools["test-tool"]; ok {
+		t.Error("agent.tool should be replaced by the tools list")
+	}
+	if _, ok := tools["classify"].InputSchema.Properties["input"]; !ok {
+		t.Errorf("classify should take its own argument, got %v", tools["classify"].InputSchema.Properties)
+	}
+	if _, ok := tools["classify"].InputSchema.Properties["urgent"]; !ok {
+		t.Errorf("classify should take the arguments of its input schema, got %v", tools["classify"].InputSchema.Properties)
+	}
+	if got := tools["classify"].InputSchema.Required; !slices.Contains(got, "team") || !slices.Contains(got, "input") {
+		t.Errorf("classify should require its own and the required schema arguments, got %v", got)
+	}
+	if _, ok := tools["summarize"].InputSchema.Properties["team"]; ok {
+		t.Error("the input schema of classify should not leak into summarize")
+	}
+	if !srv.isMainTool("summarize") || !srv.isMainTool("classify") || srv.isMainTool(EndSessionToolName) {
+		t.Error("isMainTool should match exactly the configured tools")
+	}
+	if desc := tools[EndSessionToolName].Description; !strings.Contains(desc, "`summarize` or `classify`") {
+		t.Errorf("end session tool should mention all main tools, got %q", desc)
+	}
+}
+
 func Test_initSSEServer_and_initStdioServer(t *testing.T) {
 	cfg := configuration.MCPServerConfigForTest()
 	log := newTestLogger()
@@ -287,7 +367,7 @@ func Test_initSSEServer_and_initStdioServer(t *testing.T) {
 	}
 	t.Run("SSE server not initialized", func(t *testing.T) {
 		srv.server = nil
-		err := srv.initSSEServer(nil)
+		err := srv.initSSEServer(nil, nil)
 		if err == nil || err.Error() != "server is not *server.MCPServer" {
 			t.Errorf("expected error for nil server, got %v", err)
 		}
@@ -308,41 +388,89 @@ func Test_buildMainTool_and_buildLoggingTool(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create MCPServer: %v", err)
 	}
-	mainTool := srv.buildMainTool()
+	mainTool := srv.buildMainTool(cfg.Tool)
 	if mainTool.Name != "test-tool" {
 		t.Errorf("expected test-tool, got %s", mainTool.Name)
 	}
+	if _, ok := mainTool.InputSchema.Properties[BudgetArgumentName]; !ok {
+		t.Errorf("main tool should accept %s", BudgetArgumentName)
+	}
 	logTool := srv.buildLoggingTool()
 	if logTool.Name != "logging/setLevel" {
 		t.Errorf("expected logging/setLevel, got %s", logTool.Name)
 	}
 }
 
-func Test_getIsHttpMode(t *testing.T) {
+func Test_validateTransports(t *testing.T) {
 	cfg := configuration.MCPServerConfigForTest()
+	cfg.HTTP.Enabled = false
+	cfg.Stdio.Enabled = false
+	err := validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "at least one of the stdio, HTTP and Streamable HTTP transports must be enabled")
+
 	cfg.HTTP.Enabled = true
 	cfg.Stdio.Enabled = true
-	_, err := getIsHttpMode(cfg)
+	cfg.StreamableHTTP = configuration.HTTPConfig{Enabled: true, Host: cfg.HTTP.Host, Port: cfg.HTTP.Port}
+	assert.NoError(t, validateTransports(cfg), "all transports at once, SSE and Streamable HTTP on one port")
+
+	cfg.HTTP.Path = "/api/"
+	cfg.StreamableHTTP.Path = "api/sse"
+	err = validateTransports(cfg)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "both HTTP and Stdio modes cannot be enabled")
+	assert.Contains(t, err.Error(), "taken by the SSE transport")
 
-	cfg.HTTP.Enabled = false
-	cfg.Stdio.Enabled = false
-	_, err = getIsHttpMode(cfg)
+	cfg.StreamableHTTP.Path = "/mcp"
+	cfg.StreamableHTTP.TLS = configuration.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}
+	err = validateTransports(cfg)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "either HTTP or Stdio mode must be enabled")
+	assert.Contains(t, err.Error(), "need the same TLS settings")
 
-	cfg.HTTP.Enabled = true
-	cfg.Stdio.Enabled = false
-	ok, err := getIsHttpMode(cfg)
-	assert.NoError(t, err)
-	assert.True(t, ok)
+	cfg.StreamableHTTP.Port++
+	assert.NoError(t, validateTransports(cfg), "another port has its own listener")
+
+	cfg.Metrics = configuration.MetricsConfig{Enabled: true, Path: "/mcp"}
+	err = validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the metrics path /mcp is taken by an MCP transport")
+
+	cfg.Metrics.Path = "/metrics"
+	assert.NoError(t, validateTransports(cfg), "metrics on the Streamable HTTP transport")
+
+	cfg.Metrics.Host, cfg.Metrics.Port = cfg.HTTP.Host, cfg.HTTP.Port
+	err = validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the metrics listener and an HTTP transport share")
 
 	cfg.HTTP.Enabled = false
-	cfg.Stdio.Enabled = true
-	ok, err = getIsHttpMode(cfg)
-	assert.NoError(t, err)
-	assert.False(t, ok)
+	cfg.StreamableHTTP.Enabled = false
+	assert.NoError(t, validateTransports(cfg), "metrics on an admin port in stdio mode")
+
+	cfg.Metrics.Port = 0
+	err = validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the metrics need a port of their own")
+
+	cfg.Metrics = configuration.MetricsConfig{}
+	cfg.APIEnabled = true
+	err = validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the HTTP APIs need the HTTP or Streamable HTTP transport")
+
+	cfg.StreamableHTTP.Enabled = true
+	assert.NoError(t, validateTransports(cfg))
+
+	cfg.APIEnabled, cfg.OpenAIEnabled = false, true
+	cfg.StreamableHTTP.Path = "/v1/mcp"
+	err = validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the MCP transport path /v1/mcp is inside /v1/")
+
+	cfg.StreamableHTTP.Path = "/mcp"
+	cfg.Metrics = configuration.MetricsConfig{Enabled: true, Path: "/v1/metrics"}
+	err = validateTransports(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the metrics path /v1/metrics is taken by the HTTP APIs")
 }
 
 func Test_initSSEServer_and_initStdioServer_nilServer(t *testing.T) {
@@ -350,7 +478,7 @@ func Test_initSSEServer_and_initStdioServer_nilServer(t *testing.T) {
 	log := newTestLogger()
 	srv, _ := NewMCPServer(cfg, log)
 	srv.server = nil
-	err := srv.initSSEServer(nil)
+	err := srv.initSSEServer(nil, nil)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "server is not *server.MCPServer")
 
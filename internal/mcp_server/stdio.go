@@ -0,0 +1,200 @@
+// Package mcp_server: stdio transport of the MCP server
+package mcp_server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const stdioSessionID = "stdio"
+
+// stdioSession is the only client session of the stdio transport.
+// Besides receiving requests, it can send requests to the client, which mcp-go sessions cannot.
+type stdioSession struct {
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+	loggingLevel  atomic.Value
+	elicitation   atomic.Bool // the client declared the elicitation capability
+
+	writer   *stdioWriter
+	outgoing *clientRequests
+}
+
+func newStdioSession(writer *stdioWriter) *stdioSession {
+	return &stdioSession{
+		notifications: make(chan mcp.JSONRPCNotification, 100),
+		writer:        writer,
+		outgoing:      newClientRequests(),
+	}
+}
+
+func (s *stdioSession) SessionID() string {
+	return stdioSessionID
+}
+
+func (s *stdioSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *stdioSession) Initialize() {
+	s.loggingLevel.Store(mcp.LoggingLevelError)
+	s.initialized.Store(true)
+}
+
+func (s *stdioSession) Initialized() bool {
+	return s.initialized.Load()
+}
+
+func (s *stdioSession) SetLogLevel(level mcp.LoggingLevel) {
+	s.loggingLevel.Store(level)
+}
+
+func (s *stdioSession) GetLogLevel() mcp.LoggingLevel {
+	level, ok := s.loggingLevel.Load().(mcp.LoggingLevel)
+	if !ok {
+		return mcp.LoggingLevelError
+	}
+	return level
+}
+
+var _ server.SessionWithLogging = (*stdioSession)(nil)
+
+// request sends a request to the client and waits for its result.
+// If ctx is done first, the client is told to cancel the request.
+func (s *stdioSession) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	return s.outgoing.send(ctx, method, params, s.writer.write)
+}
+
+// canElicit reports whether the client declared the elicitation capability.
+func (s *stdioSession) canElicit(ctx context.Context) bool {
+	return s.elicitation.Load()
+}
+
+// stdioWriter serializes messages written to the output by concurrent handlers.
+type stdioWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *stdioWriter) write(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = fmt.Fprintf(w.out, "%s\n", data)
+	return err
+}
+
+// serveStdio reads JSON-RPC messages from in and writes responses and notifications to out.
+// Unlike the stdio server of mcp-go, tool calls are handled concurrently, so that a notifications/cancelled
+// sent by the client while a call is running is processed. Other messages are handled in the order they arrive.
+// It returns when ctx is cancelled or, after the running calls finish, when the input is closed.
+func (s *MCPServer) serveStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	writer := &stdioWriter{out: out}
+	session := newStdioSession(writer)
+	if err := s.server.RegisterSession(ctx, session); err != nil {
+		return fmt.Errorf("register session: %w", err)
+	}
+	defer s.server.UnregisterSession(ctx, session.SessionID())
+	ctx = s.server.WithContext(ctx, session)
+
+	go func() {
+		for {
+			select {
+			case notification := <-session.notifications:
+				if err := writer.write(notification); err != nil {
+					s.log.Warnf("MCPServer: failed to write notification: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var calls sync.WaitGroup
+	defer calls.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			// Nobody is left to answer requests sent to the client
+			session.outgoing.close(errors.New("client closed the input"))
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read input: %w", err)
+		case line := <-lines:
+			var message struct {
+				ID     any    `json:"id"`
+				Method string `json:"method"`
+				clientResponse
+			}
+			if err := json.Unmarshal([]byte(line), &message); err != nil {
+				if err := writer.write(mcp.NewJSONRPCError(mcp.NewRequestId(nil), mcp.PARSE_ERROR, "Parse error", nil)); err != nil {
+					return fmt.Errorf("failed to write response: %w", err)
+				}
+				continue
+			}
+			if message.Method == "" && message.ID != nil {
+				if !session.outgoing.deliver(message.ID, message.clientResponse) {
+					s.log.Warnf("MCPServer: response to unknown request %v", message.ID)
+				}
+				continue
+			}
+			if message.Method == string(mcp.MethodInitialize) {
+				session.elicitation.Store(declaresElicitation([]byte(line)))
+			}
+			msgCtx := withRequestIDSlot(ctx)
+			if message.Method != string(mcp.MethodToolsCall) {
+				s.handleStdioMessage(msgCtx, line, writer)
+				continue
+			}
+			calls.Add(1)
+			go func() {
+				defer calls.Done()
+				s.handleStdioMessage(msgCtx, line, writer)
+			}()
+		}
+	}
+}
+
+// handleStdioMessage passes one message to the MCP server and writes the response, if any.
+func (s *MCPServer) handleStdioMessage(ctx context.Context, line string, writer *stdioWriter) {
+	response := s.server.HandleMessage(ctx, json.RawMessage(line))
+	if response == nil {
+		return
+	}
+	if err := writer.write(liftStructuredContent(response)); err != nil {
+		s.log.Warnf("MCPServer: failed to write response: %v", err)
+	}
+}
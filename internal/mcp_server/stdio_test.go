@@ -0,0 +1,229 @@
+package mcp_server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stdioClient drives serveStdio through pipes.
+type stdioClient struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Scanner
+	result chan error
+}
+
+func startStdioServer(t *testing.T, ctx context.Context, srv *MCPServer) *stdioClient {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &stdioClient{t: t, in: inW, out: bufio.NewScanner(outR), result: make(chan error, 1)}
+	go func() {
+		c.result <- srv.serveStdio(ctx, inR, outW)
+	}()
+	return c
+}
+
+func (c *stdioClient) send(message string) {
+	_, err := io.WriteString(c.in, message+"\n")
+	require.NoError(c.t, err)
+}
+
+// readResponse returns the next message that has an ID, skipping notifications.
+func (c *stdioClient) readResponse() map[string]any {
+	for c.out.Scan() {
+		var msg map[string]any
+		require.NoError(c.t, json.Unmarshal(c.out.Bytes(), &msg))
+		if _, ok := msg["id"]; ok {
+			return msg
+		}
+	}
+	c.t.Fatalf("output closed: %v", c.out.Err())
+	return nil
+}
+
+func TestMCPServer_serveStdio_Cancellation(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	srv.registerTools(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return mcp.NewToolResultError("cancelled"), nil
+		case <-time.After(5 * time.Second):
+			return mcp.NewToolResultText("done"), nil
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := startStdioServer(t, ctx, srv)
+
+	client.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	client.readResponse()
+	client.send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"test-tool","arguments":{"arg":"hi"}}}`)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("tool call did not start: %v", client.readResponse())
+	}
+
+	// The loop keeps reading while the call runs, so other requests are answered
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	assert.Equal(t, float64(2), client.readResponse()["id"])
+
+	client.send(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1,"reason":"user abort"}}`)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("tool call was not cancelled")
+	}
+	assert.Equal(t, float64(1), client.readResponse()["id"])
+
+	require.NoError(t, client.in.Close())
+	select {
+	case err := <-client.result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop after the input was closed")
+	}
+}
+
+func TestInFlightRequests(t *testing.T) {
+	r := newInFlightRequests()
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	r.add("s1", requestKey(float64(1)), cancel1)
+	r.add("s1", requestKey("a"), cancel2)
+
+	assert.False(t, r.cancel("s2", requestKey(float64(1))), "requests of other sessions must not match")
+	assert.True(t, r.cancel("s1", requestKey(mcp.NewRequestId(int64(1)))))
+	assert.Error(t, ctx1.Err())
+
+	assert.Equal(t, 2, r.cancelSession("s1"))
+	assert.Error(t, ctx2.Err())
+
+	r.remove("s1", requestKey(float64(1)))
+	r.remove("s1", requestKey("a"))
+	assert.Equal(t, 0, r.cancelSession("s1"))
+}
+
+func TestMCPServer_Elicit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// startServer starts a server whose tool asks the user and returns the answer
+	startServer := func(t *testing.T) *stdioClient {
+		srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+		require.NoError(t, err)
+		srv.registerTools(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			result, err := srv.Elicit(ctx, "Allow?", map[string]any{"type": "object"})
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("%s %v", result.Action, result.Content["approve"])), nil
+		})
+		return startStdioServer(t, ctx, srv)
+	}
+
+	t.Run("supported", func(t *testing.T) {
+		client := startServer(t)
+		client.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{"elicitation":{}},"clientInfo":{"name":"test","version":"1"}}}`)
+		client.readResponse()
+		client.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"test-tool","arguments":{"arg":"hi"}}}`)
+
+		request := client.readResponse()
+		assert.Equal(t, "elicitation/create", request["method"])
+		assert.Equal(t, "Allow?", request["params"].(map[string]any)["message"])
+		id, err := json.Marshal(request["id"])
+		require.NoError(t, err)
+		client.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"action":"accept","content":{"approve":true}}}`, id))
+
+		response := client.readResponse()
+		assert.Equal(t, float64(1), response["id"])
+		text := response["result"].(map[string]any)["content"].([]any)[0].(map[string]any)["text"]
+		assert.Equal(t, "accept true", text)
+		require.NoError(t, client.in.Close())
+	})
+
+	t.Run("not declared by the client", func(t *testing.T) {
+		client := startServer(t)
+		client.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+		client.readResponse()
+		client.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"test-tool","arguments":{"arg":"hi"}}}`)
+		response := client.readResponse()
+		text := response["result"].(map[string]any)["content"].([]any)[0].(map[string]any)["text"]
+		assert.Equal(t, ErrElicitationUnsupported.Error(), text)
+		require.NoError(t, client.in.Close())
+	})
+}
+
+func TestLiftStructuredContent(t *testing.T) {
+	result := mcp.NewToolResultText(`{"title": "Disk full"}`)
+	result.Meta = map[string]any{StructuredContentMetaKey: map[string]any{"title": "Disk full"}, "sessionId": "s1"}
+	response := mcp.JSONRPCResponse{JSONRPC: mcp.JSONRPC_VERSION, ID: mcp.NewRequestId(1), Result: *result}
+
+	data, err := json.Marshal(liftStructuredContent(response))
+	require.NoError(t, err)
+	var decoded struct {
+		Result struct {
+			Meta              map[string]any `json:"_meta"`
+			Content           []any          `json:"content"`
+			StructuredContent map[string]any `json:"structuredContent"`
+		} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[string]any{"title": "Disk full"}, decoded.Result.StructuredContent)
+	assert.Equal(t, map[string]any{"sessionId": "s1"}, decoded.Result.Meta)
+	assert.Len(t, decoded.Result.Content, 1)
+
+	plain := mcp.JSONRPCResponse{JSONRPC: mcp.JSONRPC_VERSION, ID: mcp.NewRequestId(2), Result: *mcp.NewToolResultText("hi")}
+	assert.Equal(t, mcp.JSONRPCMessage(plain), liftStructuredContent(plain))
+}
+
+func TestLiftStructuredContentSSE(t *testing.T) {
+	structured := `event: message
+data: {"jsonrpc":"2.0","id":1,"result":{"_meta":{"structuredContent":{"title":"Disk full"},"sessionId":"s1"},"content":[{"type":"text","text":"{}"}]}}
+
+`
+	plain := "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":2,\"result\":{\"content\":[]}}\n\n"
+	endpoint := "event: endpoint\ndata: /message?sessionId=s1\r\n\r\n"
+	handler := liftStructuredContentSSE(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, event := range []string{endpoint, structured, plain} {
+			_, _ = fmt.Fprint(w, event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sse", nil))
+
+	body, found := strings.CutPrefix(rec.Body.String(), endpoint)
+	require.True(t, found, "the endpoint event is kept")
+	events := strings.SplitAfter(body, "\n\n")
+	require.Len(t, events, 3)
+	assert.Equal(t, plain, events[1], "responses without structured content are kept")
+	var decoded struct {
+		Result struct {
+			Meta              map[string]any `json:"_meta"`
+			StructuredContent map[string]any `json:"structuredContent"`
+		} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(events[0], sseMessagePrefix), "\n\n")), &decoded))
+	assert.Equal(t, map[string]any{"title": "Disk full"}, decoded.Result.StructuredContent)
+	assert.Equal(t, map[string]any{"sessionId": "s1"}, decoded.Result.Meta)
+	assert.True(t, rec.Flushed)
+}
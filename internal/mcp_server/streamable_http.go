@@ -0,0 +1,435 @@
+// Package mcp_server: Streamable HTTP transport of the MCP server
+package mcp_server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// DefaultStreamableHTTPPath is the endpoint of the Streamable HTTP transport if none is configured.
+	DefaultStreamableHTTPPath = "/mcp"
+	sessionIDHeader           = "Mcp-Session-Id"
+	// streamableSessionIdleTimeout is how long a session without requests is kept. Clients that go away
+	// without a DELETE would otherwise leave their sessions registered for the life of the process.
+	streamableSessionIdleTimeout = 30 * time.Minute
+)
+
+// streamableSession is a client session of the Streamable HTTP transport.
+// Its notifications are delivered on whichever SSE stream of the session reads them first.
+type streamableSession struct {
+	id            string
+	client        string // authenticated client that initialized the session; only it may use the session
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+	loggingLevel  atomic.Value
+	elicitation   atomic.Bool // the client declared the elicitation capability
+	outgoing      *clientRequests
+	// done is cancelled when the session ends, which cancels the requests of the session still being served
+	done   context.Context
+	cancel context.CancelFunc
+
+	// Guarded by the mutex of the handler
+	requests int       // Requests of the session being served, including notification streams
+	lastUsed time.Time // When the last request started or ended
+}
+
+func newStreamableSession(client string) (*streamableSession, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	done, cancel := context.WithCancel(context.Background())
+	return &streamableSession{
+		id:            hex.EncodeToString(id),
+		client:        client,
+		notifications: make(chan mcp.JSONRPCNotification, 100),
+		outgoing:      newClientRequests(),
+		done:          done,
+		cancel:        cancel,
+	}, nil
+}
+
+func (s *streamableSession) SessionID() string {
+	return s.id
+}
+
+func (s *streamableSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *streamableSession) Initialize() {
+	s.loggingLevel.Store(mcp.LoggingLevelError)
+	s.initialized.Store(true)
+}
+
+func (s *streamableSession) Initialized() bool {
+	return s.initialized.Load()
+}
+
+func (s *streamableSession) SetLogLevel(level mcp.LoggingLevel) {
+	s.loggingLevel.Store(level)
+}
+
+func (s *streamableSession) GetLogLevel() mcp.LoggingLevel {
+	level, ok := s.loggingLevel.Load().(mcp.LoggingLevel)
+	if !ok {
+		return mcp.LoggingLevelError
+	}
+	return level
+}
+
+// request sends a request to the client on the SSE stream of the tool call in ctx and waits for its result.
+// The client answers with a POST of its own.
+func (s *streamableSession) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	stream, ok := ctx.Value(responseStreamKey{}).(*responseStream)
+	if !ok {
+		return nil, fmt.Errorf("%s needs the SSE stream of a tool call", method)
+	}
+	return s.outgoing.send(ctx, method, params, stream.write)
+}
+
+// canElicit reports whether the client declared the elicitation capability and the request in ctx is
+// answered with an SSE stream.
+func (s *streamableSession) canElicit(ctx context.Context) bool {
+	_, ok := ctx.Value(responseStreamKey{}).(*responseStream)
+	return ok && s.elicitation.Load()
+}
+
+var _ server.SessionWithLogging = (*streamableSession)(nil)
+
+// responseStreamKey is the context key of the SSE stream of a tool call.
+type responseStreamKey struct{}
+
+// responseStream passes the requests of the server to the SSE stream of a tool call.
+type responseStream struct {
+	messages chan any
+	done     chan struct{} // closed when the stream ends
+}
+
+func (s *responseStream) write(message any) error {
+	select {
+	case s.messages <- message:
+		return nil
+	case <-s.done:
+		return errors.New("the stream of the tool call has ended")
+	}
+}
+
+// streamableHTTPHandler serves the MCP server over the Streamable HTTP transport (MCP 2025-03-26).
+// Responsibility: Mapping HTTP requests to JSON-RPC messages of one MCP server
+// Features: Sessions with Mcp-Session-Id; tool calls are answered with an SSE stream that also carries
+// the notifications and elicitation requests sent during the call, whose responses the client POSTs;
+// GET opens a stream for the other notifications; DELETE ends a session and cancels its running tool calls.
+// Sessions without requests for idleTimeout are ended when a new one is initialized.
+// JSON-RPC batches and resumption with Last-Event-ID are not supported.
+type streamableHTTPHandler struct {
+	mcps        *MCPServer
+	server      *server.MCPServer // of mcps, taken when the transport is set up
+	idleTimeout time.Duration
+	now         func() time.Time
+	mu          sync.Mutex
+	sessions    map[string]*streamableSession
+}
+
+func newStreamableHTTPHandler(mcps *MCPServer) *streamableHTTPHandler {
+	return &streamableHTTPHandler{
+		mcps:        mcps,
+		server:      mcps.server,
+		idleTimeout: streamableSessionIdleTimeout,
+		now:         time.Now,
+		sessions:    make(map[string]*streamableSession),
+	}
+}
+
+func (h *streamableHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.handlePost(w, r)
+	case http.MethodGet:
+		h.handleGet(w, r)
+	case http.MethodDelete:
+		h.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePost handles one JSON-RPC message of the client.
+func (h *streamableHTTPHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeJSONResponse(w, mcp.NewJSONRPCError(mcp.NewRequestId(nil), mcp.PARSE_ERROR, "Parse error", nil))
+		return
+	}
+	var message struct {
+		ID     any    `json:"id"`
+		Method string `json:"method"`
+		clientResponse
+	}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		// Arrays are batches
+		writeJSONResponse(w, mcp.NewJSONRPCError(mcp.NewRequestId(nil), mcp.INVALID_REQUEST, "Batches are not supported", nil))
+		return
+	}
+
+	if message.Method == string(mcp.MethodInitialize) {
+		h.initialize(w, r, message.ID, raw)
+		return
+	}
+	session := h.session(w, r)
+	if session == nil {
+		return
+	}
+	defer h.release(session)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// A request still running when its session ends is cancelled, so that its tool call stops spending
+	defer context.AfterFunc(session.done, cancel)()
+	ctx = h.server.WithContext(withRequestIDSlot(ctx), session)
+	if message.ID != nil && message.Method == "" {
+		// A response to a request of the server, e.g. elicitation
+		if !session.outgoing.deliver(message.ID, message.clientResponse) {
+			h.mcps.log.Warnf("MCPServer: response to unknown request %v", message.ID)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if message.ID == nil {
+		// Notifications have no answer
+		h.server.HandleMessage(ctx, raw)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if message.Method != string(mcp.MethodToolsCall) || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		writeJSONResponse(w, liftStructuredContent(h.server.HandleMessage(ctx, raw)))
+		return
+	}
+
+	// Tool calls may log and report progress while they run, so the answer is a stream
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONResponse(w, liftStructuredContent(h.server.HandleMessage(ctx, raw)))
+		return
+	}
+	stream := &responseStream{messages: make(chan any), done: make(chan struct{})}
+	defer close(stream.done)
+	ctx = context.WithValue(ctx, responseStreamKey{}, stream)
+	responses := make(chan mcp.JSONRPCMessage, 1)
+	go func() {
+		responses <- h.server.HandleMessage(ctx, raw)
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case notification := <-session.notifications:
+			writeSSEEvent(w, notification)
+			flusher.Flush()
+		case request := <-stream.messages:
+			writeSSEEvent(w, request)
+			flusher.Flush()
+		case response := <-responses:
+			writeSSEEvent(w, liftStructuredContent(response))
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// initialize starts a session. The session is kept only if the server accepts the initialize request.
+func (h *streamableHTTPHandler) initialize(w http.ResponseWriter, r *http.Request, id any, raw json.RawMessage) {
+	if id == nil {
+		writeJSONResponse(w, mcp.NewJSONRPCError(mcp.NewRequestId(nil), mcp.INVALID_REQUEST, "initialize must be a request", nil))
+		return
+	}
+	session, err := newStreamableSession(auth.ClientFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := h.server.RegisterSession(r.Context(), session); err != nil {
+		session.cancel()
+		http.Error(w, fmt.Sprintf("failed to register session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	response := h.server.HandleMessage(h.server.WithContext(withRequestIDSlot(r.Context()), session), raw)
+	if _, failed := response.(mcp.JSONRPCError); failed {
+		session.cancel()
+		h.server.UnregisterSession(r.Context(), session.id)
+		writeJSONResponse(w, response)
+		return
+	}
+	session.elicitation.Store(declaresElicitation(raw))
+	h.endIdleSessions(r.Context())
+	h.mu.Lock()
+	session.lastUsed = h.now()
+	h.sessions[session.id] = session
+	h.mu.Unlock()
+	w.Header().Set(sessionIDHeader, session.id)
+	writeJSONResponse(w, response)
+}
+
+// handleGet opens a stream of the notifications of the session that are not sent during a tool call.
+func (h *streamableHTTPHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		http.Error(w, "the client must accept text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	session := h.session(w, r)
+	if session == nil {
+		return
+	}
+	defer h.release(session)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case notification := <-session.notifications:
+			writeSSEEvent(w, notification)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-session.done.Done():
+			return
+		}
+	}
+}
+
+// handleDelete ends the session at the request of the client.
+func (h *streamableHTTPHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session := h.session(w, r)
+	if session == nil {
+		return
+	}
+	h.release(session)
+	h.endSession(r.Context(), session.id, false)
+	w.WriteHeader(http.StatusOK)
+}
+
+// session returns the session named by the request and marks it as in use, or writes an error and returns nil.
+// A session in use is not ended for being idle; the caller must release it. The session of another client is
+// treated as unknown, so that a leaked session ID cannot be used by another client.
+func (h *streamableHTTPHandler) session(w http.ResponseWriter, r *http.Request) *streamableSession {
+	id := r.Header.Get(sessionIDHeader)
+	if id == "" {
+		http.Error(w, "missing "+sessionIDHeader+" header", http.StatusBadRequest)
+		return nil
+	}
+	h.mu.Lock()
+	session, ok := h.sessions[id]
+	if ok && session.client != auth.ClientFromContext(r.Context()) {
+		ok = false
+	}
+	if ok {
+		session.requests++
+		session.lastUsed = h.now()
+	}
+	h.mu.Unlock()
+	if !ok {
+		// 404 tells the client to initialize a new session
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return nil
+	}
+	return session
+}
+
+// release marks the end of a request of the session.
+func (h *streamableHTTPHandler) release(session *streamableSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	session.requests--
+	session.lastUsed = h.now()
+}
+
+// endIdleSessions ends the sessions that have had no request for idleTimeout.
+func (h *streamableHTTPHandler) endIdleSessions(ctx context.Context) {
+	var idle []string
+	h.mu.Lock()
+	for id, session := range h.sessions {
+		if h.isIdle(session) {
+			idle = append(idle, id)
+		}
+	}
+	h.mu.Unlock()
+	for _, id := range idle {
+		if h.endSession(ctx, id, true) {
+			h.mcps.log.Infof("Ended Streamable HTTP session %s after %s without requests", id, h.idleTimeout)
+		}
+	}
+}
+
+// isIdle reports whether the session has had no request for idleTimeout. The caller holds mu.
+func (h *streamableHTTPHandler) isIdle(session *streamableSession) bool {
+	return session.requests == 0 && h.now().Sub(session.lastUsed) > h.idleTimeout
+}
+
+// endSession forgets the session and cancels its tool calls. With onlyIdle, a session that has been used
+// since it was found idle is kept. It reports whether the session was ended.
+func (h *streamableHTTPHandler) endSession(ctx context.Context, id string, onlyIdle bool) bool {
+	h.mu.Lock()
+	session, ok := h.sessions[id]
+	if ok && onlyIdle && !h.isIdle(session) {
+		ok = false
+	}
+	if ok {
+		delete(h.sessions, id)
+	}
+	h.mu.Unlock()
+	if ok {
+		session.cancel()
+		session.outgoing.close(errors.New("the session has ended"))
+		h.server.UnregisterSession(ctx, id)
+	}
+	return ok
+}
+
+// close ends all sessions.
+func (h *streamableHTTPHandler) close(ctx context.Context) {
+	h.mu.Lock()
+	ids := make([]string, 0, len(h.sessions))
+	for id := range h.sessions {
+		ids = append(ids, id)
+	}
+	h.mu.Unlock()
+	for _, id := range ids {
+		h.endSession(ctx, id, false)
+	}
+}
+
+func writeJSONResponse(w http.ResponseWriter, message mcp.JSONRPCMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(message)
+}
+
+func writeSSEEvent(w http.ResponseWriter, message any) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+}
@@ -0,0 +1,582 @@
+package mcp_server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/auth"
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postMessage(t *testing.T, url, sessionID, accept, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	if sessionID != "" {
+		req.Header.Set(sessionIDHeader, sessionID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// readSSEMessages returns the data of the events of an SSE response.
+func readSSEMessages(t *testing.T, resp *http.Response) []map[string]any {
+	t.Helper()
+	var messages []map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var msg map[string]any
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestStreamableHTTPHandler(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	srv.registerTools(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_ = srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{"progress": 1})
+		return mcp.NewToolResultText("done"), nil
+	})
+	handler := newStreamableHTTPHandler(srv)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp := postMessage(t, ts.URL, "", "application/json, text/event-stream",
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	sessionID := resp.Header.Get(sessionIDHeader)
+	require.NotEmpty(t, sessionID)
+
+	resp = postMessage(t, ts.URL, sessionID, "application/json, text/event-stream", `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	t.Run("tool call is streamed with its notifications", func(t *testing.T) {
+		resp := postMessage(t, ts.URL, sessionID, "application/json, text/event-stream",
+			`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"test-tool","arguments":{"arg":"x"}}}`)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		messages := readSSEMessages(t, resp)
+		require.Len(t, messages, 2)
+		assert.Equal(t, "notifications/progress", messages[0]["method"])
+		assert.EqualValues(t, 2, messages[1]["id"])
+		assert.Contains(t, fmt.Sprint(messages[1]["result"]), "done")
+	})
+
+	t.Run("other requests get JSON", func(t *testing.T) {
+		resp := postMessage(t, ts.URL, sessionID, "application/json, text/event-stream", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var msg map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&msg))
+		assert.Contains(t, fmt.Sprint(msg["result"]), "test-tool")
+	})
+
+	t.Run("session is required", func(t *testing.T) {
+		resp := postMessage(t, ts.URL, "", "application/json", `{"jsonrpc":"2.0","id":4,"method":"ping"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = postMessage(t, ts.URL, "unknown", "application/json", `{"jsonrpc":"2.0","id":5,"method":"ping"}`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("delete ends the session", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set(sessionIDHeader, sessionID)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = postMessage(t, ts.URL, sessionID, "application/json", `{"jsonrpc":"2.0","id":6,"method":"ping"}`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestStreamableHTTPHandler_DeleteCancelsToolCalls(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	srv.registerTools(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return mcp.NewToolResultText("done"), nil
+		}
+	})
+	ts := httptest.NewServer(newStreamableHTTPHandler(srv))
+	defer ts.Close()
+
+	resp := postMessage(t, ts.URL, "", "application/json",
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	sessionID := resp.Header.Get(sessionIDHeader)
+
+	call := make(chan []map[string]any, 1)
+	go func() {
+		resp := postMessage(t, ts.URL, sessionID, "application/json, text/event-stream",
+			`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"test-tool","arguments":{"arg":"x"}}}`)
+		call <- readSSEMessages(t, resp)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the tool call did not start")
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set(sessionIDHeader, sessionID)
+	deleted, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = deleted.Body.Close()
+	require.Equal(t, http.StatusOK, deleted.StatusCode)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("ending the session did not cancel its tool call")
+	}
+	select {
+	case messages := <-call:
+		require.NotEmpty(t, messages)
+		assert.NotContains(t, fmt.Sprint(messages[len(messages)-1]), "done")
+	case <-time.After(time.Second):
+		t.Fatal("the stream of the cancelled call did not end")
+	}
+}
+
+func TestStreamableHTTPHandler_Elicit(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	srv.registerTools(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := srv.Elicit(ctx, "Allow?", map[string]any{"type": "object"})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("%s %v", result.Action, result.Content["approve"])), nil
+	})
+	ts := httptest.NewServer(newStreamableHTTPHandler(srv))
+	defer ts.Close()
+	initialize := func(t *testing.T, capabilities string) string {
+		resp := postMessage(t, ts.URL, "", "application/json, text/event-stream",
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":`+capabilities+`,"clientInfo":{"name":"test","version":"1"}}}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.Header.Get(sessionIDHeader)
+	}
+	callTool := `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"test-tool","arguments":{"arg":"x"}}}`
+
+	t.Run("supported", func(t *testing.T) {
+		sessionID := initialize(t, `{"elicitation":{}}`)
+		resp := postMessage(t, ts.URL, sessionID, "application/json, text/event-stream", callTool)
+		events := bufio.NewScanner(resp.Body)
+		next := func() map[string]any {
+			for events.Scan() {
+				if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+					var msg map[string]any
+					require.NoError(t, json.Unmarshal([]byte(data), &msg))
+					return msg
+				}
+			}
+			t.Fatal("the stream ended")
+			return nil
+		}
+
+		request := next()
+		assert.Equal(t, "elicitation/create", request["method"])
+		assert.Equal(t, "Allow?", request["params"].(map[string]any)["message"])
+		id, err := json.Marshal(request["id"])
+		require.NoError(t, err)
+		answer := postMessage(t, ts.URL, sessionID, "application/json, text/event-stream",
+			fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"action":"accept","content":{"approve":true}}}`, id))
+		assert.Equal(t, http.StatusAccepted, answer.StatusCode)
+
+		response := next()
+		assert.EqualValues(t, 2, response["id"])
+		assert.Contains(t, fmt.Sprint(response["result"]), "accept true")
+	})
+
+	t.Run("not declared by the client", func(t *testing.T) {
+		sessionID := initialize(t, `{}`)
+		messages := readSSEMessages(t, postMessage(t, ts.URL, sessionID, "application/json, text/event-stream", callTool))
+		require.Len(t, messages, 1)
+		assert.Contains(t, fmt.Sprint(messages[0]["result"]), ErrElicitationUnsupported.Error())
+	})
+
+	t.Run("tool call answered with JSON", func(t *testing.T) {
+		sessionID := initialize(t, `{"elicitation":{}}`)
+		resp := postMessage(t, ts.URL, sessionID, "application/json", callTool)
+		var msg map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&msg))
+		assert.Contains(t, fmt.Sprint(msg["result"]), ErrElicitationUnsupported.Error(), "without a stream the request cannot be sent")
+	})
+}
+
+func TestStreamableHTTPHandler_SessionOfAnotherClient(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	srv.registerTools(nil)
+	handler := newStreamableHTTPHandler(srv)
+	// The client is named by a header here; the auth middleware sets it in production
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(auth.WithClient(r.Context(), r.Header.Get("X-Client"))))
+	}))
+	defer ts.Close()
+	send := func(client, sessionID, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Client", client)
+		if sessionID != "" {
+			req.Header.Set(sessionIDHeader, sessionID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	resp := send("alice", "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	sessionID := resp.Header.Get(sessionIDHeader)
+
+	assert.Equal(t, http.StatusNotFound, send("bob", sessionID, `{"jsonrpc":"2.0","id":2,"method":"ping"}`).StatusCode)
+	assert.Equal(t, http.StatusNotFound, send("", sessionID, `{"jsonrpc":"2.0","id":3,"method":"ping"}`).StatusCode)
+	assert.Equal(t, http.StatusOK, send("alice", sessionID, `{"jsonrpc":"2.0","id":4,"method":"ping"}`).StatusCode)
+}
+
+func TestStreamableHTTPHandler_AfterStop(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	srv.registerTools(nil)
+	handler := newStreamableHTTPHandler(srv)
+	require.NoError(t, srv.Stop(context.Background()))
+
+	// A request that reached the handler before the listener closed is still answered
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`))
+	rec := httptest.NewRecorder()
+	assert.NotPanics(t, func() { handler.ServeHTTP(rec, req) })
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Error(t, srv.SendNotificationToClient(context.Background(), "notifications/progress", nil), "no client session, but no panic")
+}
+
+func TestStreamableHTTPHandler_SessionCleanup(t *testing.T) {
+	srv, err := NewMCPServer(configuration.MCPServerConfigForTest(), newTestLogger())
+	require.NoError(t, err)
+	srv.registerTools(nil)
+	handler := newStreamableHTTPHandler(srv)
+	var clock atomic.Int64
+	clock.Store(time.Unix(1700000000, 0).UnixNano())
+	handler.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	initialize := func() string {
+		resp := postMessage(t, ts.URL, "", "application/json",
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.Header.Get(sessionIDHeader)
+	}
+	sessionCount := func() int {
+		handler.mu.Lock()
+		defer handler.mu.Unlock()
+		return len(handler.sessions)
+	}
+
+	t.Run("failed initialize leaves no session", func(t *testing.T) {
+		resp := postMessage(t, ts.URL, "", "application/json", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":"bad"}`)
+		var msg map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&msg))
+		assert.Contains(t, msg, "error")
+		assert.Empty(t, resp.Header.Get(sessionIDHeader))
+		assert.Zero(t, sessionCount())
+	})
+
+	t.Run("idle sessions end when a new one starts", func(t *testing.T) {
+		idle, busy, active := initialize(), initialize(), initialize()
+		handler.mu.Lock()
+		handler.sessions[busy].requests++ // e.g. an open notification stream
+		handler.mu.Unlock()
+		clock.Add(int64(20 * time.Minute))
+		resp := postMessage(t, ts.URL, active, "application/json", `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		clock.Add(int64(20 * time.Minute))
+
+		fresh := initialize()
+		assert.Equal(t, http.StatusNotFound, postMessage(t, ts.URL, idle, "application/json", `{"jsonrpc":"2.0","id":3,"method":"ping"}`).StatusCode)
+		for _, id := range []string{busy, active, fresh} {
+			assert.Equal(t, http.StatusOK, postMessage(t, ts.URL, id, "application/json", `{"jsonrpc":"2.0","id":4,"method":"ping"}`).StatusCode)
+		}
+		assert.Equal(t, 3, sessionCount())
+	})
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func callTestTool(t *testing.T, c *client.Client) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	init := mcp.InitializeRequest{}
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	_, err := c.Initialize(ctx, init)
+	require.NoError(t, err)
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "test-tool"
+	req.Params.Arguments = map[string]any{"arg": "x"}
+	result, err := c.CallTool(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, result.Content, 1)
+	return result.Content[0].(mcp.TextContent).Text
+}
+
+func TestMCPServer_Serve_SSEAndStreamableHTTP(t *testing.T) {
+	port := freePort(t)
+	cfg := configuration.MCPServerConfigForTest()
+	cfg.MCPLogEnabled = false
+	cfg.Stdio.Enabled = false
+	cfg.HTTP = configuration.HTTPConfig{Enabled: true, Host: "127.0.0.1", Port: port}
+	cfg.StreamableHTTP = configuration.HTTPConfig{Enabled: true, Host: "127.0.0.1", Port: port}
+	srv, err := NewMCPServer(cfg, newTestLogger())
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(context.Background(), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("done"), nil
+		})
+	}()
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+	// A bare TCP probe would hold up Shutdown for 5s as a connection without a request
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(baseURL + DefaultStreamableHTTPPath)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	streamable, err := client.NewStreamableHttpClient(baseURL + DefaultStreamableHTTPPath)
+	require.NoError(t, err)
+	assert.Equal(t, "done", callTestTool(t, streamable))
+	_ = streamable.Close()
+
+	sse, err := client.NewSSEMCPClient(baseURL + "/sse")
+	require.NoError(t, err)
+	require.NoError(t, sse.Start(context.Background()))
+	assert.Equal(t, "done", callTestTool(t, sse))
+
+	// Idle client connections the server has not seen a request on keep Shutdown waiting, so bound it
+	stopCtx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	require.NoError(t, srv.Stop(stopCtx))
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Stop")
+	}
+	_ = sse.Close()
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.Error(t, err, "the listener is closed")
+}
+
+func TestMCPServer_Serve_Authentication(t *testing.T) {
+	port := freePort(t)
+	cfg := configuration.MCPServerConfigForTest()
+	cfg.MCPLogEnabled = false
+	cfg.Stdio.Enabled = false
+	cfg.HTTP.Enabled = false
+	cfg.StreamableHTTP = configuration.HTTPConfig{
+		Enabled: true, Host: "127.0.0.1", Port: port,
+		Auth: configuration.HTTPAuthConfig{Tokens: map[string]string{"alice": "secret"}},
+	}
+	srv, err := NewMCPServer(cfg, newTestLogger())
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Serve(context.Background(), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("hello " + auth.ClientFromContext(ctx)), nil
+		})
+	}()
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_ = srv.Stop(stopCtx)
+	}()
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", port, DefaultStreamableHTTPPath)
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = http.Post(url, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	c, err := client.NewStreamableHttpClient(url, transport.WithHTTPHeaders(map[string]string{"Authorization": "Bearer secret"}))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "hello alice", callTestTool(t, c), "the tool sees the authenticated client")
+}
+
+func TestMCPServer_Serve_Metrics(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("speelka_sessions_total 1\n"))
+	})
+	serve := func(t *testing.T, cfg configuration.MCPServerConfig) <-chan error {
+		srv, err := NewMCPServer(cfg, newTestLogger())
+		require.NoError(t, err)
+		srv.SetMetricsHandler(metrics)
+		served := make(chan error, 1)
+		go func() {
+			served <- srv.Serve(context.Background(), nil)
+		}()
+		t.Cleanup(func() {
+			stopCtx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			_ = srv.Stop(stopCtx)
+		})
+		return served
+	}
+	get := func(t *testing.T, url string, header http.Header) *http.Response {
+		t.Helper()
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			require.NoError(t, err)
+			req.Header = header
+			resp, err = http.DefaultClient.Do(req)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		return resp
+	}
+
+	t.Run("on the HTTP transport behind its authentication", func(t *testing.T) {
+		port := freePort(t)
+		cfg := configuration.MCPServerConfigForTest()
+		cfg.MCPLogEnabled = false
+		cfg.Stdio.Enabled = false
+		cfg.HTTP.Enabled = false
+		cfg.StreamableHTTP = configuration.HTTPConfig{
+			Enabled: true, Host: "127.0.0.1", Port: port,
+			Auth: configuration.HTTPAuthConfig{Tokens: map[string]string{"prometheus": "secret"}},
+		}
+		cfg.Metrics = configuration.MetricsConfig{Enabled: true, Path: "/metrics"}
+		serve(t, cfg)
+
+		url := fmt.Sprintf("http://127.0.0.1:%d/metrics", port)
+		assert.Equal(t, http.StatusUnauthorized, get(t, url, http.Header{}).StatusCode)
+		assert.Equal(t, http.StatusOK, get(t, url, http.Header{"Authorization": {"Bearer secret"}}).StatusCode)
+	})
+
+	t.Run("on an admin port in stdio mode", func(t *testing.T) {
+		port := freePort(t)
+		cfg := configuration.MCPServerConfigForTest()
+		cfg.MCPLogEnabled = false
+		cfg.HTTP.Enabled = false
+		cfg.Metrics = configuration.MetricsConfig{Enabled: true, Host: "127.0.0.1", Port: port, Path: "/metrics"}
+		stdin, input, err := os.Pipe()
+		require.NoError(t, err)
+		origStdin := os.Stdin
+		os.Stdin = stdin
+		t.Cleanup(func() {
+			os.Stdin = origStdin
+			_ = stdin.Close()
+		})
+		served := serve(t, cfg)
+
+		resp := get(t, fmt.Sprintf("http://127.0.0.1:%d/metrics", port), http.Header{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = get(t, fmt.Sprintf("http://127.0.0.1:%d%s", port, DefaultStreamableHTTPPath), http.Header{})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "the admin port serves no MCP transport")
+
+		require.NoError(t, input.Close())
+		select {
+		case err := <-served:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Serve did not return when stdio was closed")
+		}
+	})
+}
+
+func TestMCPServer_Serve_API(t *testing.T) {
+	port := freePort(t)
+	cfg := configuration.MCPServerConfigForTest()
+	cfg.MCPLogEnabled = false
+	cfg.Stdio.Enabled = false
+	cfg.HTTP = configuration.HTTPConfig{
+		Enabled: true, Host: "127.0.0.1", Port: port,
+		Auth: configuration.HTTPAuthConfig{Tokens: map[string]string{"cron": "secret"}},
+	}
+	cfg.APIEnabled = true
+	srv, err := NewMCPServer(cfg, newTestLogger())
+	require.NoError(t, err)
+	srv.SetAPIHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(auth.ClientFromContext(r.Context())))
+	}))
+	go func() { _ = srv.Serve(context.Background(), nil) }()
+	t.Cleanup(func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_ = srv.Stop(stopCtx)
+	})
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/v1/run", port)
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = http.Post(url, "application/json", strings.NewReader("{}"))
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the API is behind the authentication of the transport")
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("{}"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "cron", string(body))
+}
@@ -0,0 +1,127 @@
+package mcp_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// StructuredContentMetaKey is the `_meta` entry of a tool result that carries its structured content.
+// The mcp-go version in use has no structuredContent field, so handlers put the value here and every transport
+// moves it to the top-level `structuredContent` field of the response.
+const StructuredContentMetaKey = "structuredContent"
+
+// structuredToolResult is a tool result with the structuredContent field of newer protocol revisions.
+type structuredToolResult struct {
+	mcp.CallToolResult
+	StructuredContent any `json:"structuredContent"`
+}
+
+// liftStructuredContent moves the structured content of a tool call response from `_meta` to the top level.
+// Other messages are returned as is.
+func liftStructuredContent(message mcp.JSONRPCMessage) mcp.JSONRPCMessage {
+	response, ok := message.(mcp.JSONRPCResponse)
+	if !ok {
+		return message
+	}
+	result, ok := response.Result.(mcp.CallToolResult)
+	if !ok {
+		return message
+	}
+	structured, ok := result.Meta[StructuredContentMetaKey]
+	if !ok {
+		return message
+	}
+	var meta map[string]any
+	for k, v := range result.Meta {
+		if k == StructuredContentMetaKey {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]any)
+		}
+		meta[k] = v
+	}
+	result.Meta = meta
+	response.Result = structuredToolResult{CallToolResult: result, StructuredContent: structured}
+	return response
+}
+
+// sseMessagePrefix starts each message event the vendored SSE server writes to a stream.
+const sseMessagePrefix = "event: message\ndata: "
+
+// liftStructuredContentSSE makes the HTTP SSE handler lift the structured content of the responses it streams.
+// The vendored SSE server sends the responses of HandleMessage as they are, so its events are rewritten instead.
+func liftStructuredContentSSE(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flusher, ok := w.(http.Flusher); ok {
+			w = structuredContentWriter{ResponseWriter: w, flusher: flusher}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// structuredContentWriter rewrites the SSE events of tool call responses with structured content.
+// The SSE server writes each event with a single Write.
+type structuredContentWriter struct {
+	http.ResponseWriter
+	flusher http.Flusher
+}
+
+// Write writes the event, lifting the structured content of a tool call response.
+func (w structuredContentWriter) Write(p []byte) (int, error) {
+	event, ok := liftStructuredContentEvent(p)
+	if !ok {
+		return w.ResponseWriter.Write(p)
+	}
+	if _, err := w.ResponseWriter.Write(event); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered events to the client.
+func (w structuredContentWriter) Flush() {
+	w.flusher.Flush()
+}
+
+// liftStructuredContentEvent does what liftStructuredContent does for an SSE message event holding encoded JSON.
+// It reports false if the event is not a tool call response with structured content.
+func liftStructuredContentEvent(event []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(event, []byte(sseMessagePrefix)) || !bytes.HasSuffix(event, []byte("\n\n")) {
+		return nil, false
+	}
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(event[len(sseMessagePrefix):len(event)-2], &response); err != nil {
+		return nil, false
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(response["result"], &result); err != nil || result["content"] == nil {
+		return nil, false
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(result["_meta"], &meta); err != nil {
+		return nil, false
+	}
+	structured, ok := meta[StructuredContentMetaKey]
+	if !ok {
+		return nil, false
+	}
+	delete(meta, StructuredContentMetaKey)
+	delete(result, "_meta")
+	if len(meta) > 0 {
+		result["_meta"], _ = json.Marshal(meta)
+	}
+	result["structuredContent"] = structured
+	var err error
+	if response["result"], err = json.Marshal(result); err != nil {
+		return nil, false
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, false
+	}
+	return append(append([]byte(sseMessagePrefix), data...), "\n\n"...), true
+}
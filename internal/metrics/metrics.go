@@ -0,0 +1,123 @@
+// Package metrics exports the metrics of the agent in the Prometheus text format.
+// Responsibility: Collecting the metrics of sessions, LLM requests, tool calls and MCP server health
+// Features: No dependencies besides the standard library; the components record through small interfaces
+// of their own, so the package is optional, and take the outcome labels from its constants
+package metrics
+
+import (
+	"net/http"
+	"time"
+)
+
+// Outcomes of sessions, reported by the agent.
+const (
+	SessionSuccess        = "success"
+	SessionError          = "error"
+	SessionCancelled      = "cancelled"
+	SessionBudgetExceeded = "budget_exceeded"
+	SessionMaxIterations  = "max_iterations"
+)
+
+// Outcomes of LLM requests and tool calls, reported by the LLM service and the MCP connector.
+const (
+	OutcomeOK        = "ok"
+	OutcomeError     = "error"
+	OutcomeTimeout   = "timeout"
+	OutcomeCancelled = "cancelled"
+)
+
+var (
+	durationBuckets  = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+	iterationBuckets = []float64{1, 2, 3, 5, 8, 13, 21, 34, 55}
+)
+
+// Metrics holds the metrics of the agent.
+type Metrics struct {
+	registry *Registry
+
+	sessions          *CounterVec
+	sessionIterations *HistogramVec
+	llmDuration       *HistogramVec
+	llmTokens         *CounterVec
+	llmCost           *CounterVec
+	llmRetries        *CounterVec
+	toolDuration      *HistogramVec
+	toolCalls         *CounterVec
+	serverUp          *GaugeVec
+	serverReconnects  *CounterVec
+}
+
+// New creates the metrics of the agent.
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		registry: r,
+		sessions: r.NewCounterVec("speelka_sessions_total",
+			"Agent sessions by tool and outcome.", "tool", "outcome"),
+		sessionIterations: r.NewHistogramVec("speelka_session_iterations",
+			"LLM iterations per agent session.", iterationBuckets, "tool"),
+		llmDuration: r.NewHistogramVec("speelka_llm_request_duration_seconds",
+			"Duration of LLM requests including retries.", durationBuckets, "model", "outcome"),
+		llmTokens: r.NewCounterVec("speelka_llm_tokens_total",
+			"Tokens used by LLM requests.", "model", "type"),
+		llmCost: r.NewCounterVec("speelka_llm_cost_usd_total",
+			"Cost of LLM requests in USD.", "model"),
+		llmRetries: r.NewCounterVec("speelka_llm_retries_total",
+			"Retries of failed LLM requests.", "model"),
+		toolDuration: r.NewHistogramVec("speelka_tool_call_duration_seconds",
+			"Duration of MCP tool calls.", durationBuckets, "server", "tool"),
+		toolCalls: r.NewCounterVec("speelka_tool_calls_total",
+			"MCP tool calls by outcome.", "server", "tool", "outcome"),
+		serverUp: r.NewGaugeVec("speelka_mcp_server_up",
+			"Whether the MCP server is healthy (1) or not (0).", "server"),
+		serverReconnects: r.NewCounterVec("speelka_mcp_server_reconnects_total",
+			"Successful reconnections to the MCP server.", "server"),
+	}
+}
+
+// Handler serves the metrics for Prometheus.
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// SessionFinished records a finished session of the tool.
+func (m *Metrics) SessionFinished(tool, outcome string, iterations int) {
+	m.sessions.Inc(tool, outcome)
+	m.sessionIterations.Observe(float64(iterations), tool)
+}
+
+// LLMRequest records a finished LLM request with its token usage and cost.
+func (m *Metrics) LLMRequest(model, outcome string, duration time.Duration, promptTokens, completionTokens, reasoningTokens int, cost float64) {
+	m.llmDuration.Observe(duration.Seconds(), model, outcome)
+	m.llmTokens.Add(float64(promptTokens), model, "prompt")
+	m.llmTokens.Add(float64(completionTokens), model, "completion")
+	if reasoningTokens > 0 {
+		m.llmTokens.Add(float64(reasoningTokens), model, "reasoning")
+	}
+	m.llmCost.Add(cost, model)
+}
+
+// LLMRetry records a retry of an LLM request.
+func (m *Metrics) LLMRetry(model string) {
+	m.llmRetries.Inc(model)
+}
+
+// ToolCall records a finished call of a tool of an MCP server.
+func (m *Metrics) ToolCall(server, tool, outcome string, duration time.Duration) {
+	m.toolDuration.Observe(duration.Seconds(), server, tool)
+	m.toolCalls.Inc(server, tool, outcome)
+}
+
+// ServerHealth records the health of an MCP server.
+func (m *Metrics) ServerHealth(server string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	m.serverUp.Set(value, server)
+}
+
+// ServerReconnected records a successful reconnection to an MCP server.
+func (m *Metrics) ServerReconnected(server string) {
+	m.serverReconnects.Inc(server)
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	calls := r.NewCounterVec("calls_total", "Calls.", "tool")
+	up := r.NewGaugeVec("up", "Up.")
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "tool")
+
+	calls.Inc("b")
+	calls.Add(2, `a"b\`)
+	calls.Add(-1, "b") // counters never go down
+	up.Set(1)
+	latency.Observe(0.05, "x")
+	latency.Observe(0.5, "x")
+	latency.Observe(5, "x")
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	assert.Equal(t, `# HELP calls_total Calls.
+# TYPE calls_total counter
+calls_total{tool="a\"b\\"} 2
+calls_total{tool="b"} 1
+# HELP up Up.
+# TYPE up gauge
+up 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{tool="x",le="0.1"} 1
+latency_seconds_bucket{tool="x",le="1"} 2
+latency_seconds_bucket{tool="x",le="+Inf"} 3
+latency_seconds_sum{tool="x"} 5.55
+latency_seconds_count{tool="x"} 3
+`, buf.String())
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.SessionFinished("process", SessionSuccess, 3)
+	m.LLMRequest("gpt-4o", OutcomeOK, 2*time.Second, 100, 20, 0, 0.01)
+	m.LLMRetry("gpt-4o")
+	m.ToolCall("fs", "read_file", OutcomeTimeout, time.Second)
+	m.ServerHealth("fs", false)
+	m.ServerReconnected("fs")
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`speelka_sessions_total{tool="process",outcome="success"} 1`,
+		`speelka_session_iterations_bucket{tool="process",le="3"} 1`,
+		`speelka_llm_request_duration_seconds_count{model="gpt-4o",outcome="ok"} 1`,
+		`speelka_llm_tokens_total{model="gpt-4o",type="prompt"} 100`,
+		`speelka_llm_tokens_total{model="gpt-4o",type="completion"} 20`,
+		`speelka_llm_cost_usd_total{model="gpt-4o"} 0.01`,
+		`speelka_llm_retries_total{model="gpt-4o"} 1`,
+		`speelka_tool_calls_total{server="fs",tool="read_file",outcome="timeout"} 1`,
+		`speelka_tool_call_duration_seconds_sum{server="fs",tool="read_file"} 1`,
+		`speelka_mcp_server_up{server="fs"} 0`,
+		`speelka_mcp_server_reconnects_total{server="fs"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.NotContains(t, body, `type="reasoning"`, "unused token types are not exported")
+}
@@ -0,0 +1,117 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// spendWindow is the rolling period of the daily spend caps.
+const spendWindow = 24 * time.Hour
+
+// Spend is the cost of one finished call.
+type Spend struct {
+	At   time.Time `json:"at"`
+	Cost float64   `json:"cost"`
+}
+
+// Ledger keeps the spend of each client over the last 24 hours.
+// Responsibility: Accounting the cost of the calls per client
+// Features: Older entries are dropped; with a file the ledger survives restarts and is saved atomically after each entry
+type Ledger struct {
+	path    string
+	mu      sync.Mutex
+	clients map[string][]Spend
+}
+
+// OpenLedger loads the ledger file, or starts an empty ledger if the file does not exist.
+// An empty path keeps the ledger in memory.
+func OpenLedger(path string) (*Ledger, error) {
+	l := &Ledger{path: path, clients: make(map[string][]Spend)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger `%s`: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &l.clients); err != nil {
+		return nil, fmt.Errorf("failed to parse ledger `%s`: %w", path, err)
+	}
+	return l, nil
+}
+
+// Spent returns the entries of the client from the 24 hours before now, oldest first, and their total cost.
+func (l *Ledger) Spent(client string, now time.Time) ([]Spend, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := recent(l.clients[client], now)
+	total := 0.0
+	for _, e := range entries {
+		total += e.Cost
+	}
+	return append([]Spend(nil), entries...), total
+}
+
+// Record adds the cost of a call of the client, drops the entries older than 24 hours and saves the ledger.
+func (l *Ledger) Record(client string, cost float64, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c, entries := range l.clients {
+		if entries = recent(entries, now); len(entries) > 0 {
+			l.clients[c] = entries
+		} else {
+			delete(l.clients, c)
+		}
+	}
+	l.clients[client] = append(l.clients[client], Spend{At: now, Cost: cost})
+	return l.save()
+}
+
+// save writes the ledger atomically, so that a crash never leaves a truncated file. The caller holds mu.
+func (l *Ledger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.clients, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ledger: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create ledger file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write ledger `%s`: %w", l.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write ledger `%s`: %w", l.path, err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to save ledger `%s`: %w", l.path, err)
+	}
+	return nil
+}
+
+// recent returns the entries of the spend window ending at now, sorted by time.
+func recent(entries []Spend, now time.Time) []Spend {
+	since := now.Add(-spendWindow)
+	kept := entries[:0:0]
+	for _, e := range entries {
+		if e.At.After(since) {
+			kept = append(kept, e)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].At.Before(kept[j].At) })
+	return kept
+}
@@ -0,0 +1,276 @@
+// Package quota limits how much the clients of the MCP server can use the agent.
+// Responsibility: Admitting or rejecting the calls that start an agent session
+// Features: A global cap on concurrent sessions with a bounded wait queue, per-client rate limits
+// and rolling per-client daily spend caps backed by a ledger file
+package quota
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of limits reported in LimitError.
+const (
+	LimitConcurrency = "concurrency"
+	LimitRate        = "rate"
+	LimitSpend       = "spend"
+)
+
+// anonymous names the clients that were not authenticated in errors and logs.
+const anonymous = "anonymous"
+
+// LimitError is returned when a call is rejected by a limit.
+type LimitError struct {
+	// Limit is LimitConcurrency, LimitRate or LimitSpend.
+	Limit string
+	// Client is the name of the client the limit applies to, empty for the concurrency cap.
+	Client string
+	// RetryAfter is when the call can be expected to pass, zero if unknown.
+	RetryAfter time.Duration
+	message    string
+}
+
+func (e *LimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s, retry in %s", e.message, e.RetryAfter.Round(time.Second))
+	}
+	return e.message
+}
+
+// Ticket is an admitted call. Done must be called when the call has finished.
+type Ticket struct {
+	// Remaining is the most the call may spend, zero if the client has no spend cap.
+	// It is reserved from the daily spend of the client until Done.
+	Remaining float64
+
+	limiter *Limiter
+	client  string
+	once    sync.Once
+}
+
+// Done frees the session slot of the call, records its cost and releases its reservation.
+func (t *Ticket) Done(cost float64) {
+	t.once.Do(func() {
+		l := t.limiter
+		if l.slots != nil {
+			<-l.slots
+		}
+		// The cost is recorded before the reservation is released, so that no check sees neither
+		if cost > 0 {
+			if err := l.ledger.Record(t.client, cost, l.now()); err != nil {
+				l.log.Errorf("[QUOTA] Failed to record spend of client `%s`: %v", clientName(t.client), err)
+			}
+		}
+		l.release(t.client, t.Remaining)
+	})
+}
+
+// bucket is the token bucket of the rate limit of one client.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter admits the calls that are within the limits.
+type Limiter struct {
+	cfg    configuration.LimitsConfig
+	ledger *Ledger
+	log    *logrus.Logger
+	now    func() time.Time
+	slots  chan struct{} // Running sessions, nil without a concurrency cap
+
+	mu       sync.Mutex
+	queued   int
+	buckets  map[string]*bucket
+	reserved map[string]float64 // Budgets of the running calls per client
+}
+
+// NewLimiter creates a limiter and loads the spend ledger.
+func NewLimiter(cfg configuration.LimitsConfig, log *logrus.Logger) (*Limiter, error) {
+	ledger, err := OpenLedger(cfg.LedgerFile)
+	if err != nil {
+		return nil, err
+	}
+	l := &Limiter{
+		cfg:      cfg,
+		ledger:   ledger,
+		log:      log,
+		now:      time.Now,
+		buckets:  make(map[string]*bucket),
+		reserved: make(map[string]float64),
+	}
+	if cfg.MaxConcurrentSessions > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrentSessions)
+	}
+	return l, nil
+}
+
+// Acquire admits a call of the client with the given budget, zero for no budget, waiting for a free session slot if needed.
+// Under a spend cap, the budget of the call is lowered to what the client has left and reserved until Done,
+// so that concurrent calls within the cap can run side by side. A call without a budget reserves all that is left,
+// so that the cap holds for it too; other calls of the client are rejected until it finishes.
+// It returns a *LimitError if a limit rejects the call, or the error of ctx if it ends while waiting.
+func (l *Limiter) Acquire(ctx context.Context, client string, budget float64) (*Ticket, error) {
+	limits := l.cfg.ForClient(client)
+	if err := l.takeToken(client, limits); err != nil {
+		return nil, err
+	}
+	ticket := &Ticket{limiter: l, client: client}
+	if limits.DailySpend > 0 {
+		reserved, err := l.reserveSpend(client, limits.DailySpend, budget)
+		if err != nil {
+			return nil, err
+		}
+		ticket.Remaining = reserved
+	}
+	if err := l.waitForSlot(ctx); err != nil {
+		l.release(client, ticket.Remaining)
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// takeToken takes a token from the rate limit bucket of the client.
+func (l *Limiter) takeToken(client string, limits configuration.ClientLimits) error {
+	if limits.RequestsPerMinute <= 0 {
+		return nil
+	}
+	capacity := float64(limits.Burst)
+	if capacity <= 0 {
+		capacity = math.Max(1, math.Floor(limits.RequestsPerMinute))
+	}
+	perSecond := limits.RequestsPerMinute / 60
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		return &LimitError{
+			Limit:      LimitRate,
+			Client:     client,
+			RetryAfter: time.Duration((1 - b.tokens) / perSecond * float64(time.Second)),
+			message:    fmt.Sprintf("rate limit of client `%s` exceeded: %g requests per minute", clientName(client), limits.RequestsPerMinute),
+		}
+	}
+	b.tokens--
+	return nil
+}
+
+// reserveSpend reserves the budget of a call from what the client may still spend, which is its cap less
+// its spend and the reservations of its running calls. It returns the reserved amount, or an error if nothing is left.
+func (l *Limiter) reserveSpend(client string, dailySpend, budget float64) (float64, error) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, spent := l.ledger.Spent(client, now)
+	reserved := l.reserved[client]
+	if left := dailySpend - spent - reserved; left > 0 {
+		if budget <= 0 || budget > left {
+			budget = left
+		}
+		l.reserved[client] += budget
+		return budget, nil
+	}
+	if spent < dailySpend {
+		// The running calls may not use all of their budgets, so the rest is only known when they finish
+		return 0, &LimitError{
+			Limit:   LimitSpend,
+			Client:  client,
+			message: fmt.Sprintf("daily spend cap of client `%s` reached: $%.4f of $%.4f spent in the last 24 hours and $%.4f reserved by running calls", clientName(client), spent, dailySpend, reserved),
+		}
+	}
+	// The client is below the cap again once enough of its oldest entries have left the window
+	var retryAfter time.Duration
+	left := spent
+	for _, e := range entries {
+		left -= e.Cost
+		if left < dailySpend {
+			retryAfter = e.At.Add(spendWindow).Sub(now)
+			break
+		}
+	}
+	return 0, &LimitError{
+		Limit:      LimitSpend,
+		Client:     client,
+		RetryAfter: retryAfter,
+		message:    fmt.Sprintf("daily spend cap of client `%s` reached: $%.4f of $%.4f spent in the last 24 hours", clientName(client), spent, dailySpend),
+	}
+}
+
+// release returns the reserved budget of a finished call.
+func (l *Limiter) release(client string, amount float64) {
+	if amount <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.reserved[client] -= amount; l.reserved[client] <= 1e-9 {
+		delete(l.reserved, client)
+	}
+}
+
+// waitForSlot takes a session slot, waiting in the queue if all of them are taken.
+func (l *Limiter) waitForSlot(ctx context.Context) error {
+	if l.slots == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.cfg.MaxQueuedSessions {
+		l.mu.Unlock()
+		return &LimitError{
+			Limit:   LimitConcurrency,
+			message: fmt.Sprintf("server is busy: %d sessions are running and %d are waiting", l.cfg.MaxConcurrentSessions, l.cfg.MaxQueuedSessions),
+		}
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if l.cfg.QueueTimeout > 0 {
+		timer := time.NewTimer(l.cfg.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return &LimitError{
+			Limit:   LimitConcurrency,
+			message: fmt.Sprintf("server is busy: no session slot was freed within %s", l.cfg.QueueTimeout),
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func clientName(client string) string {
+	if client == "" {
+		return anonymous
+	}
+	return client
+}
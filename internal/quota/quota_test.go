@@ -0,0 +1,199 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLimiter(t *testing.T, cfg configuration.LimitsConfig, now *time.Time) *Limiter {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	l, err := NewLimiter(cfg, log)
+	require.NoError(t, err)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func limitOf(t *testing.T, err error) *LimitError {
+	t.Helper()
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr), "expected a limit error, got %v", err)
+	return limitErr
+}
+
+func TestLimiter_RateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newTestLimiter(t, configuration.LimitsConfig{
+		ClientLimits: configuration.ClientLimits{RequestsPerMinute: 2},
+		Clients:      map[string]configuration.ClientLimits{"vip": {RequestsPerMinute: 60, Burst: 5}},
+	}, &now)
+
+	for i := 0; i < 2; i++ {
+		ticket, err := l.Acquire(context.Background(), "alice", 0)
+		require.NoError(t, err)
+		ticket.Done(0)
+	}
+	_, err := l.Acquire(context.Background(), "alice", 0)
+	limitErr := limitOf(t, err)
+	assert.Equal(t, LimitRate, limitErr.Limit)
+	assert.Equal(t, 30*time.Second, limitErr.RetryAfter)
+	assert.Contains(t, err.Error(), "rate limit of client `alice` exceeded: 2 requests per minute, retry in 30s")
+
+	_, err = l.Acquire(context.Background(), "bob", 0)
+	assert.NoError(t, err, "each client has its own bucket")
+	for i := 0; i < 5; i++ {
+		_, err = l.Acquire(context.Background(), "vip", 0)
+		assert.NoError(t, err, "clients can have their own limits")
+	}
+
+	now = now.Add(30 * time.Second)
+	_, err = l.Acquire(context.Background(), "alice", 0)
+	assert.NoError(t, err, "the bucket refills over time")
+}
+
+func TestLimiter_DailySpend(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ledgerFile := filepath.Join(t.TempDir(), "ledger.json")
+	cfg := configuration.LimitsConfig{
+		ClientLimits: configuration.ClientLimits{DailySpend: 1},
+		LedgerFile:   ledgerFile,
+	}
+	l := newTestLimiter(t, cfg, &now)
+
+	ticket, err := l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, ticket.Remaining)
+	ticket.Done(0.4)
+
+	now = now.Add(time.Hour)
+	ticket, err = l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.6, ticket.Remaining, 1e-9)
+	ticket.Done(0.7)
+
+	// The spend survives a restart
+	l = newTestLimiter(t, cfg, &now)
+	_, err = l.Acquire(context.Background(), "alice", 0)
+	limitErr := limitOf(t, err)
+	assert.Equal(t, LimitSpend, limitErr.Limit)
+	assert.Equal(t, 23*time.Hour, limitErr.RetryAfter, "the first call leaves the window after 24 hours")
+	assert.Contains(t, err.Error(), "daily spend cap of client `alice` reached: $1.1000 of $1.0000")
+
+	_, err = l.Acquire(context.Background(), "", 0)
+	assert.NoError(t, err, "the spend is counted per client")
+
+	now = now.Add(23 * time.Hour)
+	ticket, err = l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err, "the window rolls")
+	assert.InDelta(t, 0.3, ticket.Remaining, 1e-9)
+}
+
+func TestLimiter_DailySpendReservations(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newTestLimiter(t, configuration.LimitsConfig{ClientLimits: configuration.ClientLimits{DailySpend: 1}}, &now)
+
+	first, err := l.Acquire(context.Background(), "alice", 0.4)
+	require.NoError(t, err)
+	assert.Equal(t, 0.4, first.Remaining, "the budget of the call is below what is left")
+	second, err := l.Acquire(context.Background(), "alice", 0.4)
+	require.NoError(t, err, "calls within the cap run at the same time")
+	assert.Equal(t, 0.4, second.Remaining)
+	third, err := l.Acquire(context.Background(), "alice", 0.4)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.2, third.Remaining, 1e-9, "the budget is lowered to what is left")
+	unbudgeted, err := l.Acquire(context.Background(), "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, unbudgeted.Remaining, "a call without a budget reserves what is left")
+	_, err = l.Acquire(context.Background(), "", 0)
+	assert.Equal(t, LimitSpend, limitOf(t, err).Limit, "calls without a budget cannot overshoot the cap together")
+	unbudgeted.Done(0)
+
+	_, err = l.Acquire(context.Background(), "alice", 0.1)
+	limitErr := limitOf(t, err)
+	assert.Equal(t, LimitSpend, limitErr.Limit)
+	assert.Zero(t, limitErr.RetryAfter)
+	assert.Contains(t, err.Error(), "$0.0000 of $1.0000 spent in the last 24 hours and $1.0000 reserved by running calls")
+
+	// Only the cost of a finished call stays taken
+	first.Done(0.1)
+	fourth, err := l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.3, fourth.Remaining, 1e-9)
+	second.Done(0)
+	third.Done(0)
+	fourth.Done(0)
+	ticket, err := l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.9, ticket.Remaining, 1e-9)
+}
+
+func TestLimiter_Concurrency(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(t, configuration.LimitsConfig{
+		MaxConcurrentSessions: 1,
+		MaxQueuedSessions:     1,
+		QueueTimeout:          time.Minute,
+	}, &now)
+
+	running, err := l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err)
+
+	queued := make(chan error, 1)
+	go func() {
+		ticket, err := l.Acquire(context.Background(), "bob", 0)
+		if err == nil {
+			ticket.Done(0)
+		}
+		queued <- err
+	}()
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.queued == 1
+	}, time.Second, time.Millisecond)
+
+	_, err = l.Acquire(context.Background(), "carol", 0)
+	limitErr := limitOf(t, err)
+	assert.Equal(t, LimitConcurrency, limitErr.Limit)
+	assert.Contains(t, err.Error(), "server is busy: 1 sessions are running and 1 are waiting")
+
+	running.Done(0)
+	running.Done(0) // repeated calls do not free another slot
+	select {
+	case err := <-queued:
+		assert.NoError(t, err, "the waiting call gets the freed slot")
+	case <-time.After(time.Second):
+		t.Fatal("the waiting call was not admitted")
+	}
+}
+
+func TestLimiter_QueueTimeout(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(t, configuration.LimitsConfig{
+		MaxConcurrentSessions: 1,
+		MaxQueuedSessions:     1,
+		QueueTimeout:          10 * time.Millisecond,
+		ClientLimits:          configuration.ClientLimits{DailySpend: 1},
+	}, &now)
+	_, err := l.Acquire(context.Background(), "alice", 0)
+	require.NoError(t, err)
+
+	_, err = l.Acquire(context.Background(), "bob", 0)
+	assert.Contains(t, err.Error(), "no session slot was freed within 10ms")
+	assert.NotContains(t, l.reserved, "bob", "a rejected call releases its reservation")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.Acquire(ctx, "bob", 0)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,136 @@
+package session_store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// FileStore keeps each session in a JSON file in a directory.
+// Responsibility: Storing sessions across process restarts
+// Features: Atomic writes, expired sessions are removed on access and swept on save at most once per ttl
+type FileStore struct {
+	dir       string
+	ttl       time.Duration
+	mu        sync.Mutex
+	now       func() time.Time
+	lastSweep time.Time // When the expired sessions were last removed
+}
+
+// NewFileStore creates a file-backed store in dir, creating the directory if needed.
+// Zero ttl keeps sessions until they are deleted.
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("session directory is required for the file store")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory `%s`: %w", dir, err)
+	}
+	return &FileStore{dir: dir, ttl: ttl, now: time.Now}, nil
+}
+
+// Load returns the session with the given ID. The second value is false if it does not exist or has expired.
+func (s *FileStore) Load(id string) (types.SessionState, bool, error) {
+	if err := ValidateSessionID(id); err != nil {
+		return types.SessionState{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return types.SessionState{}, false, nil
+	}
+	if err != nil {
+		return types.SessionState{}, false, fmt.Errorf("failed to read session `%s`: %w", id, err)
+	}
+	var state types.SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return types.SessionState{}, false, fmt.Errorf("failed to decode session `%s`: %w", id, err)
+	}
+	if isExpired(state, s.ttl, s.now()) {
+		_ = os.Remove(s.path(id))
+		return types.SessionState{}, false, nil
+	}
+	return state, true, nil
+}
+
+// Save writes the session to disk, updating its timestamp, and drops expired sessions if they were
+// last dropped more than ttl ago.
+func (s *FileStore) Save(state types.SessionState) error {
+	if err := ValidateSessionID(state.ID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.ttl > 0 && now.Sub(s.lastSweep) >= s.ttl {
+		s.removeExpired(now)
+		s.lastSweep = now
+	}
+	state.UpdatedAt = now
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode session `%s`: %w", state.ID, err)
+	}
+	tmp, err := os.CreateTemp(s.dir, state.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create session file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session `%s`: %w", state.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session `%s`: %w", state.ID, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(state.ID)); err != nil {
+		return fmt.Errorf("failed to save session `%s`: %w", state.ID, err)
+	}
+	return nil
+}
+
+// Delete removes the session file. Deleting an unknown session is not an error.
+func (s *FileStore) Delete(id string) error {
+	if err := ValidateSessionID(id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete session `%s`: %w", id, err)
+	}
+	return nil
+}
+
+// removeExpired deletes the files of the expired sessions. Files that cannot be read are left alone.
+// The caller holds mu.
+func (s *FileStore) removeExpired(now time.Time) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var state types.SessionState
+		if err := json.Unmarshal(data, &state); err != nil {
+			continue
+		}
+		if isExpired(state, s.ttl, now) {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
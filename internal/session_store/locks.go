@@ -0,0 +1,37 @@
+package session_store
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Locks marks the sessions that a call is using.
+// Responsibility: Keeping one conversation from being run or ended by two calls at once
+// Features: Thread-safe; one instance is shared by all the agents of a process, whichever tool they serve
+type Locks struct {
+	mu     sync.Mutex
+	active map[string]struct{}
+}
+
+// NewLocks creates an empty set of session locks.
+func NewLocks() *Locks {
+	return &Locks{active: make(map[string]struct{})}
+}
+
+// Acquire marks the session as in use. It returns an error if another call is using it.
+func (l *Locks) Acquire(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, busy := l.active[id]; busy {
+		return fmt.Errorf("session `%s` is busy with another call", id)
+	}
+	l.active[id] = struct{}{}
+	return nil
+}
+
+// Release marks the session as free again.
+func (l *Locks) Release(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.active, id)
+}
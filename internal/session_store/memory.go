@@ -0,0 +1,68 @@
+package session_store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+// MemoryStore keeps sessions in process memory.
+// Responsibility: Storing sessions for the lifetime of the process
+// Features: Thread-safe, expired sessions are removed on access and on save
+type MemoryStore struct {
+	ttl      time.Duration
+	sessions map[string]types.SessionState
+	mu       sync.Mutex
+	now      func() time.Time
+}
+
+// NewMemoryStore creates an in-memory store. Zero ttl keeps sessions until they are deleted.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:      ttl,
+		sessions: make(map[string]types.SessionState),
+		now:      time.Now,
+	}
+}
+
+// Load returns the session with the given ID. The second value is false if it does not exist or has expired.
+func (s *MemoryStore) Load(id string) (types.SessionState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.sessions[id]
+	if !ok {
+		return types.SessionState{}, false, nil
+	}
+	if isExpired(state, s.ttl, s.now()) {
+		delete(s.sessions, id)
+		return types.SessionState{}, false, nil
+	}
+	return state, true, nil
+}
+
+// Save stores the session, updating its timestamp, and drops expired sessions.
+func (s *MemoryStore) Save(state types.SessionState) error {
+	if err := ValidateSessionID(state.ID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, existing := range s.sessions {
+		if isExpired(existing, s.ttl, now) {
+			delete(s.sessions, id)
+		}
+	}
+	state.UpdatedAt = now
+	s.sessions[state.ID] = state
+	return nil
+}
+
+// Delete removes the session. Deleting an unknown session is not an error.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
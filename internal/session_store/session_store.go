@@ -0,0 +1,38 @@
+// Package session_store provides storage for multi-turn agent sessions.
+// Responsibility: Persisting conversation state between calls to the agent tool
+// Features: In-memory and on-disk implementations with TTL-based expiry
+package session_store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+)
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// NewSessionID generates a random session identifier.
+func NewSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ValidateSessionID checks that the identifier is safe to use as a storage key.
+func ValidateSessionID(id string) error {
+	if !sessionIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid session id `%s`: only letters, digits, '-' and '_' are allowed", id)
+	}
+	return nil
+}
+
+// isExpired reports whether the state was last updated more than ttl ago. Zero ttl never expires.
+func isExpired(state types.SessionState, ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && now.Sub(state.UpdatedAt) > ttl
+}
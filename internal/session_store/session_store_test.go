@@ -0,0 +1,141 @@
+package session_store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func testState(id string) types.SessionState {
+	return types.SessionState{
+		ID: id,
+		Messages: []llms.MessageContent{
+			llms.TextParts(llms.ChatMessageTypeSystem, "system prompt"),
+			{
+				Role: llms.ChatMessageTypeAI,
+				Parts: []llms.ContentPart{llms.ToolCall{
+					ID:           "call-1",
+					Type:         "function",
+					FunctionCall: &llms.FunctionCall{Name: "finish", Arguments: `{"text":"hi"}`},
+				}},
+			},
+			{
+				Role: llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{
+					ToolCallID: "call-1",
+					Name:       "finish",
+					Content:    "hi",
+				}},
+			},
+		},
+		Info: types.ChatInfo{TotalTokens: 42, TotalCost: 0.5, LLMRequests: 1, RequestBudget: 2},
+	}
+}
+
+func TestNewSessionID(t *testing.T) {
+	a, err := NewSessionID()
+	require.NoError(t, err)
+	b, err := NewSessionID()
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+	assert.NoError(t, ValidateSessionID(a))
+}
+
+func TestValidateSessionID(t *testing.T) {
+	assert.NoError(t, ValidateSessionID("abc-DEF_123"))
+	assert.Error(t, ValidateSessionID(""))
+	assert.Error(t, ValidateSessionID("../etc/passwd"))
+	assert.Error(t, ValidateSessionID("a b"))
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	_, ok, err := store.Load("missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Save(testState("s1")))
+	state, ok, err := store.Load("s1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 42, state.Info.TotalTokens)
+	assert.Len(t, state.Messages, 3)
+
+	now = now.Add(2 * time.Minute)
+	_, ok, err = store.Load("s1")
+	require.NoError(t, err)
+	assert.False(t, ok, "session should expire after ttl")
+
+	require.NoError(t, store.Save(testState("s2")))
+	require.NoError(t, store.Delete("s2"))
+	_, ok, _ = store.Load("s2")
+	assert.False(t, ok)
+	assert.Error(t, store.Save(testState("bad id")))
+}
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	store, err := NewFileStore(dir, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Save(testState("s1")))
+	_, err = os.Stat(filepath.Join(dir, "s1.json"))
+	require.NoError(t, err)
+
+	// A new store instance reads the session written by the previous one
+	reopened, err := NewFileStore(dir, time.Minute)
+	require.NoError(t, err)
+	reopened.now = store.now
+	state, ok, err := reopened.Load("s1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, testState("s1").Messages, state.Messages)
+	assert.Equal(t, 0.5, state.Info.TotalCost)
+
+	now = now.Add(2 * time.Minute)
+	_, ok, err = reopened.Load("s1")
+	require.NoError(t, err)
+	assert.False(t, ok, "session should expire after ttl")
+	_, err = os.Stat(filepath.Join(dir, "s1.json"))
+	assert.True(t, os.IsNotExist(err), "expired session file should be removed")
+
+	require.NoError(t, store.Delete("unknown"))
+	_, _, err = store.Load("../x")
+	assert.Error(t, err)
+
+	_, err = NewFileStore("", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestFileStore_RemovesExpiredSessionsOnSave(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Save(testState("s1")))
+	require.NoError(t, store.Save(testState("s2")))
+	now = now.Add(30 * time.Second)
+	require.NoError(t, store.Save(testState("s2")))
+	now = now.Add(45 * time.Second)
+	require.NoError(t, store.Save(testState("s3")))
+
+	_, err = os.Stat(filepath.Join(dir, "s1.json"))
+	assert.True(t, os.IsNotExist(err), "sessions that are never loaded again are removed once expired")
+	for _, id := range []string{"s2", "s3"} {
+		_, err = os.Stat(filepath.Join(dir, id+".json"))
+		assert.NoError(t, err, "session %s has not expired", id)
+	}
+}
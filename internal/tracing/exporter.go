@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+)
+
+// otlpTimeout bounds one OTLP request.
+const otlpTimeout = 10 * time.Second
+
+// newExporter creates the exporter selected in the configuration.
+func newExporter(cfg configuration.TracingConfig) (Exporter, error) {
+	switch cfg.Exporter {
+	case configuration.TracingOTLP:
+		return &otlpExporter{
+			url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+			headers: cfg.Headers,
+			client:  &http.Client{Timeout: otlpTimeout},
+		}, nil
+	case configuration.TracingFile:
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file `%s`: %w", cfg.Path, err)
+		}
+		return &jsonLinesExporter{w: f, closer: f}, nil
+	case configuration.TracingStdout:
+		return &jsonLinesExporter{w: os.Stdout}, nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter `%s`", cfg.Exporter)
+	}
+}
+
+// jsonLinesExporter writes each span as a line of JSON, for reading traces offline.
+type jsonLinesExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer // nil for stdout
+}
+
+// jsonLine is a span as written by jsonLinesExporter.
+type jsonLine struct {
+	Service string `json:"service"`
+	SpanData
+	DurationMs float64 `json:"durationMs"`
+}
+
+func (e *jsonLinesExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		line := jsonLine{Service: service, SpanData: span, DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("failed to encode span `%s`: %w", span.Name, err)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write spans: %w", err)
+	}
+	return nil
+}
+
+func (e *jsonLinesExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// otlpExporter posts spans to an OpenTelemetry collector with the JSON encoding of OTLP/HTTP.
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (e *otlpExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(service, spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans to %s: %w", e.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector %s rejected spans: %s %s", e.url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *otlpExporter) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// otlpRequest builds an ExportTraceServiceRequest in the OTLP JSON encoding.
+func otlpRequest(service string, spans []SpanData) map[string]any {
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		s := map[string]any{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.ParentSpanID != "" {
+			s["parentSpanId"] = span.ParentSpanID
+		}
+		if span.Error != "" {
+			s["status"] = map[string]any{"code": 2, "message": span.Error}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "speelka-agent"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes converts attributes to OTLP key-values, sorted by key.
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, map[string]any{"key": key, "value": otlpValue(attrs[key])})
+	}
+	return result
+}
+
+func otlpValue(value any) map[string]any {
+	switch v := value.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]any{"stringValue": strconv.FormatFloat(v, 'g', -1, 64)}
+		}
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceParentKey is the `_meta` field that carries the W3C trace context of an MCP request.
+const TraceParentKey = "traceparent"
+
+// Inject adds the trace context of ctx to the `_meta` fields of an outgoing MCP request.
+// It does nothing if ctx carries no span context.
+func Inject(ctx context.Context, meta map[string]any) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	meta[TraceParentKey] = FormatTraceParent(sc)
+}
+
+// Extract returns a context carrying the remote span context found in the `_meta` fields of an incoming
+// MCP request, so that the spans started from it join the trace of the caller. A missing or malformed
+// trace context leaves ctx unchanged.
+func Extract(ctx context.Context, meta map[string]any) context.Context {
+	value, _ := meta[TraceParentKey].(string)
+	if value == "" {
+		return ctx
+	}
+	sc, err := ParseTraceParent(value)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// FormatTraceParent renders the span context as a W3C `traceparent` value of a sampled trace.
+func FormatTraceParent(sc SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceParent parses a W3C `traceparent` value.
+func ParseTraceParent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("malformed traceparent `%s`", value)
+	}
+	if err := decodeID(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("malformed trace ID in traceparent `%s`", value)
+	}
+	if err := decodeID(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("malformed parent ID in traceparent `%s`", value)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("traceparent `%s` has a zero ID", value)
+	}
+	return sc, nil
+}
+
+func decodeID(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return fmt.Errorf("wrong length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
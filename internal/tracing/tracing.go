@@ -0,0 +1,305 @@
+// Package tracing records spans of agent sessions, LLM requests and tool calls and exports them.
+// Responsibility: Following a request across the agent and the MCP servers and agents it calls
+// Features: W3C trace context, propagated through MCP `_meta`; export with OTLP over HTTP or as JSON lines
+// to a file or stdout, without dependencies besides the standard library. A nil *Tracer and a nil *Span
+// are valid and record nothing, so tracing is optional for the components.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// flushInterval is how often ended spans are exported.
+	flushInterval = 5 * time.Second
+	// maxBatch is how many ended spans trigger an export before the interval.
+	maxBatch = 256
+	// maxPending bounds the spans kept while the exporter is failing.
+	maxPending = 4096
+)
+
+// SpanKind tells what a span represents, as in OpenTelemetry.
+type SpanKind int
+
+// Kinds of spans.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext identifies a span within its trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether both IDs are set.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// SpanData is an ended span as passed to exporters.
+type SpanData struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// Exporter sends ended spans to their destination.
+type Exporter interface {
+	// Export sends a batch of spans.
+	Export(ctx context.Context, service string, spans []SpanData) error
+	// Close releases the resources of the exporter.
+	Close() error
+}
+
+// Tracer starts spans and exports them in batches.
+// Responsibility: Creating spans within the trace of their context and handing them to the exporter
+// Features: Ended spans are exported every few seconds and on Shutdown
+type Tracer struct {
+	service  string
+	exporter Exporter
+	log      *logrus.Logger
+
+	mu       sync.Mutex
+	pending  []SpanData
+	flush    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewTracer creates a tracer with the exporter of the configuration.
+func NewTracer(cfg configuration.TracingConfig, log *logrus.Logger) (*Tracer, error) {
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newTracer(cfg.ServiceName, exporter, log), nil
+}
+
+func newTracer(service string, exporter Exporter, log *logrus.Logger) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		log:      log,
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span as a child of the span in ctx, or of the remote span extracted into ctx,
+// or as the root of a new trace. The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: make(map[string]any),
+		},
+	}
+	span.context.TraceID = parent.TraceID
+	if parent.IsValid() {
+		span.data.ParentSpanID = hex.EncodeToString(parent.SpanID[:])
+	} else {
+		span.context.TraceID = randomTraceID()
+	}
+	span.context.SpanID = randomSpanID()
+	return ContextWithSpanContext(ctx, span.context), span
+}
+
+// Shutdown exports the remaining spans and closes the exporter. The tracer records nothing afterwards.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	var err error
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		<-t.done
+		err = t.export(ctx)
+		if closeErr := t.exporter.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// run exports the ended spans periodically until Shutdown.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+		if err := t.export(ctx); err != nil {
+			t.log.Warnf("[TRACING] Failed to export spans: %v", err)
+		}
+		cancel()
+	}
+}
+
+// export sends the pending spans. They are kept for the next attempt if the exporter fails.
+func (t *Tracer) export(ctx context.Context) error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := t.exporter.Export(ctx, t.service, batch); err != nil {
+		t.mu.Lock()
+		t.pending = append(batch, t.pending...)
+		if dropped := len(t.pending) - maxPending; dropped > 0 {
+			t.pending = t.pending[dropped:]
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	t.mu.Lock()
+	t.pending = append(t.pending, data)
+	full := len(t.pending) >= maxBatch
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Span is an operation within a trace. Its methods are safe for concurrent use and do nothing on nil.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SetAttribute sets an attribute of the span: a string, bool, integer or float.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+// End ends the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := s.data
+	data.End = time.Now()
+	data.TraceID = hex.EncodeToString(s.context.TraceID[:])
+	data.SpanID = hex.EncodeToString(s.context.SpanID[:])
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
+
+// SpanContext returns the IDs of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context carrying the span context, which becomes the parent of new spans.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, invalid if there is none.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+func randomTraceID() [16]byte {
+	var id [16]byte
+	for id == [16]byte{} {
+		mustRead(id[:])
+	}
+	return id
+}
+
+func randomSpanID() [8]byte {
+	var id [8]byte
+	for id == [8]byte{} {
+		mustRead(id[:])
+	}
+	return id
+}
+
+func mustRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate a trace ID: %v", err))
+	}
+}
@@ -0,0 +1,219 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/korchasa/speelka-agent-go/internal/configuration"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingExporter keeps the exported spans.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+	err   error
+}
+
+func (e *recordingExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Close() error { return nil }
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func TestTracer_Spans(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := newTracer("agent", exporter, newTestLogger())
+
+	ctx, session := tracer.Start(context.Background(), "agent.session", KindInternal)
+	session.SetAttribute("agent.tool", "process")
+	_, call := tracer.Start(ctx, "mcp.tool_call", KindClient)
+	call.RecordError(errors.New("boom"))
+	call.End()
+	call.End() // ending twice exports once
+	call.SetAttribute("late", true)
+	session.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	require.Len(t, exporter.spans, 2)
+	child, parent := exporter.spans[0], exporter.spans[1]
+	assert.Equal(t, "mcp.tool_call", child.Name)
+	assert.Equal(t, KindClient, child.Kind)
+	assert.Equal(t, "boom", child.Error)
+	assert.NotContains(t, child.Attributes, "late", "ended spans do not change")
+	assert.Equal(t, parent.TraceID, child.TraceID)
+	assert.Equal(t, parent.SpanID, child.ParentSpanID)
+	assert.Empty(t, parent.ParentSpanID, "the session is the root")
+	assert.Equal(t, "process", parent.Attributes["agent.tool"])
+	assert.False(t, child.End.Before(child.Start))
+
+	_, late := tracer.Start(context.Background(), "late", KindInternal)
+	late.End()
+	assert.Len(t, exporter.spans, 2, "nothing is recorded after Shutdown")
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop", KindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, context.Background(), ctx)
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("boom"))
+	span.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestTracer_KeepsSpansWhenExportFails(t *testing.T) {
+	exporter := &recordingExporter{err: errors.New("collector down")}
+	tracer := newTracer("agent", exporter, newTestLogger())
+	_, span := tracer.Start(context.Background(), "op", KindInternal)
+	span.End()
+	assert.Error(t, tracer.export(context.Background()))
+
+	exporter.err = nil
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	assert.Len(t, exporter.spans, 1)
+}
+
+func TestPropagation(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := Extract(context.Background(), map[string]any{TraceParentKey: traceParent})
+	sc := SpanContextFromContext(ctx)
+	require.True(t, sc.IsValid())
+	assert.Equal(t, traceParent, FormatTraceParent(sc))
+
+	exporter := &recordingExporter{}
+	tracer := newTracer("agent", exporter, newTestLogger())
+	ctx, span := tracer.Start(ctx, "agent.session", KindInternal)
+	meta := map[string]any{"progressToken": 1}
+	Inject(ctx, meta)
+	span.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exporter.spans[0].TraceID, "the span joins the remote trace")
+	assert.Equal(t, "00f067aa0ba902b7", exporter.spans[0].ParentSpanID)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+exporter.spans[0].SpanID+"-01", meta[TraceParentKey])
+	assert.Equal(t, 1, meta["progressToken"])
+
+	for _, bad := range []string{
+		"",
+		"garbage",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		ctx := Extract(context.Background(), map[string]any{TraceParentKey: bad})
+		assert.False(t, SpanContextFromContext(ctx).IsValid(), "traceparent %q is rejected", bad)
+	}
+	empty := map[string]any{}
+	Inject(context.Background(), empty)
+	assert.Empty(t, empty, "nothing is injected without a trace")
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	tracer, err := NewTracer(configuration.TracingConfig{Exporter: configuration.TracingFile, Path: path, ServiceName: "agent"}, newTestLogger())
+	require.NoError(t, err)
+	_, span := tracer.Start(context.Background(), "agent.session", KindInternal)
+	span.SetAttribute("session.iterations", 2)
+	span.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	require.True(t, scanner.Scan())
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+	assert.Equal(t, "agent", line["service"])
+	assert.Equal(t, "agent.session", line["name"])
+	assert.Equal(t, map[string]any{"session.iterations": 2.0}, line["attributes"])
+	assert.Contains(t, line, "durationMs")
+	assert.False(t, scanner.Scan(), "one line per span")
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		header = r.Header
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}))
+	defer collector.Close()
+
+	tracer, err := NewTracer(configuration.TracingConfig{
+		Exporter:    configuration.TracingOTLP,
+		Endpoint:    collector.URL + "/",
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+		ServiceName: "agent",
+	}, newTestLogger())
+	require.NoError(t, err)
+	ctx, parent := tracer.Start(context.Background(), "agent.session", KindInternal)
+	_, span := tracer.Start(ctx, "llm.request", KindClient)
+	span.SetAttribute("llm.model", "gpt-4o")
+	span.SetAttribute("llm.attempt", 1)
+	span.SetAttribute("llm.cost", 0.5)
+	span.SetAttribute("llm.cached", false)
+	span.RecordError(errors.New("rate limited"))
+	span.End()
+	parent.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Equal(t, "Bearer secret", header.Get("Authorization"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	resourceSpans := body["resourceSpans"].([]any)[0].(map[string]any)
+	assert.Equal(t, []any{map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "agent"}}},
+		resourceSpans["resource"].(map[string]any)["attributes"])
+	spans := resourceSpans["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	require.Len(t, spans, 2)
+	llmSpan := spans[0].(map[string]any)
+	assert.Equal(t, "llm.request", llmSpan["name"])
+	assert.Equal(t, 3.0, llmSpan["kind"])
+	assert.Equal(t, spans[1].(map[string]any)["spanId"], llmSpan["parentSpanId"])
+	assert.Equal(t, map[string]any{"code": 2.0, "message": "rate limited"}, llmSpan["status"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "llm.attempt", "value": map[string]any{"intValue": "1"}},
+		map[string]any{"key": "llm.cached", "value": map[string]any{"boolValue": false}},
+		map[string]any{"key": "llm.cost", "value": map[string]any{"doubleValue": 0.5}},
+		map[string]any{"key": "llm.model", "value": map[string]any{"stringValue": "gpt-4o"}},
+	}, llmSpan["attributes"])
+	assert.Regexp(t, `^\d+$`, llmSpan["startTimeUnixNano"])
+}
+
+func TestOTLPExporter_Rejected(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+	exporter, err := newExporter(configuration.TracingConfig{Exporter: configuration.TracingOTLP, Endpoint: collector.URL})
+	require.NoError(t, err)
+	err = exporter.Export(context.Background(), "agent", []SpanData{{Name: "op"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rejected spans: 400 Bad Request bad request")
+}
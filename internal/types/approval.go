@@ -0,0 +1,19 @@
+package types
+
+import "context"
+
+// ApprovalRequest describes a tool call that waits for a human decision.
+type ApprovalRequest struct {
+	ServerID  string `json:"server"`
+	ToolName  string `json:"tool"`
+	Arguments any    `json:"arguments"`
+}
+
+// ApprovalDecision is the answer to an ApprovalRequest. Reason is optional and explains a rejection.
+type ApprovalDecision struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ApprovalFunc asks a human whether a tool call may run. It blocks until the decision is made or ctx is done.
+type ApprovalFunc func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error)
@@ -14,4 +14,6 @@ type ChatInfo struct {
 	ModelName       string  // Name of the LLM model used for this chat
 	ToolCallCount   int     // Number of tool calls in the session
 	RequestBudget   float64 // Configured cost budget for this chat (USD or token-equivalent)
+	Compactions     int     // Number of times the history was compacted to fit the context window
+	CompactedTokens int     // Estimated tokens removed from the history by compaction
 }
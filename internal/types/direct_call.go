@@ -14,3 +14,20 @@ type DirectCallResult struct {
 	Meta    MetaInfo        `json:"meta"`
 	Error   DirectCallError `json:"error"`
 }
+
+// BatchResult is the result of one input of a batch of direct calls.
+type BatchResult struct {
+	// ID is the `id` of the input, or its line number if it has none.
+	ID any `json:"id"`
+	DirectCallResult
+}
+
+// BatchSummary aggregates the results of a batch of direct calls.
+type BatchSummary struct {
+	Items      int     `json:"items"`
+	Succeeded  int     `json:"succeeded"`
+	Failed     int     `json:"failed"`
+	Tokens     int     `json:"tokens"`
+	Cost       float64 `json:"cost"`
+	DurationMs int64   `json:"duration_ms"`
+}
@@ -3,9 +3,15 @@ package types
 // MetaInfo contains metadata about a direct call execution or agent run.
 type MetaInfo struct {
 	Tokens           int     `json:"tokens"`
-	Cost             float64 `json:"cost"`
+	Cost             float64 `json:"cost"`                // Total of the session, including the earlier calls of a continued session
+	CallCost         float64 `json:"call_cost,omitempty"` // Cost of this call alone
 	DurationMs       int64   `json:"duration_ms"`
 	PromptTokens     int     `json:"prompt_tokens,omitempty"`
 	CompletionTokens int     `json:"completion_tokens,omitempty"`
 	ReasoningTokens  int     `json:"reasoning_tokens,omitempty"`
+	SessionID        string  `json:"session_id,omitempty"`
+
+	// StructuredContent is the final answer validated against the output schema, if one is configured.
+	// It is returned to the client as the answer itself rather than as meta information.
+	StructuredContent map[string]any `json:"-"`
 }
@@ -0,0 +1,76 @@
+package types
+
+import (
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ProgressEventKind identifies the stage of an agent session reported by a ProgressEvent.
+type ProgressEventKind string
+
+const (
+	// ProgressEventIteration is reported before each LLM request.
+	ProgressEventIteration ProgressEventKind = "iteration"
+	// ProgressEventToolCall is reported when a tool call starts.
+	ProgressEventToolCall ProgressEventKind = "tool_call"
+	// ProgressEventToolResult is reported when a tool call finishes.
+	ProgressEventToolResult ProgressEventKind = "tool_result"
+	// ProgressEventApproval is reported when a tool call starts waiting for approval.
+	ProgressEventApproval ProgressEventKind = "approval"
+)
+
+// ProgressEvent describes a step of a running agent session.
+type ProgressEvent struct {
+	Kind      ProgressEventKind `json:"kind"`
+	Iteration int               `json:"iteration"`
+	ToolName  string            `json:"tool,omitempty"`
+	IsError   bool              `json:"is_error,omitempty"`
+	Tokens    int               `json:"tokens"`
+	Cost      float64           `json:"cost"`
+
+	// Arguments of a tool call and Result, the text of its result, are for local display only:
+	// results can be large, so they are not sent to remote clients.
+	Arguments any    `json:"-"`
+	Result    string `json:"-"`
+}
+
+// ProgressFunc receives progress events. It may be called concurrently from several goroutines.
+type ProgressFunc func(event ProgressEvent)
+
+// SessionOptions holds per-call settings for an agent session.
+type SessionOptions struct {
+	// Progress, if set, receives progress events while the session runs.
+	Progress ProgressFunc
+
+	// SessionID continues a previously saved conversation. Empty starts a new one.
+	SessionID string
+
+	// Client is the authenticated caller, empty without authentication. A conversation it starts can only be
+	// continued or ended by the same client.
+	Client string
+
+	// Approve, if set, decides on tool calls whose policy requires approval.
+	// Without it such calls are rejected.
+	Approve ApprovalFunc
+
+	// RequestBudget, if positive, lowers the configured request budget for this call. It can never raise it.
+	RequestBudget float64
+
+	// Arguments are the validated arguments of the input schema of the tool. A new session passes them
+	// to the prompt template by name; a continued one ignores them.
+	Arguments map[string]any
+
+	// Stateless runs a new session without saving it, for callers that never get the session ID to continue it.
+	Stateless bool
+}
+
+// SessionState is a saved multi-turn conversation.
+type SessionState struct {
+	ID        string                `json:"id"`
+	Tool      string                `json:"tool,omitempty"`   // Main tool that started the session and alone may continue or end it; empty in older sessions, which any tool may use
+	Client    string                `json:"client,omitempty"` // Authenticated client that started the session and alone may continue or end it; empty for callers without authentication
+	Messages  []llms.MessageContent `json:"messages"`
+	Info      ChatInfo              `json:"info"`
+	UpdatedAt time.Time             `json:"updated_at"`
+}
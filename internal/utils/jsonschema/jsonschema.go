@@ -0,0 +1,377 @@
+// Package jsonschema validates JSON values against a subset of JSON Schema.
+// Responsibility: Checking the structured final answer of the agent against the configured output schema
+// Features: Supports type, properties, required, additionalProperties, items, enum, const, anyOf,
+// numeric and length limits and pattern; other keywords (title, description, format, ...) are ignored
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Check reports keywords of the schema that have a value of the wrong kind, so that a broken schema
+// is rejected when the configuration is loaded instead of failing every answer.
+func Check(schema map[string]any) error {
+	var errs []string
+	check(schema, "", &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Validate returns the violations of the schema by value, one message per violation, prefixed by the
+// path of the offending value (`$` is the root). value must be decoded JSON: map[string]any, []any,
+// float64, string, bool or nil. An empty result means the value is valid.
+func Validate(schema map[string]any, value any) []string {
+	var errs []string
+	validate(schema, value, "$", &errs)
+	return errs
+}
+
+func check(schema map[string]any, path string, errs *[]string) {
+	fail := func(keyword, format string, args ...any) {
+		*errs = append(*errs, fmt.Sprintf("%s%s: %s", path, keyword, fmt.Sprintf(format, args...)))
+	}
+	if t, ok := schema["type"]; ok {
+		for _, name := range typeNames(t) {
+			if !knownType(name) {
+				fail("type", "unknown type `%v`", name)
+			}
+		}
+		if len(typeNames(t)) == 0 {
+			fail("type", "must be a string or an array of strings")
+		}
+	}
+	if props, ok := schema["properties"]; ok {
+		m, ok := props.(map[string]any)
+		if !ok {
+			fail("properties", "must be an object")
+		}
+		for _, name := range sortedKeys(m) {
+			if sub, ok := m[name].(map[string]any); ok {
+				check(sub, path+"properties."+name+".", errs)
+			} else {
+				fail("properties."+name, "must be an object")
+			}
+		}
+	}
+	if required, ok := schema["required"]; ok {
+		if _, ok := stringList(required); !ok {
+			fail("required", "must be an array of strings")
+		}
+	}
+	if additional, ok := schema["additionalProperties"]; ok {
+		switch a := additional.(type) {
+		case bool:
+		case map[string]any:
+			check(a, path+"additionalProperties.", errs)
+		default:
+			fail("additionalProperties", "must be a boolean or an object")
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		if sub, ok := items.(map[string]any); ok {
+			check(sub, path+"items.", errs)
+		} else {
+			fail("items", "must be an object")
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		if _, ok := enum.([]any); !ok {
+			fail("enum", "must be an array")
+		}
+	}
+	if anyOf, ok := schema["anyOf"]; ok {
+		list, ok := anyOf.([]any)
+		if !ok || len(list) == 0 {
+			fail("anyOf", "must be a non-empty array")
+		}
+		for i, item := range list {
+			if sub, ok := item.(map[string]any); ok {
+				check(sub, fmt.Sprintf("%sanyOf.%d.", path, i), errs)
+			} else {
+				fail(fmt.Sprintf("anyOf.%d", i), "must be an object")
+			}
+		}
+	}
+	for _, keyword := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"} {
+		if v, ok := schema[keyword]; ok {
+			if _, ok := number(v); !ok {
+				fail(keyword, "must be a number")
+			}
+		}
+	}
+	for _, keyword := range []string{"minLength", "maxLength", "minItems", "maxItems"} {
+		if v, ok := schema[keyword]; ok {
+			if n, ok := number(v); !ok || n < 0 || n != math.Trunc(n) {
+				fail(keyword, "must be a non-negative integer")
+			}
+		}
+	}
+	if pattern, ok := schema["pattern"]; ok {
+		s, ok := pattern.(string)
+		if !ok {
+			fail("pattern", "must be a string")
+		} else if _, err := regexp.Compile(s); err != nil {
+			fail("pattern", "%v", err)
+		}
+	}
+}
+
+func validate(schema map[string]any, value any, path string, errs *[]string) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if t, ok := schema["type"]; ok {
+		names := typeNames(t)
+		matched := false
+		for _, name := range names {
+			if hasType(value, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(names, " or "), typeOf(value))
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, option := range enum {
+			if equal(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+	if c, ok := schema["const"]; ok && !equal(c, value) {
+		fail("must be %v", c)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, item := range anyOf {
+			if sub, ok := item.(map[string]any); ok && len(Validate(sub, value)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of the allowed schemas")
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		validateObject(schema, v, path, errs)
+	case []any:
+		if n, ok := number(schema["minItems"]); ok && float64(len(v)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(v)) > n {
+			fail("must have at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := number(schema["minLength"]); ok && length < n {
+			fail("must be at least %v characters long", n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && length > n {
+			fail("must be at most %v characters long", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("must match the pattern `%s`", pattern)
+			}
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && v < n {
+			fail("must be >= %v", n)
+		}
+		if n, ok := number(schema["maximum"]); ok && v > n {
+			fail("must be <= %v", n)
+		}
+		if n, ok := number(schema["exclusiveMinimum"]); ok && v <= n {
+			fail("must be > %v", n)
+		}
+		if n, ok := number(schema["exclusiveMaximum"]); ok && v >= n {
+			fail("must be < %v", n)
+		}
+	}
+}
+
+func validateObject(schema map[string]any, value map[string]any, path string, errs *[]string) {
+	if required, ok := stringList(schema["required"]); ok {
+		for _, name := range required {
+			if _, ok := value[name]; !ok {
+				*errs = append(*errs, fmt.Sprintf("%s: missing required property `%s`", path, name))
+			}
+		}
+	}
+	props, _ := schema["properties"].(map[string]any)
+	for _, name := range sortedKeys(value) {
+		if sub, ok := props[name].(map[string]any); ok {
+			validate(sub, value[name], path+"."+name, errs)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*errs = append(*errs, fmt.Sprintf("%s: unexpected property `%s`", path, name))
+			}
+		case map[string]any:
+			validate(additional, value[name], path+"."+name, errs)
+		}
+	}
+}
+
+// typeNames returns the type names of the `type` keyword, which may be a string or an array of strings.
+func typeNames(t any) []string {
+	if s, ok := t.(string); ok {
+		return []string{s}
+	}
+	names, _ := stringList(t)
+	return names
+}
+
+func knownType(name string) bool {
+	switch name {
+	case "object", "array", "string", "number", "integer", "boolean", "null":
+		return true
+	}
+	return false
+}
+
+func hasType(value any, name string) bool {
+	switch name {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// number converts a numeric keyword value. Schemas loaded from YAML hold integers as int.
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// equal compares a schema value with a decoded JSON value.
+func equal(schemaValue, value any) bool {
+	if a, ok := number(schemaValue); ok {
+		b, ok := value.(float64)
+		return ok && a == b
+	}
+	switch s := schemaValue.(type) {
+	case []any:
+		v, ok := value.([]any)
+		if !ok || len(s) != len(v) {
+			return false
+		}
+		for i := range s {
+			if !equal(s[i], v[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		v, ok := value.(map[string]any)
+		if !ok || len(s) != len(v) {
+			return false
+		}
+		for k := range s {
+			if !equal(s[k], v[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return schemaValue == value
+}
+
+func stringList(v any) ([]string, bool) {
+	switch list := v.(type) {
+	case []string:
+		return list, true
+	case []any:
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			names = append(names, s)
+		}
+		return names, true
+	}
+	return nil, false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var reportSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"title":    map[string]any{"type": "string", "minLength": 3},
+		"severity": map[string]any{"type": "string", "enum": []any{"low", "high"}},
+		"score":    map[string]any{"type": "number", "minimum": 0, "maximum": 10},
+		"count":    map[string]any{"type": "integer"},
+		"tags":     map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": 2},
+		"owner":    map[string]any{"type": []any{"string", "null"}},
+	},
+	"required":             []any{"title", "severity"},
+	"additionalProperties": false,
+}
+
+func decode(t *testing.T, s string) any {
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(s), &v))
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"valid", `{"title":"Disk full","severity":"high","score":7.5,"count":2,"tags":["ops"],"owner":null}`, nil},
+		{"missing required", `{"title":"Disk full"}`, []string{"$: missing required property `severity`"}},
+		{"wrong type", `{"title":"Disk full","severity":"high","count":1.5}`, []string{"$.count: expected integer, got number"}},
+		{"enum", `{"title":"Disk full","severity":"medium"}`, []string{"$.severity: must be one of [low high]"}},
+		{"limits", `{"title":"Hi","severity":"low","score":11,"tags":["a","b","c"]}`, []string{
+			"$.score: must be <= 10",
+			"$.tags: must have at most 2 items",
+			"$.title: must be at least 3 characters long",
+		}},
+		{"items", `{"title":"Disk full","severity":"low","tags":[1]}`, []string{"$.tags[0]: expected string, got number"}},
+		{"additional properties", `{"title":"Disk full","severity":"low","extra":true}`, []string{"$: unexpected property `extra`"}},
+		{"not an object", `"Disk full"`, []string{"$: expected object, got string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Validate(reportSchema, decode(t, tt.value)))
+		})
+	}
+}
+
+func TestValidate_AnyOfAndPattern(t *testing.T) {
+	schema := map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": "string", "pattern": "^[a-z]+$"},
+			map[string]any{"type": "integer"},
+		},
+	}
+	assert.Empty(t, Validate(schema, decode(t, `"abc"`)))
+	assert.Empty(t, Validate(schema, decode(t, `3`)))
+	assert.Equal(t, []string{"$: does not match any of the allowed schemas"}, Validate(schema, decode(t, `"ABC"`)))
+}
+
+func TestCheck(t *testing.T) {
+	assert.NoError(t, Check(reportSchema))
+	err := Check(map[string]any{
+		"type": "dictionary",
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string", "pattern": "("},
+			"n":    map[string]any{"maxLength": -1},
+		},
+		"required": "name",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "type: unknown type `dictionary`")
+	assert.Contains(t, err.Error(), "properties.name.pattern")
+	assert.Contains(t, err.Error(), "properties.n.maxLength: must be a non-negative integer")
+	assert.Contains(t, err.Error(), "required: must be an array of strings")
+}